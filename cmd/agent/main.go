@@ -3,7 +3,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -13,18 +15,31 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/ArtemShalinFe/metcoll/internal/agent"
 	"github.com/ArtemShalinFe/metcoll/internal/build"
+	"github.com/ArtemShalinFe/metcoll/internal/collector"
 	"github.com/ArtemShalinFe/metcoll/internal/configuration"
-	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
-	"github.com/ArtemShalinFe/metcoll/internal/metrics"
-	"github.com/ArtemShalinFe/metcoll/internal/stats"
 )
 
-// timeoutShutdown is waiting time until the rest of the gorutins are completed.
-const timeoutShutdown = time.Second * 60
+const (
+	// timeoutIngestShutdown is waiting time until the ingest listeners are completed.
+	timeoutIngestShutdown = time.Second * 30
+
+	// timeoutStatusShutdown is waiting time until the status endpoint is completed.
+	timeoutStatusShutdown = time.Second * 5
+
+	// timeoutShutdown is waiting time until the rest of the gorutins are completed.
+	timeoutShutdown = time.Second * 60
+)
+
+// exitCodeNotDelivered is the exit code of the one-shot mode when the batch was not delivered to any server.
+const exitCodeNotDelivered = 2
 
 func main() {
 	if err := run(); err != nil {
+		if errors.Is(err, agent.ErrNotDelivered) {
+			os.Exit(exitCodeNotDelivered)
+		}
 		zap.S().Fatalf("an occured fatal err: %w", err)
 	}
 }
@@ -70,32 +85,83 @@ func run() error {
 		}
 	}(componentsErrs)
 
-	client, err := metcoll.InitClient(ctx, cfg, sl)
+	agent, err := agent.NewAgent(ctx, cfg, sl)
 	if err != nil {
-		return fmt.Errorf("cannot init metcoll client err: %w", err)
+		return fmt.Errorf("cannot init agent err: %w", err)
 	}
-	stats := stats.NewStats()
-
-	go func() {
-		mcs := make(chan []*metrics.Metrics, cfg.Limit)
-		defer close(mcs)
 
-		errs := make(chan error, cfg.Limit)
-		defer close(errs)
+	if cfg.Once || cfg.DryRun {
+		// the context is cancelled before waiting for the graceful shutdown of the logger.
+		defer cancelCtx()
 
-		stats.RunCollectBatchStats(ctx, cfg, mcs)
-		for i := 0; i < cfg.Limit; i++ {
-			go client.BatchUpdateMetric(ctx, mcs, errs)
+		if cfg.DryRun {
+			err = agent.DryRun(ctx, os.Stdout, cfg.DryRunFormat)
+		} else {
+			err = agent.Once(ctx)
+		}
+		if err != nil {
+			sl.Error(err)
 		}
+		return err
+	}
 
-		for err := range errs {
-			if err != nil {
-				sl.Errorf("batch update metrics failed err: %w", err)
-			} else {
-				stats.ClearPollCount()
+	if cfg.IngestAddress != "" || cfg.IngestSocket != "" {
+		ingest := collector.NewIngest(cfg, sl)
+		agent.AddCollector(ingest)
+
+		go func(errs chan<- error) {
+			if err := ingest.ListenAndServe(); err != nil {
+				errs <- fmt.Errorf("ingest listen and serve err: %w", err)
+			}
+		}(componentsErrs)
+		sl.Infof("ingest running at address: %s, socket: %s", cfg.IngestAddress, cfg.IngestSocket)
+
+		// graceful shutdown ingest
+		wg.Add(1)
+		go func(errs chan<- error) {
+			defer wg.Done()
+			<-ctx.Done()
+
+			shutdownCtx, cancelShutdownCtx := context.WithTimeout(context.Background(), timeoutIngestShutdown)
+			defer cancelShutdownCtx()
+
+			if err := ingest.Shutdown(shutdownCtx); err != nil {
+				errs <- fmt.Errorf("ingest shutdown err: %w", err)
 			}
+		}(componentsErrs)
+	}
+
+	if cfg.StatusAddress != "" {
+		status := &http.Server{
+			Addr:    cfg.StatusAddress,
+			Handler: agent.StatusHandler(),
 		}
-	}()
+
+		go func(errs chan<- error) {
+			if err := status.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("status listen and serve err: %w", err)
+			}
+		}(componentsErrs)
+		sl.Infof("agent status running at address: %s", cfg.StatusAddress)
+
+		// graceful shutdown status
+		wg.Add(1)
+		go func(errs chan<- error) {
+			defer wg.Done()
+			<-ctx.Done()
+
+			shutdownCtx, cancelShutdownCtx := context.WithTimeout(context.Background(), timeoutStatusShutdown)
+			defer cancelShutdownCtx()
+
+			if err := status.Shutdown(shutdownCtx); err != nil {
+				errs <- fmt.Errorf("status shutdown err: %w", err)
+			}
+		}(componentsErrs)
+	}
+
+	if err := agent.Run(ctx); err != nil {
+		return fmt.Errorf("cannot run agent err: %w", err)
+	}
 
 	sl.Info("metcoll client starting")
 
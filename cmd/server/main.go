@@ -108,6 +108,28 @@ func run() error {
 	}(componentsErrs)
 	sl.Info("server running at address: ", cfg.Address)
 
+	// reload keys
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				sl.Info("SIGHUP received, reloading the crypto keys")
+				if err := s.ReloadKeys(); err != nil {
+					sl.Errorf("the crypto keys were not reloaded, the previous keys are used, err: %v", err)
+				}
+			}
+		}
+	}()
+
 	// graceful shutdown server
 	wg.Add(1)
 	go func(errs chan<- error) {
@@ -0,0 +1,323 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/queue"
+	"github.com/ArtemShalinFe/metcoll/internal/stats"
+)
+
+// clientFactory - creates the client of the metcoll servers.
+type clientFactory func(ctx context.Context,
+	cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (metcoll.MetricUpdater, error)
+
+// maxPendingBatches - the limit of the batches the worker keeps for the servers that have not received them
+// in the fan-out mode, the oldest batch is dropped to make room for the new one.
+const maxPendingBatches = 100
+
+// worker - the goroutine that sends the batches when the queue is disabled.
+type worker struct {
+	stop chan struct{}
+	done chan struct{}
+	// cancel - cancels the sending in progress, so the stopping does not wait for its retries.
+	cancel context.CancelFunc
+}
+
+func newWorker(ctx context.Context) (*worker, context.Context) {
+	wctx, cancel := context.WithCancel(ctx)
+	return &worker{stop: make(chan struct{}), done: make(chan struct{}), cancel: cancel}, wctx
+}
+
+// pendingBatch - the batch that was not delivered to some of the servers in the fan-out mode.
+type pendingBatch struct {
+	mcs     []*metrics.Metrics
+	servers []string
+}
+
+// Agent - collects the metrics and sends them to the metcoll servers.
+type Agent struct {
+	mux        *sync.Mutex
+	cfg        *configuration.ConfigAgent
+	base       *configuration.ConfigAgent
+	profile    *metcoll.Profile
+	sl         *zap.SugaredLogger
+	stats      *stats.Stats
+	client     metcoll.MetricUpdater
+	newClient  clientFactory
+	sendQueue  *queue.Queue
+	health     *health
+	sender     *worker
+	mcs        chan []*metrics.Metrics
+	workers    []*worker
+	collectors []*collectorRun
+}
+
+// NewAgent - Object constructor. The configuration is validated before the agent is created.
+func NewAgent(ctx context.Context, cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (*Agent, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("agent configuration is incorrect, err: %w", err)
+	}
+
+	return newAgent(ctx, cfg, sl, metcoll.InitClient)
+}
+
+func newAgent(ctx context.Context, cfg *configuration.ConfigAgent,
+	sl *zap.SugaredLogger, newClient clientFactory) (*Agent, error) {
+	a := &Agent{
+		mux:       &sync.Mutex{},
+		cfg:       cfg,
+		base:      cfg,
+		sl:        sl,
+		stats:     stats.NewStats(),
+		newClient: newClient,
+		health:    newHealth(),
+		mcs:       make(chan []*metrics.Metrics, cfg.Limit),
+	}
+	a.stats.AddCollector(&selfMetrics{agent: a})
+
+	if err := a.stats.SetAggregations(cfg.Aggregate); err != nil {
+		return nil, fmt.Errorf("cannot set report window aggregations err: %w", err)
+	}
+	a.setDeltaReporting(cfg)
+
+	client, err := a.initClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	a.client = client
+
+	if cfg.QueuePath != "" {
+		a.sendQueue, err = queue.NewQueue(cfg, sl)
+		if err != nil {
+			return nil, fmt.Errorf("cannot init send queue err: %w", err)
+		}
+		sl.Infof("send queue running at path: %s, batches waiting: %d", cfg.QueuePath, a.sendQueue.Len())
+	}
+
+	return a, nil
+}
+
+func (a *Agent) initClient(ctx context.Context, cfg *configuration.ConfigAgent) (metcoll.MetricUpdater, error) {
+	client, err := a.newClient(ctx, cfg, a.sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot init metcoll client err: %w", err)
+	}
+	client.OnResync(a.stats.Resync)
+
+	return client, nil
+}
+
+func (a *Agent) setDeltaReporting(cfg *configuration.ConfigAgent) {
+	if cfg.DeltaOnly {
+		a.stats.SetDeltaReporting(cfg.DeltaAbsolute, cfg.DeltaRelative, cfg.ResyncReports)
+	} else {
+		a.stats.DisableDeltaReporting()
+	}
+}
+
+// AddCollector - registers an additional source of metrics that is not managed by the agent.
+func (a *Agent) AddCollector(c stats.Collector) {
+	a.stats.AddCollector(c)
+}
+
+// Run - starts the collection and the sending of the metrics until the context is done.
+// The configuration is reloaded on SIGHUP and when the config file changes,
+// the configuration profile is fetched from the servers if it is enabled.
+func (a *Agent) Run(ctx context.Context) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.stats.RunCollectBatchStats(ctx, a.cfg, a.mcs)
+
+	if err := a.startCollectors(ctx, a.cfg); err != nil {
+		return err
+	}
+
+	if a.sendQueue != nil {
+		go a.sendQueue.Enqueue(ctx, a.mcs, a.stats.ClearPollCount, a.stats.Undelivered)
+		a.startSender(ctx)
+	} else {
+		a.resizeWorkers(ctx, a.cfg.Limit)
+	}
+
+	go a.watchConfig(ctx)
+	go a.watchProfile(ctx)
+
+	return nil
+}
+
+// startSender - starts sending the batches from the queue one by one to keep their order.
+func (a *Agent) startSender(ctx context.Context) {
+	w, sctx := newWorker(ctx)
+
+	client := a.client
+	go func() {
+		defer close(w.done)
+		defer w.cancel()
+		a.sendQueue.Send(sctx, &trackedSender{sender: client, health: a.health})
+	}()
+
+	a.sender = w
+}
+
+// resizeWorkers - starts or stops the workers to match the limit.
+func (a *Agent) resizeWorkers(ctx context.Context, limit int) {
+	for len(a.workers) < limit {
+		w, wctx := newWorker(ctx)
+		go a.work(wctx, w, a.client)
+		a.workers = append(a.workers, w)
+	}
+
+	for len(a.workers) > limit {
+		last := len(a.workers) - 1
+		close(a.workers[last].stop)
+		a.workers = a.workers[:last]
+	}
+}
+
+// work - sends the batches until the worker is stopped.
+// The batch taken from the channel is always sent, so stopping the worker does not lose it,
+// the batch whose sending was cancelled is returned to the stats and reported again.
+// In the fan-out mode the batch that some servers have not received is resent only to them
+// before the next batch, so the other servers do not count it twice.
+func (a *Agent) work(ctx context.Context, w *worker, client metcoll.MetricUpdater) {
+	defer close(w.done)
+	defer w.cancel()
+
+	sender := &trackedSender{sender: client, health: a.health}
+	var pending []*pendingBatch
+	defer func() { a.dropPending(pending) }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stop:
+			return
+		case m := <-a.mcs:
+			pending = a.resend(ctx, sender, pending)
+
+			err := sender.BatchUpdate(ctx, m)
+			var ue *metcoll.UndeliveredError
+			switch {
+			case errors.As(err, &ue):
+				a.sl.Errorf("batch was not delivered to some servers, it is resent to them later, err: %v", err)
+				pending = a.keepPending(pending, &pendingBatch{mcs: m, servers: ue.Undelivered()})
+				a.stats.ClearPollCount()
+			case err != nil:
+				a.sl.Errorf("batch update metrics failed err: %v", err)
+				a.health.drop()
+				a.stats.Undelivered(m)
+			default:
+				a.stats.ClearPollCount()
+			}
+		}
+	}
+}
+
+// resend - sends the pending batches to the servers that have not received them.
+// The server that fails is not tried again until the next batch.
+func (a *Agent) resend(ctx context.Context, sender *trackedSender, pending []*pendingBatch) []*pendingBatch {
+	down := make(map[string]bool)
+	kept := pending[:0]
+	for _, p := range pending {
+		servers := p.servers[:0]
+		for _, server := range p.servers {
+			if down[server] {
+				servers = append(servers, server)
+				continue
+			}
+
+			err := sender.BatchUpdateTo(ctx, server, p.mcs)
+			switch {
+			case err == nil:
+			case permanent(err):
+				a.sl.Errorf("pending batch was rejected by server %s and dropped, err: %v", server, err)
+			default:
+				down[server] = true
+				servers = append(servers, server)
+			}
+		}
+
+		if len(servers) > 0 {
+			p.servers = servers
+			kept = append(kept, p)
+		}
+	}
+
+	return kept
+}
+
+// keepPending - adds the batch to the pending ones, the oldest batch is dropped if the limit is exceeded.
+func (a *Agent) keepPending(pending []*pendingBatch, p *pendingBatch) []*pendingBatch {
+	pending = append(pending, p)
+	if len(pending) > maxPendingBatches {
+		a.sl.Errorf("too many batches are pending, the oldest batch was not delivered to servers %v and was dropped",
+			pending[0].servers)
+		a.health.drop()
+		pending = pending[1:]
+	}
+
+	return pending
+}
+
+// dropPending - records the pending batches that the stopped worker has not delivered.
+func (a *Agent) dropPending(pending []*pendingBatch) {
+	for _, p := range pending {
+		a.sl.Errorf("pending batch was not delivered to servers %v and was dropped", p.servers)
+		a.health.drop()
+	}
+}
+
+// permanent - reports whether the server has rejected the batch, so resending it cannot help.
+func permanent(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// stopSending - stops the workers and the queue sender and waits until they are completed.
+// The sendings in progress are cancelled, so the waiting is not held by their retries.
+func (a *Agent) stopSending() {
+	workers := a.workers
+	if a.sender != nil {
+		workers = append(workers, a.sender)
+	}
+
+	for _, w := range workers {
+		close(w.stop)
+		w.cancel()
+	}
+	for _, w := range workers {
+		<-w.done
+	}
+
+	a.workers = nil
+	a.sender = nil
+}
+
+// swapClient - replaces the client, the batches are sent by the new client from now on.
+func (a *Agent) swapClient(ctx context.Context, client metcoll.MetricUpdater, limit int) {
+	a.stopSending()
+
+	if c, ok := a.client.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			a.sl.Errorf("closing the previous client was failed, err: %v", err)
+		}
+	}
+	a.client = client
+
+	if a.sendQueue != nil {
+		a.startSender(ctx)
+	} else {
+		a.resizeWorkers(ctx, limit)
+	}
+}
@@ -0,0 +1,426 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+type fakeClient struct {
+	mux     *sync.Mutex
+	profile *metcoll.Profile
+	err     error
+	// hang - the sending waits until the context is done, as the one retrying the unavailable server.
+	hang    bool
+	key     string
+	batches int
+	closed  bool
+}
+
+func (c *fakeClient) BatchUpdateMetric(ctx context.Context, mcs <-chan []*metrics.Metrics, result chan<- error) {
+}
+
+func (c *fakeClient) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	c.mux.Lock()
+	c.batches++
+	hang, err := c.hang, c.err
+	c.mux.Unlock()
+
+	if hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (c *fakeClient) OnResync(fn func()) {}
+
+func (c *fakeClient) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *fakeClient) AgentProfile(ctx context.Context, agentID string, labels []string) (*metcoll.Profile, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.profile == nil {
+		return nil, metcoll.ErrProfileNotFound
+	}
+	return c.profile, nil
+}
+
+func (c *fakeClient) setProfile(p *metcoll.Profile) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.profile = p
+}
+
+func (c *fakeClient) setErr(err error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.err = err
+}
+
+func (c *fakeClient) sent() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.batches
+}
+
+type fakeClients struct {
+	clients []*fakeClient
+}
+
+func (f *fakeClients) newClient(ctx context.Context,
+	cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (metcoll.MetricUpdater, error) {
+	c := &fakeClient{mux: &sync.Mutex{}, key: string(cfg.Key)}
+	f.clients = append(f.clients, c)
+	return c, nil
+}
+
+func testConfig() *configuration.ConfigAgent {
+	return &configuration.ConfigAgent{
+		Server:         "localhost:8080",
+		SendMode:       configuration.SendModeFailover,
+		PollInterval:   1,
+		ReportInterval: 1,
+		Limit:          2,
+	}
+}
+
+func TestNewAgent_IncorrectConfig(t *testing.T) {
+	cfg := testConfig()
+	cfg.Limit = 0
+
+	_, err := NewAgent(context.Background(), cfg, zap.S())
+	assert.Error(t, err)
+}
+
+func TestAgent_Reload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clients := &fakeClients{}
+	a, err := newAgent(ctx, testConfig(), zap.S(), clients.newClient)
+	require.NoError(t, err)
+	require.NoError(t, a.Run(ctx))
+	assert.Len(t, a.workers, 2)
+
+	t.Run("incorrect config is not applied", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Limit = 0
+		assert.Error(t, a.Reload(ctx, cfg))
+
+		cfg = testConfig()
+		cfg.Aggregate = []string{"Unknown"}
+		assert.Error(t, a.Reload(ctx, cfg))
+		assert.Len(t, a.workers, 2)
+	})
+
+	t.Run("workers are resized", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Limit = 4
+		require.NoError(t, a.Reload(ctx, cfg))
+		assert.Len(t, a.workers, 4)
+		assert.Len(t, clients.clients, 1)
+
+		cfg.Limit = 1
+		require.NoError(t, a.Reload(ctx, cfg))
+		assert.Len(t, a.workers, 1)
+	})
+
+	t.Run("client is replaced after the key change", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Limit = 1
+		cfg.Key = []byte("new key")
+		require.NoError(t, a.Reload(ctx, cfg))
+		require.Len(t, clients.clients, 2)
+		assert.True(t, clients.clients[0].closed)
+		assert.Equal(t, "new key", clients.clients[1].key)
+
+		a.mcs <- []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)}
+		assert.Eventually(t, func() bool {
+			return clients.clients[1].sent() > 0
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("client is replaced while the sending is retried", func(t *testing.T) {
+		clients.clients[1].mux.Lock()
+		clients.clients[1].hang = true
+		sent := clients.clients[1].batches
+		clients.clients[1].mux.Unlock()
+
+		a.mcs <- []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)}
+		require.Eventually(t, func() bool {
+			return clients.clients[1].sent() > sent
+		}, 5*time.Second, 10*time.Millisecond)
+
+		cfg := testConfig()
+		cfg.Limit = 1
+		cfg.Key = []byte("another key")
+		done := make(chan error, 1)
+		go func() { done <- a.Reload(ctx, cfg) }()
+
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("reload is held by the sending in progress")
+		}
+		require.Len(t, clients.clients, 3)
+		assert.True(t, clients.clients[1].closed)
+	})
+
+	t.Run("collectors are restarted", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Limit = 1
+		cfg.Key = []byte("another key")
+		cfg.ExecProbes = []configuration.ExecProbe{{Name: "echo", Command: []string{"echo", "probe 1"}}}
+		require.NoError(t, a.Reload(ctx, cfg))
+		require.Len(t, a.collectors, 1)
+		assert.Len(t, clients.clients, 3)
+
+		cfg = testConfig()
+		cfg.Limit = 1
+		cfg.Key = []byte("another key")
+		require.NoError(t, a.Reload(ctx, cfg))
+		assert.Empty(t, a.collectors)
+	})
+}
+
+func TestAgent_updateProfile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clients := &fakeClients{}
+	a, err := newAgent(ctx, testConfig(), zap.S(), clients.newClient)
+	require.NoError(t, err)
+	require.NoError(t, a.Run(ctx))
+	client := clients.clients[0]
+
+	t.Run("profile is applied", func(t *testing.T) {
+		client.setProfile(&metcoll.Profile{Name: "fast", Config: []byte(`{"poll_interval":"5s","rate_limit":3}`)})
+		require.NoError(t, a.updateProfile(ctx))
+		assert.Equal(t, 5, a.cfg.PollInterval)
+		assert.Len(t, a.workers, 3)
+		assert.Equal(t, 1, a.base.PollInterval)
+	})
+
+	t.Run("incorrect profile is not applied", func(t *testing.T) {
+		client.setProfile(&metcoll.Profile{Name: "fast", Config: []byte(`{"rate_limit":-1}`)})
+		assert.Error(t, a.updateProfile(ctx))
+		assert.Equal(t, 5, a.cfg.PollInterval)
+		assert.Equal(t, "fast", a.profile.Name)
+	})
+
+	t.Run("local configuration is applied after the profile removal", func(t *testing.T) {
+		client.setProfile(nil)
+		require.NoError(t, a.updateProfile(ctx))
+		assert.Equal(t, 1, a.cfg.PollInterval)
+		assert.Len(t, a.workers, 2)
+		assert.Nil(t, a.profile)
+	})
+}
+
+func TestAgent_Status(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := testConfig()
+	cfg.APIToken = "mct_secret"
+
+	clients := &fakeClients{}
+	a, err := newAgent(ctx, cfg, zap.S(), clients.newClient)
+	require.NoError(t, err)
+	a.resizeWorkers(ctx, 1)
+	client := clients.clients[0]
+
+	batch := []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)}
+	a.mcs <- batch
+	require.Eventually(t, func() bool {
+		return a.Status().Sent == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	client.setErr(errors.New("server is unavailable"))
+	a.mcs <- batch
+	require.Eventually(t, func() bool {
+		return a.Status().Failed == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	st := a.Status()
+	assert.Equal(t, int64(1), st.Dropped)
+	assert.False(t, st.LastSuccess.IsZero())
+	assert.Zero(t, st.QueueDepth)
+
+	t.Run("metrics are reported", func(t *testing.T) {
+		sm := &selfMetrics{agent: a}
+		values := func() map[string]*metrics.Metrics {
+			ms := make(map[string]*metrics.Metrics)
+			for _, m := range sm.Collect(ctx) {
+				ms[m.ID] = m
+			}
+			return ms
+		}
+
+		ms := values()
+		assert.Equal(t, int64(1), *ms[AgentBatchesSent].Delta)
+		assert.Equal(t, int64(1), *ms[AgentBatchesFailed].Delta)
+		assert.Equal(t, int64(1), *ms[AgentBatchesDropped].Delta)
+		assert.Equal(t, float64(st.LastSuccess.Unix()), *ms[AgentLastSendTime].Value)
+		assert.Contains(t, ms, AgentQueueDepth)
+		assert.Contains(t, ms, AgentSendLatency)
+
+		ms = values()
+		assert.Equal(t, int64(0), *ms[AgentBatchesSent].Delta)
+		assert.Equal(t, int64(0), *ms[AgentBatchesFailed].Delta)
+	})
+
+	t.Run("status endpoint", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", http.NoBody))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp struct {
+			Config map[string]any `json:"config"`
+			Status
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, int64(1), resp.Sent)
+		assert.Equal(t, int64(1), resp.Failed)
+		assert.Equal(t, "localhost:8080", resp.Config["address"])
+		assert.NotContains(t, rec.Body.String(), cfg.APIToken)
+	})
+}
+
+func TestHealth_responseHashMismatch(t *testing.T) {
+	ctx := context.Background()
+	h := newHealth()
+	client := &fakeClient{mux: &sync.Mutex{}}
+	batch := []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)}
+
+	client.setErr(errors.New("server is unavailable"))
+	assert.Error(t, h.track(ctx, client, batch))
+
+	client.setErr(&metcoll.DestinationError{Server: "localhost:8080", Err: metcoll.ErrResponseHash})
+	assert.ErrorIs(t, h.track(ctx, client, batch), metcoll.ErrResponseHash)
+
+	assert.Equal(t, int64(2), h.failed)
+	assert.Equal(t, int64(1), h.mismatches)
+}
+
+func TestAgent_Once(t *testing.T) {
+	ctx := context.Background()
+
+	clients := &fakeClients{}
+	a, err := newAgent(ctx, testConfig(), zap.S(), clients.newClient)
+	require.NoError(t, err)
+
+	t.Run("batch is sent", func(t *testing.T) {
+		require.NoError(t, a.Once(ctx))
+		assert.Equal(t, 1, clients.clients[0].sent())
+	})
+
+	t.Run("batch is not delivered", func(t *testing.T) {
+		clients.clients[0].setErr(errors.New("server is unavailable"))
+		assert.ErrorIs(t, a.Once(ctx), ErrNotDelivered)
+	})
+
+	t.Run("dry run prints the batch", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, a.DryRun(ctx, &buf, configuration.DryRunFormatJSON))
+		assert.Equal(t, 2, clients.clients[0].sent())
+
+		var mcs []*metrics.Metrics
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &mcs))
+		ids := make([]string, 0, len(mcs))
+		for _, m := range mcs {
+			ids = append(ids, m.ID)
+		}
+		assert.Contains(t, ids, "PollCount")
+		assert.Contains(t, ids, AgentBatchesFailed)
+	})
+
+	t.Run("client without wire format", func(t *testing.T) {
+		assert.Error(t, a.DryRun(ctx, io.Discard, configuration.DryRunFormatWire))
+	})
+}
+
+// fanoutClient - sends the batches to every server, the servers that are down fail.
+type fanoutClient struct {
+	fakeClient
+	down  map[string]bool
+	calls map[string]int
+}
+
+func (c *fanoutClient) Fanout() []string {
+	return []string{"a", "b"}
+}
+
+func (c *fanoutClient) BatchUpdateTo(ctx context.Context, server string, mcs []*metrics.Metrics) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.calls[server]++
+	if c.down[server] {
+		return errors.New("server is unavailable")
+	}
+	return nil
+}
+
+func TestAgent_resend(t *testing.T) {
+	ctx := context.Background()
+	a, err := newAgent(ctx, testConfig(), zap.S(), (&fakeClients{}).newClient)
+	require.NoError(t, err)
+
+	client := &fanoutClient{
+		fakeClient: fakeClient{mux: &sync.Mutex{}},
+		down:       map[string]bool{"b": true},
+		calls:      make(map[string]int),
+	}
+	sender := &trackedSender{sender: client, health: a.health}
+	batch := []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)}
+
+	pending := []*pendingBatch{
+		{mcs: batch, servers: []string{"b"}},
+		{mcs: batch, servers: []string{"a", "b"}},
+	}
+	pending = a.resend(ctx, sender, pending)
+	require.Len(t, pending, 2)
+	assert.Equal(t, []string{"b"}, pending[1].servers)
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, client.calls, "the server that is down is tried once")
+
+	client.down["b"] = false
+	pending = a.resend(ctx, sender, pending)
+	assert.Empty(t, pending)
+	assert.Equal(t, map[string]int{"a": 1, "b": 3}, client.calls, "the batches are resent only to the server b")
+
+	t.Run("oldest batch is dropped over the limit", func(t *testing.T) {
+		for i := 0; i <= maxPendingBatches; i++ {
+			pending = a.keepPending(pending, &pendingBatch{mcs: batch, servers: []string{"b"}})
+		}
+		assert.Len(t, pending, maxPendingBatches)
+		assert.Equal(t, int64(1), a.Status().Dropped)
+	})
+}
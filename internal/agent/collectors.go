@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/ArtemShalinFe/metcoll/internal/collector"
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/stats"
+)
+
+// collectorRun - the collector running in its own goroutine.
+type collectorRun struct {
+	collector stats.Collector
+	cancel    context.CancelFunc
+	done      chan struct{}
+	name      string
+}
+
+// runnable - the collector that polls its source until the context is done
+// or once for the one-shot modes.
+type runnable interface {
+	stats.Collector
+	Run(ctx context.Context)
+	Once(ctx context.Context)
+}
+
+// newCollectors - creates the collectors enabled in the configuration without starting them.
+func newCollectors(cfg *configuration.ConfigAgent, a *Agent) (map[string]runnable, error) {
+	cs := make(map[string]runnable)
+
+	if len(cfg.ScrapeTargets) > 0 {
+		scraper, err := collector.NewScraper(cfg, a.sl)
+		if err != nil {
+			return nil, fmt.Errorf("cannot init prometheus scraper err: %w", err)
+		}
+		cs["prometheus scraper"] = scraper
+	}
+
+	if len(cfg.ExecProbes) > 0 {
+		cs["exec collector"] = collector.NewExec(cfg, a.sl)
+	}
+
+	if len(cfg.LogTails) > 0 {
+		logs, err := collector.NewLogTail(cfg, a.sl)
+		if err != nil {
+			return nil, fmt.Errorf("cannot init log tailing err: %w", err)
+		}
+		cs["log tailing"] = logs
+	}
+
+	return cs, nil
+}
+
+func (a *Agent) startCollectors(ctx context.Context, cfg *configuration.ConfigAgent) error {
+	cs, err := newCollectors(cfg, a)
+	if err != nil {
+		return err
+	}
+	a.runCollectors(ctx, cs)
+
+	return nil
+}
+
+func (a *Agent) runCollectors(ctx context.Context, cs map[string]runnable) {
+	for name, c := range cs {
+		cctx, cancel := context.WithCancel(ctx)
+		run := &collectorRun{
+			collector: c,
+			cancel:    cancel,
+			done:      make(chan struct{}),
+			name:      name,
+		}
+
+		a.stats.AddCollector(c)
+		go func(c runnable) {
+			defer close(run.done)
+			c.Run(cctx)
+		}(c)
+
+		a.collectors = append(a.collectors, run)
+		a.sl.Infof("%s running", name)
+	}
+}
+
+// stopCollectors - stops the collectors and waits until they are completed.
+// The metrics they have accumulated are sent with the next report.
+func (a *Agent) stopCollectors() {
+	for _, run := range a.collectors {
+		run.cancel()
+		<-run.done
+		a.stats.RemoveCollector(run.collector)
+	}
+
+	a.collectors = nil
+}
+
+// collectorsConfig - the part of the configuration used by the collectors.
+type collectorsConfig struct {
+	ScrapePrefix  string
+	LogStatePath  string
+	ScrapeTargets []string
+	ScrapeInclude []string
+	ScrapeExclude []string
+	ExecProbes    []configuration.ExecProbe
+	LogTails      []configuration.LogTail
+	PollInterval  int
+}
+
+func collectorsChanged(prev, cfg *configuration.ConfigAgent) bool {
+	selectCfg := func(c *configuration.ConfigAgent) collectorsConfig {
+		return collectorsConfig{
+			ScrapePrefix:  c.ScrapePrefix,
+			LogStatePath:  c.LogStatePath,
+			ScrapeTargets: c.ScrapeTargets,
+			ScrapeInclude: c.ScrapeInclude,
+			ScrapeExclude: c.ScrapeExclude,
+			ExecProbes:    c.ExecProbes,
+			LogTails:      c.LogTails,
+			PollInterval:  c.PollInterval,
+		}
+	}
+
+	return !reflect.DeepEqual(selectCfg(prev), selectCfg(cfg))
+}
@@ -0,0 +1,3 @@
+// Package agent runs the collection of metrics and sends them to the metcoll servers.
+// The configuration of the agent can be reloaded without restart.
+package agent
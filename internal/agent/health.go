@@ -0,0 +1,220 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/queue"
+)
+
+const (
+	// AgentBatchesSent - count of the batches delivered to the servers.
+	AgentBatchesSent = "AgentBatchesSent"
+	// AgentBatchesFailed - count of the failed sendings of the batches.
+	AgentBatchesFailed = "AgentBatchesFailed"
+	// AgentResponseHashMismatches - count of the server responses whose hash is incorrect,
+	// the responses may have been changed on the way from the server.
+	AgentResponseHashMismatches = "AgentResponseHashMismatches"
+	// AgentBatchesDropped - count of the batches that were discarded without delivery.
+	AgentBatchesDropped = "AgentBatchesDropped"
+	// AgentBatchesRetried - count of the failed sendings that are retried from the send queue.
+	AgentBatchesRetried = "AgentBatchesRetried"
+	// AgentQueueDepth - count of the batches waiting for sending.
+	AgentQueueDepth = "AgentQueueDepth"
+	// AgentLastSendTime - unix time of the last successful sending.
+	AgentLastSendTime = "AgentLastSendTime"
+	// AgentSendLatency - duration of the last sending in seconds.
+	AgentSendLatency = "AgentSendLatency"
+)
+
+// health - results of the batch sendings.
+type health struct {
+	mux         *sync.Mutex
+	lastSuccess time.Time
+	latency     time.Duration
+	sent        int64
+	failed      int64
+	mismatches  int64
+	dropped     int64
+}
+
+func newHealth() *health {
+	return &health{mux: &sync.Mutex{}}
+}
+
+// track - sends the batch and records the result of the sending.
+func (h *health) track(ctx context.Context, s queue.Sender, mcs []*metrics.Metrics) error {
+	start := time.Now()
+	err := s.BatchUpdate(ctx, mcs)
+	latency := time.Since(start)
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.latency = latency
+	if err != nil {
+		h.failed++
+		if errors.Is(err, metcoll.ErrResponseHash) {
+			h.mismatches++
+		}
+		return err
+	}
+
+	h.sent++
+	h.lastSuccess = time.Now()
+	return nil
+}
+
+// drop - records the batch discarded without delivery.
+func (h *health) drop() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.dropped++
+}
+
+// trackedSender - the sender whose sendings are recorded.
+type trackedSender struct {
+	sender queue.Sender
+	health *health
+}
+
+func (t *trackedSender) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	return t.health.track(ctx, t.sender, mcs)
+}
+
+// Fanout - returns the servers if the sender sends the batches to all of them, nil otherwise.
+func (t *trackedSender) Fanout() []string {
+	if fs, ok := t.sender.(queue.FanoutSender); ok {
+		return fs.Fanout()
+	}
+	return nil
+}
+
+// BatchUpdateTo - sends the batch only to the server, the sender without the fan-out sends it as usual.
+func (t *trackedSender) BatchUpdateTo(ctx context.Context, server string, mcs []*metrics.Metrics) error {
+	fs, ok := t.sender.(queue.FanoutSender)
+	if !ok {
+		return t.BatchUpdate(ctx, mcs)
+	}
+
+	return t.health.track(ctx, senderFunc(func(ctx context.Context, mcs []*metrics.Metrics) error {
+		return fs.BatchUpdateTo(ctx, server, mcs)
+	}), mcs)
+}
+
+// senderFunc - the function that sends the batch.
+type senderFunc func(ctx context.Context, mcs []*metrics.Metrics) error
+
+func (f senderFunc) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	return f(ctx, mcs)
+}
+
+// Status - the health of the agent.
+type Status struct {
+	LastSuccess time.Time `json:"last_success"`
+	// Latency - duration of the last sending in seconds.
+	Latency    float64 `json:"send_latency"`
+	Sent       int64   `json:"batches_sent"`
+	Failed     int64   `json:"batches_failed"`
+	Mismatches int64   `json:"response_hash_mismatches"`
+	Dropped    int64   `json:"batches_dropped"`
+	Retried    int64   `json:"batches_retried"`
+	QueueDepth int     `json:"queue_depth"`
+}
+
+// Status - returns the health of the agent.
+func (a *Agent) Status() Status {
+	a.health.mux.Lock()
+	st := Status{
+		LastSuccess: a.health.lastSuccess,
+		Latency:     a.health.latency.Seconds(),
+		Sent:        a.health.sent,
+		Failed:      a.health.failed,
+		Mismatches:  a.health.mismatches,
+		Dropped:     a.health.dropped,
+	}
+	a.health.mux.Unlock()
+
+	st.Dropped += a.stats.Dropped()
+	if a.sendQueue != nil {
+		st.Dropped += a.sendQueue.Dropped()
+		st.Retried = a.sendQueue.Retried()
+		st.QueueDepth = a.sendQueue.Len()
+	} else {
+		st.QueueDepth = len(a.mcs)
+	}
+
+	return st
+}
+
+// selfMetrics - reports the health of the agent along with the runtime stats.
+type selfMetrics struct {
+	agent    *Agent
+	reported Status
+}
+
+// Collect - returns the counters increased since the previous call and the current gauges.
+func (s *selfMetrics) Collect(_ context.Context) []*metrics.Metrics {
+	st := s.agent.Status()
+	prev := s.reported
+	s.reported = st
+
+	ms := []*metrics.Metrics{
+		metrics.NewCounterMetric(AgentBatchesSent, st.Sent-prev.Sent),
+		metrics.NewCounterMetric(AgentBatchesFailed, st.Failed-prev.Failed),
+		metrics.NewCounterMetric(AgentResponseHashMismatches, st.Mismatches-prev.Mismatches),
+		metrics.NewCounterMetric(AgentBatchesDropped, st.Dropped-prev.Dropped),
+		metrics.NewCounterMetric(AgentBatchesRetried, st.Retried-prev.Retried),
+		metrics.NewGaugeMetric(AgentQueueDepth, float64(st.QueueDepth)),
+		metrics.NewGaugeMetric(AgentSendLatency, st.Latency),
+	}
+	if !st.LastSuccess.IsZero() {
+		ms = append(ms, metrics.NewGaugeMetric(AgentLastSendTime, float64(st.LastSuccess.Unix())))
+	}
+
+	return ms
+}
+
+// statusResponse - the health of the agent along with its effective configuration.
+type statusResponse struct {
+	Config *configuration.ConfigAgent `json:"config"`
+	Status
+}
+
+// StatusHandler - returns the handler of the local status endpoint GET /status.
+func (a *Agent) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		a.mux.Lock()
+		cfg := a.cfg
+		a.mux.Unlock()
+
+		b, err := json.Marshal(statusResponse{Status: a.Status(), Config: cfg})
+		if err != nil {
+			a.sl.Errorf("an error occurred while marshal agent status to json error: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			a.sl.Errorf("an error occurred while writing the agent status, err: %v", err)
+		}
+	})
+
+	return mux
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+// ErrNotDelivered - error occurs when the single batch was not delivered to any server.
+var ErrNotDelivered = errors.New("the batch was not delivered")
+
+// collectOnce - polls the runtime stats and the collectors once and returns the batch.
+func (a *Agent) collectOnce(ctx context.Context) ([]*metrics.Metrics, error) {
+	a.mux.Lock()
+	cfg := a.cfg
+	a.mux.Unlock()
+
+	cs, err := newCollectors(cfg, a)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range cs {
+		c.Once(ctx)
+		a.stats.AddCollector(c)
+	}
+
+	return a.stats.CollectBatch(ctx), nil
+}
+
+// Once - collects a single batch and sends it to the servers.
+// ErrNotDelivered is returned if the batch was not delivered to any server.
+func (a *Agent) Once(ctx context.Context) error {
+	mcs, err := a.collectOnce(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := a.health.track(ctx, a.client, mcs); err != nil {
+		var ue *metcoll.UndeliveredError
+		if !errors.As(err, &ue) {
+			return fmt.Errorf("%w, err: %w", ErrNotDelivered, err)
+		}
+		a.sl.Errorf("batch was not delivered to some servers, err: %v", err)
+	}
+	a.sl.Infof("batch of %d metrics was sent", len(mcs))
+
+	return nil
+}
+
+// DryRun - collects a single batch and writes it without contacting the servers.
+// The batch is written as JSON or as the request the client sends to the servers.
+func (a *Agent) DryRun(ctx context.Context, w io.Writer, format string) error {
+	mcs, err := a.collectOnce(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "", configuration.DryRunFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(mcs); err != nil {
+			return fmt.Errorf("cannot write the batch as json, err: %w", err)
+		}
+	case configuration.DryRunFormatWire:
+		ww, ok := a.client.(metcoll.WireWriter)
+		if !ok {
+			return errors.New("the client does not support the wire format")
+		}
+		if err := ww.WriteWire(ctx, w, mcs); err != nil {
+			return fmt.Errorf("cannot write the batch in the wire format, err: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown dry run format: %s", format)
+	}
+
+	return nil
+}
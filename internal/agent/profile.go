@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
+)
+
+// watchProfile - fetches the configuration profile from the servers and applies it when it changes.
+// The first profile is fetched right after the start.
+func (a *Agent) watchProfile(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		a.mux.Lock()
+		interval := time.Duration(a.cfg.ProfileInterval) * time.Second
+		a.mux.Unlock()
+
+		if interval == 0 {
+			timer.Reset(configCheckInterval)
+			continue
+		}
+
+		if err := a.updateProfile(ctx); err != nil {
+			a.sl.Errorf("the agent profile was not applied, err: %v", err)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// updateProfile - applies the profile received from the servers to the local configuration.
+// If the profile was removed on the servers, the local configuration is applied.
+func (a *Agent) updateProfile(ctx context.Context) error {
+	a.mux.Lock()
+	client, base, current := a.client, a.base, a.profile
+	a.mux.Unlock()
+
+	fetcher, ok := client.(metcoll.ProfileFetcher)
+	if !ok {
+		return errors.New("the client does not support agent profiles")
+	}
+
+	p, err := fetcher.AgentProfile(ctx, base.ID(), base.Labels)
+	if errors.Is(err, metcoll.ErrProfileNotFound) {
+		if current == nil {
+			return nil
+		}
+
+		a.sl.Infof("agent profile %s was removed, the local configuration is applied", current.Name)
+		if err := a.Reload(ctx, base); err != nil {
+			return err
+		}
+		a.setProfile(nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot fetch the agent profile, err: %w", err)
+	}
+
+	if current != nil && current.Name == p.Name && bytes.Equal(current.Config, p.Config) {
+		return nil
+	}
+
+	cfg, err := base.WithProfile(p.Config)
+	if err != nil {
+		return fmt.Errorf("cannot apply the agent profile %s, err: %w", p.Name, err)
+	}
+
+	if err := a.Reload(ctx, cfg); err != nil {
+		return err
+	}
+	a.setProfile(p)
+	a.sl.Infof("agent profile %s was applied", p.Name)
+
+	return nil
+}
+
+func (a *Agent) setProfile(p *metcoll.Profile) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.profile = p
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metcoll"
+)
+
+// configCheckInterval - how often the config file is checked for changes.
+const configCheckInterval = 5 * time.Second
+
+// watchConfig - reloads the configuration on SIGHUP and when the config file changes.
+func (a *Agent) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+
+	a.mux.Lock()
+	path := a.cfg.Path
+	a.mux.Unlock()
+
+	last := fileVersion(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			a.sl.Info("SIGHUP received, reloading the configuration")
+		case <-ticker.C:
+			v := fileVersion(path)
+			if v == last {
+				continue
+			}
+			last = v
+			a.sl.Infof("config file %s has changed, reloading the configuration", path)
+		}
+
+		if err := a.reload(ctx); err != nil {
+			a.sl.Errorf("the configuration was not reloaded, err: %v", err)
+		}
+	}
+}
+
+// fileVersion - returns the modification time and the size of the file, empty if the file is unavailable.
+func fileVersion(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// reload - reads the local configuration again, the fetched profile is applied over it.
+func (a *Agent) reload(ctx context.Context) error {
+	a.mux.Lock()
+	base, p := a.base, a.profile
+	a.mux.Unlock()
+
+	base, err := base.Reload()
+	if err != nil {
+		return err
+	}
+
+	cfg := base
+	if p != nil {
+		cfg, err = base.WithProfile(p.Config)
+		if err != nil {
+			return fmt.Errorf("cannot apply the agent profile %s, err: %w", p.Name, err)
+		}
+	}
+
+	if err := a.Reload(ctx, cfg); err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	a.base = base
+	a.mux.Unlock()
+
+	return nil
+}
+
+// Reload - applies the new configuration. The intervals, the rate limit, the keys,
+// the servers and the collectors are changed without losing the collected and queued metrics.
+// If the configuration cannot be applied, the agent keeps working with the previous one.
+func (a *Agent) Reload(ctx context.Context, cfg *configuration.ConfigAgent) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("agent configuration is incorrect, err: %w", err)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	prev := a.cfg
+	a.warnRestartRequired(prev, cfg)
+
+	var client metcoll.MetricUpdater
+	if clientChanged(prev, cfg) {
+		c, err := a.initClient(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		client = c
+	}
+
+	var cs map[string]runnable
+	if collectorsChanged(prev, cfg) {
+		var err error
+		cs, err = newCollectors(cfg, a)
+		if err != nil {
+			closeClient(client)
+			return err
+		}
+	}
+
+	if err := a.stats.SetAggregations(cfg.Aggregate); err != nil {
+		closeClient(client)
+		return fmt.Errorf("cannot set report window aggregations err: %w", err)
+	}
+
+	a.stats.SetIntervals(cfg)
+	if deltaChanged(prev, cfg) {
+		a.setDeltaReporting(cfg)
+	}
+
+	if client != nil {
+		a.swapClient(ctx, client, cfg.Limit)
+	} else if a.sendQueue == nil {
+		a.resizeWorkers(ctx, cfg.Limit)
+	}
+
+	if cs != nil {
+		a.stopCollectors()
+		a.runCollectors(ctx, cs)
+	}
+
+	a.cfg = cfg
+	a.sl.Infof("agent configuration was reloaded: %s", cfg)
+
+	return nil
+}
+
+func closeClient(client metcoll.MetricUpdater) {
+	if c, ok := client.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+func clientChanged(prev, cfg *configuration.ConfigAgent) bool {
+	return prev.Server != cfg.Server ||
+		!reflect.DeepEqual(prev.Servers, cfg.Servers) ||
+		prev.SendMode != cfg.SendMode ||
+		!bytes.Equal(prev.Key, cfg.Key) ||
+		prev.PublicCryptoKey != cfg.PublicCryptoKey ||
+		prev.CertFilePath != cfg.CertFilePath ||
+		prev.UseProtobuff != cfg.UseProtobuff ||
+		prev.ID() != cfg.ID() ||
+		prev.ReportInterval != cfg.ReportInterval ||
+		prev.RetryMax != cfg.RetryMax ||
+		prev.RetryWaitMin != cfg.RetryWaitMin ||
+		prev.RetryWaitMax != cfg.RetryWaitMax ||
+		prev.AgentIP != cfg.AgentIP ||
+		prev.AgentInterface != cfg.AgentInterface ||
+		prev.AgentSubnet != cfg.AgentSubnet ||
+		prev.SigningKey != cfg.SigningKey ||
+		prev.APIToken != cfg.APIToken ||
+		prev.Tenant != cfg.Tenant ||
+		prev.UseTLS != cfg.UseTLS ||
+		prev.TLSCA != cfg.TLSCA ||
+		prev.TLSCert != cfg.TLSCert ||
+		prev.TLSKey != cfg.TLSKey ||
+		prev.TLSServerName != cfg.TLSServerName
+}
+
+func deltaChanged(prev, cfg *configuration.ConfigAgent) bool {
+	return prev.DeltaOnly != cfg.DeltaOnly ||
+		prev.DeltaAbsolute != cfg.DeltaAbsolute ||
+		prev.DeltaRelative != cfg.DeltaRelative ||
+		prev.ResyncReports != cfg.ResyncReports
+}
+
+// warnRestartRequired - logs the changed settings that are applied only after the restart of the agent.
+func (a *Agent) warnRestartRequired(prev, cfg *configuration.ConfigAgent) {
+	if prev.IngestAddress != cfg.IngestAddress || prev.IngestSocket != cfg.IngestSocket {
+		a.sl.Info("ingest listeners have changed, the agent must be restarted to apply them")
+	}
+
+	if prev.QueuePath != cfg.QueuePath || prev.QueueMaxSize != cfg.QueueMaxSize || prev.QueueFsync != cfg.QueueFsync {
+		a.sl.Info("send queue settings have changed, the agent must be restarted to apply them")
+	}
+
+	if a.sendQueue != nil && prev.Limit != cfg.Limit {
+		a.sl.Info("rate limit has changed, it is not used while the send queue is enabled, " +
+			"the queued batches are sent one by one")
+	}
+
+	if prev.StatusAddress != cfg.StatusAddress {
+		a.sl.Info("status address has changed, the agent must be restarted to apply it")
+	}
+}
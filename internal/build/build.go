@@ -45,6 +45,11 @@ func (b *Build) String() string {
 	return fmt.Sprintf(infoTemplate(), b.buildVersion, b.buildDate, b.buildCommit)
 }
 
+// Version - returns the version of the build.
+func (b *Build) Version() string {
+	return b.buildVersion
+}
+
 func infoTemplate() string {
 	return "Build version: %s; Build date: %s; Build commit: %s;"
 }
@@ -16,10 +16,12 @@ func TestInfo(t *testing.T) {
 		buildDate    string
 		buildCommit  string
 		want         string
+		wantVersion  string
 	}{
 		{
-			name: "case #1",
-			want: fmt.Sprintf(infoTemplate(), notAvailable, notAvailable, notAvailable),
+			name:        "case #1",
+			want:        fmt.Sprintf(infoTemplate(), notAvailable, notAvailable, notAvailable),
+			wantVersion: notAvailable,
 		},
 		{
 			name:         "case #2",
@@ -27,6 +29,7 @@ func TestInfo(t *testing.T) {
 			buildDate:    date,
 			buildCommit:  "test",
 			want:         fmt.Sprintf(infoTemplate(), "1.0", date, "test"),
+			wantVersion:  "1.0",
 		},
 	}
 	for _, tt := range tests {
@@ -40,6 +43,9 @@ func TestInfo(t *testing.T) {
 			if got := b.String(); got != tt.want {
 				t.Errorf("b.String() = %v, want %v", got, tt.want)
 			}
+			if got := b.Version(); got != tt.wantVersion {
+				t.Errorf("b.Version() = %v, want %v", got, tt.wantVersion)
+			}
 		})
 	}
 }
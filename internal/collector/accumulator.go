@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+// accumulator - stores the collected values between reports.
+// Counters are summed up and reset after each report, gauges keep the last value.
+type accumulator struct {
+	mux      *sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+}
+
+func newAccumulator() *accumulator {
+	return &accumulator{
+		mux:      &sync.Mutex{},
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+func (a *accumulator) addCounter(id string, delta int64) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.counters[id] += delta
+}
+
+func (a *accumulator) setGauge(id string, value float64) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	a.gauges[id] = value
+}
+
+// add - stores the metrics of a known type, others are ignored.
+func (a *accumulator) add(ms []*metrics.Metrics) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, m := range ms {
+		switch m.MType {
+		case metrics.CounterMetric:
+			a.counters[m.ID] += *m.Delta
+		case metrics.GaugeMetric:
+			a.gauges[m.ID] = *m.Value
+		}
+	}
+}
+
+// Collect - returns the accumulated metrics. Counters are reset after the call,
+// the stats keep the deltas of the report that was not delivered and send them with the next one.
+func (a *accumulator) Collect(_ context.Context) []*metrics.Metrics {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	ms := make([]*metrics.Metrics, 0, len(a.counters)+len(a.gauges))
+	for id, delta := range a.counters {
+		ms = append(ms, metrics.NewCounterMetric(id, delta))
+	}
+	for id, value := range a.gauges {
+		ms = append(ms, metrics.NewGaugeMetric(id, value))
+	}
+
+	a.counters = make(map[string]int64)
+
+	return ms
+}
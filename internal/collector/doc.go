@@ -0,0 +1,3 @@
+// Package collector contains additional sources of metrics for the agent.
+// Collected metrics are sent to the server together with the runtime stats.
+package collector
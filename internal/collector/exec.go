@@ -0,0 +1,247 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+const (
+	// ExecProbeFailures - count of the probe runs that failed to start, timed out or exited with non-zero code.
+	ExecProbeFailures = "ExecProbeFailures"
+	// ExecProbeParseErrors - count of the probe output lines that could not be parsed.
+	ExecProbeParseErrors = "ExecProbeParseErrors"
+	// ExecProbeExitCode - exit code of the last probe run.
+	ExecProbeExitCode = "ExecProbeExitCode"
+)
+
+// Exec - runs the configured commands at their own interval and parses their stdout as metrics.
+//
+// Supported output formats:
+//
+//	name value
+//	name:type:value
+//	[{"id":"name","type":"gauge","value":1}]
+type Exec struct {
+	*accumulator
+	sl              *zap.SugaredLogger
+	probes          []configuration.ExecProbe
+	defaultInterval time.Duration
+}
+
+// NewExec - Object constructor.
+func NewExec(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) *Exec {
+	interval := time.Duration(cfg.PollInterval) * time.Second
+	if interval <= 0 {
+		const defaultInterval = 2 * time.Second
+		interval = defaultInterval
+	}
+
+	return &Exec{
+		accumulator:     newAccumulator(),
+		sl:              sl,
+		probes:          cfg.ExecProbes,
+		defaultInterval: interval,
+	}
+}
+
+// Run - runs every probe at its interval until the context is done.
+func (e *Exec) Run(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, p := range e.probes {
+		wg.Add(1)
+		go func(p configuration.ExecProbe) {
+			defer wg.Done()
+			e.runProbe(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// Once - runs every probe once and waits until they are completed.
+func (e *Exec) Once(ctx context.Context) {
+	wg := &sync.WaitGroup{}
+	for _, p := range e.probes {
+		wg.Add(1)
+		go func(p configuration.ExecProbe) {
+			defer wg.Done()
+			e.Probe(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (e *Exec) runProbe(ctx context.Context, p configuration.ExecProbe) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = e.defaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.Probe(ctx, p)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe - executes the probe command once and stores the parsed metrics.
+func (e *Exec) Probe(ctx context.Context, p configuration.ExecProbe) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = p.Interval
+	}
+	if timeout == 0 {
+		timeout = e.defaultInterval
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	failures := selfMetricID(ExecProbeFailures, p.Name)
+	parseErrors := selfMetricID(ExecProbeParseErrors, p.Name)
+
+	cmd := exec.CommandContext(pctx, p.Command[0], p.Command[1:]...) //nolint:gosec // commands come from the agent config
+	setProcessGroup(cmd)
+	// the children of the probe may keep its output open after the probe has exited or was killed.
+	cmd.WaitDelay = timeout
+
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		e.sl.Infof("exec probe %s has exited, but its children kept the output open, they were killed", p.Name)
+		killProcessGroup(cmd)
+		err = nil
+	}
+	e.setGauge(selfMetricID(ExecProbeExitCode, p.Name), float64(cmd.ProcessState.ExitCode()))
+	if err != nil {
+		e.addCounter(failures, 1)
+		e.addCounter(parseErrors, 0)
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			e.sl.Errorf("exec probe %s exited with code %d, stderr: %s",
+				p.Name, exitErr.ExitCode(), strings.TrimSpace(string(exitErr.Stderr)))
+		} else {
+			e.sl.Errorf("exec probe %s was failed, err: %v", p.Name, err)
+		}
+		return
+	}
+
+	ms, errs := parseProbeOutput(out)
+	for _, err := range errs {
+		e.sl.Errorf("exec probe %s output, err: %v", p.Name, err)
+	}
+
+	e.add(ms)
+	e.addCounter(failures, 0)
+	e.addCounter(parseErrors, int64(len(errs)))
+}
+
+// selfMetricID - returns the ID of the agent self-metric for the probe.
+func selfMetricID(metric string, probe string) string {
+	return sanitizeID(metric + "_" + probe)
+}
+
+// parseProbeOutput - parses the probe output. Valid metrics are returned even if some lines are incorrect.
+func parseProbeOutput(out []byte) ([]*metrics.Metrics, []error) {
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' || trimmed[0] == '{' {
+		return parseProbeJSON(trimmed)
+	}
+
+	var ms []*metrics.Metrics
+	var errs []error
+
+	sc := bufio.NewScanner(bytes.NewReader(trimmed))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m, err := parseProbeLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n, err))
+			continue
+		}
+		ms = append(ms, m)
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("an error occured while reading output, err: %w", err))
+	}
+
+	return ms, errs
+}
+
+// parseProbeLine - parses the line in the format `name value` or `name:type:value`.
+// Metrics without type are gauges.
+func parseProbeLine(line string) (*metrics.Metrics, error) {
+	const typedParts = 3
+	if parts := strings.Split(line, ":"); len(parts) == typedParts {
+		m, err := metrics.NewMetric(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]))
+		if err != nil {
+			return nil, fmt.Errorf("line %q is incorrect, err: %w", line, err)
+		}
+		return m, validate(m)
+	}
+
+	const plainParts = 2
+	fields := strings.Fields(line)
+	if len(fields) != plainParts {
+		return nil, fmt.Errorf("line %q has unknown format", line)
+	}
+
+	m, err := metrics.NewMetric(fields[0], metrics.GaugeMetric, fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("line %q is incorrect, err: %w", line, err)
+	}
+
+	return m, validate(m)
+}
+
+func parseProbeJSON(out []byte) ([]*metrics.Metrics, []error) {
+	var ms []*metrics.Metrics
+	if out[0] == '{' {
+		var m metrics.Metrics
+		if err := json.Unmarshal(out, &m); err != nil {
+			return nil, []error{fmt.Errorf("unmarshal output was failed, err: %w", err)}
+		}
+		ms = append(ms, &m)
+	} else if err := json.Unmarshal(out, &ms); err != nil {
+		return nil, []error{fmt.Errorf("unmarshal output was failed, err: %w", err)}
+	}
+
+	valid := make([]*metrics.Metrics, 0, len(ms))
+	var errs []error
+	for _, m := range ms {
+		if err := validate(m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		valid = append(valid, m)
+	}
+
+	return valid, errs
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		out      string
+		want     map[string]string
+		wantErrs int
+	}{
+		{
+			name: "plain",
+			out:  "disk_free 12.5\n# comment\n\nload 1\n",
+			want: map[string]string{"disk_free": "gauge 12.5", "load": "gauge 1"},
+		},
+		{
+			name: "typed",
+			out:  "requests:counter:3\ntemp:gauge:36.6\nbad:summary:1\nvalue_only\n",
+			want: map[string]string{
+				"requests": "counter 3",
+				"temp":     "gauge 36.6",
+			},
+			wantErrs: 2,
+		},
+		{
+			name:     "non-finite values",
+			out:      "nan NaN\ninf:gauge:+Inf\nload 1\n",
+			want:     map[string]string{"load": "gauge 1"},
+			wantErrs: 2,
+		},
+		{
+			name: "json array",
+			out:  `[{"id":"c","type":"counter","delta":2},{"id":"g","type":"gauge"}]`,
+			want: map[string]string{"c": "counter 2"},
+
+			wantErrs: 1,
+		},
+		{
+			name: "json object",
+			out:  `{"id":"g","type":"gauge","value":0.5}`,
+			want: map[string]string{"g": "gauge 0.5"},
+		},
+		{
+			name:     "broken json",
+			out:      `[{"id":`,
+			want:     map[string]string{},
+			wantErrs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms, errs := parseProbeOutput([]byte(tt.out))
+
+			got := make(map[string]string)
+			for _, m := range ms {
+				got[m.ID] = m.MType + " " + m.String()
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Len(t, errs, tt.wantErrs)
+		})
+	}
+}
+
+func TestExec_Probe(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.ConfigAgent{
+		ExecProbes: []configuration.ExecProbe{
+			{Name: "ok", Command: []string{"sh", "-c", "echo 'probe_value 7'; echo 'broken line here'"}},
+			{Name: "fail", Command: []string{"sh", "-c", "exit 3"}},
+			{Name: "slow", Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond},
+		},
+	}
+	e := NewExec(cfg, zap.S())
+	for _, p := range cfg.ExecProbes {
+		e.Probe(ctx, p)
+	}
+
+	got := make(map[string]string)
+	for _, m := range e.Collect(ctx) {
+		got[m.ID] = m.String()
+	}
+
+	assert.Equal(t, "7", got["probe_value"])
+	assert.Equal(t, "1", got["ExecProbeParseErrors_ok"])
+	assert.Equal(t, "0", got["ExecProbeFailures_ok"])
+	assert.Equal(t, "0", got["ExecProbeExitCode_ok"])
+	assert.Equal(t, "1", got["ExecProbeFailures_fail"])
+	assert.Equal(t, "3", got["ExecProbeExitCode_fail"])
+	assert.Equal(t, "1", got["ExecProbeFailures_slow"])
+}
+
+func TestExec_ProbeChildren(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.ConfigAgent{
+		ExecProbes: []configuration.ExecProbe{
+			{Name: "child", Command: []string{"sh", "-c", "sleep 600 & echo x 1"}, Timeout: 100 * time.Millisecond},
+			{Name: "hung", Command: []string{"sh", "-c", "sleep 600 & sleep 600"}, Timeout: 100 * time.Millisecond},
+		},
+	}
+	e := NewExec(cfg, zap.S())
+
+	for _, p := range cfg.ExecProbes {
+		start := time.Now()
+		e.Probe(ctx, p)
+		assert.Less(t, time.Since(start), 5*time.Second, "probe %s is held by its children", p.Name)
+	}
+
+	got := make(map[string]string)
+	for _, m := range e.Collect(ctx) {
+		got[m.ID] = m.String()
+	}
+
+	assert.Equal(t, "1", got["x"])
+	assert.Equal(t, "0", got["ExecProbeFailures_child"])
+	assert.Equal(t, "1", got["ExecProbeFailures_hung"])
+}
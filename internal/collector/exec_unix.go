@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package collector
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup - runs the probe in its own process group, so the cancelled probe is killed with its children.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// killProcessGroup - kills the children that the exited probe has left running.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package collector
+
+import "os/exec"
+
+// setProcessGroup - the cancelled probe is killed by the default cancellation of the command.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup - the children of the probe are not tracked on windows.
+func killProcessGroup(cmd *exec.Cmd) {}
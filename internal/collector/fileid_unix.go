@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package collector
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity - returns the inode of the file, it is used to detect rotation after the agent restart.
+func fileIdentity(info os.FileInfo) uint64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return uint64(st.Ino) //nolint:unconvert // the inode type differs between platforms
+}
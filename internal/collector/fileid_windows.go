@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package collector
+
+import (
+	"os"
+)
+
+// fileIdentity - the file identity is not available, rotation is detected by the file size only.
+func fileIdentity(info os.FileInfo) uint64 {
+	return 0
+}
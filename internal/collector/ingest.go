@@ -0,0 +1,194 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/compress"
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+// errEmptyMetricID - error occurs when the pushed metric has no ID.
+var errEmptyMetricID = errors.New("metric ID is empty")
+
+// Ingest - accepts metrics pushed by applications on the same host.
+// Counters are aggregated between reports, gauges keep the last value.
+type Ingest struct {
+	*accumulator
+	server  *http.Server
+	sl      *zap.SugaredLogger
+	address string
+	socket  string
+}
+
+// NewIngest - Object constructor.
+func NewIngest(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) *Ingest {
+	i := &Ingest{
+		accumulator: newAccumulator(),
+		sl:          sl,
+		address:     cfg.IngestAddress,
+		socket:      cfg.IngestSocket,
+	}
+	i.server = &http.Server{
+		Handler: i.router(),
+	}
+
+	return i
+}
+
+func (i *Ingest) router() *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(compress.CompressMiddleware)
+	router.Use(middleware.Recoverer)
+
+	router.Post("/update/", func(w http.ResponseWriter, r *http.Request) {
+		var m metrics.Metrics
+		if err := readJSON(r.Body, &m); err != nil {
+			i.sl.Infof("ingest cannot read metric, err: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		i.ingestRequest(w, []*metrics.Metrics{&m})
+	})
+
+	router.Post("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		var ms []*metrics.Metrics
+		if err := readJSON(r.Body, &ms); err != nil {
+			i.sl.Infof("ingest cannot read metrics, err: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		i.ingestRequest(w, ms)
+	})
+
+	return router
+}
+
+func (i *Ingest) ingestRequest(w http.ResponseWriter, ms []*metrics.Metrics) {
+	if err := i.Add(ms); err != nil {
+		i.sl.Infof("ingest rejected metrics, err: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func readJSON(body io.ReadCloser, v any) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("read body was failed, err: %w", err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unmarshal body was failed, err: %w", err)
+	}
+
+	return nil
+}
+
+// Add - validates the metrics and accumulates them until the next report.
+// The batch is rejected as a whole if at least one metric is invalid.
+func (i *Ingest) Add(ms []*metrics.Metrics) error {
+	for _, m := range ms {
+		if err := validate(m); err != nil {
+			return err
+		}
+	}
+
+	i.add(ms)
+
+	return nil
+}
+
+func validate(m *metrics.Metrics) error {
+	if m == nil {
+		return errors.New("metric is empty")
+	}
+
+	if strings.TrimSpace(m.ID) == "" {
+		return errEmptyMetricID
+	}
+
+	switch m.MType {
+	case metrics.CounterMetric:
+		if m.Delta == nil {
+			return fmt.Errorf("counter %s has nil delta", m.ID)
+		}
+	case metrics.GaugeMetric:
+		if m.Value == nil {
+			return fmt.Errorf("gauge %s has nil value", m.ID)
+		}
+		// NaN and Inf cannot be marshaled to JSON, so they would break the whole batch.
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return fmt.Errorf("gauge %s has non-finite value %v", m.ID, *m.Value)
+		}
+	default:
+		return fmt.Errorf("metric %s has unknow type: %s", m.ID, m.MType)
+	}
+
+	return nil
+}
+
+// ListenAndServe - starts the listeners on the configured address and unix socket.
+func (i *Ingest) ListenAndServe() error {
+	var listeners []net.Listener
+
+	if i.address != "" {
+		l, err := net.Listen("tcp", i.address)
+		if err != nil {
+			return fmt.Errorf("ingest cannot listen address %s, err: %w", i.address, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if i.socket != "" {
+		if err := os.Remove(i.socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("ingest cannot remove stale socket %s, err: %w", i.socket, err)
+		}
+
+		l, err := net.Listen("unix", i.socket)
+		if err != nil {
+			return fmt.Errorf("ingest cannot listen socket %s, err: %w", i.socket, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- i.server.Serve(l)
+		}(l)
+	}
+
+	for range listeners {
+		if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("ingest listen and serve err: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown - gracefully stops the listeners.
+func (i *Ingest) Shutdown(ctx context.Context) error {
+	if err := i.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("ingest shutdown err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+func TestIngest_Router(t *testing.T) {
+	i := NewIngest(&configuration.ConfigAgent{}, zap.S())
+	ts := httptest.NewServer(i.router())
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		body   string
+		status int
+	}{
+		{
+			name:   "batch",
+			url:    "/updates/",
+			body:   `[{"id":"c1","type":"counter","delta":2},{"id":"g1","type":"gauge","value":1.5}]`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "single counter",
+			url:    "/update/",
+			body:   `{"id":"c1","type":"counter","delta":3}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "single gauge",
+			url:    "/update/",
+			body:   `{"id":"g1","type":"gauge","value":2.5}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "unknow type",
+			url:    "/updates/",
+			body:   `[{"id":"c2","type":"counter","delta":1},{"id":"s1","type":"summary","value":1}]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "nil value",
+			url:    "/update/",
+			body:   `{"id":"g2","type":"gauge"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "empty id",
+			url:    "/update/",
+			body:   `{"id":" ","type":"gauge","value":1}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "bad json",
+			url:    "/updates/",
+			body:   `[{"id":`,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tt.url, bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("cannot create request, err: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatalf("request failed, err: %v", err)
+			}
+			defer func() {
+				if err := resp.Body.Close(); err != nil {
+					t.Errorf("cannot close body, err: %v", err)
+				}
+			}()
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+
+	got := make(map[string]string)
+	for _, m := range i.Collect(context.Background()) {
+		got[m.ID] = m.String()
+	}
+	assert.Equal(t, map[string]string{"c1": "5", "g1": "2.5"}, got)
+
+	got = make(map[string]string)
+	for _, m := range i.Collect(context.Background()) {
+		got[m.ID] = m.String()
+	}
+	assert.Equal(t, map[string]string{"g1": "2.5"}, got, "counters must be reset, gauges keep the last value")
+}
+
+func TestIngest_Add(t *testing.T) {
+	i := NewIngest(&configuration.ConfigAgent{}, zap.S())
+
+	err := i.Add([]*metrics.Metrics{
+		metrics.NewCounterMetric("c1", 1),
+		{ID: "c2", MType: metrics.CounterMetric},
+	})
+	assert.Error(t, err)
+	assert.Empty(t, i.Collect(context.Background()), "invalid batch must be rejected as a whole")
+}
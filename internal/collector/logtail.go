@@ -0,0 +1,364 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+// LogTail - tails the configured files and derives metrics from the lines matching the rules.
+// The files are reopened after rotation and reread after truncation.
+type LogTail struct {
+	*accumulator
+	sl        *zap.SugaredLogger
+	statePath string
+	files     []*tailedFile
+	interval  time.Duration
+	// restored - the saved offsets are applied, they are read on the first poll.
+	restored bool
+}
+
+type logRule struct {
+	re     *regexp.Regexp
+	metric string
+	mtype  string
+	group  int
+}
+
+type tailedFile struct {
+	file   *os.File
+	info   os.FileInfo
+	state  *tailState
+	path   string
+	rules  []logRule
+	offset int64
+	// startAtEnd - the file is read from the end if the agent sees it for the first time.
+	startAtEnd bool
+}
+
+// tailState - the position in the file saved between agent restarts.
+type tailState struct {
+	Offset   int64  `json:"offset"`
+	Identity uint64 `json:"identity"`
+}
+
+// NewLogTail - Object constructor.
+func NewLogTail(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (*LogTail, error) {
+	interval := time.Duration(cfg.PollInterval) * time.Second
+	if interval <= 0 {
+		const defaultInterval = 2 * time.Second
+		interval = defaultInterval
+	}
+
+	l := &LogTail{
+		accumulator: newAccumulator(),
+		sl:          sl,
+		statePath:   cfg.LogStatePath,
+		interval:    interval,
+	}
+
+	for _, lt := range cfg.LogTails {
+		rules, err := compileLogRules(lt.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("log %s has incorrect rules, err: %w", lt.Path, err)
+		}
+
+		l.files = append(l.files, &tailedFile{
+			path:       lt.Path,
+			rules:      rules,
+			startAtEnd: true,
+		})
+	}
+
+	return l, nil
+}
+
+func compileLogRules(rules []configuration.LogRule) ([]logRule, error) {
+	res := make([]logRule, 0, len(rules))
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q is incorrect, err: %w", r.Pattern, err)
+		}
+
+		if r.Metric == "" {
+			return nil, fmt.Errorf("pattern %q: %w", r.Pattern, errEmptyMetricID)
+		}
+
+		rule := logRule{re: re, metric: r.Metric, group: r.Group}
+		switch r.Type {
+		case metrics.CounterMetric, "":
+			rule.mtype = metrics.CounterMetric
+		case metrics.GaugeMetric:
+			rule.mtype = metrics.GaugeMetric
+			if rule.group == 0 {
+				rule.group = 1
+			}
+			if rule.group > re.NumSubexp() {
+				return nil, fmt.Errorf("pattern %q has no capture group %d", r.Pattern, rule.group)
+			}
+		default:
+			return nil, fmt.Errorf("metric %s has unknow type: %s", r.Metric, r.Type)
+		}
+
+		res = append(res, rule)
+	}
+
+	return res, nil
+}
+
+// Run - reads the new lines every poll interval until the context is done.
+func (l *LogTail) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		l.Poll()
+
+		select {
+		case <-ctx.Done():
+			l.close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Once - reads the lines appended since the previous run and closes the logs.
+func (l *LogTail) Once(_ context.Context) {
+	l.Poll()
+	l.close()
+}
+
+// Poll - reads the lines appended since the previous call and saves the offsets.
+func (l *LogTail) Poll() {
+	if !l.restored {
+		l.restoreState()
+	}
+
+	for _, f := range l.files {
+		if err := l.tail(f); err != nil {
+			l.sl.Errorf("tailing log %s was failed, err: %v", f.path, err)
+		}
+	}
+
+	if err := l.saveState(); err != nil {
+		l.sl.Errorf("saving log offsets was failed, err: %v", err)
+	}
+}
+
+func (l *LogTail) tail(f *tailedFile) error {
+	for _, r := range f.rules {
+		if r.mtype == metrics.CounterMetric {
+			l.addCounter(r.metric, 0)
+		}
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.startAtEnd = false
+		if errors.Is(err, os.ErrNotExist) {
+			// the file was moved away by rotation, the rest of it is read before the new one appears.
+			if f.file != nil {
+				return l.read(f)
+			}
+			return nil
+		}
+		return fmt.Errorf("cannot stat log, err: %w", err)
+	}
+
+	if f.file != nil && !os.SameFile(f.info, info) {
+		if err := l.read(f); err != nil {
+			l.sl.Errorf("reading the rest of rotated log %s was failed, err: %v", f.path, err)
+		}
+		if err := f.file.Close(); err != nil {
+			l.sl.Errorf("closing rotated log %s was failed, err: %v", f.path, err)
+		}
+		f.file = nil
+		f.offset = 0
+	}
+
+	if f.file == nil {
+		if err := f.open(info); err != nil {
+			return err
+		}
+	}
+
+	if info.Size() < f.offset {
+		// the file was truncated.
+		f.offset = 0
+	}
+
+	return l.read(f)
+}
+
+func (f *tailedFile) open(info os.FileInfo) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("cannot open log, err: %w", err)
+	}
+
+	f.file = file
+	f.info = info
+
+	switch {
+	case f.state != nil:
+		if f.state.Identity == fileIdentity(info) && f.state.Offset <= info.Size() {
+			f.offset = f.state.Offset
+		} else {
+			// the file was rotated while the agent was stopped.
+			f.offset = 0
+		}
+		f.state = nil
+	case f.startAtEnd:
+		f.offset = info.Size()
+	default:
+		f.offset = 0
+	}
+	f.startAtEnd = false
+
+	return nil
+}
+
+// read - matches the complete lines after the offset. An incomplete last line is read next time.
+func (l *LogTail) read(f *tailedFile) error {
+	if _, err := f.file.Seek(f.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek log, err: %w", err)
+	}
+
+	r := bufio.NewReader(f.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("cannot read log, err: %w", err)
+		}
+
+		f.offset += int64(len(line))
+		l.match(f, bytes.TrimRight(line, "\r\n"))
+	}
+}
+
+func (l *LogTail) match(f *tailedFile, line []byte) {
+	for _, r := range f.rules {
+		switch r.mtype {
+		case metrics.CounterMetric:
+			if r.re.Match(line) {
+				l.addCounter(r.metric, 1)
+			}
+		case metrics.GaugeMetric:
+			sm := r.re.FindSubmatch(line)
+			if sm == nil {
+				continue
+			}
+
+			v, err := strconv.ParseFloat(string(sm[r.group]), 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				l.sl.Infof("log %s: value %q of metric %s is not a finite number", f.path, sm[r.group], r.metric)
+				continue
+			}
+			l.setGauge(r.metric, v)
+		}
+	}
+}
+
+// restoreState - applies the offsets saved by the previous run. The offsets are read when the tailing starts,
+// not when the collector is created, so on reload the offsets saved by the replaced collector are seen
+// and its lines are not counted again.
+func (l *LogTail) restoreState() {
+	l.restored = true
+
+	states, err := l.loadState()
+	if err != nil {
+		l.sl.Errorf("log offsets were not restored, the logs are read from the end, err: %v", err)
+		return
+	}
+
+	for _, f := range l.files {
+		if st, ok := states[f.path]; ok {
+			f.state = st
+		}
+	}
+}
+
+func (l *LogTail) loadState() (map[string]*tailState, error) {
+	states := make(map[string]*tailState)
+	if l.statePath == "" {
+		return states, nil
+	}
+
+	b, err := os.ReadFile(l.statePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return states, nil
+		}
+		return nil, fmt.Errorf("cannot read log offsets file, err: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &states); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal log offsets, err: %w", err)
+	}
+
+	return states, nil
+}
+
+func (l *LogTail) saveState() error {
+	if l.statePath == "" {
+		return nil
+	}
+
+	states := make(map[string]*tailState)
+	for _, f := range l.files {
+		switch {
+		case f.file != nil:
+			states[f.path] = &tailState{Offset: f.offset, Identity: fileIdentity(f.info)}
+		case f.state != nil:
+			states[f.path] = f.state
+		}
+	}
+
+	b, err := json.Marshal(states)
+	if err != nil {
+		return fmt.Errorf("cannot marshal log offsets, err: %w", err)
+	}
+
+	const fileMode = 0600
+	tmp := l.statePath + ".tmp"
+	if err := os.WriteFile(tmp, b, fileMode); err != nil {
+		return fmt.Errorf("cannot write log offsets, err: %w", err)
+	}
+
+	if err := os.Rename(tmp, l.statePath); err != nil {
+		return fmt.Errorf("cannot replace log offsets file, err: %w", err)
+	}
+
+	return nil
+}
+
+func (l *LogTail) close() {
+	for _, f := range l.files {
+		if f.file == nil {
+			continue
+		}
+		if err := f.file.Close(); err != nil {
+			l.sl.Errorf("closing log %s was failed, err: %v", f.path, err)
+		}
+		f.file = nil
+	}
+}
@@ -0,0 +1,147 @@
+//go:build usetempdir
+// +build usetempdir
+
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+func appendLines(t *testing.T, path string, lines string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(lines)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func collectLogs(l *LogTail) map[string]string {
+	got := make(map[string]string)
+	for _, m := range l.Collect(context.Background()) {
+		got[m.ID] = m.String()
+	}
+	return got
+}
+
+func TestLogTail_Poll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, "ERROR old line\n")
+
+	cfg := &configuration.ConfigAgent{
+		LogStatePath: filepath.Join(dir, "state.json"),
+		LogTails: []configuration.LogTail{
+			{
+				Path: path,
+				Rules: []configuration.LogRule{
+					{Pattern: "ERROR", Metric: "log_errors"},
+					{Pattern: `latency=(\d+)`, Metric: "log_latency", Type: "gauge"},
+				},
+			},
+		},
+	}
+
+	l, err := NewLogTail(cfg, zap.S())
+	require.NoError(t, err)
+
+	// the existing content is skipped on the first start.
+	l.Poll()
+	assert.Equal(t, map[string]string{"log_errors": "0"}, collectLogs(l))
+
+	appendLines(t, path, "ERROR one latency=15\nINFO ok\nERROR partial")
+	l.Poll()
+	assert.Equal(t, map[string]string{"log_errors": "1", "log_latency": "15"}, collectLogs(l))
+
+	appendLines(t, path, " line\n")
+	l.Poll()
+	assert.Equal(t, "1", collectLogs(l)["log_errors"])
+
+	// rotation: the rest of the old file is read and the new file is read from the beginning.
+	appendLines(t, path, "ERROR before rotation\n")
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendLines(t, path, "ERROR after rotation\n")
+	l.Poll()
+	assert.Equal(t, "2", collectLogs(l)["log_errors"])
+
+	// truncation.
+	require.NoError(t, os.Truncate(path, 0))
+	l.Poll()
+	appendLines(t, path, "ERROR after truncation\n")
+	l.Poll()
+	assert.Equal(t, "1", collectLogs(l)["log_errors"])
+	l.close()
+
+	// restart: the lines written while the agent was stopped are read.
+	appendLines(t, path, "ERROR while stopped\nERROR while stopped\n")
+	l, err = NewLogTail(cfg, zap.S())
+	require.NoError(t, err)
+	l.Poll()
+	assert.Equal(t, "2", collectLogs(l)["log_errors"])
+
+	// reload: the new collector is created while the old one is running,
+	// it continues from the offsets saved by the old one when it starts.
+	next, err := NewLogTail(cfg, zap.S())
+	require.NoError(t, err)
+	appendLines(t, path, "ERROR before reload\n")
+	l.Poll()
+	assert.Equal(t, "1", collectLogs(l)["log_errors"])
+	l.close()
+
+	next.Poll()
+	assert.Equal(t, "0", collectLogs(next)["log_errors"])
+	next.close()
+}
+
+func TestLogTail_NonFiniteGauge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, "")
+
+	cfg := &configuration.ConfigAgent{
+		LogTails: []configuration.LogTail{{
+			Path:  path,
+			Rules: []configuration.LogRule{{Pattern: `temp=(\S+)`, Metric: "temp", Type: "gauge"}},
+		}},
+	}
+
+	l, err := NewLogTail(cfg, zap.S())
+	require.NoError(t, err)
+	l.Poll()
+
+	appendLines(t, path, "temp=NaN\ntemp=+Inf\ntemp=36.6\ntemp=-Inf\n")
+	l.Poll()
+	assert.Equal(t, map[string]string{"temp": "36.6"}, collectLogs(l))
+	l.close()
+}
+
+func TestNewLogTail_IncorrectRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule configuration.LogRule
+	}{
+		{name: "bad pattern", rule: configuration.LogRule{Pattern: "(", Metric: "m"}},
+		{name: "empty metric", rule: configuration.LogRule{Pattern: "x"}},
+		{name: "unknown type", rule: configuration.LogRule{Pattern: "x", Metric: "m", Type: "summary"}},
+		{name: "no group", rule: configuration.LogRule{Pattern: "x", Metric: "m", Type: "gauge"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &configuration.ConfigAgent{
+				LogTails: []configuration.LogTail{{Path: "app.log", Rules: []configuration.LogRule{tt.rule}}},
+			}
+			_, err := NewLogTail(cfg, zap.S())
+			assert.Error(t, err)
+		})
+	}
+}
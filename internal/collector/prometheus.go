@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	promCounter   = "counter"
+	promGauge     = "gauge"
+	promHistogram = "histogram"
+	promSummary   = "summary"
+	promUntyped   = "untyped"
+)
+
+// promLabel - a label of the prometheus sample.
+type promLabel struct {
+	name  string
+	value string
+}
+
+// promSample - a single sample of the prometheus text exposition format.
+type promSample struct {
+	name   string
+	family string
+	mtype  string
+	labels []promLabel
+	value  float64
+}
+
+// errMalformedSample - error occurs when the sample line cannot be parsed.
+var errMalformedSample = errors.New("malformed sample")
+
+// parsePrometheus - parses the prometheus text exposition format.
+// Samples with NaN and infinite values are skipped.
+func parsePrometheus(r io.Reader) ([]promSample, error) {
+	types := make(map[string]string)
+	var samples []promSample
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			const typeFields = 4
+			if len(fields) >= typeFields && fields[1] == "TYPE" {
+				types[fields[2]] = strings.ToLower(fields[3])
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+
+		s.family, s.mtype = sampleType(types, s.name)
+		samples = append(samples, s)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("an error occured while reading exposition, err: %w", err)
+	}
+
+	return samples, nil
+}
+
+// sampleType - returns the metric family and its type for the sample name.
+func sampleType(types map[string]string, name string) (string, string) {
+	if t, ok := types[name]; ok {
+		return name, t
+	}
+
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total"} {
+		family := strings.TrimSuffix(name, suffix)
+		if family == name {
+			continue
+		}
+		if t, ok := types[family]; ok {
+			return family, t
+		}
+	}
+
+	return name, promUntyped
+}
+
+func parseSample(line string) (promSample, error) {
+	var s promSample
+
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return s, fmt.Errorf("%w: %q", errMalformedSample, line)
+	}
+	s.name = line[:end]
+	rest := line[end:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, tail, err := parseLabels(rest[1:])
+		if err != nil {
+			return s, fmt.Errorf("%w: %q, err: %w", errMalformedSample, line, err)
+		}
+		s.labels = labels
+		rest = tail
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("%w: %q has no value", errMalformedSample, line)
+	}
+
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("%w: %q, err: %w", errMalformedSample, line, err)
+	}
+	s.value = v
+
+	return s, nil
+}
+
+// parseLabels - parses the labels after the opening brace and returns the rest of the line.
+func parseLabels(in string) ([]promLabel, string, error) {
+	var labels []promLabel
+
+	for {
+		in = strings.TrimLeft(in, " \t,")
+		if strings.HasPrefix(in, "}") {
+			return labels, in[1:], nil
+		}
+
+		eq := strings.IndexByte(in, '=')
+		if eq <= 0 {
+			return nil, "", errors.New("label without value")
+		}
+		name := strings.TrimSpace(in[:eq])
+		in = strings.TrimLeft(in[eq+1:], " \t")
+
+		if !strings.HasPrefix(in, `"`) {
+			return nil, "", fmt.Errorf("label %s value is not quoted", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(in) && in[i] != '"'; i++ {
+			if in[i] == '\\' && i+1 < len(in) {
+				i++
+				switch in[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(in[i])
+				}
+				continue
+			}
+			value.WriteByte(in[i])
+		}
+		if i >= len(in) {
+			return nil, "", fmt.Errorf("label %s value is not terminated", name)
+		}
+
+		labels = append(labels, promLabel{name: name, value: value.String()})
+		in = in[i+1:]
+	}
+}
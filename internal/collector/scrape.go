@@ -0,0 +1,245 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+const promAccept = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// Scraper - periodically scrapes prometheus endpoints
+// and converts gauges and counters into metrics.
+type Scraper struct {
+	*accumulator
+	client   *http.Client
+	previous map[string]float64
+	// fraction - the fractional part of the counter increase that is not reported yet.
+	fraction map[string]float64
+	sl       *zap.SugaredLogger
+	prefix   string
+	targets  []string
+	include  []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	interval time.Duration
+}
+
+// NewScraper - Object constructor.
+func NewScraper(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (*Scraper, error) {
+	include, err := compileRules(cfg.ScrapeInclude)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile scrape include rules, err: %w", err)
+	}
+
+	exclude, err := compileRules(cfg.ScrapeExclude)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile scrape exclude rules, err: %w", err)
+	}
+
+	interval := time.Duration(cfg.PollInterval) * time.Second
+	if interval == 0 {
+		const defaultInterval = 2 * time.Second
+		interval = defaultInterval
+	}
+
+	return &Scraper{
+		accumulator: newAccumulator(),
+		client:      &http.Client{Timeout: interval},
+		previous:    make(map[string]float64),
+		fraction:    make(map[string]float64),
+		sl:          sl,
+		prefix:      cfg.ScrapePrefix,
+		targets:     cfg.ScrapeTargets,
+		include:     include,
+		exclude:     exclude,
+		interval:    interval,
+	}, nil
+}
+
+func compileRules(rules []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q is incorrect, err: %w", rule, err)
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// Run - scrapes the targets every poll interval until the context is done.
+func (s *Scraper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.Scrape(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Once - scrapes all targets once.
+func (s *Scraper) Once(ctx context.Context) {
+	s.Scrape(ctx)
+}
+
+// Scrape - scrapes all targets once. Unavailable targets are logged and skipped.
+func (s *Scraper) Scrape(ctx context.Context) {
+	for _, target := range s.targets {
+		if err := s.scrapeTarget(ctx, target); err != nil {
+			s.sl.Errorf("scrape target %s was failed, err: %v", target, err)
+		}
+	}
+}
+
+func (s *Scraper) scrapeTarget(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("cannot create scrape request, err: %w", err)
+	}
+	req.Header.Set("Accept", promAccept)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("scrape request execute err: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			s.sl.Errorf("an error occured while body closing err: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	samples, err := parsePrometheus(resp.Body)
+	if err != nil {
+		return fmt.Errorf("cannot parse exposition, err: %w", err)
+	}
+
+	prefix := targetPrefix(target)
+	for i := range samples {
+		sample := &samples[i]
+		if !s.accepted(sample.family) {
+			continue
+		}
+
+		id := s.metricID(prefix, sample)
+		switch sample.mtype {
+		case promCounter:
+			delta, ok := s.counterDelta(id, sample.value)
+			if !ok {
+				// the first observation is a baseline, the history before it is unknown.
+				continue
+			}
+			s.addCounter(id, delta)
+		case promGauge, promUntyped:
+			s.setGauge(id, sample.value)
+		default:
+			continue
+		}
+	}
+
+	return nil
+}
+
+// counterDelta - stores the counter value and returns the whole part of its increase since the previous one.
+// The fractional part is carried to the next increase, so the fractional counters are not lost.
+func (s *Scraper) counterDelta(key string, value float64) (int64, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	prev, ok := s.previous[key]
+	s.previous[key] = value
+	if !ok {
+		return 0, false
+	}
+
+	increase := value - prev
+	if value < prev {
+		// counter was reset.
+		increase = value
+	}
+
+	increase += s.fraction[key]
+	whole := math.Trunc(increase)
+	s.fraction[key] = increase - whole
+
+	return int64(whole), true
+}
+
+func (s *Scraper) accepted(name string) bool {
+	for _, re := range s.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(s.include) == 0 {
+		return true
+	}
+
+	for _, re := range s.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// targetPrefix - returns the prefix of the metrics of the target made of its host and port,
+// so the same metrics of different targets do not overwrite each other.
+func targetPrefix(target string) string {
+	host := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	return host + "_"
+}
+
+// metricID - builds the metric ID from the prefixes, the sample name and its labels.
+func (s *Scraper) metricID(target string, sample *promSample) string {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(target)
+	b.WriteString(sample.name)
+	for _, l := range sample.labels {
+		b.WriteString("_")
+		b.WriteString(l.name)
+		b.WriteString("_")
+		b.WriteString(l.value)
+	}
+
+	return sanitizeID(b.String())
+}
+
+// sanitizeID - replaces the characters that are not allowed in the metric ID.
+func sanitizeID(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, id)
+}
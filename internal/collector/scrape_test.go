@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+const exposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} %d 1395066363000
+http_requests_total{method="post",code="400"} 3
+# TYPE queue_size gauge
+queue_size 12.5
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 4773
+rpc_duration_seconds_sum 1.7560473e+07
+rpc_duration_seconds_count 2693
+go_threads 8
+# TYPE temperature gauge
+temperature NaN
+escaped{path="C:\\dir\\\"x\", y"} 1
+`
+
+func TestParsePrometheus(t *testing.T) {
+	samples, err := parsePrometheus(strings.NewReader(fmt.Sprintf(exposition, 1027)))
+	require.NoError(t, err)
+
+	got := make(map[string]string)
+	for _, s := range samples {
+		got[s.name+fmt.Sprint(s.labels)] = s.mtype
+	}
+
+	assert.Equal(t, map[string]string{
+		"http_requests_total[{method post} {code 200}]": promCounter,
+		"http_requests_total[{method post} {code 400}]": promCounter,
+		"queue_size[]":                         promGauge,
+		"rpc_duration_seconds[{quantile 0.5}]": promSummary,
+		"rpc_duration_seconds_sum[]":           promSummary,
+		"rpc_duration_seconds_count[]":         promSummary,
+		"go_threads[]":                         promUntyped,
+		`escaped[{path C:\dir\"x", y}]`:        promUntyped,
+	}, got)
+
+	_, err = parsePrometheus(strings.NewReader(`broken{a="b" 1`))
+	assert.Error(t, err)
+
+	_, err = parsePrometheus(strings.NewReader(`novalue`))
+	assert.Error(t, err)
+}
+
+func TestScraper_Collect(t *testing.T) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		fmt.Fprintf(w, exposition, 1000+n*10)
+	}))
+	defer ts.Close()
+
+	cfg := &configuration.ConfigAgent{
+		ScrapeTargets: []string{ts.URL},
+		ScrapePrefix:  "app_",
+		ScrapeExclude: []string{"^go_"},
+	}
+	s, err := NewScraper(cfg, zap.S())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s.Scrape(ctx)
+
+	app := "app_" + sanitizeID(strings.TrimPrefix(ts.URL, "http://")) + "_"
+	assert.Equal(t, map[string]string{
+		app + "queue_size":                 "gauge 12.5",
+		app + "escaped_path_C__dir__x___y": "gauge 1",
+	}, collected(ctx, s), "the first scrape of counters is a baseline")
+
+	s.Scrape(ctx)
+	got := collected(ctx, s)
+	assert.Equal(t, "counter 10", got[app+"http_requests_total_method_post_code_200"])
+	assert.Equal(t, "counter 0", got[app+"http_requests_total_method_post_code_400"])
+	assert.NotContains(t, got, app+"go_threads")
+	assert.NotContains(t, got, app+"rpc_duration_seconds_sum")
+
+	cfg.ScrapeInclude = []string{"["}
+	_, err = NewScraper(cfg, zap.S())
+	assert.Error(t, err)
+}
+
+func collected(ctx context.Context, s *Scraper) map[string]string {
+	got := make(map[string]string)
+	for _, m := range s.Collect(ctx) {
+		got[m.ID] = m.MType + " " + m.String()
+	}
+	return got
+}
+
+func TestScraper_fractionalCounter(t *testing.T) {
+	values := []string{"0.9", "1.1", "1.95", "0.5"}
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		fmt.Fprintf(w, "# TYPE seconds_total counter\nseconds_total %s\n", values[n-1])
+	}))
+	defer ts.Close()
+
+	s, err := NewScraper(&configuration.ConfigAgent{ScrapeTargets: []string{ts.URL}}, zap.S())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	id := sanitizeID(strings.TrimPrefix(ts.URL, "http://")) + "_seconds_total"
+	s.Scrape(ctx)
+	for _, want := range []string{"counter 0", "counter 1", "counter 0"} {
+		s.Scrape(ctx)
+		assert.Equal(t, want, collected(ctx, s)[id])
+	}
+
+	// the remainder 0.05 and the value 0.5 after the reset are carried.
+	assert.InDelta(t, 0.55, s.fraction[id], 1e-9)
+}
+
+func TestScraper_targets(t *testing.T) {
+	newTarget := func(size int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "# TYPE queue_size gauge\nqueue_size %d\n", size)
+		}))
+	}
+	first, second := newTarget(1), newTarget(2)
+	defer first.Close()
+	defer second.Close()
+
+	s, err := NewScraper(&configuration.ConfigAgent{ScrapeTargets: []string{first.URL, second.URL}}, zap.S())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s.Scrape(ctx)
+
+	id := func(ts *httptest.Server) string {
+		return sanitizeID(strings.TrimPrefix(ts.URL, "http://")) + "_queue_size"
+	}
+	assert.Equal(t, map[string]string{
+		id(first):  "gauge 1",
+		id(second): "gauge 2",
+	}, collected(ctx, s), "the same metrics of the targets do not overwrite each other")
+}
@@ -2,6 +2,7 @@ package compress
 
 import (
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -27,8 +28,11 @@ func NewGzipReader(r io.ReadCloser) (*gzipReader, error) {
 
 func (c gzipReader) Read(p []byte) (int, error) {
 	n, err := c.zipR.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, io.EOF
+	}
 	if err != nil {
-		return 0, fmt.Errorf("an error occured while zipR reading, err: %w", err)
+		return n, fmt.Errorf("an error occured while zipR reading, err: %w", err)
 	}
 	return n, nil
 }
@@ -0,0 +1,108 @@
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExecProbe - configuration of the command whose output is collected as metrics.
+type ExecProbe struct {
+	// Name - is used in the agent self-metrics of the probe.
+	Name string
+	// Command - executable and its arguments.
+	Command []string
+	// Interval - how often the command is executed.
+	Interval time.Duration
+	// Timeout - the command is killed if it has not completed in time.
+	Timeout time.Duration
+}
+
+// UnmarshalJSON - For anmarshaling of the time parameters of the probe.
+func (p *ExecProbe) UnmarshalJSON(data []byte) error {
+	type ExecProbeJSON struct {
+		Name     string   `json:"name"`
+		Interval string   `json:"interval"`
+		Timeout  string   `json:"timeout"`
+		Command  []string `json:"command"`
+	}
+
+	var v ExecProbeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("exec probe unmarshal error, err: %w", err)
+	}
+
+	if v.Name == "" {
+		return errors.New("exec probe name is empty")
+	}
+	if len(v.Command) == 0 {
+		return fmt.Errorf("exec probe %s has no command", v.Name)
+	}
+
+	p.Name = v.Name
+	p.Command = v.Command
+
+	interval, err := parsePositiveDuration(v.Interval)
+	if err != nil {
+		return fmt.Errorf("cannot parse exec probe %s interval err: %w", v.Name, err)
+	}
+	p.Interval = interval
+
+	timeout, err := parsePositiveDuration(v.Timeout)
+	if err != nil {
+		return fmt.Errorf("cannot parse exec probe %s timeout err: %w", v.Name, err)
+	}
+	p.Timeout = timeout
+
+	return nil
+}
+
+// parseOptionalDuration - parses the duration, an empty string means zero duration.
+func parseOptionalDuration(d string) (time.Duration, error) {
+	if d == "" {
+		return 0, nil
+	}
+
+	pd, err := time.ParseDuration(d)
+	if err != nil {
+		return 0, fmt.Errorf("duration %q is incorrect, err: %w", d, err)
+	}
+
+	return pd, nil
+}
+
+// parsePositiveDuration - parses the duration that must be positive if it is set,
+// an empty string means zero duration, so the default value is used.
+func parsePositiveDuration(d string) (time.Duration, error) {
+	pd, err := parseOptionalDuration(d)
+	if err != nil {
+		return 0, err
+	}
+	if d != "" && pd <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", d)
+	}
+
+	return pd, nil
+}
+
+// LogTail - configuration of the file whose lines are matched by the rules.
+type LogTail struct {
+	// Path - path to the log file.
+	Path string `json:"path"`
+	// Rules - each line of the file is checked against every rule.
+	Rules []LogRule `json:"rules"`
+}
+
+// LogRule - a rule that derives a metric from the matching log lines.
+type LogRule struct {
+	// Pattern - regular expression for the log line.
+	Pattern string `json:"pattern"`
+	// Metric - ID of the derived metric.
+	Metric string `json:"metric"`
+	// Type - counter increments the metric for every matching line,
+	// gauge takes the value from the capture group.
+	Type string `json:"type"`
+	// Group - number of the capture group with the gauge value, the first group by default.
+	Group int `json:"group"`
+}
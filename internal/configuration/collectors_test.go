@@ -0,0 +1,71 @@
+//go:build usetempdir
+// +build usetempdir
+
+package configuration
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecProbe_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    ExecProbe
+		wantErr bool
+	}{
+		{
+			name: "#1",
+			data: `{"name":"disk","command":["df","-k"],"interval":"30s","timeout":"5s"}`,
+			want: ExecProbe{
+				Name:     "disk",
+				Command:  []string{"df", "-k"},
+				Interval: 30 * time.Second,
+				Timeout:  5 * time.Second,
+			},
+		},
+		{
+			name: "#2 without durations",
+			data: `{"name":"disk","command":["df"]}`,
+			want: ExecProbe{Name: "disk", Command: []string{"df"}},
+		},
+		{
+			name:    "#3 without command",
+			data:    `{"name":"disk"}`,
+			wantErr: true,
+		},
+		{
+			name:    "#4 bad interval",
+			data:    `{"name":"disk","command":["df"],"interval":"1masdasd"}`,
+			wantErr: true,
+		},
+		{
+			name:    "#5 negative interval",
+			data:    `{"name":"disk","command":["df"],"interval":"-1s"}`,
+			wantErr: true,
+		},
+		{
+			name:    "#6 zero timeout",
+			data:    `{"name":"disk","command":["df"],"timeout":"0s"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var got ExecProbe
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExecProbe.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
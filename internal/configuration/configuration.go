@@ -20,13 +20,35 @@ const (
 
 	defaultPrivateCryptoKeyPath = ""
 
+	cryptoKeyDirFlagName    = "crypto-key-dir"
+	defaultCryptoKeyDirPath = ""
+
 	envHashKey = "KEY"
 
 	trustedSubnetFlagName = "t"
 	defaultTrustedSubnet  = ""
 
+	trustedProxiesFlagName = "trusted-proxies"
+	defaultTrustedProxies  = ""
+
 	useProtobuffFlagName = "pb"
 	defaultUseProtobuff  = false
+
+	agentProfilesFlagName = "profiles"
+	defaultAgentProfiles  = ""
+
+	staleReportsFlagName = "stale-reports"
+	defaultStaleReports  = 3
+
+	agentKeysFlagName = "agent-keys"
+	defaultAgentKeys  = ""
+
+	adminTokenFlagName = "admin-token"
+
+	tenantsFlagName = "tenants"
+
+	tlsClientCAFlagName = "tls-client-ca"
+	tlsCRLFlagName      = "tls-crl"
 )
 
 func newConfig() *Config {
@@ -35,6 +57,7 @@ func newConfig() *Config {
 		FileStoragePath: defaultFileStoragePath,
 		StoreInterval:   defaultStoreInterval,
 		Restore:         defaultRestore,
+		StaleReports:    defaultStaleReports,
 	}
 }
 
@@ -45,12 +68,35 @@ type Config struct {
 	Database         string `env:"DATABASE_DSN" json:"database_dsn"`
 	ConfigFile       string `env:"CONFIG"`
 	PrivateCryptoKey string `env:"CRYPTO_KEY" json:"crypto_key"`
-	TrustedSubnet    string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	CertFilePath     string `env:"CERTIFICATE" json:"certificate"`
-	Key              []byte
-	StoreInterval    int  `env:"STORE_INTERVAL" json:"store_interval"`
-	Restore          bool `env:"RESTORE" json:"restore"`
-	UseProtobuff     bool `env:"USE_PROTOBUFF" json:"use_protobuff"`
+	// CryptoKeyDir - the directory with the private keys of the keyring, every *.pem private key is loaded.
+	CryptoKeyDir string `env:"CRYPTO_KEY_DIR" json:"crypto_key_dir"`
+	// TrustedSubnet - the comma separated networks in the CIDR notation, the agents out of them are rejected.
+	// The IPv4 address without the mask means its classful network as before the CIDR notation was supported.
+	TrustedSubnet string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	// TrustedProxies - the comma separated networks of the proxies, the X-Forwarded-For and the X-Real-IP
+	// headers are taken into account only if the request comes from them.
+	TrustedProxies string `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	CertFilePath   string `env:"CERTIFICATE" json:"certificate"`
+	AgentProfiles  string `env:"AGENT_PROFILES" json:"agent_profiles"`
+	// AgentKeys - the json file with the public keys the agents sign the requests with.
+	AgentKeys string `env:"AGENT_KEYS" json:"agent_keys"`
+	// AdminToken - the token with the admin scope, the API tokens are required if it is set.
+	// The other tokens are managed with it and are kept in the storage.
+	AdminToken string `env:"ADMIN_TOKEN" json:"admin_token"`
+	// Tenants - the json file with the tenants, their hash and crypto keys and the quotas on the metrics.
+	Tenants string `env:"TENANTS" json:"tenants"`
+	// TLSCert, TLSKey - the certificate and the key the http server is served with over TLS.
+	TLSCert string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey  string `env:"TLS_KEY" json:"tls_key"`
+	// TLSClientCA - the CA bundle the client certificates are verified with, the certificates are required if it is set.
+	TLSClientCA string `env:"TLS_CLIENT_CA" json:"tls_client_ca"`
+	// TLSCRL - the CRLs the client certificates are checked with, the revoked agents are rejected.
+	TLSCRL        string `env:"TLS_CRL" json:"tls_crl"`
+	Key           []byte
+	StoreInterval int  `env:"STORE_INTERVAL" json:"store_interval"`
+	StaleReports  int  `env:"STALE_REPORTS" json:"stale_reports"`
+	Restore       bool `env:"RESTORE" json:"restore"`
+	UseProtobuff  bool `env:"USE_PROTOBUFF" json:"use_protobuff"`
 }
 
 // Parse - return parsed config.
@@ -85,8 +131,20 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 		Database        string `json:"database_dsn"`
 		StoreInterval   string `json:"store_interval"`
 		HashKey         string `json:"hashkey"`
+		CryptoKey       string `json:"crypto_key"`
+		CryptoKeyDir    string `json:"crypto_key_dir"`
 		TrustedSubnet   string `json:"trusted_subnet"`
+		TrustedProxies  string `json:"trusted_proxies"`
 		CertFilePath    string `json:"certificate"`
+		AgentProfiles   string `json:"agent_profiles"`
+		AgentKeys       string `json:"agent_keys"`
+		AdminToken      string `json:"admin_token"`
+		Tenants         string `json:"tenants"`
+		TLSCert         string `json:"tls_cert"`
+		TLSKey          string `json:"tls_key"`
+		TLSClientCA     string `json:"tls_client_ca"`
+		TLSCRL          string `json:"tls_crl"`
+		StaleReports    int    `json:"stale_reports"`
 		Restore         bool   `json:"restore"`
 		UseProtobuff    bool   `json:"use_protobuff"`
 	}
@@ -102,8 +160,22 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 	c.Database = v.Database
 	c.UseProtobuff = v.UseProtobuff
 	c.TrustedSubnet = v.TrustedSubnet
+	c.TrustedProxies = v.TrustedProxies
 	c.Key = []byte(v.HashKey)
+	c.PrivateCryptoKey = v.CryptoKey
+	c.CryptoKeyDir = v.CryptoKeyDir
 	c.CertFilePath = v.CertFilePath
+	c.AgentProfiles = v.AgentProfiles
+	c.AgentKeys = v.AgentKeys
+	c.AdminToken = v.AdminToken
+	c.Tenants = v.Tenants
+	c.TLSCert = v.TLSCert
+	c.TLSKey = v.TLSKey
+	c.TLSClientCA = v.TLSClientCA
+	c.TLSCRL = v.TLSCRL
+	if v.StaleReports != 0 {
+		c.StaleReports = v.StaleReports
+	}
 
 	si, err := time.ParseDuration(v.StoreInterval)
 	if err != nil {
@@ -114,6 +186,11 @@ func (c *Config) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// TLSEnabled - returns true if the TLS options of the servers are set.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCert != "" || c.TLSKey != "" || c.TLSClientCA != "" || c.TLSCRL != ""
+}
+
 func (c *Config) String() string {
 	return fmt.Sprintf("Addres: %s, StoreInterval: %d, Restore: %t, DSN: %s, FS path: %s, Config: %s, Protobuff: %t",
 		c.Address, c.StoreInterval, c.Restore, c.Database, c.FileStoragePath, c.ConfigFile, c.UseProtobuff)
@@ -140,8 +217,13 @@ func (c *Config) setFromConfigs(configCL, configENV, configFile *Config, path st
 	c.PrivateCryptoKey = getConfigVar(
 		configCL.PrivateCryptoKey, configENV.PrivateCryptoKey, configFile.PrivateCryptoKey, defaultPrivateCryptoKeyPath, "")
 
+	c.CryptoKeyDir = getConfigVar(
+		configCL.CryptoKeyDir, configENV.CryptoKeyDir, configFile.CryptoKeyDir, defaultCryptoKeyDirPath, "")
+
 	c.TrustedSubnet = getConfigVar(
 		configCL.TrustedSubnet, configENV.TrustedSubnet, configFile.TrustedSubnet, defaultTrustedSubnet, "")
+	c.TrustedProxies = getConfigVar(
+		configCL.TrustedProxies, configENV.TrustedProxies, configFile.TrustedProxies, defaultTrustedProxies, "")
 
 	c.UseProtobuff = getConfigVar(
 		configCL.UseProtobuff, configENV.UseProtobuff, configFile.UseProtobuff, defaultUseProtobuff, false)
@@ -150,6 +232,21 @@ func (c *Config) setFromConfigs(configCL, configENV, configFile *Config, path st
 
 	c.CertFilePath = getConfigVar(configCL.CertFilePath, configENV.CertFilePath, configFile.CertFilePath, "", "")
 
+	c.AgentProfiles = getConfigVar(
+		configCL.AgentProfiles, configENV.AgentProfiles, configFile.AgentProfiles, defaultAgentProfiles, "")
+
+	c.StaleReports = getConfigVar(
+		configCL.StaleReports, configENV.StaleReports, configFile.StaleReports, defaultStaleReports, 0)
+
+	c.AgentKeys = getConfigVar(configCL.AgentKeys, configENV.AgentKeys, configFile.AgentKeys, defaultAgentKeys, "")
+	c.AdminToken = getConfigVar(configCL.AdminToken, configENV.AdminToken, configFile.AdminToken, "", "")
+	c.Tenants = getConfigVar(configCL.Tenants, configENV.Tenants, configFile.Tenants, "", "")
+
+	c.TLSCert = getConfigVar(configCL.TLSCert, configENV.TLSCert, configFile.TLSCert, "", "")
+	c.TLSKey = getConfigVar(configCL.TLSKey, configENV.TLSKey, configFile.TLSKey, "", "")
+	c.TLSClientCA = getConfigVar(configCL.TLSClientCA, configENV.TLSClientCA, configFile.TLSClientCA, "", "")
+	c.TLSCRL = getConfigVar(configCL.TLSCRL, configENV.TLSCRL, configFile.TLSCRL, "", "")
+
 	c.ConfigFile = path
 }
 
@@ -183,9 +280,32 @@ func readConfigFromCL() *Config {
 	flag.StringVar(&c.Database, "d", "", "database connection")
 	flag.StringVar(&hashkey, hashKeyFlagName, defaultHashKey, "hash key for check agent request hash")
 	flag.StringVar(&c.PrivateCryptoKey, cryptoKeyFlagName, defaultCryptoKeyPath, "path to privatekey.pem")
-	flag.StringVar(&c.TrustedSubnet, trustedSubnetFlagName, defaultTrustedSubnet, "trusted subnet, example 192.168.31.1")
+	flag.StringVar(&c.CryptoKeyDir, cryptoKeyDirFlagName, defaultCryptoKeyDirPath,
+		"path to the directory with the private keys, the keys are reloaded on SIGHUP")
+	flag.StringVar(&c.TrustedSubnet, trustedSubnetFlagName, defaultTrustedSubnet,
+		"comma separated trusted subnets, example 192.168.31.0/24,fd00::/8; "+
+			"the IPv4 address without the mask means its classful network, 192.168.31.1 means 192.168.31.0/24")
+	flag.StringVar(&c.TrustedProxies, trustedProxiesFlagName, defaultTrustedProxies,
+		"comma separated subnets of the trusted proxies, the X-Forwarded-For and X-Real-IP headers are used behind them")
 	flag.BoolVar(&c.UseProtobuff, useProtobuffFlagName, defaultUseProtobuff, "use grpc instead of http protocol")
 	flag.StringVar(&c.CertFilePath, certFileFlagName, defaultCertFilePath, "absolute path to cert (x509)")
+	flag.StringVar(&c.AgentProfiles, agentProfilesFlagName, defaultAgentProfiles,
+		"path to the json file with the configuration profiles of the agents")
+	flag.IntVar(&c.StaleReports, staleReportsFlagName, defaultStaleReports,
+		"count of the missed report intervals after which the agent is marked as stale")
+	flag.StringVar(&c.AgentKeys, agentKeysFlagName, defaultAgentKeys,
+		"path to the json file with the Ed25519 public keys of the agents, the requests of other agents are rejected")
+	flag.StringVar(&c.AdminToken, adminTokenFlagName, "",
+		"API token with the admin scope, enables the token authentication of the requests")
+	flag.StringVar(&c.Tenants, tenantsFlagName, "",
+		"path to the json file with the tenants, the metrics of every tenant are stored separately")
+	flag.StringVar(&c.TLSCert, tlsCertFlagName, "",
+		"path to the certificate (PEM) of the http server, enables TLS, the file is reloaded when it changes")
+	flag.StringVar(&c.TLSKey, tlsKeyFlagName, "", "path to the private key (PEM) of the http server certificate")
+	flag.StringVar(&c.TLSClientCA, tlsClientCAFlagName, "",
+		"path to the CA bundle (PEM) the client certificates of the agents are verified with, enables mTLS")
+	flag.StringVar(&c.TLSCRL, tlsCRLFlagName, "",
+		"path to the CRLs (PEM or DER) of the client CAs, the file is reloaded when it changes")
 
 	flag.Parse()
 
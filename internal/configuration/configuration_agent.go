@@ -2,10 +2,13 @@ package configuration
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env"
@@ -25,6 +28,14 @@ const (
 	defaultMetcollAddress  = "localhost:8080"
 	metcollAddressFlagName = "a"
 
+	metcollAddressesFlagName = "addresses"
+
+	// SendModeFailover - batches are sent to the first healthy server.
+	SendModeFailover = "failover"
+	// SendModeFanout - batches are sent to all servers.
+	SendModeFanout   = "fanout"
+	sendModeFlagName = "send-mode"
+
 	defaultHashKey  = ""
 	hashKeyFlagName = "k"
 
@@ -36,32 +47,163 @@ const (
 
 	certFileFlagName    = "s"
 	defaultCertFilePath = ""
+
+	ingestAddressFlagName = "ingest-address"
+	defaultIngestAddress  = ""
+
+	ingestSocketFlagName = "ingest-socket"
+	defaultIngestSocket  = ""
+
+	scrapeTargetsFlagName = "scrape-targets"
+	scrapePrefixFlagName  = "scrape-prefix"
+	defaultScrapePrefix   = ""
+	scrapeIncludeFlagName = "scrape-include"
+	scrapeExcludeFlagName = "scrape-exclude"
+
+	aggregateFlagName = "aggregate"
+
+	deltaOnlyFlagName     = "delta-only"
+	defaultDeltaOnly      = false
+	deltaAbsoluteFlagName = "delta-absolute"
+	defaultDeltaAbsolute  = 0
+	deltaRelativeFlagName = "delta-relative"
+	defaultDeltaRelative  = 0
+	resyncReportsFlagName = "resync-reports"
+	defaultResyncReports  = 30
+
+	logStateFlagName    = "log-state"
+	defaultLogStatePath = ""
+
+	queuePathFlagName    = "queue-path"
+	defaultQueuePath     = ""
+	queueMaxSizeFlagName = "queue-max-size"
+	defaultQueueMaxSize  = 64 << 20
+	queueFsyncFlagName   = "queue-fsync"
+	defaultQueueFsync    = false
+
+	agentIDFlagName         = "agent-id"
+	defaultAgentID          = ""
+	labelsFlagName          = "labels"
+	profileIntervalFlagName = "profile-interval"
+	defaultProfileInterval  = 0
+
+	statusAddressFlagName = "status-address"
+	defaultStatusAddress  = ""
+
+	onceFlagName   = "once"
+	defaultOnce    = false
+	dryRunFlagName = "dry-run"
+	defaultDryRun  = false
+
+	agentIPFlagName        = "agent-ip"
+	agentInterfaceFlagName = "agent-interface"
+	agentSubnetFlagName    = "agent-subnet"
+
+	signingKeyFlagName = "signing-key"
+
+	apiTokenFlagName = "api-token"
+
+	tenantFlagName = "tenant"
+
+	useTLSFlagName        = "tls"
+	defaultUseTLS         = false
+	tlsCAFlagName         = "tls-ca"
+	tlsCertFlagName       = "tls-cert"
+	tlsKeyFlagName        = "tls-key"
+	tlsServerNameFlagName = "tls-server-name"
+
+	retryMaxFlagName     = "retry-max"
+	defaultRetryMax      = 3
+	retryWaitMinFlagName = "retry-wait-min"
+	defaultRetryWaitMin  = 1
+	retryWaitMaxFlagName = "retry-wait-max"
+	defaultRetryWaitMax  = 5
+
+	// DryRunFormatJSON - the batch is printed as JSON.
+	DryRunFormatJSON = "json"
+	// DryRunFormatWire - the batch is printed as the request sent to the server.
+	DryRunFormatWire     = "wire"
+	dryRunFormatFlagName = "dry-run-format"
 )
 
 // ConfigAgent contains configuration for agent.
 type ConfigAgent struct {
-	Server          string `env:"ADDRESS" json:"address,omitempty"`
-	Path            string `env:"CONFIG"`
-	PublicCryptoKey string `env:"CRYPTO_KEY" json:"crypto_key"`
-	CertFilePath    string `env:"CERTIFICATE" json:"certificate"`
-	Key             []byte
-	PollInterval    int  `env:"POLL_INTERVAL" json:"poll_interval,omitempty"`
-	ReportInterval  int  `env:"REPORT_INTERVAL" json:"report_interval,omitempty"`
-	Limit           int  `env:"RATE_LIMIT"`
-	UseProtobuff    bool `env:"USE_PROTOBUFF" json:"use_protobuff"`
+	// configCL - command line variables, they are kept for the reload of the configuration.
+	configCL        *ConfigAgent
+	Server          string   `env:"ADDRESS" json:"address,omitempty"`
+	SendMode        string   `env:"SEND_MODE" json:"send_mode"`
+	Path            string   `env:"CONFIG"`
+	PublicCryptoKey string   `env:"CRYPTO_KEY" json:"crypto_key"`
+	CertFilePath    string   `env:"CERTIFICATE" json:"certificate"`
+	IngestAddress   string   `env:"INGEST_ADDRESS" json:"ingest_address"`
+	IngestSocket    string   `env:"INGEST_SOCKET" json:"ingest_socket"`
+	ScrapePrefix    string   `env:"SCRAPE_PREFIX" json:"scrape_prefix"`
+	Servers         []string `env:"ADDRESSES" json:"addresses"`
+	ScrapeTargets   []string `env:"SCRAPE_TARGETS" json:"scrape_targets"`
+	Aggregate       []string `env:"AGGREGATE" json:"aggregate"`
+	ScrapeInclude   []string `env:"SCRAPE_INCLUDE" json:"scrape_include"`
+	ScrapeExclude   []string `env:"SCRAPE_EXCLUDE" json:"scrape_exclude"`
+	LogStatePath    string   `env:"LOG_STATE_FILE" json:"log_state_file"`
+	QueuePath       string   `env:"QUEUE_PATH" json:"queue_path"`
+	AgentID         string   `env:"AGENT_ID" json:"agent_id"`
+	StatusAddress   string   `env:"STATUS_ADDRESS" json:"status_address"`
+	DryRunFormat    string   `env:"DRY_RUN_FORMAT" json:"-"`
+	AgentIP         string   `env:"AGENT_IP" json:"agent_ip"`
+	AgentInterface  string   `env:"AGENT_INTERFACE" json:"agent_interface"`
+	AgentSubnet     string   `env:"AGENT_SUBNET" json:"agent_subnet"`
+	SigningKey      string   `env:"SIGNING_KEY" json:"signing_key"`
+	// APIToken - the API token the agent is authenticated with on the servers that require the tokens.
+	// It is read from the file by UnmarshalJSON, but it is never marshaled, so the status does not show it.
+	APIToken string `env:"API_TOKEN" json:"-"`
+	// Tenant - the tenant the metrics are sent to, the default tenant of the server if it is empty.
+	Tenant string `env:"TENANT" json:"tenant"`
+	// TLSCA - the CA bundle the certificates of the servers are verified with, the system roots by default.
+	TLSCA string `env:"TLS_CA" json:"tls_ca"`
+	// TLSCert, TLSKey - the client certificate of the agent for the servers that verify the agents.
+	TLSCert string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey  string `env:"TLS_KEY" json:"tls_key"`
+	// TLSServerName - the name the certificates of the servers are verified for, the host of the server by default.
+	TLSServerName   string      `env:"TLS_SERVER_NAME" json:"tls_server_name"`
+	Labels          []string    `env:"LABELS" json:"labels"`
+	ExecProbes      []ExecProbe `json:"exec_probes"`
+	LogTails        []LogTail   `json:"log_tails"`
+	Key             []byte      `json:"-"`
+	QueueMaxSize    int64       `env:"QUEUE_MAX_SIZE" json:"queue_max_size"`
+	DeltaAbsolute   float64     `env:"DELTA_ABSOLUTE" json:"delta_absolute"`
+	DeltaRelative   float64     `env:"DELTA_RELATIVE" json:"delta_relative"`
+	ResyncReports   int         `env:"RESYNC_REPORTS" json:"resync_reports"`
+	PollInterval    int         `env:"POLL_INTERVAL" json:"poll_interval,omitempty"`
+	ReportInterval  int         `env:"REPORT_INTERVAL" json:"report_interval,omitempty"`
+	Limit           int         `env:"RATE_LIMIT" json:"rate_limit"`
+	ProfileInterval int         `env:"PROFILE_INTERVAL" json:"profile_interval"`
+	RetryMax        int         `env:"RETRY_MAX" json:"retry_max"`
+	RetryWaitMin    int         `env:"RETRY_WAIT_MIN" json:"retry_wait_min"`
+	RetryWaitMax    int         `env:"RETRY_WAIT_MAX" json:"retry_wait_max"`
+	UseProtobuff    bool        `env:"USE_PROTOBUFF" json:"use_protobuff"`
+	UseTLS          bool        `env:"USE_TLS" json:"use_tls"`
+	QueueFsync      bool        `env:"QUEUE_FSYNC" json:"queue_fsync"`
+	DeltaOnly       bool        `env:"DELTA_ONLY" json:"delta_only"`
+	Once            bool        `env:"ONCE" json:"-"`
+	DryRun          bool        `env:"DRY_RUN" json:"-"`
 }
 
 func newConfigAgent() *ConfigAgent {
 	return &ConfigAgent{
 		Server:         defaultMetcollAddress,
+		SendMode:       SendModeFailover,
 		PollInterval:   defaultPollInterval,
 		ReportInterval: defaultReportInterval,
 		Limit:          defaultLimit,
 		Path:           defaultConfigPath,
+		QueueMaxSize:   defaultQueueMaxSize,
+		ResyncReports:  defaultResyncReports,
+		RetryMax:       defaultRetryMax,
+		RetryWaitMin:   defaultRetryWaitMin,
+		RetryWaitMax:   defaultRetryWaitMax,
 	}
 }
 
-// ParseAgent - return parsed config.
+// ParseAgent - return parsed and validated config.
 //
 // Environment variables have higher priority over command line variables and config file.
 // Command line variables have higher priority over variables from config file.
@@ -81,16 +223,123 @@ func ParseAgent() (*ConfigAgent, error) {
 
 	var c ConfigAgent
 	c.setFromConfigs(configCL, configENV, configFile, path)
+	c.configCL = configCL
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("agent configuration is incorrect, err: %w", err)
+	}
 
 	return &c, nil
 }
 
+// Reload - returns the configuration with the environment variables and the config file read again.
+// Command line variables are kept from the initial parsing. The new configuration is validated.
+func (c *ConfigAgent) Reload() (*ConfigAgent, error) {
+	configCL := c.configCL
+	if configCL == nil {
+		configCL = newConfigAgent()
+	}
+
+	configENV, err := readConfigAgentFromENV()
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred when reading the agent configuration env var, err: %w", err)
+	}
+
+	configFile, err := readConfigAgentFromFile(c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred when reading the agent configuration file, err: %w", err)
+	}
+
+	var n ConfigAgent
+	n.setFromConfigs(configCL, configENV, configFile, c.Path)
+	n.configCL = configCL
+
+	if err := n.Validate(); err != nil {
+		return nil, fmt.Errorf("reloaded agent configuration is incorrect, err: %w", err)
+	}
+
+	return &n, nil
+}
+
+// Validate - checks the values of the configuration.
+func (c *ConfigAgent) Validate() error {
+	if c.PollInterval <= 0 {
+		return fmt.Errorf("poll interval must be positive, got %d", c.PollInterval)
+	}
+
+	if c.ReportInterval <= 0 {
+		return fmt.Errorf("report interval must be positive, got %d", c.ReportInterval)
+	}
+
+	if c.Limit <= 0 {
+		return fmt.Errorf("rate limit must be positive, got %d", c.Limit)
+	}
+
+	switch c.SendMode {
+	case SendModeFailover, SendModeFanout:
+	default:
+		return fmt.Errorf("unknown send mode: %s", c.SendMode)
+	}
+
+	if c.DeltaAbsolute < 0 || c.DeltaRelative < 0 {
+		return errors.New("delta thresholds must not be negative")
+	}
+
+	if c.ProfileInterval < 0 {
+		return fmt.Errorf("profile interval must not be negative, got %d", c.ProfileInterval)
+	}
+
+	// the profiles change the collectors of the agent, so the profiles that are not signed are not accepted.
+	if c.ProfileInterval > 0 && len(c.Key) == 0 {
+		return errors.New("profile fetching requires the hash key to verify the profiles")
+	}
+
+	if c.AgentIP != "" && net.ParseIP(c.AgentIP) == nil {
+		return fmt.Errorf("agent IP %q is incorrect", c.AgentIP)
+	}
+
+	if c.AgentSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.AgentSubnet); err != nil {
+			return fmt.Errorf("agent subnet %q is incorrect, err: %w", c.AgentSubnet, err)
+		}
+	}
+
+	if c.RetryMax < 0 {
+		return fmt.Errorf("retry max must not be negative, got %d", c.RetryMax)
+	}
+
+	if c.RetryWaitMin < 0 || c.RetryWaitMax < 0 {
+		return errors.New("retry waits must not be negative")
+	}
+
+	if c.RetryWaitMin > c.RetryWaitMax {
+		return fmt.Errorf("retry wait min %d must not exceed retry wait max %d", c.RetryWaitMin, c.RetryWaitMax)
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("TLS certificate and TLS key must be set together")
+	}
+
+	switch c.DryRunFormat {
+	case "", DryRunFormatJSON, DryRunFormatWire:
+	default:
+		return fmt.Errorf("unknown dry run format: %s", c.DryRunFormat)
+	}
+
+	return nil
+}
+
 // setFromConfigs -  sets configuration values from instances obtained
 // from command line variables, environment variables, configuration file variables.
 func (c *ConfigAgent) setFromConfigs(configCL, configENV, configFile *ConfigAgent, path string) {
 	c.Server = getConfigVar(
 		configCL.Server, configENV.Server, configFile.Server, defaultMetcollAddress, "")
 
+	c.Servers = getConfigSliceVar(configCL.Servers, configENV.Servers, configFile.Servers)
+
+	c.SendMode = getConfigVar(
+		configCL.SendMode, configENV.SendMode, configFile.SendMode, SendModeFailover, "")
+
 	c.PollInterval = getConfigVar(
 		configCL.PollInterval, configENV.PollInterval, configFile.PollInterval, defaultPollInterval, 0)
 
@@ -111,18 +360,138 @@ func (c *ConfigAgent) setFromConfigs(configCL, configENV, configFile *ConfigAgen
 	c.CertFilePath = getConfigVar(
 		configCL.CertFilePath, configENV.CertFilePath, configFile.CertFilePath, defaultCryptoKeyPath, "")
 
+	c.IngestAddress = getConfigVar(
+		configCL.IngestAddress, configENV.IngestAddress, configFile.IngestAddress, defaultIngestAddress, "")
+
+	c.IngestSocket = getConfigVar(
+		configCL.IngestSocket, configENV.IngestSocket, configFile.IngestSocket, defaultIngestSocket, "")
+
+	c.ScrapeTargets = getConfigSliceVar(configCL.ScrapeTargets, configENV.ScrapeTargets, configFile.ScrapeTargets)
+
+	c.ScrapePrefix = getConfigVar(
+		configCL.ScrapePrefix, configENV.ScrapePrefix, configFile.ScrapePrefix, defaultScrapePrefix, "")
+
+	c.ScrapeInclude = getConfigSliceVar(configCL.ScrapeInclude, configENV.ScrapeInclude, configFile.ScrapeInclude)
+
+	c.ScrapeExclude = getConfigSliceVar(configCL.ScrapeExclude, configENV.ScrapeExclude, configFile.ScrapeExclude)
+
+	c.Aggregate = getConfigSliceVar(configCL.Aggregate, configENV.Aggregate, configFile.Aggregate)
+
+	c.ExecProbes = configFile.ExecProbes
+
+	c.LogTails = configFile.LogTails
+
+	c.LogStatePath = getConfigVar(
+		configCL.LogStatePath, configENV.LogStatePath, configFile.LogStatePath, defaultLogStatePath, "")
+
+	c.QueuePath = getConfigVar(
+		configCL.QueuePath, configENV.QueuePath, configFile.QueuePath, defaultQueuePath, "")
+
+	c.QueueMaxSize = getConfigVar(
+		configCL.QueueMaxSize, configENV.QueueMaxSize, configFile.QueueMaxSize, defaultQueueMaxSize, 0)
+
+	c.QueueFsync = getConfigVar(
+		configCL.QueueFsync, configENV.QueueFsync, configFile.QueueFsync, defaultQueueFsync, false)
+
+	c.DeltaOnly = getConfigVar(
+		configCL.DeltaOnly, configENV.DeltaOnly, configFile.DeltaOnly, defaultDeltaOnly, false)
+
+	c.DeltaAbsolute = getConfigVar(
+		configCL.DeltaAbsolute, configENV.DeltaAbsolute, configFile.DeltaAbsolute, defaultDeltaAbsolute, 0)
+
+	c.DeltaRelative = getConfigVar(
+		configCL.DeltaRelative, configENV.DeltaRelative, configFile.DeltaRelative, defaultDeltaRelative, 0)
+
+	c.ResyncReports = getConfigVar(
+		configCL.ResyncReports, configENV.ResyncReports, configFile.ResyncReports, defaultResyncReports, 0)
+
+	c.AgentID = getConfigVar(
+		configCL.AgentID, configENV.AgentID, configFile.AgentID, defaultAgentID, "")
+
+	c.Labels = getConfigSliceVar(configCL.Labels, configENV.Labels, configFile.Labels)
+
+	c.ProfileInterval = getConfigVar(
+		configCL.ProfileInterval, configENV.ProfileInterval, configFile.ProfileInterval, defaultProfileInterval, 0)
+
+	c.StatusAddress = getConfigVar(
+		configCL.StatusAddress, configENV.StatusAddress, configFile.StatusAddress, defaultStatusAddress, "")
+
+	c.AgentIP = getConfigVar(configCL.AgentIP, configENV.AgentIP, configFile.AgentIP, "", "")
+	c.AgentInterface = getConfigVar(
+		configCL.AgentInterface, configENV.AgentInterface, configFile.AgentInterface, "", "")
+	c.AgentSubnet = getConfigVar(configCL.AgentSubnet, configENV.AgentSubnet, configFile.AgentSubnet, "", "")
+	c.SigningKey = getConfigVar(configCL.SigningKey, configENV.SigningKey, configFile.SigningKey, "", "")
+	c.APIToken = getConfigVar(configCL.APIToken, configENV.APIToken, configFile.APIToken, "", "")
+	c.Tenant = getConfigVar(configCL.Tenant, configENV.Tenant, configFile.Tenant, "", "")
+
+	c.UseTLS = getConfigVar(configCL.UseTLS, configENV.UseTLS, configFile.UseTLS, defaultUseTLS, false)
+	c.TLSCA = getConfigVar(configCL.TLSCA, configENV.TLSCA, configFile.TLSCA, "", "")
+	c.TLSCert = getConfigVar(configCL.TLSCert, configENV.TLSCert, configFile.TLSCert, "", "")
+	c.TLSKey = getConfigVar(configCL.TLSKey, configENV.TLSKey, configFile.TLSKey, "", "")
+	c.TLSServerName = getConfigVar(
+		configCL.TLSServerName, configENV.TLSServerName, configFile.TLSServerName, "", "")
+
+	// zero retries are allowed, so the unset value is negative.
+	c.RetryMax = getConfigVar(configCL.RetryMax, configENV.RetryMax, configFile.RetryMax, defaultRetryMax, -1)
+	c.RetryWaitMin = getConfigVar(
+		configCL.RetryWaitMin, configENV.RetryWaitMin, configFile.RetryWaitMin, defaultRetryWaitMin, 0)
+	c.RetryWaitMax = getConfigVar(
+		configCL.RetryWaitMax, configENV.RetryWaitMax, configFile.RetryWaitMax, defaultRetryWaitMax, 0)
+
+	c.Once = getConfigVar(configCL.Once, configENV.Once, false, defaultOnce, false)
+	c.DryRun = getConfigVar(configCL.DryRun, configENV.DryRun, false, defaultDryRun, false)
+	c.DryRunFormat = getConfigVar(configCL.DryRunFormat, configENV.DryRunFormat, "", DryRunFormatJSON, "")
+
 	c.Path = path
 }
 
 // UnmarshalJSON - For anmarshaling of the time parameters of the configuration file.
 func (c *ConfigAgent) UnmarshalJSON(data []byte) error {
 	type ConfigAgentJSON struct {
-		Server         string `json:"address,omitempty"`
-		PollInterval   string `json:"poll_interval,omitempty"`
-		ReportInterval string `json:"report_interval,omitempty"`
-		HashKey        string `json:"hashkey"`
-		CertFilePath   string `json:"certificate"`
-		UseProtobuff   bool   `json:"use_protobuff"`
+		Server          string      `json:"address,omitempty"`
+		SendMode        string      `json:"send_mode"`
+		Servers         []string    `json:"addresses"`
+		PollInterval    string      `json:"poll_interval,omitempty"`
+		ReportInterval  string      `json:"report_interval,omitempty"`
+		HashKey         string      `json:"hashkey"`
+		CertFilePath    string      `json:"certificate"`
+		IngestAddress   string      `json:"ingest_address"`
+		IngestSocket    string      `json:"ingest_socket"`
+		ScrapePrefix    string      `json:"scrape_prefix"`
+		ScrapeTargets   []string    `json:"scrape_targets"`
+		Aggregate       []string    `json:"aggregate"`
+		ScrapeInclude   []string    `json:"scrape_include"`
+		ScrapeExclude   []string    `json:"scrape_exclude"`
+		LogStatePath    string      `json:"log_state_file"`
+		QueuePath       string      `json:"queue_path"`
+		AgentID         string      `json:"agent_id"`
+		StatusAddress   string      `json:"status_address"`
+		Labels          []string    `json:"labels"`
+		ProfileInterval string      `json:"profile_interval"`
+		RetryWaitMin    string      `json:"retry_wait_min"`
+		AgentIP         string      `json:"agent_ip"`
+		AgentInterface  string      `json:"agent_interface"`
+		AgentSubnet     string      `json:"agent_subnet"`
+		SigningKey      string      `json:"signing_key"`
+		APIToken        string      `json:"api_token"`
+		Tenant          string      `json:"tenant"`
+		TLSCA           string      `json:"tls_ca"`
+		TLSCert         string      `json:"tls_cert"`
+		TLSKey          string      `json:"tls_key"`
+		TLSServerName   string      `json:"tls_server_name"`
+		RetryWaitMax    string      `json:"retry_wait_max"`
+		RetryMax        *int        `json:"retry_max"`
+		ExecProbes      []ExecProbe `json:"exec_probes"`
+		LogTails        []LogTail   `json:"log_tails"`
+		QueueMaxSize    int64       `json:"queue_max_size"`
+		DeltaAbsolute   float64     `json:"delta_absolute"`
+		DeltaRelative   float64     `json:"delta_relative"`
+		ResyncReports   int         `json:"resync_reports"`
+		Limit           int         `json:"rate_limit"`
+		UseProtobuff    bool        `json:"use_protobuff"`
+		UseTLS          bool        `json:"use_tls"`
+		QueueFsync      bool        `json:"queue_fsync"`
+		DeltaOnly       bool        `json:"delta_only"`
 	}
 
 	var v ConfigAgentJSON
@@ -131,6 +500,10 @@ func (c *ConfigAgent) UnmarshalJSON(data []byte) error {
 	}
 
 	c.Server = v.Server
+	c.Servers = v.Servers
+	if v.SendMode != "" {
+		c.SendMode = v.SendMode
+	}
 	pi, err := time.ParseDuration(v.PollInterval)
 	if err != nil {
 		return fmt.Errorf("cannot parse poll interval duration err: %w", err)
@@ -143,12 +516,95 @@ func (c *ConfigAgent) UnmarshalJSON(data []byte) error {
 	}
 	c.ReportInterval = int(ri.Seconds())
 	c.UseProtobuff = v.UseProtobuff
+	if v.Limit != 0 {
+		c.Limit = v.Limit
+	}
 	c.Key = []byte(v.HashKey)
 	c.CertFilePath = v.CertFilePath
+	c.IngestAddress = v.IngestAddress
+	c.IngestSocket = v.IngestSocket
+	c.ScrapePrefix = v.ScrapePrefix
+	c.ScrapeTargets = v.ScrapeTargets
+	c.Aggregate = v.Aggregate
+	c.ScrapeInclude = v.ScrapeInclude
+	c.ScrapeExclude = v.ScrapeExclude
+	c.ExecProbes = v.ExecProbes
+	c.LogTails = v.LogTails
+	c.LogStatePath = v.LogStatePath
+	c.QueuePath = v.QueuePath
+	if v.QueueMaxSize != 0 {
+		c.QueueMaxSize = v.QueueMaxSize
+	}
+	c.QueueFsync = v.QueueFsync
+	c.DeltaOnly = v.DeltaOnly
+	c.DeltaAbsolute = v.DeltaAbsolute
+	c.DeltaRelative = v.DeltaRelative
+	if v.ResyncReports != 0 {
+		c.ResyncReports = v.ResyncReports
+	}
+	c.AgentID = v.AgentID
+	c.StatusAddress = v.StatusAddress
+	c.Labels = v.Labels
+	c.AgentIP = v.AgentIP
+	c.AgentInterface = v.AgentInterface
+	c.AgentSubnet = v.AgentSubnet
+	c.SigningKey = v.SigningKey
+	c.APIToken = v.APIToken
+	c.Tenant = v.Tenant
+	c.UseTLS = v.UseTLS
+	c.TLSCA = v.TLSCA
+	c.TLSCert = v.TLSCert
+	c.TLSKey = v.TLSKey
+	c.TLSServerName = v.TLSServerName
+
+	profileInterval, err := parseOptionalDuration(v.ProfileInterval)
+	if err != nil {
+		return fmt.Errorf("cannot parse profile interval duration err: %w", err)
+	}
+	c.ProfileInterval = int(profileInterval.Seconds())
+
+	if v.RetryMax != nil {
+		c.RetryMax = *v.RetryMax
+	}
+
+	retryWaitMin, err := parseOptionalDuration(v.RetryWaitMin)
+	if err != nil {
+		return fmt.Errorf("cannot parse retry wait min duration err: %w", err)
+	}
+	if retryWaitMin != 0 {
+		c.RetryWaitMin = int(retryWaitMin.Seconds())
+	}
+
+	retryWaitMax, err := parseOptionalDuration(v.RetryWaitMax)
+	if err != nil {
+		return fmt.Errorf("cannot parse retry wait max duration err: %w", err)
+	}
+	if retryWaitMax != 0 {
+		c.RetryWaitMax = int(retryWaitMax.Seconds())
+	}
 
 	return nil
 }
 
+// ID - returns the ID of the agent from the configuration or the hostname.
+func (c *ConfigAgent) ID() string {
+	if c.AgentID != "" {
+		return c.AgentID
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return hostname
+}
+
+// TLSEnabled - returns true if the agent connects to the servers over TLS.
+func (c *ConfigAgent) TLSEnabled() bool {
+	return c.UseTLS || c.TLSCA != "" || c.TLSCert != ""
+}
+
 func (c *ConfigAgent) String() string {
 	return fmt.Sprintf("Addres: %s, ReportInterval: %d, PollInterval: %d, Limit: %d, Path: %s",
 		c.Server, c.ReportInterval, c.PollInterval, c.Limit, c.Path)
@@ -178,6 +634,12 @@ func readConfigAgentFromCL() *ConfigAgent {
 
 	var hashkey string
 	flag.StringVar(&c.Server, metcollAddressFlagName, defaultMetcollAddress, "address metcoll server")
+	flag.Func(metcollAddressesFlagName, "comma separated list of metcoll servers", func(v string) error {
+		c.Servers = splitList(v)
+		return nil
+	})
+	flag.StringVar(&c.SendMode, sendModeFlagName, SendModeFailover,
+		"mode of sending to several servers: failover - to the first healthy server, fanout - to all servers")
 	flag.StringVar(&c.Path, configFlagName, defaultConfigPath, "path to json config file")
 	flag.IntVar(&c.ReportInterval, reportIntervalFlagName, defaultReportInterval, "report push interval")
 	flag.IntVar(&c.PollInterval, pollIntervalFlagName, defaultPollInterval, "poll interval")
@@ -186,9 +648,86 @@ func readConfigAgentFromCL() *ConfigAgent {
 	flag.StringVar(&c.PublicCryptoKey, cryptoKeyFlagName, defaultCryptoKeyPath, "path to publickey.pem")
 	flag.BoolVar(&c.UseProtobuff, useProtobuffFlagName, defaultUseProtobuff, "use protobuf instead of http protocol")
 	flag.StringVar(&c.CertFilePath, certFileFlagName, defaultCertFilePath, "absolute path to certificate (x509)")
+	flag.StringVar(&c.IngestAddress, ingestAddressFlagName, defaultIngestAddress,
+		"address of the local listener for application metrics, example localhost:8125")
+	flag.StringVar(&c.IngestSocket, ingestSocketFlagName, defaultIngestSocket,
+		"path to the unix socket for application metrics")
+	flag.Func(scrapeTargetsFlagName, "comma separated list of prometheus endpoints for scraping", func(v string) error {
+		c.ScrapeTargets = splitList(v)
+		return nil
+	})
+	flag.Func(aggregateFlagName,
+		"comma separated list of gauges aggregated within the report window, example Alloc,CPUutilization1:max",
+		func(v string) error {
+			c.Aggregate = splitList(v)
+			return nil
+		})
+	flag.StringVar(&c.LogStatePath, logStateFlagName, defaultLogStatePath,
+		"path to the file where the offsets of the tailed logs are saved")
+	flag.StringVar(&c.QueuePath, queuePathFlagName, defaultQueuePath,
+		"path to the directory of the persistent send queue")
+	flag.Int64Var(&c.QueueMaxSize, queueMaxSizeFlagName, defaultQueueMaxSize,
+		"max size of the send queue in bytes, the oldest batches are dropped when it is exceeded")
+	flag.BoolVar(&c.QueueFsync, queueFsyncFlagName, defaultQueueFsync, "fsync every batch written to the send queue")
+	flag.BoolVar(&c.DeltaOnly, deltaOnlyFlagName, defaultDeltaOnly, "send only the gauges that have changed")
+	flag.Float64Var(&c.DeltaAbsolute, deltaAbsoluteFlagName, defaultDeltaAbsolute,
+		"minimal absolute change of the gauge to be sent in delta only mode")
+	flag.Float64Var(&c.DeltaRelative, deltaRelativeFlagName, defaultDeltaRelative,
+		"minimal relative change of the gauge to be sent in delta only mode, example 0.05")
+	flag.IntVar(&c.ResyncReports, resyncReportsFlagName, defaultResyncReports,
+		"count of reports after which all gauges are sent in delta only mode")
+	flag.StringVar(&c.AgentID, agentIDFlagName, defaultAgentID,
+		"unique name of the agent used to select its configuration profile, the hostname by default")
+	flag.Func(labelsFlagName, "comma separated list of the agent labels, example web,eu-west", func(v string) error {
+		c.Labels = splitList(v)
+		return nil
+	})
+	flag.IntVar(&c.ProfileInterval, profileIntervalFlagName, defaultProfileInterval,
+		"interval of fetching the configuration profile from the server in seconds, 0 disables fetching")
+	flag.StringVar(&c.StatusAddress, statusAddressFlagName, defaultStatusAddress,
+		"address of the local http listener with the agent status, example localhost:8081")
+	flag.StringVar(&c.AgentIP, agentIPFlagName, "", "IPv4 or IPv6 address the agent reports to the servers")
+	flag.StringVar(&c.AgentInterface, agentInterfaceFlagName, "",
+		"network interface whose address the agent reports to the servers")
+	flag.StringVar(&c.AgentSubnet, agentSubnetFlagName, "",
+		"subnet in CIDR notation that contains the address the agent reports to the servers")
+	flag.StringVar(&c.SigningKey, signingKeyFlagName, "",
+		"path to the Ed25519 private key (PKCS #8 PEM) the agent signs the requests with")
+	flag.StringVar(&c.APIToken, apiTokenFlagName, "", "API token the agent is authenticated with on the servers")
+	flag.StringVar(&c.Tenant, tenantFlagName, "", "tenant the metrics are sent to, the default tenant if it is empty")
+	flag.BoolVar(&c.UseTLS, useTLSFlagName, defaultUseTLS, "connect to the http servers over TLS")
+	flag.StringVar(&c.TLSCA, tlsCAFlagName, "",
+		"path to the CA bundle (PEM) the server certificates are verified with, enables TLS")
+	flag.StringVar(&c.TLSCert, tlsCertFlagName, "",
+		"path to the client certificate (PEM) of the agent, enables TLS, the file is reloaded when it changes")
+	flag.StringVar(&c.TLSKey, tlsKeyFlagName, "", "path to the private key (PEM) of the client certificate")
+	flag.StringVar(&c.TLSServerName, tlsServerNameFlagName, "",
+		"name the server certificates are verified for, the host of the server by default")
+	flag.IntVar(&c.RetryMax, retryMaxFlagName, defaultRetryMax,
+		"max count of the retries of the failed http request, 0 disables retries")
+	flag.IntVar(&c.RetryWaitMin, retryWaitMinFlagName, defaultRetryWaitMin,
+		"min wait before the retry of the http request in seconds, the wait is doubled with every retry")
+	flag.IntVar(&c.RetryWaitMax, retryWaitMaxFlagName, defaultRetryWaitMax,
+		"max wait before the retry of the http request in seconds")
+	flag.BoolVar(&c.Once, onceFlagName, defaultOnce, "collect and send a single batch, then exit")
+	flag.BoolVar(&c.DryRun, dryRunFlagName, defaultDryRun,
+		"collect a single batch and print it to stdout without sending to the server")
+	flag.StringVar(&c.DryRunFormat, dryRunFormatFlagName, DryRunFormatJSON,
+		"format of the dry run output: json - the batch, wire - the request sent to the server")
+	flag.StringVar(&c.ScrapePrefix, scrapePrefixFlagName, defaultScrapePrefix, "prefix for the scraped metric IDs, the host and port of the target follow it")
+	flag.Func(scrapeIncludeFlagName, "comma separated list of regexps for the scraped metrics", func(v string) error {
+		c.ScrapeInclude = splitList(v)
+		return nil
+	})
+	flag.Func(scrapeExcludeFlagName, "comma separated list of regexps for the ignored metrics", func(v string) error {
+		c.ScrapeExclude = splitList(v)
+		return nil
+	})
 
 	flag.Parse()
 
+	c.Key = []byte(hashkey)
+
 	return c
 }
 
@@ -253,6 +792,35 @@ func getConfigVar[val comparable](varCL, varENV, varFile, def, empty val) val {
 	return v
 }
 
+// getConfigSliceVar - check len slice variables received from
+// the application command line, environment variable, and configuration file.
+//
+// Environment variables have higher priority over command line variables and config file.
+// Command line variables have higher priority over variables from config file.
+func getConfigSliceVar[val any](varCL, varENV, varFile []val) []val {
+	if len(varENV) != 0 {
+		return varENV
+	}
+
+	if len(varCL) != 0 {
+		return varCL
+	}
+
+	return varFile
+}
+
+// splitList - splits a comma separated list and drops the empty elements.
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
 // getConfigByteVar - check len bytes variables received from
 // the application command line, environment variable, and configuration file.
 //
@@ -147,7 +147,11 @@ func Test_readConfigAgentFromFile(t *testing.T) {
 		"report_interval": "1m",
 		"poll_interval": "1h",
 		"crypto_key": "/path/to/key.pem",
-		"hashkey": "nope"
+		"hashkey": "nope",
+		"retry_max": 0,
+		"retry_wait_min": "2s",
+		"retry_wait_max": "1m",
+		"api_token": "secret"
 	}`)
 
 	jsonConfigErr := newAgentConfigFile(t,
@@ -169,6 +173,10 @@ func Test_readConfigAgentFromFile(t *testing.T) {
 	want2.ReportInterval = reportInterval
 	want2.PollInterval = pollInterval
 	want2.Key = []byte("nope")
+	want2.RetryMax = 0
+	want2.RetryWaitMin = 2
+	want2.RetryWaitMax = 60
+	want2.APIToken = "secret"
 
 	wantErr := newConfigAgent()
 
@@ -230,3 +238,62 @@ func newAgentConfigFile(t *testing.T, jsonText string) string {
 
 	return f.Name()
 }
+
+func TestConfigAgent_Validate(t *testing.T) {
+	valid := func() *ConfigAgent {
+		c := newConfigAgent()
+		c.SendMode = SendModeFailover
+		return c
+	}
+
+	tests := []struct {
+		modify  func(c *ConfigAgent)
+		name    string
+		wantErr bool
+	}{
+		{name: "default", modify: func(c *ConfigAgent) {}},
+		{name: "zero poll interval", modify: func(c *ConfigAgent) { c.PollInterval = 0 }, wantErr: true},
+		{name: "zero report interval", modify: func(c *ConfigAgent) { c.ReportInterval = 0 }, wantErr: true},
+		{name: "zero limit", modify: func(c *ConfigAgent) { c.Limit = 0 }, wantErr: true},
+		{name: "unknown send mode", modify: func(c *ConfigAgent) { c.SendMode = "roundrobin" }, wantErr: true},
+		{name: "negative delta", modify: func(c *ConfigAgent) { c.DeltaRelative = -1 }, wantErr: true},
+		{name: "incorrect agent IP", modify: func(c *ConfigAgent) { c.AgentIP = "10.0.0" }, wantErr: true},
+		{name: "IPv6 agent IP", modify: func(c *ConfigAgent) { c.AgentIP = "2001:db8::1" }},
+		{name: "incorrect agent subnet", modify: func(c *ConfigAgent) { c.AgentSubnet = "10.0.0.0" }, wantErr: true},
+		{name: "negative retry max", modify: func(c *ConfigAgent) { c.RetryMax = -1 }, wantErr: true},
+		{name: "retry wait min exceeds max", modify: func(c *ConfigAgent) { c.RetryWaitMin = 10 }, wantErr: true},
+		{name: "unknown dry run format", modify: func(c *ConfigAgent) { c.DryRunFormat = "yaml" }, wantErr: true},
+		{name: "TLS certificate without key", modify: func(c *ConfigAgent) { c.TLSCert = "agent.crt" }, wantErr: true},
+		{name: "TLS certificate with key", modify: func(c *ConfigAgent) { c.TLSCert, c.TLSKey = "agent.crt", "agent.key" }},
+		{name: "profiles without hash key", modify: func(c *ConfigAgent) { c.ProfileInterval = 60 }, wantErr: true},
+		{name: "profiles with hash key", modify: func(c *ConfigAgent) { c.ProfileInterval, c.Key = 60, []byte("key") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			assert.Equal(t, tt.wantErr, c.Validate() != nil)
+		})
+	}
+}
+
+func TestConfigAgent_Reload(t *testing.T) {
+	path := newAgentConfigFile(t, `{
+		"report_interval": "5s",
+		"poll_interval": "1s",
+		"rate_limit": 3
+	}`)
+
+	c := &ConfigAgent{Path: path, configCL: newConfigAgent()}
+	got, err := c.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, got.ReportInterval)
+	assert.Equal(t, 1, got.PollInterval)
+	assert.Equal(t, 3, got.Limit)
+	assert.Equal(t, path, got.Path)
+
+	broken := newAgentConfigFile(t, `{"report_interval": "5"}`)
+	c = &ConfigAgent{Path: broken}
+	_, err = c.Reload()
+	assert.Error(t, err)
+}
@@ -113,6 +113,7 @@ func Test_readConfigFromFile(t *testing.T) {
 			"store_file": "/tmp/metrics-db.json", 
 			"database_dsn": "", 
 			"crypto_key": "/path/to/key.pem",
+			"crypto_key_dir": "/path/to/keys",
 			"hashkey": "nope"
 		}`)
 
@@ -125,11 +126,14 @@ func Test_readConfigFromFile(t *testing.T) {
 	want := newConfig()
 	want.StoreInterval = 1
 	want.Key = []byte("nope")
+	want.PrivateCryptoKey = "/path/to/key.pem"
 
 	want2 := newConfig()
 	want2.Address = "localhost:8090"
 	want2.StoreInterval = 60
 	want2.Key = []byte("nope")
+	want2.PrivateCryptoKey = "/path/to/key.pem"
+	want2.CryptoKeyDir = "/path/to/keys"
 
 	wantErr := newConfig()
 
@@ -0,0 +1,97 @@
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// agentProfileJSON - settings of the agent that can be set by the configuration profile of the metcoll server.
+// Absent settings keep the local values of the agent.
+// The exec probes run the commands on the host, the scrape targets make requests from the host
+// and the log tails read its files, so they are set only by the local configuration.
+type agentProfileJSON struct {
+	PollInterval   *string  `json:"poll_interval"`
+	ReportInterval *string  `json:"report_interval"`
+	Limit          *int     `json:"rate_limit"`
+	ScrapePrefix   *string  `json:"scrape_prefix"`
+	DeltaOnly      *bool    `json:"delta_only"`
+	DeltaAbsolute  *float64 `json:"delta_absolute"`
+	DeltaRelative  *float64 `json:"delta_relative"`
+	ResyncReports  *int     `json:"resync_reports"`
+	Aggregate      []string `json:"aggregate"`
+	ScrapeInclude  []string `json:"scrape_include"`
+	ScrapeExclude  []string `json:"scrape_exclude"`
+}
+
+// WithProfile - returns the configuration with the settings of the profile applied.
+//
+// The profile is the JSON object with the intervals, the rate limit, the report settings and the scrape filters.
+// Settings that are not supported by profiles, such as the servers, the keys, the exec probes,
+// the scrape targets or the log tails, are rejected.
+func (c *ConfigAgent) WithProfile(profile []byte) (*ConfigAgent, error) {
+	dec := json.NewDecoder(bytes.NewReader(profile))
+	dec.DisallowUnknownFields()
+
+	var v agentProfileJSON
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("agent profile unmarshal error, err: %w", err)
+	}
+
+	n := *c
+	if v.PollInterval != nil {
+		pi, err := time.ParseDuration(*v.PollInterval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse profile poll interval duration err: %w", err)
+		}
+		n.PollInterval = int(pi.Seconds())
+	}
+
+	if v.ReportInterval != nil {
+		ri, err := time.ParseDuration(*v.ReportInterval)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse profile report interval duration err: %w", err)
+		}
+		n.ReportInterval = int(ri.Seconds())
+	}
+
+	setIfPresent(&n.Limit, v.Limit)
+	setIfPresent(&n.ScrapePrefix, v.ScrapePrefix)
+	setIfPresent(&n.DeltaOnly, v.DeltaOnly)
+	setIfPresent(&n.DeltaAbsolute, v.DeltaAbsolute)
+	setIfPresent(&n.DeltaRelative, v.DeltaRelative)
+	setIfPresent(&n.ResyncReports, v.ResyncReports)
+
+	// An empty list in the profile clears the local value, an absent list keeps it.
+	if v.Aggregate != nil {
+		n.Aggregate = v.Aggregate
+	}
+	if v.ScrapeInclude != nil {
+		n.ScrapeInclude = v.ScrapeInclude
+	}
+	if v.ScrapeExclude != nil {
+		n.ScrapeExclude = v.ScrapeExclude
+	}
+
+	if err := n.Validate(); err != nil {
+		return nil, fmt.Errorf("agent configuration with the profile is incorrect, err: %w", err)
+	}
+
+	return &n, nil
+}
+
+// ValidateAgentProfile - checks that the profile can be applied to the agent with the default configuration.
+func ValidateAgentProfile(profile []byte) error {
+	if _, err := newConfigAgent().WithProfile(profile); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func setIfPresent[val any](dst *val, v *val) {
+	if v != nil {
+		*dst = *v
+	}
+}
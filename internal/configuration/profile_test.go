@@ -0,0 +1,96 @@
+//go:build usetempdir
+// +build usetempdir
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigAgent_WithProfile(t *testing.T) {
+	local := newConfigAgent()
+	local.Server = localhost8090
+	local.Key = []byte("key")
+	local.ScrapeTargets = []string{"http://localhost:9100/metrics"}
+	local.Aggregate = []string{"Alloc"}
+
+	tests := []struct {
+		name    string
+		profile string
+		want    func(c *ConfigAgent)
+		wantErr bool
+	}{
+		{
+			name:    "intervals and scrape filters",
+			profile: `{"poll_interval":"1s","report_interval":"30s","rate_limit":4,"scrape_include":["go_.*"],"scrape_exclude":[]}`,
+			want: func(c *ConfigAgent) {
+				c.PollInterval = 1
+				c.ReportInterval = 30
+				c.Limit = 4
+				c.ScrapeInclude = []string{"go_.*"}
+				c.ScrapeExclude = []string{}
+			},
+		},
+		{
+			name:    "delta reporting",
+			profile: `{"delta_only":true,"delta_relative":0.1}`,
+			want: func(c *ConfigAgent) {
+				c.DeltaOnly = true
+				c.DeltaRelative = 0.1
+			},
+		},
+		{
+			name:    "empty profile",
+			profile: `{}`,
+			want:    func(c *ConfigAgent) {},
+		},
+		{
+			name:    "servers cannot be set",
+			profile: `{"address":"localhost:9090"}`,
+			wantErr: true,
+		},
+		{
+			name:    "exec probes cannot be set",
+			profile: `{"exec_probes":[{"name":"echo","command":["echo","1"],"interval":"5s"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "scrape targets cannot be set",
+			profile: `{"scrape_targets":["http://169.254.169.254/latest/meta-data"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "log tails cannot be set",
+			profile: `{"log_tails":[{"path":"/etc/shadow","rules":[{"pattern":"root","metric":"root","type":"counter"}]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "incorrect interval",
+			profile: `{"report_interval":"0s"}`,
+			wantErr: true,
+		},
+		{
+			name:    "incorrect json",
+			profile: `{"poll_interval":`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := local.WithProfile([]byte(tt.profile))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			want := *local
+			tt.want(&want)
+			assert.Equal(t, &want, got)
+			assert.Equal(t, []string{"http://localhost:9100/metrics"}, local.ScrapeTargets)
+		})
+	}
+}
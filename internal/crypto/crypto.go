@@ -1,67 +1,294 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
-
-	"go.uber.org/zap"
 )
 
+// GetKeyBytes - returns the content of the key file.
 func GetKeyBytes(pathToKey string) ([]byte, error) {
-	f, err := os.Open(pathToKey)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil, nil
+	buf, err := os.ReadFile(pathToKey)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred when reading the key file %s, err: %w", pathToKey, err)
+	}
+
+	return buf, nil
+}
+
+// envelopeMagic - the prefix of the payloads encrypted with the data key.
+// The payloads without it are encrypted with the RSA key only by the agents of the previous versions.
+var envelopeMagic = []byte("MCE")
+
+const (
+	// envelopeV1 - AES-256-GCM data key wrapped with RSA-OAEP SHA-256.
+	envelopeV1 byte = 1
+	// envelopeV2 - same as envelopeV1 with the ID of the key that wraps the data key.
+	envelopeV2 byte = 2
+
+	dataKeySize   = 32
+	wrappedLenLen = 2
+	keyIDSize     = 8
+	nonceSize     = 12
+)
+
+// oaepLabel - binds the wrapped data key to the payloads of metcoll.
+var oaepLabel = []byte("metcoll")
+
+// ErrUnknownKey - error occurs when the payload is encrypted with the key that is not in the keyring.
+var ErrUnknownKey = errors.New("unknown key ID")
+
+// Encrypt - encrypts the message with the random AES-256-GCM data key, the data key is encrypted with RSA-OAEP.
+// The result is magic | version | key ID | wrapped key length | wrapped key | nonce | ciphertext,
+// so the size of the message is not limited by the size of the key.
+func Encrypt(publicKey []byte, msg []byte) ([]byte, error) {
+	pub, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := keyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("an error occurred while generating data key, err: %w", err)
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, dataKey, oaepLabel)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while encrypt data key, err: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(envelopeMagic)+1+keyIDSize+wrappedLenLen+len(wrapped)+gcm.NonceSize())
+	header = append(header, envelopeMagic...)
+	header = append(header, envelopeV2)
+	header = append(header, kid...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("an error occurred while generating nonce, err: %w", err)
+	}
+
+	// the header is authenticated, so the key ID and the wrapped key cannot be replaced.
+	return gcm.Seal(append(header, nonce...), nonce, msg, header), nil
+}
+
+// Decrypt - decrypts the message encrypted by Encrypt or with the RSA key only by the previous versions.
+func Decrypt(privateKey []byte, msg []byte) ([]byte, error) {
+	private, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
 	}
+
+	kid, err := keyID(&private.PublicKey)
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when opening a file with a key, err: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			zap.S().Errorf("an error occurred when closing file %s, err: %w", pathToKey, err)
+
+	return decrypt(map[string]*rsa.PrivateKey{hex.EncodeToString(kid): private}, msg)
+}
+
+// KeyID - returns the ID of the key pair, it is the beginning of the SHA-256 of the public key.
+// The private key is accepted as well.
+func KeyID(key []byte) (string, error) {
+	if pub, err := parsePublicKey(key); err == nil {
+		kid, err := keyID(pub)
+		if err != nil {
+			return "", err
 		}
-	}()
+		return hex.EncodeToString(kid), nil
+	}
 
-	i, err := f.Stat()
+	private, err := parsePrivateKey(key)
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred while retrieving file %s, err: %w", pathToKey, err)
+		return "", fmt.Errorf("the key is neither public nor private, err: %w", err)
 	}
-	buf := make([]byte, i.Size())
 
-	if _, err := f.Read(buf); err != nil {
-		return nil, fmt.Errorf("an error occurred when reading file %s, err: %w", pathToKey, err)
+	kid, err := keyID(&private.PublicKey)
+	if err != nil {
+		return "", err
 	}
 
-	return buf, nil
+	return hex.EncodeToString(kid), nil
 }
 
-func Encrypt(publicKey []byte, msg []byte) ([]byte, error) {
+func keyID(pub *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while marshal public key, err: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return sum[:keyIDSize], nil
+}
+
+// envelope - the parts of the message encrypted with the data key.
+type envelope struct {
+	keyID      string
+	wrapped    []byte
+	nonce      []byte
+	ciphertext []byte
+	header     []byte
+	version    byte
+}
+
+// decrypt - decrypts the message with the key selected by the key ID of the message.
+// The messages of the previous versions have no key ID, so every key is tried.
+func decrypt(keys map[string]*rsa.PrivateKey, msg []byte) ([]byte, error) {
+	env, ok := parseEnvelope(msg)
+	if ok && env.version == envelopeV2 {
+		private, ok := keys[env.keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, env.keyID)
+		}
+		return openEnvelope(private, env)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("there are no keys to decrypt the message")
+	}
+
+	var errs []error
+	if ok {
+		// the RSA only message may start with the magic by chance, its length is the size of the key.
+		for _, private := range keys {
+			if len(env.wrapped) != private.Size() {
+				continue
+			}
+			decrypted, err := openEnvelope(private, env)
+			if err == nil {
+				return decrypted, nil
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	for _, private := range keys {
+		decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, private, msg)
+		if err == nil {
+			return decrypted, nil
+		}
+		errs = append(errs, fmt.Errorf("an error occurred while decrypt text, err: %w", err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func openEnvelope(private *rsa.PrivateKey, env *envelope) ([]byte, error) {
+	if len(env.wrapped) != private.Size() {
+		return nil, fmt.Errorf("the wrapped data key size %d does not match the key size %d",
+			len(env.wrapped), private.Size())
+	}
+
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, private, env.wrapped, oaepLabel)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while decrypt data key, err: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := gcm.Open(nil, env.nonce, env.ciphertext, env.header)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while decrypt text, err: %w", err)
+	}
+
+	return decrypted, nil
+}
+
+// parseEnvelope - splits the message encrypted by Encrypt, ok is false for the messages of the previous versions.
+func parseEnvelope(msg []byte) (*envelope, bool) {
+	prefix := len(envelopeMagic) + 1
+	if len(msg) < prefix || !bytes.Equal(msg[:len(envelopeMagic)], envelopeMagic) {
+		return nil, false
+	}
+
+	env := &envelope{version: msg[len(envelopeMagic)]}
+	switch env.version {
+	case envelopeV1:
+	case envelopeV2:
+		if len(msg) < prefix+keyIDSize {
+			return nil, false
+		}
+		env.keyID = hex.EncodeToString(msg[prefix : prefix+keyIDSize])
+		prefix += keyIDSize
+	default:
+		return nil, false
+	}
+
+	if len(msg) < prefix+wrappedLenLen {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint16(msg[prefix:]))
+	prefix += wrappedLenLen
+	if len(msg) < prefix+n+nonceSize {
+		return nil, false
+	}
+
+	env.header = msg[:prefix+n]
+	env.wrapped = msg[prefix : prefix+n]
+	env.nonce = msg[prefix+n : prefix+n+nonceSize]
+	env.ciphertext = msg[prefix+n+nonceSize:]
+
+	return env, true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while init cipher, err: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while init GCM, err: %w", err)
+	}
+
+	return gcm, nil
+}
+
+func parsePublicKey(publicKey []byte) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode(publicKey)
 	if block == nil {
 		return nil, fmt.Errorf("encrypt PEM formatted block not found")
 	}
 
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("an error occurred while parse public key, err: %w", err)
 	}
 
-	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, pub.(*rsa.PublicKey), msg)
-	if err != nil {
-		if errors.Is(err, rsa.ErrMessageTooLong) {
-			return nil, fmt.Errorf("msg size: %d, key size: %d, err: %w", binary.Size(msg), binary.Size(publicKey), err)
-		}
-		return nil, fmt.Errorf("an error occurred while encrypt text, err: %w", err)
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key type %T is not supported", key)
 	}
-	return encrypted, nil
+
+	return pub, nil
 }
 
-func Decrypt(privateKey []byte, msg []byte) ([]byte, error) {
+func parsePrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privateKey)
 	if block == nil {
 		return nil, fmt.Errorf("decrypt PEM formatted block not found")
@@ -71,15 +298,11 @@ func Decrypt(privateKey []byte, msg []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("an error occurred while parse private key, err: %w", err)
 	}
+
 	private, ok := key.(*rsa.PrivateKey)
 	if !ok {
-		return nil, fmt.Errorf("an error occurred when check key, err: %w", err)
-	}
-
-	decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, private, msg)
-	if err != nil {
-		return nil, fmt.Errorf("an error occurred while decrypt text, err: %w", err)
+		return nil, fmt.Errorf("private key type %T is not supported", key)
 	}
 
-	return decrypted, nil
+	return private, nil
 }
@@ -4,6 +4,7 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -33,7 +34,8 @@ func TestEncryptDecrypt(t *testing.T) {
 		}
 	}()
 
-	if err := generateKeys(t, privFile, pubFile, 100); err != nil {
+	const bits = 2048
+	if err := generateKeys(t, privFile, pubFile, bits); err != nil {
 		t.Errorf("cannot generate keypair, err: %v", err)
 	}
 
@@ -65,11 +67,11 @@ func TestEncryptDecrypt(t *testing.T) {
 			args: args{
 				publicKey:  pubFile,
 				privateKey: privFile,
-				msg:        []byte("this is a super secret that has a super large size"),
+				msg:        bytes.Repeat([]byte("this is a super secret that has a super large size"), 100000),
 			},
 			wantErr:        false,
-			wantEncryptErr: true,
-			wantDecryptErr: true,
+			wantEncryptErr: false,
+			wantDecryptErr: false,
 		},
 		{
 			name: "#3",
@@ -105,6 +107,9 @@ func TestEncryptDecrypt(t *testing.T) {
 				t.Errorf("private key GetKeyBytes() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				return
+			}
 			assert.NotEqual(t, len(gotPrivate), 0)
 
 			gotPublic, err := GetKeyBytes(tt.args.publicKey)
@@ -135,6 +140,93 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecrypt(t *testing.T) {
+	td := t.TempDir()
+	privFile := path.Join(td, "priv.pem")
+	pubFile := path.Join(td, "pub.pem")
+
+	const bits = 2048
+	if err := generateKeys(t, privFile, pubFile, bits); err != nil {
+		t.Fatalf("cannot generate keypair, err: %v", err)
+	}
+
+	privateKey, err := GetKeyBytes(privFile)
+	if err != nil {
+		t.Fatalf("cannot read private key, err: %v", err)
+	}
+	publicKey, err := GetKeyBytes(pubFile)
+	if err != nil {
+		t.Fatalf("cannot read public key, err: %v", err)
+	}
+
+	msg := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+
+	t.Run("payload of the previous version", func(t *testing.T) {
+		pub, err := parsePublicKey(publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		legacy, err := rsa.EncryptPKCS1v15(rand.Reader, pub, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decrypted, err := Decrypt(privateKey, legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, msg, decrypted)
+	})
+
+	t.Run("payloads are different", func(t *testing.T) {
+		first, err := Encrypt(publicKey, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := Encrypt(publicKey, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NotEqual(t, first, second)
+		assert.Equal(t, envelopeMagic, first[:len(envelopeMagic)])
+		assert.Equal(t, envelopeV2, first[len(envelopeMagic)])
+	})
+
+	t.Run("tampered payload", func(t *testing.T) {
+		encrypted, err := Encrypt(publicKey, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted[len(encrypted)-1] ^= 1
+		_, err = Decrypt(privateKey, encrypted)
+		if err == nil {
+			t.Error("Decrypt() error = nil, want error")
+		}
+	})
+
+	t.Run("replaced data key", func(t *testing.T) {
+		encrypted, err := Encrypt(publicKey, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other, err := Encrypt(publicKey, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const header = 3 + 1 + keyIDSize + wrappedLenLen
+		copy(encrypted[header:header+bits/8], other[header:header+bits/8])
+		_, err = Decrypt(privateKey, encrypted)
+		if err == nil {
+			t.Error("Decrypt() error = nil, want error")
+		}
+	})
+}
+
 func generateKeys(t *testing.T, privFile string, pubFile string, bits int) error {
 	t.Helper()
 
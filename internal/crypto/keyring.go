@@ -0,0 +1,144 @@
+package crypto
+
+import (
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// keyExt - the extension of the private key files in the keyring directory.
+const keyExt = ".pem"
+
+// Keyring - the private keys of the server identified by the key ID.
+// The keys are read again on Reload, so the key is rotated by adding the new key,
+// switching the agents to the new public key and removing the old key without the restart of the server.
+type Keyring struct {
+	mux   *sync.RWMutex
+	keys  map[string]*rsa.PrivateKey
+	paths []string
+}
+
+// NewKeyring - Object constructor. The path is the private key file or the directory with *.pem private key files.
+// Returns nil if no path is set.
+func NewKeyring(paths ...string) (*Keyring, error) {
+	k := &Keyring{mux: &sync.RWMutex{}}
+	for _, p := range paths {
+		if p != "" {
+			k.paths = append(k.paths, p)
+		}
+	}
+	if len(k.paths) == 0 {
+		return nil, nil
+	}
+
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload - reads the keys again. The keys are kept unchanged if any of them cannot be read.
+func (k *Keyring) Reload() error {
+	keys := make(map[string]*rsa.PrivateKey)
+	for _, p := range k.paths {
+		files, dir, err := keyFiles(p)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			b, err := GetKeyBytes(f)
+			if err != nil {
+				return err
+			}
+
+			// the directory may also contain the public keys and the certificates, for example the rsagen output.
+			if dir && !isPrivateKey(b) {
+				continue
+			}
+
+			private, err := parsePrivateKey(b)
+			if err != nil {
+				return fmt.Errorf("cannot parse the key file %s, err: %w", f, err)
+			}
+
+			kid, err := keyID(&private.PublicKey)
+			if err != nil {
+				return err
+			}
+			keys[hex.EncodeToString(kid)] = private
+		}
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("there are no keys in %v", k.paths)
+	}
+
+	k.mux.Lock()
+	k.keys = keys
+	k.mux.Unlock()
+
+	return nil
+}
+
+// IDs - returns the sorted IDs of the keys.
+func (k *Keyring) IDs() []string {
+	k.mux.RLock()
+	defer k.mux.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Decrypt - decrypts the message encrypted by Encrypt with the key of the message key ID.
+// The messages of the previous versions are decrypted with any key that fits.
+func (k *Keyring) Decrypt(msg []byte) ([]byte, error) {
+	k.mux.RLock()
+	// the map is replaced on reload and never changed, so it is used without the lock.
+	keys := k.keys
+	k.mux.RUnlock()
+
+	return decrypt(keys, msg)
+}
+
+// keyFiles - returns the path if it is the file or the *.pem files of the directory.
+func keyFiles(path string) (files []string, dir bool, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot stat the key path %s, err: %w", path, err)
+	}
+
+	if !fi.IsDir() {
+		return []string{path}, false, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, true, fmt.Errorf("cannot read the key directory %s, err: %w", path, err)
+	}
+
+	for _, e := range entries {
+		if e.Type().IsRegular() && filepath.Ext(e.Name()) == keyExt {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+
+	return files, true, nil
+}
+
+func isPrivateKey(b []byte) bool {
+	block, _ := pem.Decode(b)
+	return block != nil && strings.HasSuffix(block.Type, "PRIVATE KEY")
+}
@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// LoadSigningKey - reads the Ed25519 private key from the PKCS #8 PEM file,
+// for example the key generated by `openssl genpkey -algorithm ed25519`.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := GetKeyBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("signing key PEM formatted block not found in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while parse signing key, err: %w", err)
+	}
+
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key type %T is not supported", key)
+	}
+
+	return private, nil
+}
+
+// EncodeVerifyKey - returns the public key in base64, the form in which it is registered on the server.
+func EncodeVerifyKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// ParseVerifyKey - parses the Ed25519 public key in base64 or in the PKIX PEM form.
+func ParseVerifyKey(key string) (ed25519.PublicKey, error) {
+	key = strings.TrimSpace(key)
+
+	if strings.HasPrefix(key, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(key))
+		if block == nil {
+			return nil, fmt.Errorf("verify key PEM formatted block not found")
+		}
+
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("an error occurred while parse verify key, err: %w", err)
+		}
+
+		edPub, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("verify key type %T is not supported", pub)
+		}
+		return edPub, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while decode verify key, err: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("verify key size %d is incorrect, want %d", len(b), ed25519.PublicKeySize)
+	}
+
+	return ed25519.PublicKey(b), nil
+}
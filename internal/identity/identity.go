@@ -0,0 +1,236 @@
+// Package identity is used to find the address the agent reports to the servers.
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+const (
+	// SourceConfig - the address is set in the configuration.
+	SourceConfig = "config"
+	// SourceInterface - the address of the network interface named in the configuration.
+	SourceInterface = "interface"
+	// SourceSubnet - the address of the host from the subnet set in the configuration.
+	SourceSubnet = "subnet"
+	// SourceRoute - the address of the interface the servers are reachable through.
+	SourceRoute = "route"
+	// SourceFallback - the first address of the host interfaces.
+	SourceFallback = "fallback"
+)
+
+// errNoAddress - error occurs when the host has no suitable address.
+var errNoAddress = errors.New("no suitable address")
+
+// Address - the address of the agent and the way it was found.
+type Address struct {
+	Source string
+	// Warnings - the reasons why the configured sources were skipped.
+	Warnings []error
+	IP       net.IP
+}
+
+// String - returns the IP address or an empty string if the address was not found.
+func (a *Address) String() string {
+	if a == nil || a.IP == nil {
+		return ""
+	}
+
+	return a.IP.String()
+}
+
+// netInterface - the network interface of the host and its addresses.
+type netInterface struct {
+	name  string
+	addrs []net.IP
+	up    bool
+}
+
+// resolver - looks up the addresses of the host, the lookups are replaced in tests.
+type resolver struct {
+	interfaces func() ([]netInterface, error)
+	route      func(server string) (net.IP, error)
+}
+
+// Resolve - returns the address of the agent. The address is taken from the configuration,
+// from the named interface, from the interface with an address in the subnet, from the route
+// to the first server or from the first interface of the host, in this order.
+// The sources that cannot provide the address are skipped with a warning,
+// the empty address is returned if no source has provided it.
+func Resolve(cfg *configuration.ConfigAgent) *Address {
+	r := &resolver{interfaces: hostInterfaces, route: routeIP}
+
+	return r.resolve(cfg)
+}
+
+func (r *resolver) resolve(cfg *configuration.ConfigAgent) *Address {
+	a := &Address{}
+
+	if cfg.AgentIP != "" {
+		ip := net.ParseIP(cfg.AgentIP)
+		if ip != nil {
+			a.IP, a.Source = ip, SourceConfig
+			return a
+		}
+		a.Warnings = append(a.Warnings, fmt.Errorf("agent IP %q is incorrect", cfg.AgentIP))
+	}
+
+	ifaces, err := r.interfaces()
+	if err != nil {
+		a.Warnings = append(a.Warnings, fmt.Errorf("cannot list network interfaces, err: %w", err))
+	}
+
+	if cfg.AgentInterface != "" {
+		ip, err := interfaceIP(ifaces, cfg.AgentInterface)
+		if err == nil {
+			a.IP, a.Source = ip, SourceInterface
+			return a
+		}
+		a.Warnings = append(a.Warnings, err)
+	}
+
+	if cfg.AgentSubnet != "" {
+		ip, err := subnetIP(ifaces, cfg.AgentSubnet)
+		if err == nil {
+			a.IP, a.Source = ip, SourceSubnet
+			return a
+		}
+		a.Warnings = append(a.Warnings, err)
+	}
+
+	servers := cfg.Servers
+	if len(servers) == 0 && cfg.Server != "" {
+		servers = []string{cfg.Server}
+	}
+	if len(servers) > 0 {
+		ip, err := r.route(servers[0])
+		if err == nil {
+			a.IP, a.Source = ip, SourceRoute
+			return a
+		}
+		a.Warnings = append(a.Warnings, fmt.Errorf("cannot find the route to %s, err: %w", servers[0], err))
+	}
+
+	if ip := firstIP(ifaces, func(ip net.IP) bool { return ip.IsGlobalUnicast() }); ip != nil {
+		a.IP, a.Source = ip, SourceFallback
+		return a
+	}
+	if ip := firstIP(ifaces, func(ip net.IP) bool { return ip.IsLoopback() }); ip != nil {
+		a.IP, a.Source = ip, SourceFallback
+		return a
+	}
+	a.Warnings = append(a.Warnings, fmt.Errorf("cannot find the address of the host: %w", errNoAddress))
+
+	return a
+}
+
+// interfaceIP - returns the global address of the named interface, IPv4 is preferred.
+func interfaceIP(ifaces []netInterface, name string) (net.IP, error) {
+	for _, iface := range ifaces {
+		if iface.name != name {
+			continue
+		}
+
+		if !iface.up {
+			return nil, fmt.Errorf("network interface %s is down", name)
+		}
+
+		ip := preferIPv4(iface.addrs, func(ip net.IP) bool { return ip.IsGlobalUnicast() || ip.IsLoopback() })
+		if ip == nil {
+			return nil, fmt.Errorf("network interface %s: %w", name, errNoAddress)
+		}
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("network interface %s is not found", name)
+}
+
+// subnetIP - returns the address of the host that belongs to the subnet.
+func subnetIP(ifaces []netInterface, cidr string) (net.IP, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("agent subnet %q is incorrect, err: %w", cidr, err)
+	}
+
+	ip := firstIP(ifaces, subnet.Contains)
+	if ip == nil {
+		return nil, fmt.Errorf("subnet %s: %w", cidr, errNoAddress)
+	}
+
+	return ip, nil
+}
+
+// firstIP - returns the first matching address of the up interfaces, IPv4 is preferred.
+func firstIP(ifaces []netInterface, match func(ip net.IP) bool) net.IP {
+	var addrs []net.IP
+	for _, iface := range ifaces {
+		if iface.up {
+			addrs = append(addrs, iface.addrs...)
+		}
+	}
+
+	return preferIPv4(addrs, match)
+}
+
+func preferIPv4(addrs []net.IP, match func(ip net.IP) bool) net.IP {
+	var v6 net.IP
+	for _, ip := range addrs {
+		if !match(ip) {
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+		if v6 == nil {
+			v6 = ip
+		}
+	}
+
+	return v6
+}
+
+// hostInterfaces - returns the network interfaces of the host.
+func hostInterfaces() ([]netInterface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get network interfaces, err: %w", err)
+	}
+
+	res := make([]netInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get addresses of network interface %s, err: %w", iface.Name, err)
+		}
+
+		ni := netInterface{name: iface.Name, up: iface.Flags&net.FlagUp != 0}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				ni.addrs = append(ni.addrs, ipnet.IP)
+			}
+		}
+		res = append(res, ni)
+	}
+
+	return res, nil
+}
+
+// routeIP - returns the local address of the route to the server.
+// The UDP socket is only connected, no packets are sent.
+func routeIP(server string) (net.IP, error) {
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect udp socket, err: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unknown local address type %T", conn.LocalAddr())
+	}
+
+	return addr.IP, nil
+}
@@ -0,0 +1,109 @@
+package identity
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+func testResolver(routeErr error) *resolver {
+	return &resolver{
+		interfaces: func() ([]netInterface, error) {
+			return []netInterface{
+				{name: "lo", up: true, addrs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+				{name: "eth0", up: true, addrs: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("10.0.0.5")}},
+				{name: "eth1", up: true, addrs: []net.IP{net.ParseIP("2001:db8::5")}},
+				{name: "eth2", up: false, addrs: []net.IP{net.ParseIP("172.16.0.5")}},
+			}, nil
+		},
+		route: func(server string) (net.IP, error) {
+			if routeErr != nil {
+				return nil, routeErr
+			}
+			return net.ParseIP("192.168.0.5"), nil
+		},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	errNoRoute := errors.New("network is unreachable")
+
+	tests := []struct {
+		cfg      *configuration.ConfigAgent
+		routeErr error
+		name     string
+		wantIP   string
+		source   string
+		warnings int
+	}{
+		{
+			name:   "config",
+			cfg:    &configuration.ConfigAgent{AgentIP: "2001:db8::10", AgentInterface: "eth0"},
+			wantIP: "2001:db8::10",
+			source: SourceConfig,
+		},
+		{
+			name:   "interface",
+			cfg:    &configuration.ConfigAgent{AgentInterface: "eth0", Server: "metcoll:8080"},
+			wantIP: "10.0.0.5",
+			source: SourceInterface,
+		},
+		{
+			name:   "IPv6 interface",
+			cfg:    &configuration.ConfigAgent{AgentInterface: "eth1"},
+			wantIP: "2001:db8::5",
+			source: SourceInterface,
+		},
+		{
+			name:   "subnet",
+			cfg:    &configuration.ConfigAgent{AgentSubnet: "2001:db8::/32"},
+			wantIP: "2001:db8::5",
+			source: SourceSubnet,
+		},
+		{
+			name:     "down interface falls back to route",
+			cfg:      &configuration.ConfigAgent{AgentInterface: "eth2", Server: "metcoll:8080"},
+			wantIP:   "192.168.0.5",
+			source:   SourceRoute,
+			warnings: 1,
+		},
+		{
+			name:     "subnet without addresses falls back to route",
+			cfg:      &configuration.ConfigAgent{AgentSubnet: "172.16.0.0/12", Servers: []string{"metcoll:8080"}},
+			wantIP:   "192.168.0.5",
+			source:   SourceRoute,
+			warnings: 1,
+		},
+		{
+			name:     "air-gapped host",
+			cfg:      &configuration.ConfigAgent{AgentInterface: "wlan0", Server: "metcoll:8080"},
+			routeErr: errNoRoute,
+			wantIP:   "10.0.0.5",
+			source:   SourceFallback,
+			warnings: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testResolver(tt.routeErr).resolve(tt.cfg)
+			assert.Equal(t, tt.wantIP, a.String())
+			assert.Equal(t, tt.source, a.Source)
+			assert.Len(t, a.Warnings, tt.warnings)
+		})
+	}
+
+	t.Run("no addresses", func(t *testing.T) {
+		r := &resolver{
+			interfaces: func() ([]netInterface, error) { return nil, errors.New("permission denied") },
+			route:      func(server string) (net.IP, error) { return nil, errNoRoute },
+		}
+
+		a := r.resolve(&configuration.ConfigAgent{Server: "metcoll:8080"})
+		assert.Equal(t, "", a.String())
+		assert.Len(t, a.Warnings, 3)
+	})
+}
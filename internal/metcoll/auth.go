@@ -0,0 +1,410 @@
+package metcoll
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+const (
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+	// redactedToken - replaces the token in the requests written by the dry run, so the output can be shared.
+	redactedToken = bearerPrefix + "REDACTED"
+	apiTokens     = "/api/tokens"
+
+	// scopeRead - the token reads the metrics and the agents.
+	scopeRead = "read"
+	// scopeWrite - the token updates the metrics and reads the profiles, it is the scope of the agents.
+	scopeWrite = "write"
+	// scopeAdmin - the token manages the tokens, every other scope is granted to it.
+	scopeAdmin = "admin"
+)
+
+var (
+	// errNoToken - the request has no token.
+	errNoToken = errors.New("the API token is required")
+	// errUnknownToken - the token is not in the storage.
+	errUnknownToken = errors.New("the API token is unknown")
+)
+
+type tokenContextKey struct{}
+
+// tokenAuth - authenticates the requests with the API tokens kept in the storage.
+// The admin token from the configuration is not stored, so the first tokens are created with it.
+type tokenAuth struct {
+	tokens    storage.TokenStorage
+	adminHash string
+}
+
+// newTokenAuth - Object constructor. Returns nil if the admin token is not set, the tokens are not required then.
+func newTokenAuth(stg Storage, adminToken string) (*tokenAuth, error) {
+	if adminToken == "" {
+		return nil, nil
+	}
+
+	ts, ok := stg.(storage.TokenStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage %T cannot keep the API tokens", stg)
+	}
+
+	return &tokenAuth{tokens: ts, adminHash: hashToken(adminToken)}, nil
+}
+
+// hashToken - returns the SHA-256 hash of the token in hex, the tokens are stored and looked up by it.
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// authenticate - returns the token the value belongs to.
+func (a *tokenAuth) authenticate(ctx context.Context, value string) (*storage.Token, error) {
+	if value == "" {
+		return nil, errNoToken
+	}
+
+	hash := hashToken(value)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(a.adminHash)) == 1 {
+		return &storage.Token{ID: scopeAdmin, Name: scopeAdmin, Scopes: []string{scopeAdmin}}, nil
+	}
+
+	t, err := a.tokens.GetToken(ctx, hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			return nil, errUnknownToken
+		}
+		return nil, fmt.Errorf("cannot get API token err: %w", err)
+	}
+
+	return t, nil
+}
+
+// hasScope - checks that the scope is granted to the token.
+func hasScope(t *storage.Token, scope string) bool {
+	return contains(t.Scopes, scope) || contains(t.Scopes, scopeAdmin)
+}
+
+func withToken(ctx context.Context, t *storage.Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, t)
+}
+
+// allowedMetric - checks that the token of the request is allowed to access the metric.
+// Every metric is allowed if the tokens are not required or the token has no prefixes.
+func allowedMetric(ctx context.Context, id string) bool {
+	t, ok := ctx.Value(tokenContextKey{}).(*storage.Token)
+	if !ok || len(t.Prefixes) == 0 {
+		return true
+	}
+
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metricListID - returns the ID of the metric from the line of the metric list in the format "<MetricName> <Value>".
+func metricListID(line string) string {
+	id, _, _ := strings.Cut(line, " ")
+	return id
+}
+
+// bearerToken - returns the token from the value of the Authorization header.
+func bearerToken(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) < len(bearerPrefix) || !strings.EqualFold(v[:len(bearerPrefix)], bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(v[len(bearerPrefix):])
+}
+
+// requestScope - returns the scope the request requires, an empty scope means no token is required.
+func requestScope(r *http.Request) string {
+	switch {
+	case r.URL.Path == "/ping":
+		return ""
+	case strings.HasPrefix(r.URL.Path, apiTokens):
+		return scopeAdmin
+	case isSigned(r):
+		return scopeWrite
+	case r.Method == http.MethodGet || r.URL.Path == "/value/":
+		return scopeRead
+	default:
+		return scopeAdmin
+	}
+}
+
+// tokenChecker - middleware authenticates the request with the API token and checks its scope.
+func (s *HTTPServer) tokenChecker(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := requestScope(r)
+		if s.auth == nil || scope == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := s.auth.authenticate(r.Context(), bearerToken(r.Header.Get(authorizationHeader)))
+		if err != nil {
+			s.log.Infof("request %s %s was rejected, err: %v", r.Method, r.URL.Path, err)
+			if errors.Is(err, errNoToken) || errors.Is(err, errUnknownToken) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !hasScope(t, scope) {
+			s.log.Infof("request %s %s of token %s was rejected, the %s scope is required", r.Method, r.URL.Path, t.ID, scope)
+			http.Error(w, fmt.Sprintf("the %s scope is required", scope), http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(withToken(r.Context(), t)))
+	})
+}
+
+// methodScopes - the scopes of the gRPC methods, the unknown methods require the admin scope.
+var methodScopes = map[string]string{
+	Metcoll_MetricList_FullMethodName:   scopeRead,
+	Metcoll_ReadMetric_FullMethodName:   scopeRead,
+	Metcoll_AgentList_FullMethodName:    scopeRead,
+	Metcoll_Updates_FullMethodName:      scopeWrite,
+	Metcoll_Update_FullMethodName:       scopeWrite,
+	Metcoll_AgentProfile_FullMethodName: scopeWrite,
+}
+
+// tokenChecker - authenticates the request with the API token from the metadata and checks its scope.
+func (s *GRPCServer) tokenChecker() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if s.auth == nil {
+			return handler(ctx, req)
+		}
+
+		var value string
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(authorizationHeader); len(v) > 0 {
+			value = bearerToken(v[0])
+		}
+
+		t, err := s.auth.authenticate(ctx, value)
+		if err != nil {
+			s.sl.Infof("request %s was rejected, err: %v", info.FullMethod, err)
+			if errors.Is(err, errNoToken) || errors.Is(err, errUnknownToken) {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+			return nil, status.Error(codes.Internal, "cannot authenticate the request")
+		}
+
+		scope, ok := methodScopes[info.FullMethod]
+		if !ok {
+			scope = scopeAdmin
+		}
+		if !hasScope(t, scope) {
+			s.sl.Infof("request %s of token %s was rejected, the %s scope is required", info.FullMethod, t.ID, scope)
+			return nil, status.Errorf(codes.PermissionDenied, "the %s scope is required", scope)
+		}
+
+		return handler(withToken(ctx, t), req)
+	}
+}
+
+// tokenInfo - the token in the responses of the admin API, the hash of the token is not shown.
+type tokenInfo struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	// Token - the token itself, it is shown only once when the token is created.
+	Token    string   `json:"token,omitempty"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Scopes   []string `json:"scopes"`
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+func newTokenInfo(t *storage.Token) *tokenInfo {
+	return &tokenInfo{
+		CreatedAt: t.CreatedAt,
+		ID:        t.ID,
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		Prefixes:  t.Prefixes,
+		Tenant:    t.Tenant,
+	}
+}
+
+// newToken - returns the token with the scopes and its value, the value is not kept by the server.
+func newToken(name string, scopes []string, prefixes []string) (*storage.Token, string, error) {
+	const tokenLen = 32
+
+	if len(scopes) == 0 {
+		return nil, "", errors.New("the token must have at least one scope")
+	}
+	for _, scope := range scopes {
+		if scope != scopeRead && scope != scopeWrite && scope != scopeAdmin {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	for _, p := range prefixes {
+		if strings.TrimSpace(p) == "" {
+			return nil, "", errors.New("the metric prefix must not be empty")
+		}
+	}
+
+	id, err := newInstanceID()
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("cannot generate API token, err: %w", err)
+	}
+	value := "mct_" + hex.EncodeToString(b)
+
+	return &storage.Token{
+		CreatedAt: time.Now().UTC(),
+		ID:        id,
+		Name:      name,
+		Hash:      hashToken(value),
+		Scopes:    scopes,
+		Prefixes:  prefixes,
+	}, value, nil
+}
+
+// TokenList - returns the tokens without their values.
+func (h *Handler) TokenList(ctx context.Context, w http.ResponseWriter) {
+	if h.tokens == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	list, err := h.tokens.TokenList(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while getting token list err: %w", err)
+		return
+	}
+
+	infos := make([]*tokenInfo, 0, len(list))
+	for _, t := range list {
+		infos = append(infos, newTokenInfo(t))
+	}
+
+	b, err := json.Marshal(infos)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while marshal token list err: %w", err)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	h.writeResponseBody(w, b)
+}
+
+// AddToken - creates the token, its value is returned only in this response.
+func (h *Handler) AddToken(ctx context.Context, w http.ResponseWriter, body io.ReadCloser) {
+	if h.tokens == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		Name     string   `json:"name"`
+		Scopes   []string `json:"scopes"`
+		Tenant   string   `json:"tenant"`
+		Prefixes []string `json:"prefixes"`
+	}
+	if err := json.NewDecoder(body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		h.logger.Errorf("AddToken unmarshal error: %w", err)
+		return
+	}
+
+	t, value, err := newToken(request.Name, request.Scopes, request.Prefixes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the admin scope manages the tokens of every tenant, so it is not bound to a tenant.
+	if request.Tenant != storage.DefaultTenant {
+		if contains(t.Scopes, scopeAdmin) {
+			http.Error(w, "the token with the admin scope cannot be bound to a tenant", http.StatusBadRequest)
+			return
+		}
+		if _, err := h.tenants.get(request.Tenant); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t.Tenant = request.Tenant
+	}
+
+	if err := h.tokens.AddToken(ctx, t); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while saving the token err: %w", err)
+		return
+	}
+
+	info := newTokenInfo(t)
+	info.Token = value
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while marshal the token err: %w", err)
+		return
+	}
+
+	h.logger.Infof("token %s (%s) of tenant %q with scopes %v was created", t.ID, t.Name, t.Tenant, t.Scopes)
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusCreated)
+	h.writeResponseBody(w, b)
+}
+
+// DeleteToken - deletes the token, the requests with it are rejected at once.
+func (h *Handler) DeleteToken(ctx context.Context, w http.ResponseWriter, id string) {
+	if h.tokens == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := h.tokens.DeleteToken(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while deleting the token err: %w", err)
+		return
+	}
+
+	h.logger.Infof("token %s was deleted", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
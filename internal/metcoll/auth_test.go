@@ -0,0 +1,249 @@
+package metcoll
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+const adminToken = "admin-secret"
+
+func TestHTTPServer_tokenChecker(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.Config{AdminToken: adminToken}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+	srv, err := NewHTTPServer(ctx, stg, cfg, zap.S())
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+
+	request := func(t *testing.T, method, path, token, body string) (int, string) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set(authorizationHeader, bearerPrefix+token)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(b)
+	}
+	newToken := func(t *testing.T, body string) *tokenInfo {
+		t.Helper()
+
+		code, resp := request(t, http.MethodPost, apiTokens, adminToken, body)
+		require.Equal(t, http.StatusCreated, code, resp)
+
+		var info tokenInfo
+		require.NoError(t, json.Unmarshal([]byte(resp), &info))
+		require.NotEmpty(t, info.Token)
+
+		return &info
+	}
+
+	writer := newToken(t, `{"name":"agents","scopes":["write"],"prefixes":["web."]}`)
+	reader := newToken(t, `{"name":"grafana","scopes":["read"],"prefixes":["web."]}`)
+
+	code, _ := request(t, http.MethodPost, "/update/gauge/db.load/1", adminToken, "")
+	require.Equal(t, http.StatusOK, code)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		want   int
+	}{
+		{name: "ping without token", method: http.MethodGet, path: "/ping", want: http.StatusOK},
+		{name: "metric list without token", method: http.MethodGet, path: "/", want: http.StatusUnauthorized},
+		{name: "unknown token", method: http.MethodGet, path: "/", token: "unknown", want: http.StatusUnauthorized},
+		{
+			name:   "write with writer token",
+			method: http.MethodPost,
+			path:   "/update/gauge/web.load/1",
+			token:  writer.Token,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "write out of the prefixes",
+			method: http.MethodPost,
+			path:   "/update/gauge/db.load/2",
+			token:  writer.Token,
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "batch with metric out of the prefixes",
+			method: http.MethodPost,
+			path:   updates,
+			token:  writer.Token,
+			body:   `[{"id":"web.load","type":"gauge","value":1},{"id":"db.load","type":"gauge","value":2}]`,
+			want:   http.StatusForbidden,
+		},
+		{name: "read with writer token", method: http.MethodGet, path: "/value/gauge/web.load", token: writer.Token,
+			want: http.StatusForbidden},
+		{name: "read with reader token", method: http.MethodGet, path: "/value/gauge/web.load", token: reader.Token,
+			want: http.StatusOK},
+		{name: "read out of the prefixes", method: http.MethodGet, path: "/value/gauge/db.load", token: reader.Token,
+			want: http.StatusForbidden},
+		{
+			name:   "read json out of the prefixes",
+			method: http.MethodPost,
+			path:   "/value/",
+			token:  reader.Token,
+			body:   `{"id":"db.load","type":"gauge"}`,
+			want:   http.StatusForbidden,
+		},
+		{name: "write with reader token", method: http.MethodPost, path: "/update/gauge/web.load/1", token: reader.Token,
+			want: http.StatusForbidden},
+		{name: "token list with reader token", method: http.MethodGet, path: apiTokens, token: reader.Token,
+			want: http.StatusForbidden},
+		{name: "token with unknown scope", method: http.MethodPost, path: apiTokens, token: adminToken,
+			body: `{"name":"x","scopes":["delete"]}`, want: http.StatusBadRequest},
+		{name: "token without scopes", method: http.MethodPost, path: apiTokens, token: adminToken,
+			body: `{"name":"x"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := request(t, tt.method, tt.path, tt.token, tt.body)
+			assert.Equal(t, tt.want, code, resp)
+		})
+	}
+
+	t.Run("metric list is filtered by the prefixes", func(t *testing.T) {
+		code, resp := request(t, http.MethodGet, "/", reader.Token, "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Contains(t, resp, "web.load")
+		assert.NotContains(t, resp, "db.load")
+	})
+
+	t.Run("token list", func(t *testing.T) {
+		code, resp := request(t, http.MethodGet, apiTokens, adminToken, "")
+		require.Equal(t, http.StatusOK, code)
+
+		var list []*tokenInfo
+		require.NoError(t, json.Unmarshal([]byte(resp), &list))
+		require.Len(t, list, 2)
+		assert.Equal(t, writer.ID, list[0].ID)
+		assert.Equal(t, []string{"write"}, list[0].Scopes)
+		assert.Empty(t, list[0].Token)
+		assert.NotContains(t, resp, "hash")
+	})
+
+	t.Run("agent with token", func(t *testing.T) {
+		u, err := url.Parse(ts.URL)
+		require.NoError(t, err)
+
+		c, err := NewHTTPClient(&configuration.ConfigAgent{Server: u.Host, APIToken: writer.Token}, zap.S())
+		require.NoError(t, err)
+		assert.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("web.cpu", 1)}))
+	})
+
+	t.Run("deleted token is rejected", func(t *testing.T) {
+		code, _ := request(t, http.MethodDelete, apiTokens+"/"+writer.ID, adminToken, "")
+		require.Equal(t, http.StatusNoContent, code)
+
+		code, _ = request(t, http.MethodDelete, apiTokens+"/"+writer.ID, adminToken, "")
+		assert.Equal(t, http.StatusNotFound, code)
+
+		code, _ = request(t, http.MethodPost, "/update/gauge/web.load/1", writer.Token, "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+}
+
+func TestGRPCServer_tokenChecker(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.Config{AdminToken: adminToken}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+
+	writer, writerToken, err := newToken("agents", []string{scopeWrite}, []string{"web."})
+	require.NoError(t, err)
+	require.NoError(t, stg.AddToken(ctx, writer))
+	reader, readerToken, err := newToken("grafana", []string{scopeRead}, nil)
+	require.NoError(t, err)
+	require.NoError(t, stg.AddToken(ctx, reader))
+
+	s, err := NewGRPCServer(stg, cfg, zap.S())
+	require.NoError(t, err)
+	RegisterMetcollServer(s.grpcServer, s.ms)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("server exited with error: %v", err)
+		}
+	}()
+	defer s.grpcServer.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	t.Run("agent with token", func(t *testing.T) {
+		acfg := &configuration.ConfigAgent{Server: "bufnet", APIToken: writerToken}
+		c, err := NewGRPCClient(ctx, acfg, zap.S())
+		require.NoError(t, err)
+		c.conns[acfg.Server] = conn
+
+		assert.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("web.requests", 1)}))
+		assert.Equal(t, codes.PermissionDenied,
+			status.Code(c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("db.requests", 1)})))
+	})
+
+	client := NewMetcollClient(conn)
+	read := func(token string) error {
+		mctx := ctx
+		if token != "" {
+			mctx = metadata.AppendToOutgoingContext(ctx, authorizationHeader, bearerPrefix+token)
+		}
+		_, err := client.ReadMetric(mctx,
+			&ReadMetricRequest{Metric: convertPBMetric(metrics.NewCounterMetric("web.requests", 0))})
+		return err
+	}
+
+	assert.NoError(t, read(readerToken))
+	assert.NoError(t, read(adminToken))
+	assert.Equal(t, codes.Unauthenticated, status.Code(read("")))
+	assert.Equal(t, codes.PermissionDenied, status.Code(read(writerToken)))
+}
+
+func TestBearerToken(t *testing.T) {
+	assert.Equal(t, "secret", bearerToken("Bearer secret"))
+	assert.Equal(t, "secret", bearerToken(" bearer  secret "))
+	assert.Equal(t, "", bearerToken("Basic c2VjcmV0"))
+	assert.Equal(t, "", bearerToken(""))
+}
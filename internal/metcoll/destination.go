@@ -0,0 +1,323 @@
+package metcoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+const (
+	// breakerThreshold - count of consecutive failures after which the server is considered unhealthy.
+	breakerThreshold = 3
+	// breakerCooldown - time after which an unhealthy server is tried again.
+	breakerCooldown = 30 * time.Second
+)
+
+var (
+	// errCircuitOpen - error occurs when the server is skipped because it failed recently.
+	errCircuitOpen = errors.New("server is unhealthy, circuit is open")
+	// errNoHealthyServer - error occurs when all servers are unhealthy in failover mode.
+	errNoHealthyServer = errors.New("no healthy servers")
+	// errUnknownServer - error occurs when the batch is resent to the server that is no longer configured.
+	errUnknownServer = errors.New("server is not configured")
+)
+
+// DestinationError - the error of sending the batch to the specific server.
+type DestinationError struct {
+	Err    error
+	Server string
+}
+
+func (e *DestinationError) Error() string {
+	return fmt.Sprintf("server %s: %v", e.Server, e.Err)
+}
+
+func (e *DestinationError) Unwrap() error {
+	return e.Err
+}
+
+// UndeliveredError - the batch was delivered to some of the servers in the fan-out mode.
+// The batch must be resent only to the servers that have not received it, so the others do not count it twice.
+type UndeliveredError struct {
+	Errs    []error
+	Servers []string
+}
+
+func (e *UndeliveredError) Error() string {
+	return fmt.Sprintf("batch was not delivered to servers %s: %v", strings.Join(e.Servers, ", "), errors.Join(e.Errs...))
+}
+
+func (e *UndeliveredError) Unwrap() []error {
+	return e.Errs
+}
+
+// Undelivered - returns the servers the batch has to be resent to.
+func (e *UndeliveredError) Undelivered() []string {
+	return e.Servers
+}
+
+// permanentError - reports whether the server has rejected the payload, so resending it cannot help.
+func permanentError(err error) bool {
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// breaker - circuit breaker that tracks the health of the server.
+type breaker struct {
+	mux      *sync.Mutex
+	openedAt time.Time
+	cooldown time.Duration
+	failures int
+}
+
+func newBreaker() *breaker {
+	return &breaker{
+		mux:      &sync.Mutex{},
+		cooldown: breakerCooldown,
+	}
+}
+
+// allow - reports whether the request may be sent. After the cooldown a single trial request is allowed.
+func (b *breaker) allow() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.failures < breakerThreshold {
+		return true
+	}
+
+	if time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	// half-open: the next failure opens the circuit for the cooldown again.
+	b.openedAt = time.Now()
+	return true
+}
+
+func (b *breaker) success() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.failures = 0
+}
+
+func (b *breaker) failure() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openedAt = time.Now()
+	}
+}
+
+type destination struct {
+	breaker *breaker
+	server  string
+}
+
+// destinations - the servers of the agent and the mode of sending to them.
+type destinations struct {
+	mux       *sync.Mutex
+	instances map[string]string
+	onResync  func()
+	mode      string
+	list      []*destination
+}
+
+func newDestinations(cfg *configuration.ConfigAgent) (*destinations, error) {
+	servers := cfg.Servers
+	if len(servers) == 0 {
+		servers = []string{cfg.Server}
+	}
+
+	mode := cfg.SendMode
+	switch mode {
+	case "":
+		mode = configuration.SendModeFailover
+	case configuration.SendModeFailover, configuration.SendModeFanout:
+	default:
+		return nil, fmt.Errorf("unknown send mode: %s", mode)
+	}
+
+	d := &destinations{
+		mux:       &sync.Mutex{},
+		instances: make(map[string]string),
+		mode:      mode,
+	}
+	for _, s := range servers {
+		d.list = append(d.list, &destination{server: s, breaker: newBreaker()})
+	}
+
+	return d, nil
+}
+
+// fanoutServers - returns the servers if the batches are sent to all of them, nil in the failover mode.
+func (d *destinations) fanoutServers() []string {
+	if d.mode != configuration.SendModeFanout {
+		return nil
+	}
+
+	return d.servers()
+}
+
+func (d *destinations) servers() []string {
+	servers := make([]string, 0, len(d.list))
+	for _, dst := range d.list {
+		servers = append(servers, dst.server)
+	}
+
+	return servers
+}
+
+func (d *destinations) setResyncHandler(fn func()) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.onResync = fn
+}
+
+// observe - remembers the instance ID of the server and calls the resync handler
+// if the server was restarted and might have lost the previously sent values.
+func (d *destinations) observe(server string, instance string) {
+	if instance == "" {
+		return
+	}
+
+	d.mux.Lock()
+	prev := d.instances[server]
+	d.instances[server] = instance
+	onResync := d.onResync
+	d.mux.Unlock()
+
+	if prev != "" && prev != instance && onResync != nil {
+		onResync()
+	}
+}
+
+// result - returns the error of the sending. In the fan-out mode the batch that is delivered to some servers
+// and failed on the others is returned with UndeliveredError, the servers that have rejected it are only logged.
+func (d *destinations) result(errs []error, delivered bool, logf func(template string, args ...any)) error {
+	if !delivered {
+		return errors.Join(errs...)
+	}
+
+	var pending []string
+	var pendingErrs []error
+	for _, err := range errs {
+		var de *DestinationError
+		if d.mode == configuration.SendModeFanout && errors.As(err, &de) && !permanentError(err) {
+			pending = append(pending, de.Server)
+			pendingErrs = append(pendingErrs, err)
+			continue
+		}
+		logf("batch update was failed, err: %v", err)
+	}
+
+	if len(pending) > 0 {
+		return &UndeliveredError{Servers: pending, Errs: pendingErrs}
+	}
+
+	return nil
+}
+
+// sendTo - sends the batch only to the server, the batch is resent to the server after the failure in the fan-out mode.
+func (d *destinations) sendTo(ctx context.Context, server string,
+	fn func(ctx context.Context, server string) error) error {
+	for _, dst := range d.list {
+		if dst.server != server {
+			continue
+		}
+
+		if !dst.breaker.allow() {
+			return &DestinationError{Server: server, Err: errCircuitOpen}
+		}
+
+		if err := fn(ctx, server); err != nil {
+			dst.breaker.failure()
+			return &DestinationError{Server: server, Err: err}
+		}
+		dst.breaker.success()
+
+		return nil
+	}
+
+	return &DestinationError{Server: server, Err: errUnknownServer}
+}
+
+// send - sends the batch using `fn` according to the mode and returns the errors of every destination.
+// The batch is delivered if at least one server has received it.
+func (d *destinations) send(ctx context.Context,
+	fn func(ctx context.Context, server string) error) (errs []error, delivered bool) {
+	if d.mode == configuration.SendModeFanout {
+		return d.fanout(ctx, fn)
+	}
+
+	return d.failover(ctx, fn)
+}
+
+func (d *destinations) failover(ctx context.Context,
+	fn func(ctx context.Context, server string) error) ([]error, bool) {
+	var errs []error
+	for _, dst := range d.list {
+		if !dst.breaker.allow() {
+			continue
+		}
+
+		if err := fn(ctx, dst.server); err != nil {
+			dst.breaker.failure()
+			errs = append(errs, &DestinationError{Server: dst.server, Err: err})
+			continue
+		}
+
+		dst.breaker.success()
+		return errs, true
+	}
+
+	if len(errs) == 0 {
+		errs = append(errs, errNoHealthyServer)
+	}
+
+	return errs, false
+}
+
+func (d *destinations) fanout(ctx context.Context,
+	fn func(ctx context.Context, server string) error) ([]error, bool) {
+	errs := make([]error, len(d.list))
+
+	wg := &sync.WaitGroup{}
+	for i, dst := range d.list {
+		if !dst.breaker.allow() {
+			errs[i] = &DestinationError{Server: dst.server, Err: errCircuitOpen}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, dst *destination) {
+			defer wg.Done()
+
+			if err := fn(ctx, dst.server); err != nil {
+				dst.breaker.failure()
+				errs[i] = &DestinationError{Server: dst.server, Err: err}
+				return
+			}
+			dst.breaker.success()
+		}(i, dst)
+	}
+	wg.Wait()
+
+	res := errs[:0]
+	for _, err := range errs {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+
+	return res, len(res) < len(d.list)
+}
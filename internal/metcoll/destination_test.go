@@ -0,0 +1,159 @@
+package metcoll
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+type fakeServers struct {
+	mux   *sync.Mutex
+	down  map[string]bool
+	calls map[string]int
+}
+
+func newFakeServers(down ...string) *fakeServers {
+	f := &fakeServers{
+		mux:   &sync.Mutex{},
+		down:  make(map[string]bool),
+		calls: make(map[string]int),
+	}
+	for _, s := range down {
+		f.down[s] = true
+	}
+	return f
+}
+
+func (f *fakeServers) send(ctx context.Context, server string) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.calls[server]++
+	if f.down[server] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestDestinations_Failover(t *testing.T) {
+	d, err := newDestinations(&configuration.ConfigAgent{Servers: []string{"a", "b"}})
+	require.NoError(t, err)
+
+	f := newFakeServers("a")
+	for i := 0; i < breakerThreshold+2; i++ {
+		errs, delivered := d.send(context.Background(), f.send)
+		assert.True(t, delivered)
+		if i < breakerThreshold {
+			require.Len(t, errs, 1)
+			var de *DestinationError
+			require.ErrorAs(t, errs[0], &de)
+			assert.Equal(t, "a", de.Server)
+		} else {
+			assert.Empty(t, errs)
+		}
+	}
+
+	// the unhealthy server is skipped until the cooldown.
+	assert.Equal(t, breakerThreshold, f.calls["a"])
+	assert.Equal(t, breakerThreshold+2, f.calls["b"])
+
+	f.down["b"] = true
+	errs, delivered := d.send(context.Background(), f.send)
+	assert.False(t, delivered)
+	assert.Len(t, errs, 1)
+
+	d.list[0].breaker.cooldown = 0
+	f.down["a"] = false
+	_, delivered = d.send(context.Background(), f.send)
+	assert.True(t, delivered)
+	assert.Equal(t, breakerThreshold+1, f.calls["a"])
+}
+
+func TestDestinations_Fanout(t *testing.T) {
+	d, err := newDestinations(&configuration.ConfigAgent{
+		Servers:  []string{"a", "b", "c"},
+		SendMode: configuration.SendModeFanout,
+	})
+	require.NoError(t, err)
+
+	f := newFakeServers("b")
+	errs, delivered := d.send(context.Background(), f.send)
+	assert.True(t, delivered)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "server b")
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, f.calls)
+
+	f = newFakeServers("a", "b", "c")
+	errs, delivered = d.send(context.Background(), f.send)
+	assert.False(t, delivered)
+	assert.Len(t, errs, 3)
+}
+
+func TestNewDestinations(t *testing.T) {
+	d, err := newDestinations(&configuration.ConfigAgent{Server: "localhost:8080"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost:8080"}, d.servers())
+	assert.Equal(t, configuration.SendModeFailover, d.mode)
+
+	_, err = newDestinations(&configuration.ConfigAgent{SendMode: "roundrobin"})
+	assert.Error(t, err)
+}
+
+func TestDestinations_observe(t *testing.T) {
+	d, err := newDestinations(&configuration.ConfigAgent{Servers: []string{"a", "b"}})
+	require.NoError(t, err)
+
+	resyncs := 0
+	d.setResyncHandler(func() { resyncs++ })
+
+	d.observe("a", "1")
+	d.observe("b", "2")
+	d.observe("a", "1")
+	d.observe("a", "")
+	assert.Equal(t, 0, resyncs)
+
+	d.observe("a", "3")
+	assert.Equal(t, 1, resyncs)
+}
+
+type rejectedError struct{}
+
+func (e *rejectedError) Error() string   { return "batch was rejected" }
+func (e *rejectedError) Permanent() bool { return true }
+
+func TestDestinations_result(t *testing.T) {
+	failed := &DestinationError{Server: "b", Err: errors.New("connection refused")}
+	rejected := &DestinationError{Server: "c", Err: &rejectedError{}}
+	logf := func(template string, args ...any) {}
+
+	t.Run("fan-out", func(t *testing.T) {
+		d, err := newDestinations(&configuration.ConfigAgent{
+			Servers:  []string{"a", "b", "c"},
+			SendMode: configuration.SendModeFanout,
+		})
+		require.NoError(t, err)
+
+		err = d.result([]error{failed, rejected}, true, logf)
+		var ue *UndeliveredError
+		require.ErrorAs(t, err, &ue)
+		assert.Equal(t, []string{"b"}, ue.Undelivered())
+		assert.ErrorIs(t, err, failed)
+
+		assert.NoError(t, d.result([]error{rejected}, true, logf), "the rejected batch is not resent")
+		assert.False(t, errors.As(d.result([]error{failed}, false, logf), &ue))
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		d, err := newDestinations(&configuration.ConfigAgent{Servers: []string{"a", "b"}})
+		require.NoError(t, err)
+
+		assert.NoError(t, d.result([]error{failed}, true, logf))
+		assert.Error(t, d.result([]error{failed}, false, logf))
+	})
+}
@@ -1,81 +1,139 @@
 package metcoll
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
 	"time"
 
 	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/ArtemShalinFe/metcoll/internal/build"
 	"github.com/ArtemShalinFe/metcoll/internal/configuration"
 	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/tlsconfig"
 )
 
 type GRPCClient struct {
-	cc       grpc.ClientConnInterface
-	sl       *zap.SugaredLogger
-	host     string
-	clientIP string
-	certPath string
-	hashkey  []byte
+	conns          map[string]grpc.ClientConnInterface
+	dests          *destinations
+	sl             *zap.SugaredLogger
+	clientIP       string
+	agentID        string
+	version        string
+	reportInterval string
+	apiToken       string
+	tenant         string
+	creds          func(server string) credentials.TransportCredentials
+	hashkey        []byte
+	publicKey      []byte
+	signKey        ed25519.PrivateKey
 }
 
 func NewGRPCClient(ctx context.Context, cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (*GRPCClient, error) {
-	clientIP, err := localIP()
+	publicKey, err := loadPublicKey(cfg, sl)
 	if err != nil {
-		return nil, fmt.Errorf("an occured error when grpc agent getting local IP, err: %w", err)
+		return nil, err
 	}
 
+	signKey, err := loadSigningKey(cfg, sl)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := getClientCreds(cfg, sl)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when getting client credentials: %w", err)
+	}
+
+	clientIP := agentIP(cfg, sl)
+
+	dests, err := newDestinations(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when grpc agent getting servers, err: %w", err)
+	}
+
+	b := build.NewBuild()
 	c := &GRPCClient{
-		host:     cfg.Server,
-		clientIP: clientIP,
-		hashkey:  cfg.Key,
-		sl:       sl,
-		certPath: cfg.CertFilePath,
+		conns:          make(map[string]grpc.ClientConnInterface),
+		dests:          dests,
+		clientIP:       clientIP,
+		agentID:        cfg.ID(),
+		version:        b.Version(),
+		reportInterval: strconv.Itoa(cfg.ReportInterval),
+		apiToken:       cfg.APIToken,
+		tenant:         cfg.Tenant,
+		hashkey:        cfg.Key,
+		publicKey:      publicKey,
+		signKey:        signKey,
+		sl:             sl,
+		creds:          creds,
 	}
 
 	return c, nil
 }
 
-func getClientCreds(certFilePath string) (credentials.TransportCredentials, error) {
-	if certFilePath == "" {
-		creds := insecure.NewCredentials()
-		return creds, nil
+// getClientCreds - returns the function that builds the credentials of the server from the TLS options,
+// the certificate of the server is trusted if the TLS options are not set.
+// The TLS credentials are built for every server, so its certificate is verified against its host.
+func getClientCreds(cfg *configuration.ConfigAgent,
+	sl *zap.SugaredLogger) (func(server string) credentials.TransportCredentials, error) {
+	if cfg.TLSEnabled() {
+		tlsClient, err := tlsconfig.NewClient(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey, cfg.TLSServerName, sl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS configuration: %w", err)
+		}
+		return func(server string) credentials.TransportCredentials {
+			return credentials.NewTLS(tlsClient.Config(server))
+		}, nil
 	}
 
-	creds, err := credentials.NewClientTLSFromFile(
-		certFilePath,
-		"")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load credentials: %w", err)
+	creds := insecure.NewCredentials()
+	if cfg.CertFilePath != "" {
+		var err error
+		creds, err = credentials.NewClientTLSFromFile(
+			cfg.CertFilePath,
+			"")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials: %w", err)
+		}
 	}
-	return creds, nil
+
+	return func(string) credentials.TransportCredentials {
+		return creds
+	}, nil
 }
 
 func (c *GRPCClient) setupConn(ctx context.Context) error {
-	opts := c.getDialOpts()
+	for _, server := range c.dests.servers() {
+		opts := append(c.getDialOpts(), grpc.WithTransportCredentials(c.creds(server)))
 
-	creds, err := getClientCreds(c.certPath)
-	if err != nil {
-		return fmt.Errorf("an occured error when getting client credentials: %w", err)
-	}
+		conn, err := grpc.DialContext(ctx, server, opts...)
+		if err != nil {
+			return fmt.Errorf("server is not available at %s, err: %w", server, err)
+		}
 
-	opts = append(opts, grpc.WithTransportCredentials(creds))
-	conn, err := grpc.DialContext(ctx, c.host, opts...)
-	if err != nil {
-		return fmt.Errorf("server is not available at %s, err: %w", c.host, err)
+		c.conns[server] = conn
 	}
 
-	c.cc = conn
-
 	return nil
 }
 
@@ -93,6 +151,7 @@ func (c *GRPCClient) getDialOpts() []grpc.DialOption {
 
 	chain := grpc.WithChainUnaryInterceptor(
 		c.clientCompressInterceptor,
+		c.clientEncryptInterceptor,
 		grpc_retry.UnaryClientInterceptor(retryopts...),
 	)
 
@@ -101,46 +160,298 @@ func (c *GRPCClient) getDialOpts() []grpc.DialOption {
 	return opts
 }
 
-func (c *GRPCClient) BatchUpdateMetric(ctx context.Context, mcs <-chan []*metrics.Metrics, result chan<- error) {
-	mc := NewMetcollClient(c.cc)
-
+// headers - returns the metadata of the agent sent with every request.
+func (c *GRPCClient) headers() map[string]string {
 	headers := map[string]string{
-		realIP:     c.clientIP,
-		HashSHA256: "",
+		realIP:               c.clientIP,
+		agentIDHeader:        c.agentID,
+		agentVersionHeader:   c.version,
+		reportIntervalHeader: c.reportInterval,
+		HashSHA256:           "",
 	}
+	if c.apiToken != "" {
+		headers[authorizationHeader] = bearerPrefix + c.apiToken
+	}
+	if c.tenant != "" {
+		headers[tenantHeader] = c.tenant
+	}
+
+	return headers
+}
 
+func (c *GRPCClient) BatchUpdateMetric(ctx context.Context, mcs <-chan []*metrics.Metrics, result chan<- error) {
 	for m := range mcs {
-		var request BatchUpdateRequest
+		errs, _ := c.batchUpdate(ctx, m)
+		for _, err := range errs {
+			result <- err
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
+
+// BatchUpdate - Sends the batch of metrics to the servers.
+// The error is returned if the batch was not delivered to any server. In the fan-out mode
+// UndeliveredError is returned if the batch was not delivered to some of the servers.
+func (c *GRPCClient) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	errs, delivered := c.batchUpdate(ctx, mcs)
+	return c.dests.result(errs, delivered, c.sl.Errorf)
+}
+
+// BatchUpdateTo - Sends the batch of metrics only to the server, the batch that was not delivered
+// to the server in the fan-out mode is resent with it.
+func (c *GRPCClient) BatchUpdateTo(ctx context.Context, server string, mcs []*metrics.Metrics) error {
+	request, headers, err := c.updatesRequest(mcs)
+	if err != nil {
+		return err
+	}
+
+	mctx := metadata.NewOutgoingContext(ctx, metadata.New(headers))
+
+	return c.dests.sendTo(mctx, server, c.sendRequest(request))
+}
+
+// Fanout - returns the servers if the batches are sent to all of them, nil in the failover mode.
+func (c *GRPCClient) Fanout() []string {
+	return c.dests.fanoutServers()
+}
+
+func (c *GRPCClient) batchUpdate(ctx context.Context, mcs []*metrics.Metrics) ([]error, bool) {
+	request, headers, err := c.updatesRequest(mcs)
+	if err != nil {
+		return []error{err}, false
+	}
+
+	mctx := metadata.NewOutgoingContext(ctx, metadata.New(headers))
+
+	return c.dests.send(mctx, c.sendRequest(request))
+}
+
+// sendRequest - returns the function that sends the updates request to the server.
+func (c *GRPCClient) sendRequest(request *BatchUpdateRequest) func(ctx context.Context, server string) error {
+	return func(ctx context.Context, server string) error {
+		var header metadata.MD
+		mc := NewMetcollClient(c.conns[server])
+		resp, err := mc.Updates(ctx, request, grpc.Header(&header))
+		if err != nil {
+			return fmt.Errorf("grpc updates request was failed, err: %w", err)
+		}
+
+		if instance := header.Get(instanceHeader); len(instance) > 0 {
+			c.dests.observe(server, instance[0])
+		}
+
+		return c.verifyResponse(header, resp)
+	}
+}
+
+// verifyResponse - checks the hash of the server response if the hash key is set.
+func (c *GRPCClient) verifyResponse(header metadata.MD, resp proto.Message) error {
+	if len(c.hashkey) == 0 {
+		return nil
+	}
+
+	b, err := responseBytes(resp)
+	if err != nil {
+		return err
+	}
+
+	var hash string
+	if hashes := header.Get(HashSHA256); len(hashes) > 0 {
+		hash = hashes[0]
+	}
+
+	return verifyResponseHash(c.hashkey, b, hash)
+}
+
+// updatesRequest - returns the request with the batch of metrics and its metadata.
+func (c *GRPCClient) updatesRequest(mcs []*metrics.Metrics) (*BatchUpdateRequest, map[string]string, error) {
+	headers := c.headers()
+
+	var request BatchUpdateRequest
+	for _, mtrs := range mcs {
+		pbm := convertPBMetric(mtrs)
+		request.Metrics = append(request.Metrics, pbm)
+	}
+
+	if err := c.signHeaders(headers, request.Metrics); err != nil {
+		return nil, nil, fmt.Errorf("unable to sign batch metrics, err: %w", err)
+	}
+
+	return &request, headers, nil
+}
+
+// signHeaders - sets the HMAC and the signature of the part of the request that is checked by the server.
+func (c *GRPCClient) signHeaders(headers map[string]string, part any) error {
+	if len(c.hashkey) == 0 && c.signKey == nil {
+		return nil
+	}
+
+	b, err := convertToBytes(part)
+	if err != nil {
+		return fmt.Errorf("unable to convert request to bytes, err: %w", err)
+	}
 
-		for _, mtrs := range m {
-			pbm := convertPBMetric(mtrs)
-			request.Metrics = append(request.Metrics, pbm)
+	if len(c.hashkey) != 0 {
+		h := hmac.New(sha256.New, c.hashkey)
+
+		h.Write(b)
+		headers[HashSHA256] = hashBytesToString(h, nil)
+	}
+
+	if c.signKey != nil {
+		timestamp := signatureTimestamp()
+		headers[timestampHeader] = timestamp
+		headers[signatureHeader] = signRequest(c.signKey, c.agentID, timestamp, b)
+	}
+
+	return nil
+}
+
+// WriteWire - writes the metadata and the gzipped length-prefixed message
+// of the request that sends the batch to the first server without sending it.
+func (c *GRPCClient) WriteWire(ctx context.Context, w io.Writer, mcs []*metrics.Metrics) error {
+	request, headers, err := c.updatesRequest(mcs)
+	if err != nil {
+		return err
+	}
+
+	var sealed proto.Message = request
+	if len(c.publicKey) != 0 {
+		sealed, err = sealMessage(c.publicKey, request)
+		if err != nil {
+			return err
 		}
+	}
+
+	msg, err := proto.Marshal(sealed)
+	if err != nil {
+		return fmt.Errorf("cannot marshal batch update request, err: %w", err)
+	}
+
+	var zBuf bytes.Buffer
+	zw, err := encoding.GetCompressor(gzip.Name).Compress(&zBuf)
+	if err != nil {
+		return fmt.Errorf("cannot init compressor, err: %w", err)
+	}
+	if _, err := zw.Write(msg); err != nil {
+		return fmt.Errorf("cannot write compressed message, err: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("cannot close compress writer, err: %w", err)
+	}
 
-		if len(c.hashkey) != 0 {
-			b, err := convertToBytes(request.Metrics)
-			if err != nil {
-				result <- fmt.Errorf("unable to convert batch metrics to bytes, err: %w", err)
-				return
-			}
-			h := hmac.New(sha256.New, c.hashkey)
+	md := metadata.New(headers)
+	md.Set(":path", Metcoll_Updates_FullMethodName)
+	md.Set(":authority", c.dests.servers()[0])
+	md.Set("content-type", "application/grpc")
+	md.Set("grpc-encoding", gzip.Name)
+	if _, ok := headers[authorizationHeader]; ok {
+		md.Set(authorizationHeader, redactedToken)
+	}
 
-			h.Write(b)
-			headers[HashSHA256] = hashBytesToString(h, nil)
+	keys := make([]string, 0, md.Len())
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		for _, v := range md.Get(k) {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
 		}
+	}
+	buf.WriteString("\r\n")
+
+	const compressedFlag = 1
+	prefix := make([]byte, 5)
+	prefix[0] = compressedFlag
+	binary.BigEndian.PutUint32(prefix[1:], uint32(zBuf.Len()))
+	buf.Write(prefix)
+	buf.Write(zBuf.Bytes())
+
+	if _, err := buf.WriteTo(w); err != nil {
+		return fmt.Errorf("cannot write request, err: %w", err)
+	}
+
+	return nil
+}
+
+// AgentProfile - requests the configuration profile of the agent from the servers one by one
+// until the profile is received.
+func (c *GRPCClient) AgentProfile(ctx context.Context, agentID string, labels []string) (*Profile, error) {
+	request := AgentProfileRequest{AgentId: agentID, Labels: labels}
 
-		mctx := metadata.NewOutgoingContext(ctx, metadata.New(headers))
-		_, err := mc.Updates(mctx, &request)
+	headers := c.headers()
+
+	if err := c.signHeaders(headers, &request); err != nil {
+		return nil, fmt.Errorf("unable to sign agent profile request, err: %w", err)
+	}
+
+	mctx := metadata.NewOutgoingContext(ctx, metadata.New(headers))
+
+	var errs []error
+	for _, server := range c.dests.servers() {
+		var header metadata.MD
+		mc := NewMetcollClient(c.conns[server])
+		resp, err := mc.AgentProfile(mctx, &request, grpc.Header(&header))
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
 		if err != nil {
-			result <- err
+			errs = append(errs, &DestinationError{
+				Err:    fmt.Errorf("grpc agent profile request was failed, err: %w", err),
+				Server: server,
+			})
+			continue
+		}
+		if err := c.verifyResponse(header, resp); err != nil {
+			errs = append(errs, &DestinationError{Err: err, Server: server})
+			continue
+		}
+		if len(resp.GetConfig()) == 0 {
+			continue
 		}
 
-		select {
-		case <-ctx.Done():
-			return
-		default:
+		if err := verifyProfile(c.hashkey, resp.GetConfig(), resp.GetHash()); err != nil {
+			errs = append(errs, &DestinationError{Err: err, Server: server})
+			continue
 		}
+
+		return &Profile{Name: resp.GetName(), Config: resp.GetConfig()}, nil
 	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return nil, ErrProfileNotFound
+}
+
+// Close - closes the connections to the servers.
+func (c *GRPCClient) Close() error {
+	var errs []error
+	for server, cc := range c.conns {
+		conn, ok := cc.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("cannot close connection to %s, err: %w", server, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// OnResync - sets the handler that is called when the server asks to resend all values.
+func (c *GRPCClient) OnResync(fn func()) {
+	c.dests.setResyncHandler(fn)
 }
 
 func convertPBMetric(m *metrics.Metrics) *Metric {
@@ -172,3 +483,20 @@ func (c *GRPCClient) clientCompressInterceptor(ctx context.Context, method strin
 	}
 	return nil
 }
+
+// clientEncryptInterceptor - replaces the request with the request encrypted with the public key of the servers.
+// The hash of the request is calculated before the encryption, so the server checks it after the decryption.
+func (c *GRPCClient) clientEncryptInterceptor(ctx context.Context, method string, req interface{},
+	reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	m, ok := req.(proto.Message)
+	if len(c.publicKey) == 0 || !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	sealed, err := sealMessage(c.publicKey, m)
+	if err != nil {
+		return fmt.Errorf("encrypt %s was failed, err: %w", method, err)
+	}
+
+	return invoker(ctx, method, sealed, reply, cc, opts...)
+}
@@ -63,7 +63,7 @@ func TestGRPCClient_BatchUpdateMetric(t *testing.T) {
 	}
 	defer conn.Close()
 
-	c.cc = conn
+	c.conns[cfg.Server] = conn
 
 	t.Run("batch update metrics", func(t *testing.T) {
 		mcs := make(chan []*metrics.Metrics, 1)
@@ -0,0 +1,73 @@
+package metcoll
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
+)
+
+// encryptedField - the field of the request that contains the whole request encrypted with the public key of the server.
+const encryptedField = "encrypted"
+
+// errEncryptionNotConfigured - error occurs when the encrypted request is received by the server without the keys.
+var errEncryptionNotConfigured = errors.New("the server has no keys to decrypt the request")
+
+// sealMessage - returns the request of the same type that contains only the encrypted request.
+// The messages that cannot be encrypted are returned as is.
+func sealMessage(publicKey []byte, m proto.Message) (proto.Message, error) {
+	fd := m.ProtoReflect().Descriptor().Fields().ByName(encryptedField)
+	if fd == nil {
+		return m, nil
+	}
+
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal the request, err: %w", err)
+	}
+
+	encrypted, err := crypto.Encrypt(publicKey, b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt the request, err: %w", err)
+	}
+
+	sealed := m.ProtoReflect().New()
+	sealed.Set(fd, protoreflect.ValueOfBytes(encrypted))
+
+	return sealed.Interface(), nil
+}
+
+// openMessage - returns the decrypted request if the request is encrypted, the plain requests are returned as is.
+func openMessage(keyring *crypto.Keyring, m proto.Message) (proto.Message, error) {
+	fd := m.ProtoReflect().Descriptor().Fields().ByName(encryptedField)
+	if fd == nil {
+		return m, nil
+	}
+
+	encrypted := m.ProtoReflect().Get(fd).Bytes()
+	if len(encrypted) == 0 {
+		return m, nil
+	}
+
+	if keyring == nil {
+		return nil, errEncryptionNotConfigured
+	}
+
+	b, err := keyring.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the request, err: %w", err)
+	}
+
+	msg := m.ProtoReflect().New()
+	if err := proto.Unmarshal(b, msg.Interface()); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal the decrypted request, err: %w", err)
+	}
+	if msg.Has(fd) {
+		return nil, errors.New("the decrypted request is encrypted again")
+	}
+
+	return msg.Interface(), nil
+}
@@ -0,0 +1,161 @@
+//go:build usetempdir
+// +build usetempdir
+
+package metcoll
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+func TestGRPC_encryptedUpdates(t *testing.T) {
+	privateKey, publicKey := writeTestKeys(t)
+
+	tests := []struct {
+		name       string
+		privateKey string
+		publicKey  string
+		wantCode   codes.Code
+	}{
+		{name: "encrypted request", privateKey: privateKey, publicKey: publicKey, wantCode: codes.OK},
+		{name: "plain request to the server with keys", privateKey: privateKey, wantCode: codes.OK},
+		{name: "encrypted request to the server without keys", publicKey: publicKey, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			ctrl := gomock.NewController(t)
+			ms := NewMockMetcollServer(ctrl)
+
+			var got *BatchUpdateRequest
+			ms.EXPECT().Updates(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, r *BatchUpdateRequest) (*BatchUpdateResponse, error) {
+					got = r
+					return &BatchUpdateResponse{}, nil
+				}).MaxTimes(1)
+
+			scfg := &configuration.Config{Key: hashKey, PrivateCryptoKey: tt.privateKey}
+			s, err := NewGRPCServer(nil, scfg, zap.S())
+			require.NoError(t, err)
+			s.RegisterService(&Metcoll_ServiceDesc, ms)
+
+			lis := bufconn.Listen(1024 * 1024)
+			go func() {
+				if err := s.Serve(lis); err != nil {
+					t.Errorf("server exited with error: %v", err)
+				}
+			}()
+			defer s.grpcServer.Stop()
+
+			acfg := &configuration.ConfigAgent{Server: "bufnet", Key: hashKey, PublicCryptoKey: tt.publicKey}
+			c, err := NewGRPCClient(ctx, acfg, zap.S())
+			require.NoError(t, err)
+
+			opts := append(c.getDialOpts(),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+			conn, err := grpc.DialContext(ctx, acfg.Server, opts...)
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+
+			mcs := []*metrics.Metrics{
+				metrics.NewCounterMetric("counter1", 1),
+				metrics.NewGaugeMetric("gauge1", 0.1),
+			}
+			request, headers, err := c.updatesRequest(mcs)
+			require.NoError(t, err)
+
+			mctx := metadata.NewOutgoingContext(ctx, metadata.New(headers))
+			_, err = NewMetcollClient(conn).Updates(mctx, request)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+
+			if tt.wantCode == codes.OK {
+				require.NotNil(t, got)
+				assert.Empty(t, got.GetEncrypted())
+				assert.Equal(t, len(mcs), len(got.GetMetrics()))
+			}
+		})
+	}
+}
+
+func TestSealMessage(t *testing.T) {
+	privateKey, publicKey := writeTestKeys(t)
+
+	pub, err := os.ReadFile(publicKey)
+	require.NoError(t, err)
+
+	request := &AgentProfileRequest{AgentId: "web-01", Labels: []string{"eu"}}
+	sealed, err := sealMessage(pub, request)
+	require.NoError(t, err)
+
+	sr, ok := sealed.(*AgentProfileRequest)
+	require.True(t, ok)
+	assert.Empty(t, sr.GetAgentId())
+	assert.NotEmpty(t, sr.GetEncrypted())
+
+	_, err = openMessage(nil, sealed)
+	assert.ErrorIs(t, err, errEncryptionNotConfigured)
+
+	keyring, err := crypto.NewKeyring(privateKey)
+	require.NoError(t, err)
+
+	opened, err := openMessage(keyring, sealed)
+	require.NoError(t, err)
+	or, ok := opened.(*AgentProfileRequest)
+	require.True(t, ok)
+	assert.Equal(t, request.GetAgentId(), or.GetAgentId())
+	assert.Equal(t, request.GetLabels(), or.GetLabels())
+
+	plain := &MetricListRequest{}
+	same, err := sealMessage(pub, plain)
+	require.NoError(t, err)
+	assert.Same(t, plain, same)
+}
+
+// writeTestKeys - writes the RSA key pair to the temp dir and returns the paths to the private and the public keys.
+func writeTestKeys(t *testing.T) (string, string) {
+	t.Helper()
+
+	const bits = 2048
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	publicBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	td := t.TempDir()
+	privateKey := path.Join(td, "private.pem")
+	publicKey := path.Join(td, "public.pem")
+
+	err = os.WriteFile(privateKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: privateBytes}), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(publicKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: publicBytes}), 0600)
+	require.NoError(t, err)
+
+	return privateKey, publicKey
+}
@@ -0,0 +1,87 @@
+package metcoll
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// certIdentity - returns the identity of the agent from its verified client certificate:
+// the common name of the subject, or the first DNS or URI name if the common name is empty.
+func certIdentity(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName, true
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], true
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String(), true
+	default:
+		return "", false
+	}
+}
+
+// peerIP - returns the IP address of the connection, an empty string is returned if it is not an IP address.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+
+	return host
+}
+
+// identitySetter - replaces the agent ID in the metadata, which the agent is able to forge,
+// with the identity of the verified client certificate.
+// The request with the agent ID other than the identity of the certificate is rejected.
+func (s *GRPCServer) identitySetter() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		id, ok := certIdentity(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+
+		if v := md.Get(agentIDHeader); len(v) > 0 {
+			if agentID := strings.TrimSpace(v[0]); agentID != "" && agentID != id {
+				s.sl.Infof("request %s of agent %q was rejected, the certificate is issued to %q",
+					info.FullMethod, agentID, id)
+				return nil, status.Errorf(codes.PermissionDenied, "the certificate is not issued to agent %s", agentID)
+			}
+		}
+
+		md.Set(agentIDHeader, id)
+
+		return handler(metadata.NewIncomingContext(ctx, md), req)
+	}
+}
@@ -21,22 +21,28 @@ import (
 	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
 	"github.com/ArtemShalinFe/metcoll/internal/metrics"
 	"github.com/ArtemShalinFe/metcoll/internal/storage"
+	"github.com/ArtemShalinFe/metcoll/internal/tlsconfig"
 )
 
 type MetricService struct {
 	UnimplementedMetcollServer
-	storage Storage
-	log     *zap.SugaredLogger
+	storage   Storage
+	log       *zap.SugaredLogger
+	profiles  *profileStore
+	inventory *inventory
 }
 
 func NewMetricService(s Storage, sl *zap.SugaredLogger) *MetricService {
 	return &MetricService{
-		storage: s,
-		log:     sl,
+		storage:   s,
+		log:       sl,
+		inventory: newInventory(defaultStaleReports),
 	}
 }
 
@@ -54,9 +60,18 @@ func (ms *MetricService) Updates(ctx context.Context, request *BatchUpdateReques
 			return &response, fmt.Errorf("an error occured while convert pb metric, err: %w", err)
 		}
 
+		if !allowedMetric(ctx, mtr.ID) {
+			return nil, status.Errorf(codes.PermissionDenied, "metric %s is not allowed for the token", mtr.ID)
+		}
+
 		mtrs[i] = mtr
 	}
 
+	if err := checkQuota(ctx, ms.storage, mtrs); err != nil {
+		ms.log.Infof("BatchUpdate was rejected, err: %v", err)
+		return nil, quotaError(err)
+	}
+
 	ums, errs, err := metrics.BatchUpdate(ctx, mtrs, ms.storage)
 	if err != nil {
 		ms.log.Errorf("BatchUpdate update was failed, err: %w", err)
@@ -88,6 +103,15 @@ func (ms *MetricService) Update(ctx context.Context, request *UpdateRequest) (*U
 		return &response, fmt.Errorf("an error occured while convert metric, err: %w", err)
 	}
 
+	if !allowedMetric(ctx, mtr.ID) {
+		return nil, status.Errorf(codes.PermissionDenied, "metric %s is not allowed for the token", mtr.ID)
+	}
+
+	if err := checkQuota(ctx, ms.storage, []*metrics.Metrics{mtr}); err != nil {
+		ms.log.Infof("metric %s was not updated, err: %v", mtr.ID, err)
+		return nil, quotaError(err)
+	}
+
 	ms.log.Infof("Trying update (%s) metric %s with value: %s", mtr.MType, mtr.ID, mtr.String())
 
 	if err := mtr.Update(ctx, ms.storage); err != nil {
@@ -113,6 +137,10 @@ func (ms *MetricService) ReadMetric(ctx context.Context, request *ReadMetricRequ
 		return &response, fmt.Errorf("an error occured while convert metric in reading, err: %w", err)
 	}
 
+	if !allowedMetric(ctx, mtr.ID) {
+		return nil, status.Errorf(codes.PermissionDenied, "metric %s is not allowed for the token", mtr.ID)
+	}
+
 	if err := mtr.Get(ctx, ms.storage); err != nil {
 		if !errors.Is(err, storage.ErrNoRows) {
 			ms.log.Errorf("an error occurred while reading the metric, err: %w", err)
@@ -139,6 +167,9 @@ func (ms *MetricService) MetricList(ctx context.Context, request *MetricListRequ
 
 	list := ""
 	for _, v := range mts {
+		if !allowedMetric(ctx, metricListID(v)) {
+			continue
+		}
 		list += fmt.Sprintf(mt, v)
 	}
 
@@ -158,32 +189,89 @@ func convertMetric(pbm *Metric) (*metrics.Metrics, error) {
 }
 
 type GRPCServer struct {
-	grpcServer    *grpc.Server
-	addr          string
-	trustedSubnet *net.IPNet
-	ms            *MetricService
-	sl            *zap.SugaredLogger
-	hashkey       []byte
+	grpcServer *grpc.Server
+	addr       string
+	instanceID string
+	ipResolver *ipResolver
+	auth       *tokenAuth
+	tenants    *tenantStore
+	ms         *MetricService
+	sl         *zap.SugaredLogger
+	agentKeys  *agentKeyStore
+	keyring    *crypto.Keyring
+	hashkey    []byte
 }
 
 func NewGRPCServer(s Storage, cfg *configuration.Config, sl *zap.SugaredLogger) (*GRPCServer, error) {
+	instanceID, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := newProfileStore(cfg.AgentProfiles, cfg.Key, sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load agent profiles err: %w", err)
+	}
+
+	agentKeys, err := newAgentKeyStore(cfg.AgentKeys, sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load agent keys err: %w", err)
+	}
+
+	ipResolver, err := newIPResolver(cfg.TrustedSubnet, cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newTokenAuth(s, cfg.AdminToken)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := crypto.NewKeyring(cfg.PrivateCryptoKey, cfg.CryptoKeyDir)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when grpc server loading the keyring, err: %w", err)
+	}
+	if keyring != nil {
+		sl.Infof("loaded crypto keys: %v", keyring.IDs())
+	}
+
+	tenants, err := newTenantStore(cfg.Tenants, cfg.Key, keyring, sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load tenants err: %w", err)
+	}
+
 	srv := &GRPCServer{
-		addr:          cfg.Address,
-		ms:            NewMetricService(s, sl),
-		sl:            sl,
-		trustedSubnet: parseTrustedSubnet(cfg.TrustedSubnet),
-		hashkey:       cfg.Key,
+		addr:       cfg.Address,
+		instanceID: instanceID,
+		ms:         NewMetricService(s, sl),
+		sl:         sl,
+		ipResolver: ipResolver,
+		auth:       auth,
+		tenants:    tenants,
+		agentKeys:  agentKeys,
+		keyring:    keyring,
+		hashkey:    cfg.Key,
 	}
+	srv.ms.profiles = profiles
+	srv.ms.inventory = newInventory(cfg.StaleReports)
 
-	creds, err := getServerCreds(cfg)
+	creds, err := getServerCreds(cfg, sl)
 	if err != nil {
 		return nil, err
 	}
 
 	opt := grpc.ChainUnaryInterceptor(
+		srv.instanceSetter(),
 		srv.requestLogger(),
+		srv.identitySetter(),
 		srv.resolverIP(),
+		srv.tokenChecker(),
+		srv.tenantSetter(),
+		srv.cryptoDecrypter(),
 		srv.hashChecker(),
+		srv.signatureChecker(),
+		srv.agentRecorder(),
 	)
 	srv.grpcServer = grpc.NewServer(grpc.Creds(creds), opt)
 
@@ -220,6 +308,24 @@ func (s *GRPCServer) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// ReloadKeys - reads the private keys of the keyring and of the tenants again.
+func (s *GRPCServer) ReloadKeys() error {
+	if s.keyring != nil {
+		if err := s.keyring.Reload(); err != nil {
+			return fmt.Errorf("grpc server reload keys err: %w", err)
+		}
+		s.sl.Infof("reloaded crypto keys: %v", s.keyring.IDs())
+	}
+
+	if s.tenants != nil {
+		if err := s.tenants.reloadKeys(s.sl); err != nil {
+			return fmt.Errorf("grpc server reload keys err: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (s *GRPCServer) requestLogger() grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -243,10 +349,27 @@ func (s *GRPCServer) requestLogger() grpc.UnaryServerInterceptor {
 			)
 		}
 
-		return resp, nil
+		return resp, err
 	}
 }
 
+func (s *GRPCServer) instanceSetter() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := grpc.SetHeader(ctx, metadata.Pairs(instanceHeader, s.instanceID)); err != nil {
+			s.sl.Errorf("cannot set instance header, err: %v", err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// resolverIP - resolves the real address of the agent and rejects the agents out of the trusted subnets.
+// The X-Real-IP metadata is replaced with the resolved address, so the agent is not able to forge it.
 func (s *GRPCServer) resolverIP() grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -254,46 +377,57 @@ func (s *GRPCServer) resolverIP() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		if s.trustedSubnet == nil {
-			resp, err := handler(ctx, req)
-			if err != nil {
-				return nil, status.Errorf(codes.Unknown,
-					"interruptor in resolver ip interceptor was failed, err: %v", err)
-			}
-			return resp, nil
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+
+		var real string
+		if v := md.Get(realIP); len(v) > 0 {
+			real = v[0]
 		}
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.Aborted, "'X-Real-IP' header is required")
+		ip, err := s.ipResolver.resolve(peerIP(ctx), md.Get(forwardedFor), real)
+		if err != nil {
+			return nil, status.Errorf(codes.Aborted, "cannot resolve the address of the agent, err: %v", err)
 		}
 
-		ips := md.Get(realIP)
-		if len(ips) == 0 {
-			return nil, status.Error(codes.Aborted, "'X-Real-IP' header not contain elemets")
+		if !s.ipResolver.allowed(ip) {
+			return nil, status.Errorf(codes.Aborted, "trusted subnets do not contain the address %s of the agent", ip)
 		}
 
-		ipStr := strings.TrimSpace(ips[0])
-		if strings.TrimSpace(ipStr) == "" {
-			return nil, status.Error(codes.Aborted, "first element in 'X-Real-IP' header is empty")
+		md.Delete(realIP)
+		if ip != nil {
+			md.Set(realIP, ip.String())
 		}
 
-		ip := net.ParseIP(ipStr)
-		if ip == nil {
-			return nil, status.Error(codes.Aborted, "first element in 'X-Real-IP' header is not IP")
+		resp, err := handler(metadata.NewIncomingContext(ctx, md), req)
+		if err != nil {
+			return nil, fmt.Errorf("handler in resolver ip interceptor was failed, err: %w", err)
 		}
+		return resp, nil
+	}
+}
 
-		if !s.trustedSubnet.Contains(ip) {
-			return nil, status.Error(codes.Aborted,
-				"trusted network does not contain the first element in 'X-Real-IP' header")
+// cryptoDecrypter - replaces the encrypted request with the decrypted one.
+// The plain requests are passed as is, so the agents without the public key are served over TLS.
+func (s *GRPCServer) cryptoDecrypter() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		m, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
 		}
 
-		resp, err := handler(ctx, req)
+		opened, err := openMessage(tenantKeyring(ctx, s.keyring), m)
 		if err != nil {
-			return nil, status.Errorf(codes.Unknown,
-				"handler in resolver ip interceptor was failed, err: %v", err)
+			s.sl.Infof("an occured error when decrypt %s request err: %v", info.FullMethod, err)
+			return nil, status.Error(codes.InvalidArgument, "the request cannot be decrypted")
 		}
-		return resp, nil
+
+		return handler(ctx, opened)
 	}
 }
 
@@ -304,7 +438,8 @@ func (s *GRPCServer) hashChecker() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		if len(s.hashkey) == 0 {
+		hashkey := tenantHashKey(ctx, s.hashkey)
+		if len(hashkey) == 0 {
 			resp, err := handler(ctx, req)
 			if err != nil {
 				return nil, fmt.Errorf("unable to check hash, err: %w", err)
@@ -326,17 +461,12 @@ func (s *GRPCServer) hashChecker() grpc.UnaryServerInterceptor {
 
 		hash := strings.TrimSpace(hashes[0])
 
-		correctHash, err := s.correctRequestHash(req)
+		correctHash, err := correctRequestHash(hashkey, req)
 		if err != nil {
 			return nil, status.Errorf(codes.Aborted,
 				"an occured error when getting correct request hash, err: %v", err)
 		}
 
-		header := metadata.New(map[string]string{HashSHA256: correctHash})
-		if err := grpc.SendHeader(ctx, header); err != nil {
-			return nil, status.Errorf(codes.Internal, "unable to send '%s' header", HashSHA256)
-		}
-
 		if correctHash != hash {
 			return nil, status.Error(codes.Aborted, "hash is incorrect")
 		}
@@ -345,46 +475,82 @@ func (s *GRPCServer) hashChecker() grpc.UnaryServerInterceptor {
 		if err != nil {
 			return nil, fmt.Errorf("unable to convert metrics to bytes, err: %w", err)
 		}
+
+		respHash, err := responseHash(hashkey, resp)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to get response hash, err: %v", err)
+		}
+
+		header := metadata.New(map[string]string{HashSHA256: respHash})
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to send '%s' header", HashSHA256)
+		}
+
 		return resp, nil
 	}
 }
 
-func (s *GRPCServer) messageHash(message any) (string, error) {
+// responseHash - returns the hash of the response that the agent checks.
+func responseHash(hashkey []byte, resp any) (string, error) {
+	b, err := responseBytes(resp)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, hashkey)
+	h.Write(b)
+	return hashBytesToString(h, nil), nil
+}
+
+func messageHash(hashkey []byte, message any) (string, error) {
 	b, err := convertToBytes(message)
 	if err != nil {
 		return "", fmt.Errorf("unable to convert message to bytes, err: %w", err)
 	}
-	h := hmac.New(sha256.New, s.hashkey)
+	h := hmac.New(sha256.New, hashkey)
 	h.Write(b)
 	return hashBytesToString(h, nil), nil
 }
 
-func (s *GRPCServer) correctRequestHash(req any) (string, error) {
+func correctRequestHash(hashkey []byte, req any) (string, error) {
+	part, ok := signedPart(req)
+	if !ok {
+		return "", nil
+	}
+
+	correctHash, err := messageHash(hashkey, part)
+	if err != nil {
+		return "", fmt.Errorf("%T - bad request, err: %w", req, err)
+	}
+	return correctHash, nil
+}
+
+// signedPart - returns the part of the request that is hashed and signed by the agent.
+func signedPart(req any) (any, bool) {
 	switch r := req.(type) {
 	case *BatchUpdateRequest:
-		correctHash, err := s.messageHash(r.GetMetrics())
-		if err != nil {
-			return "", fmt.Errorf("batch update - bad request, err: %w", err)
-		}
-		return correctHash, nil
+		return r.GetMetrics(), true
 	case *UpdateRequest:
-		correctHash, err := s.messageHash(r.GetMetric())
-		if err != nil {
-			return "", fmt.Errorf("update - bad request, err: %w", err)
-		}
-		return correctHash, nil
+		return r.GetMetric(), true
+	case *AgentProfileRequest:
+		return r, true
 	case *ReadMetricRequest:
-		correctHash, err := s.messageHash(r.GetMetric())
-		if err != nil {
-			return "", fmt.Errorf("read - bad request, err: %w", err)
-		}
-		return correctHash, nil
+		return r.GetMetric(), true
 	default:
-		return "", nil
+		return nil, false
 	}
 }
 
-func getServerCreds(cfg *configuration.Config) (credentials.TransportCredentials, error) {
+// getServerCreds - returns the TLS credentials with the certificates reloaded from the TLS options,
+// the certificate and the crypto key are used if the TLS options are not set.
+func getServerCreds(cfg *configuration.Config, sl *zap.SugaredLogger) (credentials.TransportCredentials, error) {
+	if cfg.TLSEnabled() {
+		tlsConfig, err := tlsconfig.NewServer(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA, cfg.TLSCRL, sl)
+		if err != nil {
+			return nil, fmt.Errorf("an occured error when loading TLS configuration, err: %w", err)
+		}
+		return credentials.NewTLS(tlsConfig), nil
+	}
+
 	if cfg.CertFilePath != "" && cfg.PrivateCryptoKey != "" {
 		creds, err := credentials.NewServerTLSFromFile(
 			cfg.CertFilePath,
@@ -410,6 +576,21 @@ func convertToBytes(val any) ([]byte, error) {
 	return buff.Bytes(), nil
 }
 
+// responseBytes - returns the response in the deterministic protobuf encoding,
+// so that the agent gets the same bytes from the received response.
+func responseBytes(resp any) ([]byte, error) {
+	m, ok := resp.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("response %T is not a protobuf message", resp)
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when marshal response, err: %w", err)
+	}
+	return b, nil
+}
+
 func responseSize(val any) (int, error) {
 	b, err := convertToBytes(val)
 	if err != nil {
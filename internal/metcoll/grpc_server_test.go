@@ -31,16 +31,14 @@ func (d *dialer) bufDialer(context.Context, string) (net.Conn, error) {
 
 var hashKey = []byte("secretKeyForHash")
 
+// testClientIP - the address of the agent in the requests of the tests.
+const testClientIP = "192.168.1.10"
+
 func testConfig(t *testing.T) *configuration.Config {
 	cfg := &configuration.Config{}
 	cfg.Key = hashKey
 
-	IP, err := localIP()
-	if err != nil {
-		t.Errorf("an occured error when getting current IP for tests, err: %v", err)
-	}
-
-	cfg.TrustedSubnet = IP
+	cfg.TrustedSubnet = testClientIP
 	return cfg
 }
 
@@ -69,13 +67,8 @@ func NewDialer(t *testing.T, stg Storage) (*dialer, error) {
 func headersForRequest(t *testing.T, b []byte) map[string]string {
 	t.Helper()
 
-	clientIP, err := localIP()
-	if err != nil {
-		t.Errorf("an occured error when getting current IP for request headers, err: %v", err)
-	}
-
 	headers := map[string]string{
-		realIP:     clientIP,
+		realIP:     testClientIP,
 		HashSHA256: "",
 	}
 	if len(hashKey) != 0 && len(b) > 0 {
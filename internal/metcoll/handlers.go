@@ -22,8 +22,12 @@ const (
 )
 
 type Handler struct {
-	storage Storage
-	logger  *zap.SugaredLogger
+	storage   Storage
+	logger    *zap.SugaredLogger
+	profiles  *profileStore
+	inventory *inventory
+	tokens    storage.TokenStorage
+	tenants   *tenantStore
 }
 
 type Storage interface {
@@ -49,6 +53,10 @@ type Storage interface {
 	//	...
 	GetDataList(ctx context.Context) ([]string, error)
 
+	// MetricCount - returns the count of the metrics of the tenant after the counters and gauges are saved,
+	// the metrics that are not saved yet are counted as new.
+	MetricCount(ctx context.Context, counters []string, gauges []string) (int, error)
+
 	// BatchSetFloat64Value - Batch saving of metric values.
 	// Returns the set metric values and errors for those metrics whose values could not be set.
 	BatchSetFloat64Value(ctx context.Context, gauges map[string]float64) (map[string]float64, []error, error)
@@ -62,8 +70,9 @@ type Storage interface {
 
 func NewHandler(s Storage, l *zap.SugaredLogger) *Handler {
 	return &Handler{
-		storage: s,
-		logger:  l,
+		storage:   s,
+		logger:    l,
+		inventory: newInventory(defaultStaleReports),
 	}
 }
 
@@ -91,6 +100,9 @@ func (h *Handler) CollectMetricList(ctx context.Context, w http.ResponseWriter)
 
 	list := ""
 	for _, v := range ms {
+		if !allowedMetric(ctx, metricListID(v)) {
+			continue
+		}
 		list += fmt.Sprintf(mt, v)
 	}
 
@@ -110,6 +122,18 @@ func (h *Handler) UpdateMetricFromURL(ctx context.Context,
 		return
 	}
 
+	if !allowedMetric(ctx, m.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		h.logger.Infof("metric %s is not allowed for the token", m.ID)
+		return
+	}
+
+	if err := checkQuota(ctx, h.storage, []*metrics.Metrics{m}); err != nil {
+		http.Error(w, err.Error(), quotaStatus(err))
+		h.logger.Infof("metric %s was not updated, err: %v", m.ID, err)
+		return
+	}
+
 	h.logger.Infof("Trying update %s metric from URL %s with value: %s", m.MType, m.ID, m.String())
 
 	if err := m.Update(ctx, h.storage); err != nil {
@@ -151,6 +175,18 @@ func (h *Handler) UpdateMetric(ctx context.Context, w http.ResponseWriter, body
 		return
 	}
 
+	if !allowedMetric(ctx, m.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		h.logger.Infof("metric %s is not allowed for the token", m.ID)
+		return
+	}
+
+	if err := checkQuota(ctx, h.storage, []*metrics.Metrics{&m}); err != nil {
+		http.Error(w, err.Error(), quotaStatus(err))
+		h.logger.Infof("metric %s was not updated, err: %v", m.ID, err)
+		return
+	}
+
 	h.logger.Infof("Trying update %s metric %s with value: %s", m.MType, m.ID, m.String())
 	h.logger.Debugf("UpdateMetric body: %s", string(b))
 
@@ -206,6 +242,18 @@ func (h *Handler) BatchUpdate(ctx context.Context, w http.ResponseWriter, body i
 			h.logger.Infof("metric %s has nil delta and value", m.ID)
 			return
 		}
+
+		if !allowedMetric(ctx, m.ID) {
+			w.WriteHeader(http.StatusForbidden)
+			h.logger.Infof("metric %s is not allowed for the token", m.ID)
+			return
+		}
+	}
+
+	if err := checkQuota(ctx, h.storage, ms); err != nil {
+		http.Error(w, err.Error(), quotaStatus(err))
+		h.logger.Infof("BatchUpdate was rejected, err: %v", err)
+		return
 	}
 
 	// Автотесты хотят, чтобы мы возвращали ошибку изменения каждой метрики
@@ -239,6 +287,11 @@ func (h *Handler) ReadMetricFromURL(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
+	if !allowedMetric(ctx, m.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	if err := m.Get(ctx, h.storage); err != nil {
 		if errors.Is(err, storage.ErrNoRows) {
 			w.WriteHeader(http.StatusNotFound)
@@ -276,6 +329,11 @@ func (h *Handler) ReadMetric(ctx context.Context, w http.ResponseWriter, body io
 		return
 	}
 
+	if !allowedMetric(ctx, m.ID) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	if err := m.Get(ctx, h.storage); err != nil {
 		if errors.Is(err, storage.ErrNoRows) {
 			w.WriteHeader(http.StatusNotFound)
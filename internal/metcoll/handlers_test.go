@@ -984,8 +984,8 @@ func testServer() (*httptest.Server, error) {
 		srv.resolverIP,
 		l.RequestLogger,
 		srv.requestHashChecker,
-		srv.responseHashSetter,
 		compress.CompressMiddleware,
+		srv.responseHashSetter,
 		srv.cryptoDecrypter)
 
 	h.CollectMetricList(ctx, httptest.NewRecorder())
@@ -5,37 +5,47 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
-	"syscall"
+	"strconv"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	"go.uber.org/zap"
 
+	"github.com/ArtemShalinFe/metcoll/internal/build"
 	"github.com/ArtemShalinFe/metcoll/internal/configuration"
 	"github.com/ArtemShalinFe/metcoll/internal/crypto"
 	"github.com/ArtemShalinFe/metcoll/internal/logger"
 	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/tlsconfig"
 )
 
 // Client - sends requests for metric updates to the server.
 type Client struct {
-	host       string
-	clientIP   string
-	httpClient *retryablehttp.Client
-	sl         *zap.SugaredLogger
-	publicKey  []byte
-	hashkey    []byte
+	dests          *destinations
+	scheme         string
+	clientIP       string
+	agentID        string
+	version        string
+	reportInterval string
+	apiToken       string
+	tenant         string
+	httpClient     *retryablehttp.Client
+	sl             *zap.SugaredLogger
+	publicKey      []byte
+	hashkey        []byte
+	signKey        ed25519.PrivateKey
 }
 
 const (
@@ -49,72 +59,70 @@ func NewHTTPClient(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (*Clie
 		return nil, fmt.Errorf("cannot init retry logger err: %w", err)
 	}
 
-	const defautMaxRetry = 3
-	const defautMinWaitRetry = 3 * time.Second
-	const defautMaxWaitRetry = 5 * time.Second
-
 	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = defautMaxRetry
+	retryClient.RetryMax = cfg.RetryMax
 	retryClient.CheckRetry = checkRetry
-	retryClient.RetryWaitMin = defautMinWaitRetry
-	retryClient.RetryWaitMax = defautMaxWaitRetry
+	retryClient.RetryWaitMin = time.Duration(cfg.RetryWaitMin) * time.Second
+	retryClient.RetryWaitMax = time.Duration(cfg.RetryWaitMax) * time.Second
 	retryClient.Logger = rl
 	retryClient.Backoff = backoff
+	// the last response is returned when the retries are exhausted, so its status is reported.
+	retryClient.ErrorHandler = retryablehttp.PassthroughErrorHandler
 
-	var publicKey []byte
-	if cfg.PublicCryptoKey != "" {
-		publicCryptoKey, err := crypto.GetKeyBytes(cfg.PublicCryptoKey)
+	scheme := "http://"
+	if cfg.TLSEnabled() {
+		tlsClient, err := tlsconfig.NewClient(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey, cfg.TLSServerName, sl)
 		if err != nil {
-			return nil, fmt.Errorf("an occured error when agent getting key bytes, err: %w", err)
+			return nil, fmt.Errorf("an occured error when agent loading the TLS configuration, err: %w", err)
+		}
+
+		transport, ok := retryClient.HTTPClient.Transport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("http transport type %T is not supported", retryClient.HTTPClient.Transport)
+		}
+		// the TLS configuration is built for every server, so its certificate is verified against its host.
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := &tls.Dialer{Config: tlsClient.Config(addr)}
+			return d.DialContext(ctx, network, addr)
 		}
-		publicKey = publicCryptoKey
+		scheme = "https://"
 	}
 
-	clientIP, err := localIP()
+	publicKey, err := loadPublicKey(cfg, sl)
 	if err != nil {
-		return nil, fmt.Errorf("an occured error when agent getting local IP, err: %w", err)
+		return nil, err
 	}
 
-	c := &Client{
-		host:       cfg.Server,
-		httpClient: retryClient,
-		sl:         sl,
-		hashkey:    cfg.Key,
-		publicKey:  publicKey,
-		clientIP:   clientIP,
+	signKey, err := loadSigningKey(cfg, sl)
+	if err != nil {
+		return nil, err
 	}
 
-	return c, nil
-}
+	clientIP := agentIP(cfg, sl)
 
-func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
-	if errors.Is(err, syscall.ECONNREFUSED) {
-		return true, err
-	} else {
-		return false, err
+	dests, err := newDestinations(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when agent getting servers, err: %w", err)
 	}
-}
-
-func backoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
-	const an0 = 0
-	const an1 = 1
-	const an2 = 2
-
-	const an0backoff = 1 * time.Second
-	const an1backoff = 3 * time.Second
-	const an2backoff = 5 * time.Second
-	const defaultbackoff = 2 * time.Second
 
-	switch attemptNum {
-	case an0:
-		return an0backoff
-	case an1:
-		return an1backoff
-	case an2:
-		return an2backoff
-	default:
-		return defaultbackoff
+	b := build.NewBuild()
+	c := &Client{
+		dests:          dests,
+		scheme:         scheme,
+		httpClient:     retryClient,
+		sl:             sl,
+		hashkey:        cfg.Key,
+		publicKey:      publicKey,
+		signKey:        signKey,
+		clientIP:       clientIP,
+		agentID:        cfg.ID(),
+		version:        b.Version(),
+		reportInterval: strconv.Itoa(cfg.ReportInterval),
+		apiToken:       cfg.APIToken,
+		tenant:         cfg.Tenant,
 	}
+
+	return c, nil
 }
 
 func (c *Client) prepareRequest(ctx context.Context, body []byte, url string) (*retryablehttp.Request, error) {
@@ -137,13 +145,26 @@ func (c *Client) prepareRequest(ctx context.Context, body []byte, url string) (*
 	req.Header.Set(contentType, "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set(realIP, c.clientIP)
+	req.Header.Set(agentIDHeader, c.agentID)
+	req.Header.Set(agentVersionHeader, c.version)
+	req.Header.Set(reportIntervalHeader, c.reportInterval)
+	if c.apiToken != "" {
+		req.Header.Set(authorizationHeader, bearerPrefix+c.apiToken)
+	}
+	if c.tenant != "" {
+		req.Header.Set(tenantHeader, c.tenant)
+	}
 
-	if len(c.hashkey) != 0 {
-		data, err := req.BodyBytes()
-		if err != nil {
-			return nil, fmt.Errorf("cannot calculate hash err: %w", err)
-		}
+	if len(c.hashkey) == 0 && c.signKey == nil {
+		return req, nil
+	}
+
+	data, err := req.BodyBytes()
+	if err != nil {
+		return nil, fmt.Errorf("cannot calculate hash err: %w", err)
+	}
 
+	if len(c.hashkey) != 0 {
 		h := hmac.New(sha256.New, c.hashkey)
 
 		h.Write(data)
@@ -151,60 +172,127 @@ func (c *Client) prepareRequest(ctx context.Context, body []byte, url string) (*
 		req.Header.Set(HashSHA256, hashBytesToString(h, nil))
 	}
 
+	if c.signKey != nil {
+		timestamp := signatureTimestamp()
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(signatureHeader, signRequest(c.signKey, c.agentID, timestamp, data))
+	}
+
 	return req, nil
 }
 
-// localIP returns preferred outbound ip of this machine.
-func localIP() (string, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// BatchUpdate - Sends the batch of metrics to the servers.
+// The error is returned if the batch was not delivered to any server. In the fan-out mode
+// UndeliveredError is returned if the batch was not delivered to some of the servers.
+func (c *Client) BatchUpdate(ctx context.Context, metrics []*metrics.Metrics) error {
+	errs, delivered := c.batchUpdate(ctx, metrics)
+	return c.dests.result(errs, delivered, c.sl.Errorf)
+}
+
+// BatchUpdateTo - Sends the batch of metrics only to the server, the batch that was not delivered
+// to the server in the fan-out mode is resent with it.
+func (c *Client) BatchUpdateTo(ctx context.Context, server string, metrics []*metrics.Metrics) error {
+	body, err := c.batchBody(metrics)
 	if err != nil {
-		return "", fmt.Errorf("an occured erorr while getting local IP, err: %w", err)
+		return err
 	}
-	defer func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("an error occured while close conn, err: %v", err)
-		}
-	}()
 
-	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
-	if !ok {
-		return "", fmt.Errorf("unknow local IP address")
+	return c.dests.sendTo(ctx, server, c.sendBody(body))
+}
+
+// Fanout - returns the servers if the batches are sent to all of them, nil in the failover mode.
+func (c *Client) Fanout() []string {
+	return c.dests.fanoutServers()
+}
+
+func (c *Client) batchUpdate(ctx context.Context, metrics []*metrics.Metrics) ([]error, bool) {
+	body, err := c.batchBody(metrics)
+	if err != nil {
+		return []error{err}, false
 	}
 
-	return localAddr.IP.To4().String(), nil
+	return c.dests.send(ctx, c.sendBody(body))
 }
 
-func (c *Client) batchUpdate(ctx context.Context, metrics []*metrics.Metrics) error {
+// sendBody - returns the function that sends the batch body to the server.
+func (c *Client) sendBody(body []byte) func(ctx context.Context, server string) error {
+	return func(ctx context.Context, server string) error {
+		req, err := c.batchRequest(ctx, server, body)
+		if err != nil {
+			return err
+		}
+
+		return c.doRequest(server, req)
+	}
+}
+
+// batchBody - returns the batch of metrics marshaled and encrypted with the public key.
+func (c *Client) batchBody(metrics []*metrics.Metrics) ([]byte, error) {
 	body, err := json.Marshal(metrics)
 	if err != nil {
-		return fmt.Errorf("cannot marshal metric err: %w", err)
+		return nil, fmt.Errorf("cannot marshal metric err: %w", err)
 	}
 
 	if len(c.publicKey) != 0 {
 		body, err = crypto.Encrypt(c.publicKey, body)
 		if err != nil {
-			return fmt.Errorf("cannot encrypt body err: %w", err)
+			return nil, fmt.Errorf("cannot encrypt body err: %w", err)
 		}
 	}
 
-	url, err := url.JoinPath("http://", c.host, updates)
+	return body, nil
+}
+
+func (c *Client) batchRequest(ctx context.Context, server string, body []byte) (*retryablehttp.Request, error) {
+	url, err := url.JoinPath(c.scheme, server, updates)
 	if err != nil {
-		return fmt.Errorf("cannot join elements in path err: %w", err)
+		return nil, fmt.Errorf("cannot join elements in path err: %w", err)
 	}
 
 	req, err := c.prepareRequest(ctx, body, url)
 	if err != nil {
-		return fmt.Errorf("cannot prepare request err: %w", err)
+		return nil, fmt.Errorf("cannot prepare request err: %w", err)
+	}
+
+	return req, nil
+}
+
+// WriteWire - writes the request that sends the batch to the first server without sending it.
+func (c *Client) WriteWire(ctx context.Context, w io.Writer, mcs []*metrics.Metrics) error {
+	body, err := c.batchBody(mcs)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.batchRequest(ctx, c.dests.servers()[0], body)
+	if err != nil {
+		return err
+	}
+
+	data, err := req.BodyBytes()
+	if err != nil {
+		return fmt.Errorf("cannot read request body err: %w", err)
+	}
+
+	r := req.Request.Clone(ctx)
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	if r.Header.Get(authorizationHeader) != "" {
+		r.Header.Set(authorizationHeader, redactedToken)
+	}
+	if err := r.Write(w); err != nil {
+		return fmt.Errorf("cannot write request err: %w", err)
 	}
 
-	return c.doRequest(req)
+	return nil
 }
 
-func (c *Client) doRequest(req *retryablehttp.Request) error {
+func (c *Client) doRequest(server string, req *retryablehttp.Request) error {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("request execute err: %w", err)
 	}
+	c.dests.observe(server, resp.Header.Get(instanceHeader))
 
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -217,18 +305,105 @@ func (c *Client) doRequest(req *retryablehttp.Request) error {
 		return fmt.Errorf("reading response body err: %w", err)
 	}
 
-	if resp.StatusCode < http.StatusMultipleChoices {
-		c.sl.Info(`request for update metric has been completed
-	code: %d, hash: %s`, resp.StatusCode, resp.Header.Get(HashSHA256))
-	} else {
-		c.sl.Error(`request for update metric has failed
-	code: %d
-	result: %s`, resp.StatusCode, string(res))
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{Code: resp.StatusCode, Body: string(res)}
+	}
+
+	if len(c.hashkey) != 0 {
+		if err := verifyResponseHash(c.hashkey, res, resp.Header.Get(HashSHA256)); err != nil {
+			return err
+		}
 	}
 
+	c.sl.Infof("request for update metric has been completed code: %d", resp.StatusCode)
+
 	return nil
 }
 
+// AgentProfile - requests the configuration profile of the agent from the servers one by one
+// until the profile is received.
+func (c *Client) AgentProfile(ctx context.Context, agentID string, labels []string) (*Profile, error) {
+	body, err := json.Marshal(&profileRequest{AgentID: agentID, Labels: labels})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal agent profile request err: %w", err)
+	}
+
+	if len(c.publicKey) != 0 {
+		body, err = crypto.Encrypt(c.publicKey, body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encrypt agent profile request err: %w", err)
+		}
+	}
+
+	var errs []error
+	for _, server := range c.dests.servers() {
+		p, err := c.agentProfile(ctx, server, body)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, ErrProfileNotFound) {
+			errs = append(errs, &DestinationError{Err: err, Server: server})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return nil, ErrProfileNotFound
+}
+
+func (c *Client) agentProfile(ctx context.Context, server string, body []byte) (*Profile, error) {
+	url, err := url.JoinPath(c.scheme, server, profile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot join elements in path err: %w", err)
+	}
+
+	req, err := c.prepareRequest(ctx, body, url)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prepare request err: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request execute err: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			c.sl.Errorf("an error occured while body closing err: %v", err)
+		}
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrProfileNotFound
+	case resp.StatusCode >= http.StatusMultipleChoices:
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body err: %w", err)
+	}
+
+	if len(c.hashkey) != 0 {
+		if err := verifyResponseHash(c.hashkey, res, resp.Header.Get(HashSHA256)); err != nil {
+			return nil, err
+		}
+	}
+
+	var pr profileResponse
+	if err := json.Unmarshal(res, &pr); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal agent profile err: %w", err)
+	}
+
+	if err := verifyProfile(c.hashkey, pr.Config, pr.Hash); err != nil {
+		return nil, err
+	}
+
+	return &Profile{Name: pr.Name, Config: pr.Config}, nil
+}
+
 // PushResult - Consolidates the result of sending the metric and the error.
 type PushResult struct {
 	Metric *metrics.Metrics
@@ -238,7 +413,8 @@ type PushResult struct {
 // BatchUpdateMetric - Sends updated metrics received from the channel `mcs` to the server.
 func (c *Client) BatchUpdateMetric(ctx context.Context, mcs <-chan []*metrics.Metrics, result chan<- error) {
 	for m := range mcs {
-		if err := c.batchUpdate(ctx, m); err != nil {
+		errs, _ := c.batchUpdate(ctx, m)
+		for _, err := range errs {
 			result <- err
 		}
 
@@ -250,6 +426,11 @@ func (c *Client) BatchUpdateMetric(ctx context.Context, mcs <-chan []*metrics.Me
 	}
 }
 
+// OnResync - sets the handler that is called when the server asks to resend all values.
+func (c *Client) OnResync(fn func()) {
+	c.dests.setResyncHandler(fn)
+}
+
 func hashBytesToString(h hash.Hash, bytes []byte) string {
 	return fmt.Sprintf("%x", h.Sum(bytes))
 }
@@ -10,21 +10,26 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"strings"
 
 	"github.com/ArtemShalinFe/metcoll/internal/compress"
 	"github.com/ArtemShalinFe/metcoll/internal/configuration"
 	"github.com/ArtemShalinFe/metcoll/internal/crypto"
 	"github.com/ArtemShalinFe/metcoll/internal/logger"
+	"github.com/ArtemShalinFe/metcoll/internal/tlsconfig"
 	"go.uber.org/zap"
 )
 
 type HTTPServer struct {
-	httpServer    *http.Server
-	log           *zap.SugaredLogger
-	trustedSubnet *net.IPNet
-	privateKey    []byte
-	hashkey       []byte
+	httpServer *http.Server
+	log        *zap.SugaredLogger
+	ipResolver *ipResolver
+	auth       *tokenAuth
+	tenants    *tenantStore
+	inventory  *inventory
+	agentKeys  *agentKeyStore
+	keyring    *crypto.Keyring
+	instanceID string
+	hashkey    []byte
 }
 
 // NewHTTPServer - Object Constructor.
@@ -34,13 +39,12 @@ func NewHTTPServer(
 	cfg *configuration.Config,
 	sl *zap.SugaredLogger,
 ) (*HTTPServer, error) {
-	var privateKey []byte
-	if cfg.PrivateCryptoKey != "" {
-		privateCryptoKey, err := crypto.GetKeyBytes(cfg.PrivateCryptoKey)
-		if err != nil {
-			return nil, fmt.Errorf("an occured error when server getting key bytes, err: %w", err)
-		}
-		privateKey = privateCryptoKey
+	keyring, err := crypto.NewKeyring(cfg.PrivateCryptoKey, cfg.CryptoKeyDir)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when server loading the keyring, err: %w", err)
+	}
+	if keyring != nil {
+		sl.Infof("loaded crypto keys: %v", keyring.IDs())
 	}
 
 	l, err := logger.NewMiddlewareLogger(sl)
@@ -52,28 +56,82 @@ func NewHTTPServer(
 		Addr: cfg.Address,
 	}
 
+	if cfg.TLSEnabled() {
+		s.TLSConfig, err = tlsconfig.NewServer(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA, cfg.TLSCRL, sl)
+		if err != nil {
+			return nil, fmt.Errorf("an occured error when server loading the TLS configuration, err: %w", err)
+		}
+	}
+
+	instanceID, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles, err := newProfileStore(cfg.AgentProfiles, cfg.Key, sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load agent profiles err: %w", err)
+	}
+	agentKeys, err := newAgentKeyStore(cfg.AgentKeys, sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load agent keys err: %w", err)
+	}
+
+	ipResolver, err := newIPResolver(cfg.TrustedSubnet, cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newTokenAuth(stg, cfg.AdminToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := newTenantStore(cfg.Tenants, cfg.Key, keyring, sl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load tenants err: %w", err)
+	}
+
+	handler := NewHandler(stg, sl)
+	handler.profiles = profiles
+	handler.tenants = tenants
+	handler.inventory = newInventory(cfg.StaleReports)
+	if auth != nil {
+		handler.tokens = auth.tokens
+	}
+
 	srv := &HTTPServer{
-		&s,
-		sl,
-		parseTrustedSubnet(cfg.TrustedSubnet),
-		privateKey,
-		cfg.Key,
+		httpServer: &s,
+		log:        sl,
+		ipResolver: ipResolver,
+		auth:       auth,
+		tenants:    tenants,
+		inventory:  handler.inventory,
+		agentKeys:  agentKeys,
+		keyring:    keyring,
+		instanceID: instanceID,
+		hashkey:    cfg.Key,
 	}
 
 	srv.httpServer.Handler = NewRouter(ctx,
-		NewHandler(stg, sl),
+		handler,
+		srv.instanceSetter,
 		srv.resolverIP,
 		l.RequestLogger,
+		srv.tokenChecker,
+		srv.tenantSetter,
 		srv.requestHashChecker,
-		srv.responseHashSetter,
+		srv.signatureChecker,
+		srv.agentRecorder,
 		compress.CompressMiddleware,
+		srv.responseHashSetter,
 		srv.cryptoDecrypter)
 
 	return srv, nil
 }
 
 func (s *HTTPServer) ListenAndServe() error {
-	if err := s.httpServer.ListenAndServe(); err != nil {
+	if err := s.listenAndServe(); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("http server listen and serve err: %w", err)
 		}
@@ -81,6 +139,16 @@ func (s *HTTPServer) ListenAndServe() error {
 	return nil
 }
 
+// listenAndServe - serves over TLS if the TLS configuration is set,
+// the certificate is taken from the configuration instead of the files.
+func (s *HTTPServer) listenAndServe() error {
+	if s.httpServer.TLSConfig != nil {
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
+
+	return s.httpServer.ListenAndServe()
+}
+
 func (s *HTTPServer) Shutdown(ctx context.Context) error {
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		return fmt.Errorf("http server shutdown err: %w", err)
@@ -88,83 +156,115 @@ func (s *HTTPServer) Shutdown(ctx context.Context) error {
 	return nil
 }
 
-// IPResolver - middleware checks header X-Real-IP in the incoming request.
+// ReloadKeys - reads the private keys of the keyring and of the tenants again.
+func (s *HTTPServer) ReloadKeys() error {
+	if s.keyring != nil {
+		if err := s.keyring.Reload(); err != nil {
+			return fmt.Errorf("http server reload keys err: %w", err)
+		}
+		s.log.Infof("reloaded crypto keys: %v", s.keyring.IDs())
+	}
+
+	if s.tenants != nil {
+		if err := s.tenants.reloadKeys(s.log); err != nil {
+			return fmt.Errorf("http server reload keys err: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// InstanceSetter - middleware sets the ID of the server instance in the server response.
+func (s *HTTPServer) instanceSetter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(instanceHeader, s.instanceID)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// resolverIP - middleware resolves the real address of the agent and rejects the agents out of the trusted subnets.
+// The X-Real-IP header is replaced with the resolved address, so the agent is not able to forge it.
 func (s *HTTPServer) resolverIP(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.trustedSubnet == nil {
-			h.ServeHTTP(w, r)
-			return
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
 		}
 
-		ipStr := strings.TrimSpace(r.Header.Get("X-Real-IP"))
-		if strings.TrimSpace(ipStr) == "" {
+		ip, err := s.ipResolver.resolve(host, r.Header.Values(forwardedFor), r.Header.Get(realIP))
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
-			s.log.Info("header X-Real-IP is empty")
+			s.log.Infof("cannot resolve the address of the agent %s, err: %v", r.RemoteAddr, err)
 			return
 		}
 
-		ip := net.ParseIP(ipStr)
-		if ip == nil {
-			w.WriteHeader(http.StatusBadRequest)
-			s.log.Info("failed parse ip from http header")
+		if !s.ipResolver.allowed(ip) {
+			w.WriteHeader(http.StatusForbidden)
+			s.log.Infof("trusted subnets do not contain the address %s of the agent", ip)
 			return
 		}
 
-		if !s.trustedSubnet.Contains(ip) {
-			w.WriteHeader(http.StatusForbidden)
-			s.log.Info("trusted network does not contain the ip address value from the 'X-Real-IP' header")
-			return
+		r.Header.Del(realIP)
+		if ip != nil {
+			r.Header.Set(realIP, ip.String())
 		}
+
+		h.ServeHTTP(w, r)
 	})
 }
 
-// CryptoDecrypter - middleware decrypt the incoming request.
+// CryptoDecrypter - middleware decrypt the incoming request with the keys of the tenant of the request.
 func (s *HTTPServer) cryptoDecrypter(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(s.privateKey) == 0 {
+		keyring := tenantKeyring(r.Context(), s.keyring)
+		if keyring == nil {
 			h.ServeHTTP(w, r)
 			return
 		}
 
-		var cryptBuf bytes.Buffer
-		tee := io.TeeReader(r.Body, &cryptBuf)
-		body, err := io.ReadAll(tee)
+		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			s.log.Errorf("an occured error when reading body err: %w", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		decrypted, err := crypto.Decrypt(s.privateKey, body)
-		if err != nil {
-			s.log.Errorf("an occured error when decrypt body err: %w", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		// the requests without body, for example the metric list, are not encrypted.
+		if len(body) == 0 {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			h.ServeHTTP(w, r)
 			return
 		}
 
-		var decryptBuf bytes.Buffer
-		_, err = decryptBuf.Write(decrypted)
+		// both the envelope payloads and the RSA only payloads of the previous agents are accepted.
+		decrypted, err := keyring.Decrypt(body)
 		if err != nil {
-			s.log.Errorf("an occured error when write decrypted body err: %w", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			s.log.Infof("an occured error when decrypt body err: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		r.Body = io.NopCloser(&decryptBuf)
+		r.Body = io.NopCloser(bytes.NewReader(decrypted))
+		h.ServeHTTP(w, r)
 	})
 }
 
-// RequestHashChecker - middleware checks the hash in the incoming request.
+// RequestHashChecker - middleware checks the hash in the incoming request with the key of the tenant of the request.
 func (s *HTTPServer) requestHashChecker(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(s.hashkey) == 0 {
+		hashkey := tenantHashKey(r.Context(), s.hashkey)
+		if len(hashkey) == 0 {
 			h.ServeHTTP(w, r)
 			return
 		}
 
-		// for ya-autotests.
+		// for ya-autotests, the agent requests of the tenant with its own key must be hashed as in gRPC.
 		bodyHash := r.Header.Get(HashSHA256)
 		if bodyHash == "" {
+			if isSigned(r) && tenantOwnsHashKey(r.Context()) {
+				http.Error(w, fmt.Sprintf("'%s' header is required", HashSHA256), http.StatusBadRequest)
+				return
+			}
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -178,12 +278,10 @@ func (s *HTTPServer) requestHashChecker(h http.Handler) http.Handler {
 		}
 		r.Body = io.NopCloser(&buf)
 
-		hash := hmac.New(sha256.New, s.hashkey)
+		hash := hmac.New(sha256.New, hashkey)
 		hash.Write(body)
 
-		sign := hash.Sum(nil)
-
-		if hashBytesToString(hash, sign) == bodyHash {
+		if hashBytesToString(hash, nil) == bodyHash {
 			h.ServeHTTP(w, r)
 		} else {
 			http.Error(w, "incorrect hash", http.StatusBadRequest)
@@ -192,40 +290,56 @@ func (s *HTTPServer) requestHashChecker(h http.Handler) http.Handler {
 	})
 }
 
-// ResponceHashSetter - middleware sets the hash in the server response.
+// ResponceHashSetter - middleware sets the hash of the response body in the server response.
+// The hash is calculated over the body before compression, as the agent reads it.
 func (s *HTTPServer) responseHashSetter(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if len(s.hashkey) == 0 {
+		hashkey := tenantHashKey(r.Context(), s.hashkey)
+		if len(hashkey) == 0 {
 			h.ServeHTTP(w, r)
 			return
 		}
 
-		hsw := newResponseHashSetter(w, s.hashkey)
+		hsw := newResponseHashSetter(w, hashkey)
 
 		h.ServeHTTP(hsw, r)
+
+		if err := hsw.flush(); err != nil {
+			s.log.Errorf("an error occurred while writing the response, err: %v", err)
+		}
 	})
 }
 
+// ResponseHashWriter - buffers the response until the handler is completed,
+// because the hash header has to be sent before the body.
 type ResponseHashWriter struct {
 	http.ResponseWriter
+	body    *bytes.Buffer
 	hashkey []byte
+	status  int
 }
 
 // NewResponseHashSetter - Object Constructor.
 func newResponseHashSetter(w http.ResponseWriter, hashkey []byte) *ResponseHashWriter {
 	return &ResponseHashWriter{
 		ResponseWriter: w,
+		body:           &bytes.Buffer{},
 		hashkey:        hashkey,
 	}
 }
 
-func (r *ResponseHashWriter) Write(b []byte) (int, error) {
-	hash := hmac.New(sha256.New, r.hashkey)
-	hash.Write(b)
+func (r *ResponseHashWriter) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
 
-	r.ResponseWriter.Header().Set(HashSHA256, hashBytesToString(hash, nil))
+func (r *ResponseHashWriter) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
 
-	n, err := r.ResponseWriter.Write(b)
+	n, err := r.body.Write(b)
 	if err != nil {
 		return 0, fmt.Errorf("response write was faild, err: %w", err)
 	}
@@ -233,23 +347,23 @@ func (r *ResponseHashWriter) Write(b []byte) (int, error) {
 	return n, nil
 }
 
-func parseTrustedSubnet(trustedSubnet string) *net.IPNet {
-	if trustedSubnet == "" {
-		return nil
-	}
+// flush - sets the hash of the buffered body and sends the response.
+func (r *ResponseHashWriter) flush() error {
+	hash := hmac.New(sha256.New, r.hashkey)
+	hash.Write(r.body.Bytes())
 
-	ip := net.ParseIP(trustedSubnet)
-	if ip == nil {
-		return nil
+	r.ResponseWriter.Header().Set(HashSHA256, hashBytesToString(hash, nil))
+
+	if r.status != 0 {
+		r.ResponseWriter.WriteHeader(r.status)
 	}
-	mask := ip.DefaultMask()
-	if mask == nil {
+	if r.body.Len() == 0 {
 		return nil
 	}
-	tn := &net.IPNet{
-		IP:   ip,
-		Mask: mask,
+
+	if _, err := r.ResponseWriter.Write(r.body.Bytes()); err != nil {
+		return fmt.Errorf("response write was faild, err: %w", err)
 	}
 
-	return tn
+	return nil
 }
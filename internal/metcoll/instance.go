@@ -0,0 +1,21 @@
+package metcoll
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// instanceHeader - header with the ID of the server instance.
+// The ID changes after every restart of the server, so the agents can resend the values it might have lost.
+const instanceHeader = "X-Metcoll-Instance"
+
+func newInstanceID() (string, error) {
+	const idLen = 8
+	b := make([]byte, idLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate server instance ID, err: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,322 @@
+package metcoll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+const (
+	// agentIDHeader - header with the ID of the agent.
+	agentIDHeader = "X-Metcoll-Agent"
+	// agentVersionHeader - header with the build version of the agent.
+	agentVersionHeader = "X-Metcoll-Agent-Version"
+	// reportIntervalHeader - header with the report interval of the agent in seconds.
+	reportIntervalHeader = "X-Metcoll-Report-Interval"
+
+	// defaultReportInterval - the report interval of the agents that do not send it.
+	defaultReportInterval = 10 * time.Second
+	// defaultStaleReports - count of the missed reports after which the agent is stale.
+	defaultStaleReports = 3
+	// agentRetention - the agents that have not reported for this period are removed from the inventory.
+	agentRetention = 24 * time.Hour
+	// maxAgents - the limit of the agents in the inventory of all tenants,
+	// the agent that has not reported for the longest time is removed to make room for the new one.
+	maxAgents = 10000
+)
+
+// agentInfo - the agent that reports the metrics to the server.
+type agentInfo struct {
+	FirstSeen      time.Time     `json:"first_seen"`
+	LastSeen       time.Time     `json:"last_seen"`
+	ID             string        `json:"id"`
+	IP             string        `json:"ip"`
+	Version        string        `json:"version"`
+	ReportInterval time.Duration `json:"-"`
+	Reports        int64         `json:"reports"`
+	Stale          bool          `json:"stale"`
+}
+
+// inventory - the agents that have reported to the server since its start.
+// The agents are kept by tenant, so the tenant sees only its own agents.
+// The agents that have not reported for the retention period are removed.
+type inventory struct {
+	pruneAt      time.Time
+	mux          *sync.Mutex
+	agents       map[string]map[string]*agentInfo
+	now          func() time.Time
+	retention    time.Duration
+	staleReports int
+	maxAgents    int
+	size         int
+}
+
+// newInventory - Object constructor.
+func newInventory(staleReports int) *inventory {
+	if staleReports <= 0 {
+		staleReports = defaultStaleReports
+	}
+
+	return &inventory{
+		mux:          &sync.Mutex{},
+		agents:       make(map[string]map[string]*agentInfo),
+		now:          time.Now,
+		retention:    agentRetention,
+		staleReports: staleReports,
+		maxAgents:    maxAgents,
+	}
+}
+
+// observe - records the report of the agent of the tenant. The agents without ID are identified by the IP address.
+func (inv *inventory) observe(tenant, id, ip, version, reportInterval string) {
+	if id == "" {
+		id = ip
+	}
+	if id == "" {
+		return
+	}
+
+	interval := defaultReportInterval
+	if s, err := strconv.Atoi(reportInterval); err == nil && s > 0 {
+		interval = time.Duration(s) * time.Second
+	}
+
+	inv.mux.Lock()
+	defer inv.mux.Unlock()
+
+	now := inv.now()
+	inv.prune(now)
+
+	a, ok := inv.agents[tenant][id]
+	if !ok {
+		if inv.size >= inv.maxAgents {
+			inv.evictOldest()
+		}
+
+		agents, ok := inv.agents[tenant]
+		if !ok {
+			agents = make(map[string]*agentInfo)
+			inv.agents[tenant] = agents
+		}
+
+		a = &agentInfo{ID: id, FirstSeen: now}
+		agents[id] = a
+		inv.size++
+	}
+
+	a.LastSeen = now
+	a.IP = ip
+	a.Version = version
+	a.ReportInterval = interval
+	a.Reports++
+}
+
+// list - returns the agents of the tenant sorted by ID. The agent is stale if it has missed several report intervals.
+func (inv *inventory) list(tenant string) []agentInfo {
+	inv.mux.Lock()
+	defer inv.mux.Unlock()
+
+	now := inv.now()
+	inv.prune(now)
+
+	agents := make([]agentInfo, 0, len(inv.agents[tenant]))
+	for _, a := range inv.agents[tenant] {
+		info := *a
+		info.Stale = now.Sub(a.LastSeen) > time.Duration(inv.staleReports)*a.ReportInterval
+		agents = append(agents, info)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
+
+	return agents
+}
+
+// prune - removes the agents that have not reported for the retention period.
+// The agents are checked once a minute, so the reports are not slowed down by the large inventory.
+func (inv *inventory) prune(now time.Time) {
+	if now.Before(inv.pruneAt) {
+		return
+	}
+
+	for tenant, agents := range inv.agents {
+		for id, a := range agents {
+			if now.Sub(a.LastSeen) > inv.retention {
+				delete(agents, id)
+				inv.size--
+			}
+		}
+		if len(agents) == 0 {
+			delete(inv.agents, tenant)
+		}
+	}
+
+	inv.pruneAt = now.Add(time.Minute)
+}
+
+// evictOldest - removes the agent that has not reported for the longest time.
+func (inv *inventory) evictOldest() {
+	var oldest *agentInfo
+	var tenant string
+	for t, agents := range inv.agents {
+		for _, a := range agents {
+			if oldest == nil || a.LastSeen.Before(oldest.LastSeen) {
+				oldest, tenant = a, t
+			}
+		}
+	}
+	if oldest == nil {
+		return
+	}
+
+	delete(inv.agents[tenant], oldest.ID)
+	if len(inv.agents[tenant]) == 0 {
+		delete(inv.agents, tenant)
+	}
+	inv.size--
+}
+
+// isReport - checks that the request updates the metrics.
+func isReport(path string) bool {
+	return strings.HasPrefix(path, "/update/") || path == updates
+}
+
+// agentRecorder - middleware records the agents whose reports of the metrics were accepted.
+func (s *HTTPServer) agentRecorder(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !isReport(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.status != 0 && (sw.status < http.StatusOK || sw.status >= http.StatusMultipleChoices) {
+			return
+		}
+
+		s.inventory.observe(
+			storage.TenantFromContext(r.Context()),
+			r.Header.Get(agentIDHeader),
+			strings.TrimSpace(r.Header.Get(realIP)),
+			r.Header.Get(agentVersionHeader),
+			r.Header.Get(reportIntervalHeader))
+	})
+}
+
+// agentRecorder - records the agents whose reports of the metrics were accepted.
+func (s *GRPCServer) agentRecorder() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch req.(type) {
+		case *BatchUpdateRequest, *UpdateRequest:
+			md, _ := metadata.FromIncomingContext(ctx)
+			first := func(key string) string {
+				if v := md.Get(key); len(v) > 0 {
+					return strings.TrimSpace(v[0])
+				}
+				return ""
+			}
+
+			s.ms.inventory.observe(storage.TenantFromContext(ctx),
+				first(agentIDHeader), first(realIP), first(agentVersionHeader), first(reportIntervalHeader))
+		}
+
+		return resp, nil
+	}
+}
+
+func templateAgentList() string {
+	return `
+	<html>
+	<head>
+		<title>Agent list</title>
+	</head>
+	<body>
+		<h1>Agent list</h1>
+		<table>
+			<tr><th>ID</th><th>IP</th><th>Version</th><th>First seen</th><th>Last seen</th><th>Reports</th><th>State</th></tr>
+			%s
+		</table>
+	</body>
+	</html>`
+}
+
+var at = `<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%d</td><td>%s</td></tr>`
+
+// AgentList - returns the html page with the agents of the tenant of the request.
+func (h *Handler) AgentList(ctx context.Context, w http.ResponseWriter) {
+	list := ""
+	for _, a := range h.inventory.list(storage.TenantFromContext(ctx)) {
+		state := "alive"
+		if a.Stale {
+			state = "stale"
+		}
+
+		list += fmt.Sprintf(at,
+			html.EscapeString(a.ID), html.EscapeString(a.IP), html.EscapeString(a.Version),
+			a.FirstSeen.Format(time.RFC3339), a.LastSeen.Format(time.RFC3339), a.Reports, state)
+	}
+
+	resp := []byte(fmt.Sprintf(templateAgentList(), list))
+
+	w.Header().Set(contentType, "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	h.writeResponseBody(w, resp)
+}
+
+// AgentListJSON - returns the agents of the tenant of the request in JSON.
+func (h *Handler) AgentListJSON(ctx context.Context, w http.ResponseWriter) {
+	b, err := json.Marshal(h.inventory.list(storage.TenantFromContext(ctx)))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while marshal agent list to json error: %w", err)
+		return
+	}
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	h.writeResponseBody(w, b)
+}
+
+// AgentList - returns the agents of the tenant of the request.
+func (ms *MetricService) AgentList(ctx context.Context, request *AgentListRequest) (*AgentListResponse, error) {
+	var response AgentListResponse
+
+	for _, a := range ms.inventory.list(storage.TenantFromContext(ctx)) {
+		response.Agents = append(response.Agents, &Agent{
+			Id:        a.ID,
+			Ip:        a.IP,
+			Version:   a.Version,
+			FirstSeen: timestamppb.New(a.FirstSeen),
+			LastSeen:  timestamppb.New(a.LastSeen),
+			Reports:   a.Reports,
+			Stale:     a.Stale,
+		})
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,252 @@
+package metcoll
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+func TestInventory(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	inv := newInventory(3)
+	inv.now = func() time.Time { return now }
+
+	inv.observe(storage.DefaultTenant, "web-01", "10.0.0.1", "1.0", "10")
+	inv.observe(storage.DefaultTenant, "", "10.0.0.2", "N/A", "")
+	now = now.Add(20 * time.Second)
+	inv.observe(storage.DefaultTenant, "web-01", "10.0.0.3", "1.1", "5")
+	inv.observe(storage.DefaultTenant, "", "", "1.0", "10")
+
+	now = now.Add(20 * time.Second)
+	agents := inv.list(storage.DefaultTenant)
+	require.Len(t, agents, 2)
+
+	assert.Equal(t, agentInfo{
+		ID:             "10.0.0.2",
+		IP:             "10.0.0.2",
+		Version:        "N/A",
+		FirstSeen:      now.Add(-40 * time.Second),
+		LastSeen:       now.Add(-40 * time.Second),
+		ReportInterval: defaultReportInterval,
+		Reports:        1,
+		Stale:          true,
+	}, agents[0])
+
+	assert.Equal(t, agentInfo{
+		ID:             "web-01",
+		IP:             "10.0.0.3",
+		Version:        "1.1",
+		FirstSeen:      now.Add(-40 * time.Second),
+		LastSeen:       now.Add(-20 * time.Second),
+		ReportInterval: 5 * time.Second,
+		Reports:        2,
+		Stale:          true,
+	}, agents[1])
+
+	inv.observe(storage.DefaultTenant, "web-01", "10.0.0.3", "1.1", "5")
+	assert.False(t, inv.list(storage.DefaultTenant)[1].Stale)
+
+	t.Run("agents of the tenants are separated", func(t *testing.T) {
+		inv.observe("team-a", "web-01", "10.1.0.1", "2.0", "10")
+
+		agents := inv.list("team-a")
+		require.Len(t, agents, 1)
+		assert.Equal(t, "10.1.0.1", agents[0].IP)
+		assert.Equal(t, int64(1), agents[0].Reports)
+
+		assert.Len(t, inv.list(storage.DefaultTenant), 2)
+		assert.Empty(t, inv.list("team-b"))
+	})
+}
+
+func TestInventory_eviction(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	inv := newInventory(3)
+	inv.now = func() time.Time { return now }
+	inv.retention = time.Hour
+	inv.maxAgents = 2
+
+	ids := func(agents []agentInfo) []string {
+		res := make([]string, 0, len(agents))
+		for _, a := range agents {
+			res = append(res, a.ID)
+		}
+		return res
+	}
+
+	inv.observe(storage.DefaultTenant, "web-01", "10.0.0.1", "1.0", "10")
+	inv.observe(storage.DefaultTenant, "web-02", "10.0.0.2", "1.0", "10")
+	now = now.Add(30 * time.Minute)
+	inv.observe(storage.DefaultTenant, "web-02", "10.0.0.2", "1.0", "10")
+
+	now = now.Add(45 * time.Minute)
+	assert.Equal(t, []string{"web-02"}, ids(inv.list(storage.DefaultTenant)),
+		"the agent that has not reported for the retention period is removed")
+
+	inv.observe(storage.DefaultTenant, "web-03", "10.0.0.3", "1.0", "10")
+	inv.observe("team-a", "web-04", "10.0.0.4", "1.0", "10")
+	assert.Equal(t, []string{"web-03"}, ids(inv.list(storage.DefaultTenant)),
+		"the agent that has not reported for the longest time is removed when the inventory is full")
+	assert.Equal(t, []string{"web-04"}, ids(inv.list("team-a")))
+	assert.Equal(t, 2, inv.size)
+}
+
+func testStorage(t *testing.T) Storage {
+	t.Helper()
+
+	stg := NewMockStorage(gomock.NewController(t))
+	stg.EXPECT().BatchSetFloat64Value(gomock.Any(), gomock.Any()).AnyTimes().
+		Return(map[string]float64{}, nil, nil)
+	stg.EXPECT().BatchAddInt64Value(gomock.Any(), gomock.Any()).AnyTimes().
+		Return(map[string]int64{}, nil, nil)
+
+	return stg
+}
+
+func TestHTTPServer_agentList(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewHTTPServer(ctx, testStorage(t), &configuration.Config{Key: hashKey}, zap.S())
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(s.httpServer.Handler)
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	c, err := NewHTTPClient(&configuration.ConfigAgent{
+		Server:         u.Host,
+		AgentID:        "web-01",
+		ReportInterval: 10,
+		Key:            hashKey,
+	}, zap.S())
+	require.NoError(t, err)
+	require.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("c", 1)}))
+	require.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("c", 1)}))
+
+	get := func(t *testing.T, path string) []byte {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return b
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var agents []agentInfo
+		require.NoError(t, json.Unmarshal(get(t, "/api/agents"), &agents))
+		require.Len(t, agents, 1)
+		assert.Equal(t, "web-01", agents[0].ID)
+		assert.Equal(t, c.clientIP, agents[0].IP)
+		assert.Equal(t, int64(2), agents[0].Reports)
+		assert.False(t, agents[0].Stale)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		assert.Contains(t, string(get(t, "/agents")), "<td>web-01</td>")
+	})
+
+	t.Run("rejected report is not recorded", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+updates, strings.NewReader("[{"))
+		require.NoError(t, err)
+		req.Header.Set(agentIDHeader, "web-02")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var agents []agentInfo
+		require.NoError(t, json.Unmarshal(get(t, "/api/agents"), &agents))
+		require.Len(t, agents, 1)
+		assert.Equal(t, "web-01", agents[0].ID)
+	})
+}
+
+func TestGRPCServer_agentRecorder(t *testing.T) {
+	s, err := NewGRPCServer(testStorage(t), &configuration.Config{}, zap.S())
+	require.NoError(t, err)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(agentIDHeader, "web-01"))
+	record := s.agentRecorder()
+
+	_, err = record(ctx, &BatchUpdateRequest{}, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.InvalidArgument, "metrics are incorrect")
+		})
+	require.Error(t, err)
+	assert.Empty(t, s.ms.inventory.list(storage.DefaultTenant), "rejected report is not recorded")
+
+	_, err = record(ctx, &BatchUpdateRequest{}, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &BatchUpdateResponse{}, nil
+		})
+	require.NoError(t, err)
+	agents := s.ms.inventory.list(storage.DefaultTenant)
+	require.Len(t, agents, 1)
+	assert.Equal(t, "web-01", agents[0].ID)
+}
+
+func TestMetricService_AgentList(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewGRPCServer(testStorage(t), &configuration.Config{Key: hashKey}, zap.S())
+	require.NoError(t, err)
+	RegisterMetcollServer(s, s.ms)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("server exited with error: %v", err)
+		}
+	}()
+	defer s.grpcServer.Stop()
+
+	c, err := NewGRPCClient(ctx, &configuration.ConfigAgent{AgentID: "web-01", Key: hashKey}, zap.S())
+	require.NoError(t, err)
+
+	opts := append(c.getDialOpts(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer((&dialer{lis: lis}).bufDialer))
+	conn, err := grpc.DialContext(ctx, "", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+	c.conns[""] = conn
+
+	require.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)}))
+
+	mctx := metadata.NewOutgoingContext(ctx, metadata.New(headersForRequest(t, nil)))
+	resp, err := NewMetcollClient(conn).AgentList(mctx, &AgentListRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetAgents(), 1)
+	assert.Equal(t, "web-01", resp.GetAgents()[0].GetId())
+	assert.Equal(t, int64(1), resp.GetAgents()[0].GetReports())
+	assert.False(t, resp.GetAgents()[0].GetStale())
+}
@@ -2,16 +2,42 @@ package metcoll
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 
 	"go.uber.org/zap"
 
 	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
+	"github.com/ArtemShalinFe/metcoll/internal/identity"
 	"github.com/ArtemShalinFe/metcoll/internal/metrics"
 )
 
+// ErrResponseHash - error occurs when the hash of the server response does not match the response,
+// the response may have been changed on the way from the server.
+var ErrResponseHash = errors.New("response hash is incorrect")
+
 type MetricUpdater interface {
 	BatchUpdateMetric(ctx context.Context, mcs <-chan []*metrics.Metrics, result chan<- error)
+	BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error
+	OnResync(fn func())
+}
+
+// ProfileFetcher - fetches the configuration profile of the agent from the servers.
+type ProfileFetcher interface {
+	// AgentProfile - returns the profile verified with the hash key
+	// or ErrProfileNotFound if the servers have no profile for the agent.
+	AgentProfile(ctx context.Context, agentID string, labels []string) (*Profile, error)
+}
+
+// WireWriter - writes the request with the batch of metrics in the format it is sent to the servers.
+type WireWriter interface {
+	// WriteWire - writes the request without sending it.
+	WriteWire(ctx context.Context, w io.Writer, mcs []*metrics.Metrics) error
 }
 
 func InitClient(ctx context.Context, cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (MetricUpdater, error) {
@@ -34,3 +60,74 @@ func InitClient(ctx context.Context, cfg *configuration.ConfigAgent, sl *zap.Sug
 		return httpClient, nil
 	}
 }
+
+// agentIP - returns the address reported to the servers in the X-Real-IP header.
+func agentIP(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) string {
+	addr := identity.Resolve(cfg)
+	for _, w := range addr.Warnings {
+		sl.Warnf("agent address source was skipped, %v", w)
+	}
+
+	if addr.IP == nil {
+		sl.Warn("agent address was not found, the requests are sent without it")
+		return ""
+	}
+	sl.Infof("agent address %s was found by %s", addr, addr.Source)
+
+	return addr.String()
+}
+
+// loadPublicKey - returns the public key of the servers the payloads are encrypted with,
+// nil is returned if the key is not set.
+func loadPublicKey(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) ([]byte, error) {
+	if cfg.PublicCryptoKey == "" {
+		return nil, nil
+	}
+
+	key, err := crypto.GetKeyBytes(cfg.PublicCryptoKey)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when agent getting key bytes, err: %w", err)
+	}
+
+	kid, err := crypto.KeyID(key)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when agent parsing the public key, err: %w", err)
+	}
+	sl.Infof("payloads are encrypted with the key %s", kid)
+
+	return key, nil
+}
+
+// loadSigningKey - returns the key the agent signs the requests with, nil is returned if the key is not set.
+// The public key is logged in the form in which it is registered on the servers.
+func loadSigningKey(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (ed25519.PrivateKey, error) {
+	if cfg.SigningKey == "" {
+		return nil, nil
+	}
+
+	key, err := crypto.LoadSigningKey(cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("an occured error when agent loading the signing key, err: %w", err)
+	}
+
+	pub, ok := key.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key public part type %T is not supported", key.Public())
+	}
+	sl.Infof("requests of agent %s are signed, public key: %s", cfg.ID(), crypto.EncodeVerifyKey(pub))
+
+	return key, nil
+}
+
+// verifyResponseHash - checks the hash of the server response with the hash key.
+// A response without the hash is rejected too, since the hash could be removed on the way.
+func verifyResponseHash(hashkey []byte, b []byte, hash string) error {
+	h := hmac.New(sha256.New, hashkey)
+	h.Write(b)
+
+	if !hmac.Equal([]byte(hashBytesToString(h, nil)), []byte(hash)) {
+		return ErrResponseHash
+	}
+
+	return nil
+}
@@ -1,11 +1,26 @@
 package metcoll
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 
-	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
 )
 
 func TestInitClient(t *testing.T) {
@@ -50,3 +65,75 @@ func TestInitClient(t *testing.T) {
 		})
 	}
 }
+
+func TestWireWriter(t *testing.T) {
+	ctx := context.Background()
+	mcs := []*metrics.Metrics{metrics.NewGaugeMetric("g", 1.5), metrics.NewCounterMetric("c", 2)}
+	cfg := &configuration.ConfigAgent{Servers: []string{"metcoll-1:8080", "metcoll-2:8080"}, Key: hashKey}
+
+	t.Run("http", func(t *testing.T) {
+		c, err := NewHTTPClient(cfg, zap.S())
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, c.WriteWire(ctx, &buf, mcs))
+
+		req, err := http.ReadRequest(bufio.NewReader(&buf))
+		require.NoError(t, err)
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "metcoll-1:8080", req.Host)
+		assert.Equal(t, updates, req.URL.Path)
+		assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		h := hmac.New(sha256.New, hashKey)
+		h.Write(body)
+		assert.Equal(t, hashBytesToString(h, nil), req.Header.Get(HashSHA256))
+
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		var got []*metrics.Metrics
+		require.NoError(t, json.NewDecoder(zr).Decode(&got))
+		assert.Equal(t, mcs, got)
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		c, err := NewGRPCClient(ctx, cfg, zap.S())
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, c.WriteWire(ctx, &buf, mcs))
+
+		r := bufio.NewReader(&buf)
+		md := make(map[string]string)
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			k, v, _ := strings.Cut(line, ": ")
+			md[k] = v
+		}
+		assert.Equal(t, Metcoll_Updates_FullMethodName, md[":path"])
+		assert.Equal(t, "metcoll-1:8080", md[":authority"])
+		assert.Equal(t, "gzip", md["grpc-encoding"])
+		assert.NotEmpty(t, md[strings.ToLower(HashSHA256)])
+
+		prefix := make([]byte, 5)
+		_, err = io.ReadFull(r, prefix)
+		require.NoError(t, err)
+		assert.Equal(t, byte(1), prefix[0])
+
+		zr, err := gzip.NewReader(io.LimitReader(r, int64(binary.BigEndian.Uint32(prefix[1:]))))
+		require.NoError(t, err)
+		msg, err := io.ReadAll(zr)
+		require.NoError(t, err)
+
+		var request BatchUpdateRequest
+		require.NoError(t, proto.Unmarshal(msg, &request))
+		assert.Len(t, request.GetMetrics(), 2)
+	})
+}
@@ -19,10 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Metcoll_MetricList_FullMethodName = "/metcoll.Metcoll/MetricList"
-	Metcoll_ReadMetric_FullMethodName = "/metcoll.Metcoll/ReadMetric"
-	Metcoll_Updates_FullMethodName    = "/metcoll.Metcoll/Updates"
-	Metcoll_Update_FullMethodName     = "/metcoll.Metcoll/Update"
+	Metcoll_MetricList_FullMethodName   = "/metcoll.Metcoll/MetricList"
+	Metcoll_ReadMetric_FullMethodName   = "/metcoll.Metcoll/ReadMetric"
+	Metcoll_Updates_FullMethodName      = "/metcoll.Metcoll/Updates"
+	Metcoll_Update_FullMethodName       = "/metcoll.Metcoll/Update"
+	Metcoll_AgentProfile_FullMethodName = "/metcoll.Metcoll/AgentProfile"
+	Metcoll_AgentList_FullMethodName    = "/metcoll.Metcoll/AgentList"
 )
 
 // MetcollClient is the client API for Metcoll service.
@@ -33,6 +35,8 @@ type MetcollClient interface {
 	ReadMetric(ctx context.Context, in *ReadMetricRequest, opts ...grpc.CallOption) (*ReadMetricResponse, error)
 	Updates(ctx context.Context, in *BatchUpdateRequest, opts ...grpc.CallOption) (*BatchUpdateResponse, error)
 	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	AgentProfile(ctx context.Context, in *AgentProfileRequest, opts ...grpc.CallOption) (*AgentProfileResponse, error)
+	AgentList(ctx context.Context, in *AgentListRequest, opts ...grpc.CallOption) (*AgentListResponse, error)
 }
 
 type metcollClient struct {
@@ -79,6 +83,24 @@ func (c *metcollClient) Update(ctx context.Context, in *UpdateRequest, opts ...g
 	return out, nil
 }
 
+func (c *metcollClient) AgentProfile(ctx context.Context, in *AgentProfileRequest, opts ...grpc.CallOption) (*AgentProfileResponse, error) {
+	out := new(AgentProfileResponse)
+	err := c.cc.Invoke(ctx, Metcoll_AgentProfile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metcollClient) AgentList(ctx context.Context, in *AgentListRequest, opts ...grpc.CallOption) (*AgentListResponse, error) {
+	out := new(AgentListResponse)
+	err := c.cc.Invoke(ctx, Metcoll_AgentList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetcollServer is the server API for Metcoll service.
 // All implementations must embed UnimplementedMetcollServer
 // for forward compatibility
@@ -87,6 +109,8 @@ type MetcollServer interface {
 	ReadMetric(context.Context, *ReadMetricRequest) (*ReadMetricResponse, error)
 	Updates(context.Context, *BatchUpdateRequest) (*BatchUpdateResponse, error)
 	Update(context.Context, *UpdateRequest) (*UpdateResponse, error)
+	AgentProfile(context.Context, *AgentProfileRequest) (*AgentProfileResponse, error)
+	AgentList(context.Context, *AgentListRequest) (*AgentListResponse, error)
 	mustEmbedUnimplementedMetcollServer()
 }
 
@@ -106,6 +130,12 @@ func (UnimplementedMetcollServer) Updates(context.Context, *BatchUpdateRequest)
 func (UnimplementedMetcollServer) Update(context.Context, *UpdateRequest) (*UpdateResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
 }
+func (UnimplementedMetcollServer) AgentProfile(context.Context, *AgentProfileRequest) (*AgentProfileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AgentProfile not implemented")
+}
+func (UnimplementedMetcollServer) AgentList(context.Context, *AgentListRequest) (*AgentListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AgentList not implemented")
+}
 func (UnimplementedMetcollServer) mustEmbedUnimplementedMetcollServer() {}
 
 // UnsafeMetcollServer may be embedded to opt out of forward compatibility for this service.
@@ -191,6 +221,42 @@ func _Metcoll_Update_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metcoll_AgentProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetcollServer).AgentProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metcoll_AgentProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetcollServer).AgentProfile(ctx, req.(*AgentProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metcoll_AgentList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetcollServer).AgentList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metcoll_AgentList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetcollServer).AgentList(ctx, req.(*AgentListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metcoll_ServiceDesc is the grpc.ServiceDesc for Metcoll service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -214,6 +280,14 @@ var Metcoll_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Update",
 			Handler:    _Metcoll_Update_Handler,
 		},
+		{
+			MethodName: "AgentProfile",
+			Handler:    _Metcoll_AgentProfile_Handler,
+		},
+		{
+			MethodName: "AgentList",
+			Handler:    _Metcoll_AgentList_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "metcoll.proto",
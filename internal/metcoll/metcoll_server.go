@@ -11,6 +11,8 @@ import (
 type MetricServer interface {
 	ListenAndServe() error
 	Shutdown(ctx context.Context) error
+	// ReloadKeys - reads the private keys of the keyring again, so the keys are rotated without the restart.
+	ReloadKeys() error
 }
 
 func InitServer(
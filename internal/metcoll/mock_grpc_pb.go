@@ -35,6 +35,46 @@ func (m *MockMetcollClient) EXPECT() *MockMetcollClientMockRecorder {
 	return m.recorder
 }
 
+// AgentList mocks base method.
+func (m *MockMetcollClient) AgentList(ctx context.Context, in *AgentListRequest, opts ...grpc.CallOption) (*AgentListResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AgentList", varargs...)
+	ret0, _ := ret[0].(*AgentListResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AgentList indicates an expected call of AgentList.
+func (mr *MockMetcollClientMockRecorder) AgentList(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AgentList", reflect.TypeOf((*MockMetcollClient)(nil).AgentList), varargs...)
+}
+
+// AgentProfile mocks base method.
+func (m *MockMetcollClient) AgentProfile(ctx context.Context, in *AgentProfileRequest, opts ...grpc.CallOption) (*AgentProfileResponse, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, in}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AgentProfile", varargs...)
+	ret0, _ := ret[0].(*AgentProfileResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AgentProfile indicates an expected call of AgentProfile.
+func (mr *MockMetcollClientMockRecorder) AgentProfile(ctx, in interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, in}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AgentProfile", reflect.TypeOf((*MockMetcollClient)(nil).AgentProfile), varargs...)
+}
+
 // MetricList mocks base method.
 func (m *MockMetcollClient) MetricList(ctx context.Context, in *MetricListRequest, opts ...grpc.CallOption) (*MetricListResponse, error) {
 	m.ctrl.T.Helper()
@@ -138,6 +178,36 @@ func (m *MockMetcollServer) EXPECT() *MockMetcollServerMockRecorder {
 	return m.recorder
 }
 
+// AgentList mocks base method.
+func (m *MockMetcollServer) AgentList(arg0 context.Context, arg1 *AgentListRequest) (*AgentListResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AgentList", arg0, arg1)
+	ret0, _ := ret[0].(*AgentListResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AgentList indicates an expected call of AgentList.
+func (mr *MockMetcollServerMockRecorder) AgentList(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AgentList", reflect.TypeOf((*MockMetcollServer)(nil).AgentList), arg0, arg1)
+}
+
+// AgentProfile mocks base method.
+func (m *MockMetcollServer) AgentProfile(arg0 context.Context, arg1 *AgentProfileRequest) (*AgentProfileResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AgentProfile", arg0, arg1)
+	ret0, _ := ret[0].(*AgentProfileResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AgentProfile indicates an expected call of AgentProfile.
+func (mr *MockMetcollServerMockRecorder) AgentProfile(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AgentProfile", reflect.TypeOf((*MockMetcollServer)(nil).AgentProfile), arg0, arg1)
+}
+
 // MetricList mocks base method.
 func (m *MockMetcollServer) MetricList(arg0 context.Context, arg1 *MetricListRequest) (*MetricListResponse, error) {
 	m.ctrl.T.Helper()
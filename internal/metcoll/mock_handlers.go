@@ -126,6 +126,21 @@ func (mr *MockStorageMockRecorder) GetInt64Value(ctx, key interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInt64Value", reflect.TypeOf((*MockStorage)(nil).GetInt64Value), ctx, key)
 }
 
+// MetricCount mocks base method.
+func (m *MockStorage) MetricCount(ctx context.Context, counters, gauges []string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MetricCount", ctx, counters, gauges)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MetricCount indicates an expected call of MetricCount.
+func (mr *MockStorageMockRecorder) MetricCount(ctx, counters, gauges interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MetricCount", reflect.TypeOf((*MockStorage)(nil).MetricCount), ctx, counters, gauges)
+}
+
 // Ping mocks base method.
 func (m *MockStorage) Ping(ctx context.Context) error {
 	m.ctrl.T.Helper()
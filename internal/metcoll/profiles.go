@@ -0,0 +1,271 @@
+package metcoll
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+const profile = "/profile/"
+
+// ErrProfileNotFound - there is no configuration profile for the agent.
+var ErrProfileNotFound = errors.New("agent profile not found")
+
+// agentProfile - the configuration profile of the agents.
+//
+// The profile is selected for the agent by its ID, then by its labels.
+// The profile without agents and labels is selected for all other agents.
+type agentProfile struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+	Agents []string        `json:"agents"`
+	Labels []string        `json:"labels"`
+}
+
+// profileRequest - the request of the configuration profile for the agent.
+type profileRequest struct {
+	AgentID string   `json:"agent_id"`
+	Labels  []string `json:"labels"`
+}
+
+// profileResponse - the configuration profile of the agent with the HMAC of the config.
+type profileResponse struct {
+	Name   string          `json:"name"`
+	Hash   string          `json:"hash"`
+	Config json.RawMessage `json:"config"`
+}
+
+// Profile - the configuration profile of the agent received from the server.
+type Profile struct {
+	Name   string
+	Config []byte
+}
+
+// profileStore - the configuration profiles of the agents loaded from the file.
+// The file is read again when it changes.
+type profileStore struct {
+	mux      *sync.Mutex
+	sl       *zap.SugaredLogger
+	path     string
+	version  string
+	profiles []agentProfile
+	hashkey  []byte
+}
+
+// newProfileStore - Object constructor. Returns nil if the path to the profiles is not set.
+func newProfileStore(path string, hashkey []byte, sl *zap.SugaredLogger) (*profileStore, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	ps := &profileStore{
+		mux:     &sync.Mutex{},
+		sl:      sl,
+		path:    path,
+		hashkey: hashkey,
+	}
+
+	if err := ps.load(); err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// load - reads the profiles from the file if it has changed since the last reading.
+func (ps *profileStore) load() error {
+	info, err := os.Stat(ps.path)
+	if err != nil {
+		return fmt.Errorf("cannot stat agent profiles file err: %w", err)
+	}
+
+	version := fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	if version == ps.version {
+		return nil
+	}
+
+	b, err := os.ReadFile(ps.path)
+	if err != nil {
+		return fmt.Errorf("cannot read agent profiles file err: %w", err)
+	}
+
+	var profiles []agentProfile
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return fmt.Errorf("cannot unmarshal agent profiles err: %w", err)
+	}
+
+	for i, p := range profiles {
+		if err := configuration.ValidateAgentProfile(p.Config); err != nil {
+			return fmt.Errorf("agent profile %s is incorrect, err: %w", p.Name, err)
+		}
+
+		// The config is sent in the same form as it is signed.
+		config, err := json.Marshal(p.Config)
+		if err != nil {
+			return fmt.Errorf("cannot marshal agent profile %s err: %w", p.Name, err)
+		}
+		profiles[i].Config = config
+	}
+
+	ps.profiles = profiles
+	ps.version = version
+	ps.sl.Infof("agent profiles were loaded from %s, profiles count: %d", ps.path, len(profiles))
+
+	return nil
+}
+
+// lookup - returns the profile for the agent and the HMAC of its config.
+// If the profiles file has changed but cannot be read, the previous profiles are used.
+// The config is signed with the hash key of the tenant of the request.
+func (ps *profileStore) lookup(ctx context.Context, agentID string, labels []string) (*agentProfile, string, error) {
+	if ps == nil {
+		return nil, "", ErrProfileNotFound
+	}
+
+	ps.mux.Lock()
+	defer ps.mux.Unlock()
+
+	if err := ps.load(); err != nil {
+		ps.sl.Errorf("agent profiles were not reloaded, err: %v", err)
+	}
+
+	p := ps.find(agentID, labels)
+	if p == nil {
+		return nil, "", ErrProfileNotFound
+	}
+
+	return p, sign(tenantHashKey(ctx, ps.hashkey), p.Config), nil
+}
+
+func (ps *profileStore) find(agentID string, labels []string) *agentProfile {
+	for i, p := range ps.profiles {
+		if contains(p.Agents, agentID) {
+			return &ps.profiles[i]
+		}
+	}
+
+	for i, p := range ps.profiles {
+		for _, l := range labels {
+			if contains(p.Labels, l) {
+				return &ps.profiles[i]
+			}
+		}
+	}
+
+	for i, p := range ps.profiles {
+		if len(p.Agents) == 0 && len(p.Labels) == 0 {
+			return &ps.profiles[i]
+		}
+	}
+
+	return nil
+}
+
+func sign(hashkey []byte, config []byte) string {
+	if len(hashkey) == 0 {
+		return ""
+	}
+
+	h := hmac.New(sha256.New, hashkey)
+	h.Write(config)
+	return hashBytesToString(h, nil)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyProfile - checks the HMAC of the profile config if the hash key is set.
+func verifyProfile(hashkey []byte, config []byte, hash string) error {
+	if len(hashkey) == 0 {
+		return nil
+	}
+
+	h := hmac.New(sha256.New, hashkey)
+	h.Write(config)
+
+	if !hmac.Equal([]byte(hashBytesToString(h, nil)), []byte(hash)) {
+		return errors.New("agent profile hash is incorrect")
+	}
+
+	return nil
+}
+
+// AgentProfile - returns the configuration profile for the agent from the request.
+func (h *Handler) AgentProfile(ctx context.Context, w http.ResponseWriter, body io.ReadCloser) {
+	var req profileRequest
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while reading body error: %w", err)
+		return
+	}
+
+	if err := json.Unmarshal(b, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		h.logger.Errorf("an error occurred while unmarshal body error: %w", err)
+		return
+	}
+
+	p, hash, err := h.profiles.lookup(ctx, req.AgentID, req.Labels)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	b, err = json.Marshal(&profileResponse{Name: p.Name, Config: p.Config, Hash: hash})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.logger.Errorf("an error occurred while marshal agent profile to json error: %w", err)
+		return
+	}
+
+	h.logger.Debugf("agent %s received the profile %s", req.AgentID, p.Name)
+
+	w.Header().Set(contentType, applicationJSON)
+	w.WriteHeader(http.StatusOK)
+	h.writeResponseBody(w, b)
+}
+
+// AgentProfile - returns the configuration profile for the agent from the request.
+func (ms *MetricService) AgentProfile(ctx context.Context,
+	request *AgentProfileRequest) (*AgentProfileResponse, error) {
+	var response AgentProfileResponse
+
+	if id, ok := certIdentity(ctx); ok && request.GetAgentId() != id {
+		return nil, status.Errorf(codes.PermissionDenied,
+			"the certificate is not issued to agent %s", request.GetAgentId())
+	}
+
+	p, hash, err := ms.profiles.lookup(ctx, request.GetAgentId(), request.GetLabels())
+	if err != nil {
+		response.Error = err.Error()
+		return &response, status.Error(codes.NotFound, err.Error())
+	}
+
+	response.Name = p.Name
+	response.Config = p.Config
+	response.Hash = hash
+
+	return &response, nil
+}
@@ -0,0 +1,183 @@
+//go:build usetempdir
+// +build usetempdir
+
+package metcoll
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+)
+
+const testProfiles = `[
+	{"name": "web-01", "agents": ["web-01"], "config": {"poll_interval": "1s"}},
+	{"name": "db", "labels": ["db", "postgres"], "config": {"report_interval": "30s", "scrape_include": ["<pg_.*>"]}},
+	{"name": "default", "config": {"rate_limit": 2}}
+]`
+
+func writeProfiles(t *testing.T, profiles string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	require.NoError(t, os.WriteFile(path, []byte(profiles), 0o600))
+
+	return path
+}
+
+func TestProfileStore_lookup(t *testing.T) {
+	ps, err := newProfileStore(writeProfiles(t, testProfiles), hashKey, zap.S())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		agentID  string
+		want     string
+		labels   []string
+		notFound bool
+	}{
+		{name: "by agent ID", agentID: "web-01", labels: []string{"db"}, want: "web-01"},
+		{name: "by label", agentID: "db-01", labels: []string{"eu", "postgres"}, want: "db"},
+		{name: "default", agentID: "app-01", want: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, hash, err := ps.lookup(context.Background(), tt.agentID, tt.labels)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, p.Name)
+			assert.NoError(t, verifyProfile(hashKey, p.Config, hash))
+		})
+	}
+
+	t.Run("without default profile", func(t *testing.T) {
+		ps, err := newProfileStore(writeProfiles(t, `[{"name": "db", "labels": ["db"], "config": {}}]`), hashKey, zap.S())
+		require.NoError(t, err)
+
+		_, _, err = ps.lookup(context.Background(), "app-01", nil)
+		assert.ErrorIs(t, err, ErrProfileNotFound)
+	})
+
+	t.Run("without profiles", func(t *testing.T) {
+		var ps *profileStore
+		_, _, err := ps.lookup(context.Background(), "app-01", nil)
+		assert.ErrorIs(t, err, ErrProfileNotFound)
+	})
+
+	t.Run("incorrect profile", func(t *testing.T) {
+		_, err := newProfileStore(writeProfiles(t, `[{"name": "db", "config": {"hashkey": "key"}}]`), hashKey, zap.S())
+		assert.Error(t, err)
+	})
+
+	t.Run("incorrect hash", func(t *testing.T) {
+		p, _, err := ps.lookup(context.Background(), "web-01", nil)
+		require.NoError(t, err)
+		assert.Error(t, verifyProfile(hashKey, p.Config, "incorrect"))
+	})
+}
+
+func TestClient_AgentProfile(t *testing.T) {
+	ctx := context.Background()
+
+	profiles := `[{"name": "db", "labels": ["db"], "config": {"report_interval": "30s", "scrape_include": ["<pg_.*>"]}}]`
+	cfg := &configuration.Config{Key: hashKey, AgentProfiles: writeProfiles(t, profiles)}
+	s, err := NewHTTPServer(ctx, nil, cfg, zap.S())
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(s.httpServer.Handler)
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	t.Run("profile is verified", func(t *testing.T) {
+		c, err := NewHTTPClient(&configuration.ConfigAgent{Server: u.Host, Key: hashKey}, zap.S())
+		require.NoError(t, err)
+
+		p, err := c.AgentProfile(ctx, "db-01", []string{"db"})
+		require.NoError(t, err)
+		assert.Equal(t, "db", p.Name)
+		assert.JSONEq(t, `{"report_interval": "30s", "scrape_include": ["<pg_.*>"]}`, string(p.Config))
+	})
+
+	t.Run("request with other key is rejected", func(t *testing.T) {
+		c, err := NewHTTPClient(&configuration.ConfigAgent{Server: u.Host, Key: []byte("other key")}, zap.S())
+		require.NoError(t, err)
+
+		_, err = c.AgentProfile(ctx, "db-01", []string{"db"})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrProfileNotFound)
+	})
+
+	t.Run("profile not found", func(t *testing.T) {
+		c, err := NewHTTPClient(&configuration.ConfigAgent{Server: u.Host, Key: hashKey}, zap.S())
+		require.NoError(t, err)
+
+		_, err = c.AgentProfile(ctx, "app-01", []string{"web"})
+		assert.ErrorIs(t, err, ErrProfileNotFound)
+	})
+}
+
+func TestGRPCClient_AgentProfile(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.Config{Key: hashKey, AgentProfiles: writeProfiles(t, testProfiles)}
+	s, err := NewGRPCServer(nil, cfg, zap.S())
+	require.NoError(t, err)
+	RegisterMetcollServer(s, s.ms)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("server exited with error: %v", err)
+		}
+	}()
+	defer s.grpcServer.Stop()
+
+	newClient := func(t *testing.T, key []byte) *GRPCClient {
+		t.Helper()
+
+		c, err := NewGRPCClient(ctx, &configuration.ConfigAgent{Key: key}, zap.S())
+		require.NoError(t, err)
+
+		opts := c.getDialOpts()
+		opts = append(opts,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithContextDialer((&dialer{lis: lis}).bufDialer))
+
+		conn, err := grpc.DialContext(ctx, "", opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		c.conns[""] = conn
+
+		return c
+	}
+
+	t.Run("profile is verified", func(t *testing.T) {
+		p, err := newClient(t, hashKey).AgentProfile(ctx, "web-01", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "web-01", p.Name)
+		assert.JSONEq(t, `{"poll_interval": "1s"}`, string(p.Config))
+	})
+
+	t.Run("request with other key is rejected", func(t *testing.T) {
+		_, err := newClient(t, []byte("other key")).AgentProfile(ctx, "web-01", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("profile not found", func(t *testing.T) {
+		s.ms.profiles = nil
+		_, err := newClient(t, hashKey).AgentProfile(ctx, "web-01", nil)
+		assert.ErrorIs(t, err, ErrProfileNotFound)
+	})
+}
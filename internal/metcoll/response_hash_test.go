@@ -0,0 +1,141 @@
+package metcoll
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+func TestClient_verifyResponseHash(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.Config{Key: hashKey}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+	srv, err := NewHTTPServer(ctx, stg, cfg, zap.S())
+	require.NoError(t, err)
+
+	tests := []struct {
+		handler http.Handler
+		wantErr error
+		name    string
+		key     []byte
+	}{
+		{name: "response of the server", handler: srv.httpServer.Handler, key: hashKey},
+		{
+			name: "changed response",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(HashSHA256, "0badc0de")
+				w.WriteHeader(http.StatusOK)
+			}),
+			key:     hashKey,
+			wantErr: ErrResponseHash,
+		},
+		{
+			name: "response without hash",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+			key:     hashKey,
+			wantErr: ErrResponseHash,
+		},
+		{
+			name: "agent without key",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+
+			u, err := url.Parse(ts.URL)
+			require.NoError(t, err)
+
+			c, err := NewHTTPClient(&configuration.ConfigAgent{Server: u.Host, Key: tt.key}, zap.S())
+			require.NoError(t, err)
+
+			err = c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)})
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestGRPCClient_verifyResponseHash(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		wantErr error
+		name    string
+		hashed  bool
+	}{
+		{name: "response of the server", hashed: true},
+		{name: "response without hash", wantErr: ErrResponseHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			ms := NewMockMetcollServer(ctrl)
+			ms.EXPECT().Updates(gomock.Any(), gomock.Any()).Return(&BatchUpdateResponse{}, nil)
+
+			var dialer func(context.Context, string) (net.Conn, error)
+			if !tt.hashed {
+				dialer = NewSrvListener(ms)
+			} else {
+				s, err := NewGRPCServer(nil, &configuration.Config{Key: hashKey}, zap.S())
+				require.NoError(t, err)
+				s.RegisterService(&Metcoll_ServiceDesc, ms)
+
+				lis := bufconn.Listen(1024 * 1024)
+				go func() {
+					if err := s.Serve(lis); err != nil {
+						t.Errorf("server exited with error: %v", err)
+					}
+				}()
+				defer s.grpcServer.Stop()
+
+				dialer = func(context.Context, string) (net.Conn, error) { return lis.Dial() }
+			}
+
+			acfg := &configuration.ConfigAgent{Server: "bufnet", Key: hashKey}
+			c, err := NewGRPCClient(ctx, acfg, zap.S())
+			require.NoError(t, err)
+
+			opts := append(c.getDialOpts(),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithContextDialer(dialer))
+			conn, err := grpc.DialContext(ctx, acfg.Server, opts...)
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+			c.conns[acfg.Server] = conn
+
+			err = c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("c", 1)})
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
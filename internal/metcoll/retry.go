@@ -0,0 +1,129 @@
+package metcoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// StatusError - the error of the request completed by the server with the unsuccessful status code.
+type StatusError struct {
+	Body string
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request has failed with code: %d, result: %s", e.Code, e.Body)
+}
+
+// Permanent - reports whether the server has rejected the payload of the request, so repeating it cannot help.
+// The request that is not authorized is not permanent, it is accepted once the token,
+// the keys or the certificates of the agent are fixed.
+func (e *StatusError) Permanent() bool {
+	switch e.Code {
+	case http.StatusBadRequest,
+		http.StatusRequestEntityTooLarge,
+		http.StatusUnsupportedMediaType,
+		http.StatusUnprocessableEntity:
+		return true
+	default:
+		return false
+	}
+}
+
+// Unauthorized - reports whether the server has rejected the agent itself.
+func (e *StatusError) Unauthorized() bool {
+	return e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden
+}
+
+// retryableStatus - the server is overloaded or temporarily failed, so the request is repeated.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests ||
+		(code >= http.StatusInternalServerError && code != http.StatusNotImplemented)
+}
+
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	if err != nil {
+		return retryableError(err), err
+	}
+
+	return retryableStatus(resp.StatusCode), nil
+}
+
+// retryableError - the connection was refused, timed out or broken, so the request is repeated.
+func retryableError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// backoff - returns the wait before the retry. The wait requested by the server in Retry-After is honoured,
+// up to the max, otherwise the wait is doubled with every attempt up to the max and a half of it is random,
+// so the agents do not retry at the same moment.
+func backoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		if wait > max {
+			wait = max
+		}
+		return wait
+	}
+
+	const maxShift = 32
+	wait := max
+	if attemptNum < maxShift {
+		if w := min << attemptNum; w > 0 && w < max {
+			wait = w
+		}
+	}
+
+	if half := wait / 2; half > 0 {
+		wait = half + time.Duration(rand.Int63n(int64(half)+1)) //nolint:gosec // jitter does not need crypto rand
+	}
+
+	return wait
+}
+
+// retryAfter - returns the wait from the Retry-After header of the 429 and 503 responses.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil ||
+		(resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
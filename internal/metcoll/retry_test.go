@@ -0,0 +1,179 @@
+package metcoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+func TestBackoff(t *testing.T) {
+	min, max := time.Second, 5*time.Second
+
+	t.Run("exponential with jitter", func(t *testing.T) {
+		for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, max, max} {
+			wait := backoff(min, max, attempt, nil)
+			assert.GreaterOrEqual(t, wait, want/2)
+			assert.LessOrEqual(t, wait, want)
+		}
+	})
+
+	t.Run("retry after in seconds", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		resp.Header.Set("Retry-After", "3")
+		assert.Equal(t, 3*time.Second, backoff(min, max, 0, resp))
+	})
+
+	t.Run("retry after is limited by the max", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+		resp.Header.Set("Retry-After", "3600")
+		assert.Equal(t, max, backoff(min, max, 0, resp))
+	})
+
+	t.Run("retry after as date", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+		resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+		assert.Equal(t, time.Duration(0), backoff(min, max, 0, resp))
+	})
+
+	t.Run("retry after is ignored for other codes", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}}
+		resp.Header.Set("Retry-After", "60")
+		assert.LessOrEqual(t, backoff(min, max, 0, resp), min)
+	})
+}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+func TestCheckRetry(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		err   error
+		name  string
+		retry bool
+	}{
+		{name: "connection refused", err: syscall.ECONNREFUSED, retry: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, retry: true},
+		{name: "broken pipe", err: &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, retry: true},
+		{name: "timeout", err: &url.Error{Op: "Post", URL: "http://metcoll", Err: &timeoutError{}}, retry: true},
+		{name: "unexpected EOF", err: fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), retry: true},
+		{name: "EOF", err: &url.Error{Op: "Post", URL: "http://metcoll", Err: io.EOF}, retry: true},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "metcoll", IsNotFound: true}},
+		{name: "certificate", err: errors.New("x509: certificate signed by unknown authority")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry, err := checkRetry(ctx, nil, tt.err)
+			assert.Equal(t, tt.retry, retry)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	t.Run("canceled request is not retried", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		retry, err := checkRetry(ctx, nil, syscall.ECONNREFUSED)
+		assert.False(t, retry)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestStatusError_Permanent(t *testing.T) {
+	tests := []struct {
+		code         int
+		permanent    bool
+		unauthorized bool
+	}{
+		{code: http.StatusBadRequest, permanent: true},
+		{code: http.StatusRequestEntityTooLarge, permanent: true},
+		{code: http.StatusUnauthorized, unauthorized: true},
+		{code: http.StatusForbidden, unauthorized: true},
+		{code: http.StatusNotFound},
+		{code: http.StatusTooManyRequests},
+		{code: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.code), func(t *testing.T) {
+			e := &StatusError{Code: tt.code}
+			assert.Equal(t, tt.permanent, e.Permanent())
+			assert.Equal(t, tt.unauthorized, e.Unauthorized())
+		})
+	}
+}
+
+func TestClient_BatchUpdate_retry(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		codes    []int
+		wantCode int
+		wantReqs int32
+	}{
+		{name: "retried after 503", codes: []int{http.StatusServiceUnavailable, http.StatusOK}, wantReqs: 2},
+		{name: "retried after 429", codes: []int{http.StatusTooManyRequests, http.StatusOK}, wantReqs: 2},
+		{
+			name:     "retries are exhausted",
+			codes:    []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantCode: http.StatusBadGateway,
+			wantReqs: 3,
+		},
+		{name: "bad request is not retried", codes: []int{http.StatusBadRequest}, wantCode: http.StatusBadRequest, wantReqs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := reqs.Add(1)
+				code := tt.codes[len(tt.codes)-1]
+				if int(n) <= len(tt.codes) {
+					code = tt.codes[n-1]
+				}
+				if code == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "0")
+				}
+				w.WriteHeader(code)
+			}))
+			defer srv.Close()
+
+			u, err := url.Parse(srv.URL)
+			require.NoError(t, err)
+
+			c, err := NewHTTPClient(&configuration.ConfigAgent{Server: u.Host, RetryMax: 2}, zap.S())
+			require.NoError(t, err)
+
+			err = c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)})
+			assert.Equal(t, tt.wantReqs, reqs.Load())
+			if tt.wantCode == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			var se *StatusError
+			require.ErrorAs(t, err, &se)
+			assert.Equal(t, tt.wantCode, se.Code)
+		})
+	}
+}
@@ -15,6 +15,7 @@ const (
 	metricTypeParam  = "metricType"
 	metricValueParam = "metricValue"
 	updates          = "/updates/"
+	tokenIDParam     = "tokenID"
 )
 
 func NewRouter(ctx context.Context, handlers *Handler, middlewares ...func(http.Handler) http.Handler) *chi.Mux {
@@ -61,6 +62,14 @@ func NewRouter(ctx context.Context, handlers *Handler, middlewares ...func(http.
 			handlers.CollectMetricList(r.Context(), w)
 		})
 
+		r.Get("/agents", func(w http.ResponseWriter, r *http.Request) {
+			handlers.AgentList(r.Context(), w)
+		})
+
+		r.Get("/api/agents", func(w http.ResponseWriter, r *http.Request) {
+			handlers.AgentListJSON(r.Context(), w)
+		})
+
 		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			handlers.Ping(r.Context(), w)
 		})
@@ -68,6 +77,22 @@ func NewRouter(ctx context.Context, handlers *Handler, middlewares ...func(http.
 		r.Post(updates, func(w http.ResponseWriter, r *http.Request) {
 			handlers.BatchUpdate(r.Context(), w, r.Body)
 		})
+
+		r.Post(profile, func(w http.ResponseWriter, r *http.Request) {
+			handlers.AgentProfile(r.Context(), w, r.Body)
+		})
+
+		r.Get(apiTokens, func(w http.ResponseWriter, r *http.Request) {
+			handlers.TokenList(r.Context(), w)
+		})
+
+		r.Post(apiTokens, func(w http.ResponseWriter, r *http.Request) {
+			handlers.AddToken(r.Context(), w, r.Body)
+		})
+
+		r.Delete(apiTokens+"/{"+tokenIDParam+"}", func(w http.ResponseWriter, r *http.Request) {
+			handlers.DeleteToken(r.Context(), w, chi.URLParam(r, tokenIDParam))
+		})
 	})
 
 	router.Group(func(r chi.Router) {
@@ -0,0 +1,357 @@
+package metcoll
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
+)
+
+// signatureHeader - header with the Ed25519 signature of the request in base64.
+const signatureHeader = "X-Metcoll-Signature"
+
+// timestampHeader - header with the time the request was signed at in nanoseconds since the epoch.
+const timestampHeader = "X-Metcoll-Timestamp"
+
+// signatureWindow - the signed requests are accepted within the window around the time of the server,
+// so the clock of the agent may differ from the clock of the server by the window.
+const signatureWindow = 5 * time.Minute
+
+var (
+	// errUnsigned - the request has no agent ID or no signature.
+	errUnsigned = errors.New("the request is not signed")
+	// errUnknownAgent - the agent is not in the registry of the agent keys.
+	errUnknownAgent = errors.New("the agent is not registered")
+	// errRevokedAgent - the key of the agent is revoked.
+	errRevokedAgent = errors.New("the agent key is revoked")
+	// errBadSignature - the signature does not match the request.
+	errBadSignature = errors.New("the signature is incorrect")
+	// errStaleSignature - the request was signed outside the signature window.
+	errStaleSignature = errors.New("the signature time is outside the allowed window")
+	// errReplayedRequest - the request with the same signature was already accepted.
+	errReplayedRequest = errors.New("the signed request was already accepted")
+)
+
+// signaturePayload - returns the message that is signed,
+// the ID of the agent and the time of the signing are signed along with the request.
+func signaturePayload(agentID string, timestamp string, b []byte) []byte {
+	payload := make([]byte, 0, len(agentID)+len(timestamp)+2+len(b))
+	payload = append(payload, agentID...)
+	payload = append(payload, '\n')
+	payload = append(payload, timestamp...)
+	payload = append(payload, '\n')
+	return append(payload, b...)
+}
+
+// signRequest - returns the signature of the request in base64.
+func signRequest(key ed25519.PrivateKey, agentID string, timestamp string, b []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, signaturePayload(agentID, timestamp, b)))
+}
+
+// signatureTimestamp - returns the time of the signing for the timestamp header.
+func signatureTimestamp() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+// agentKey - the public key the agent signs the requests with.
+type agentKey struct {
+	Agent     string `json:"agent"`
+	PublicKey string `json:"public_key"`
+	// Revoked - the requests of the agent are rejected, the key is kept to show why.
+	Revoked bool `json:"revoked"`
+}
+
+// agentKeyStore - the registry of the agent keys loaded from the file.
+// The file is read again when it changes, so the agent is added or revoked without the restart.
+// The signatures of the accepted requests are kept until they are outside the signature window,
+// so the same request cannot be replayed.
+type agentKeyStore struct {
+	pruneAt time.Time
+	mux     *sync.Mutex
+	sl      *zap.SugaredLogger
+	keys    map[string]ed25519.PublicKey
+	revoked map[string]bool
+	// seen - the signatures of the accepted requests and the time they expire at.
+	seen    map[string]time.Time
+	path    string
+	version string
+}
+
+// newAgentKeyStore - Object constructor. Returns nil if the path to the agent keys is not set.
+func newAgentKeyStore(path string, sl *zap.SugaredLogger) (*agentKeyStore, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	ks := &agentKeyStore{
+		mux:  &sync.Mutex{},
+		sl:   sl,
+		seen: make(map[string]time.Time),
+		path: path,
+	}
+
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// load - reads the agent keys from the file if it has changed since the last reading.
+func (ks *agentKeyStore) load() error {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return fmt.Errorf("cannot stat agent keys file err: %w", err)
+	}
+
+	version := fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	if version == ks.version {
+		return nil
+	}
+
+	b, err := os.ReadFile(ks.path)
+	if err != nil {
+		return fmt.Errorf("cannot read agent keys file err: %w", err)
+	}
+
+	var aks []agentKey
+	if err := json.Unmarshal(b, &aks); err != nil {
+		return fmt.Errorf("cannot unmarshal agent keys err: %w", err)
+	}
+
+	keys := make(map[string]ed25519.PublicKey, len(aks))
+	revoked := make(map[string]bool)
+	for _, ak := range aks {
+		if ak.Agent == "" {
+			return errors.New("agent key without agent ID")
+		}
+		if _, ok := keys[ak.Agent]; ok || revoked[ak.Agent] {
+			return fmt.Errorf("agent %s is registered twice", ak.Agent)
+		}
+
+		if ak.Revoked {
+			revoked[ak.Agent] = true
+			continue
+		}
+
+		key, err := crypto.ParseVerifyKey(ak.PublicKey)
+		if err != nil {
+			return fmt.Errorf("key of agent %s is incorrect, err: %w", ak.Agent, err)
+		}
+		keys[ak.Agent] = key
+	}
+
+	ks.keys = keys
+	ks.revoked = revoked
+	ks.version = version
+	ks.sl.Infof("agent keys were loaded from %s, agents count: %d, revoked: %d", ks.path, len(keys), len(revoked))
+
+	return nil
+}
+
+// verify - checks the signature of the request with the key of the agent and the time of the signing.
+// The signature of the accepted request is remembered, the request with the same signature is rejected.
+// If the keys file has changed but cannot be read, the previous keys are used.
+func (ks *agentKeyStore) verify(agentID string, timestamp string, b []byte, signature string) error {
+	if agentID == "" || timestamp == "" || signature == "" {
+		return errUnsigned
+	}
+
+	ks.mux.Lock()
+	if err := ks.load(); err != nil {
+		ks.sl.Errorf("agent keys were not reloaded, err: %v", err)
+	}
+	key, ok := ks.keys[agentID]
+	revoked := ks.revoked[agentID]
+	ks.mux.Unlock()
+
+	if revoked {
+		return fmt.Errorf("%w: %s", errRevokedAgent, agentID)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownAgent, agentID)
+	}
+
+	ns, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStaleSignature, err)
+	}
+	now := time.Now()
+	signedAt := time.Unix(0, ns)
+	if signedAt.Before(now.Add(-signatureWindow)) || signedAt.After(now.Add(signatureWindow)) {
+		return fmt.Errorf("%w: signed at %s", errStaleSignature, signedAt.UTC().Format(time.RFC3339))
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadSignature, err)
+	}
+	if !ed25519.Verify(key, signaturePayload(agentID, timestamp, b), sig) {
+		return errBadSignature
+	}
+
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+
+	if now.After(ks.pruneAt) {
+		for s, expires := range ks.seen {
+			if now.After(expires) {
+				delete(ks.seen, s)
+			}
+		}
+		ks.pruneAt = now.Add(signatureWindow)
+	}
+
+	if _, ok := ks.seen[signature]; ok {
+		return errReplayedRequest
+	}
+	ks.seen[signature] = signedAt.Add(signatureWindow)
+
+	return nil
+}
+
+// forget - forgets the signature of the request that was not handled, so the agent can retry it.
+func (ks *agentKeyStore) forget(signature string) {
+	ks.mux.Lock()
+	defer ks.mux.Unlock()
+
+	delete(ks.seen, signature)
+}
+
+// statusWriter - remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil {
+		return 0, fmt.Errorf("response write was failed, err: %w", err)
+	}
+
+	return n, nil
+}
+
+// isSigned - the requests of the agents that write the metrics or read the profiles are signed.
+func isSigned(r *http.Request) bool {
+	return r.Method == http.MethodPost && (isReport(r.URL.Path) || r.URL.Path == profile)
+}
+
+// signatureChecker - middleware checks the signature of the agent requests with the key of the agent.
+func (s *HTTPServer) signatureChecker(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.agentKeys == nil || !isSigned(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		agentID := r.Header.Get(agentIDHeader)
+		signature := r.Header.Get(signatureHeader)
+		if err := s.agentKeys.verify(agentID, r.Header.Get(timestampHeader), body, signature); err != nil {
+			s.log.Infof("request %s of agent %q was rejected, err: %v", r.URL.Path, agentID, err)
+			http.Error(w, err.Error(), signatureStatus(err))
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.status >= http.StatusBadRequest {
+			s.agentKeys.forget(signature)
+		}
+	})
+}
+
+func signatureStatus(err error) int {
+	if errors.Is(err, errUnknownAgent) || errors.Is(err, errRevokedAgent) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusUnauthorized
+}
+
+// signatureChecker - checks the signature of the agent requests with the key of the agent.
+func (s *GRPCServer) signatureChecker() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if s.agentKeys == nil {
+			return handler(ctx, req)
+		}
+		switch req.(type) {
+		case *BatchUpdateRequest, *UpdateRequest, *AgentProfileRequest:
+		default:
+			return handler(ctx, req)
+		}
+
+		part, _ := signedPart(req)
+		b, err := convertToBytes(part)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to convert request to bytes, err: %v", err)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		first := func(key string) string {
+			if v := md.Get(key); len(v) > 0 {
+				return strings.TrimSpace(v[0])
+			}
+			return ""
+		}
+
+		agentID := first(agentIDHeader)
+		signature := first(signatureHeader)
+		if err := s.agentKeys.verify(agentID, first(timestampHeader), b, signature); err != nil {
+			s.sl.Infof("request %s of agent %q was rejected, err: %v", info.FullMethod, agentID, err)
+			if errors.Is(err, errUnknownAgent) || errors.Is(err, errRevokedAgent) {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			s.agentKeys.forget(signature)
+		}
+
+		return resp, err
+	}
+}
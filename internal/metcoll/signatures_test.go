@@ -0,0 +1,297 @@
+//go:build usetempdir
+// +build usetempdir
+
+package metcoll
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+func TestAgentKeyStore_verify(t *testing.T) {
+	pub, key := newSigningKey(t)
+	_, otherKey := newSigningKey(t)
+
+	keysFile := writeAgentKeys(t, t.TempDir(), []agentKey{
+		{Agent: "web-01", PublicKey: crypto.EncodeVerifyKey(pub)},
+		{Agent: "web-02", PublicKey: crypto.EncodeVerifyKey(pub), Revoked: true},
+	})
+
+	ks, err := newAgentKeyStore(keysFile, zap.S())
+	require.NoError(t, err)
+
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	now := signatureTimestamp()
+	stale := strconv.FormatInt(time.Now().Add(-2*signatureWindow).UnixNano(), 10)
+	future := strconv.FormatInt(time.Now().Add(2*signatureWindow).UnixNano(), 10)
+
+	tests := []struct {
+		wantErr   error
+		name      string
+		agentID   string
+		timestamp string
+		signature string
+	}{
+		{name: "signed by the agent", agentID: "web-01", timestamp: now, signature: signRequest(key, "web-01", now, body)},
+		{name: "not signed", agentID: "web-01", timestamp: now, wantErr: errUnsigned},
+		{name: "without timestamp", agentID: "web-01", signature: signRequest(key, "web-01", "", body), wantErr: errUnsigned},
+		{name: "signed by other key", agentID: "web-01", timestamp: now,
+			signature: signRequest(otherKey, "web-01", now, body), wantErr: errBadSignature},
+		{name: "signed for other agent", agentID: "web-01", timestamp: now,
+			signature: signRequest(key, "web-03", now, body), wantErr: errBadSignature},
+		{name: "signed for other time", agentID: "web-01", timestamp: now,
+			signature: signRequest(key, "web-01", stale, body), wantErr: errBadSignature},
+		{name: "stale signature", agentID: "web-01", timestamp: stale,
+			signature: signRequest(key, "web-01", stale, body), wantErr: errStaleSignature},
+		{name: "signature from the future", agentID: "web-01", timestamp: future,
+			signature: signRequest(key, "web-01", future, body), wantErr: errStaleSignature},
+		{name: "incorrect timestamp", agentID: "web-01", timestamp: "yesterday",
+			signature: signRequest(key, "web-01", "yesterday", body), wantErr: errStaleSignature},
+		{name: "replayed request", agentID: "web-01", timestamp: now,
+			signature: signRequest(key, "web-01", now, body), wantErr: errReplayedRequest},
+		{name: "revoked agent", agentID: "web-02", timestamp: now,
+			signature: signRequest(key, "web-02", now, body), wantErr: errRevokedAgent},
+		{name: "unknown agent", agentID: "web-03", timestamp: now,
+			signature: signRequest(key, "web-03", now, body), wantErr: errUnknownAgent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ks.verify(tt.agentID, tt.timestamp, body, tt.signature)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("forgotten request can be retried", func(t *testing.T) {
+		ts := signatureTimestamp()
+		sig := signRequest(key, "web-01", ts, body)
+		require.NoError(t, ks.verify("web-01", ts, body, sig))
+
+		ks.forget(sig)
+		assert.NoError(t, ks.verify("web-01", ts, body, sig))
+		assert.ErrorIs(t, ks.verify("web-01", ts, body, sig), errReplayedRequest)
+	})
+
+	t.Run("agent is revoked without restart", func(t *testing.T) {
+		writeAgentKeys(t, path.Dir(keysFile), []agentKey{
+			{Agent: "web-01", PublicKey: crypto.EncodeVerifyKey(pub), Revoked: true},
+		})
+
+		ts := signatureTimestamp()
+		err := ks.verify("web-01", ts, body, signRequest(key, "web-01", ts, body))
+		assert.ErrorIs(t, err, errRevokedAgent)
+	})
+}
+
+func TestHTTPServer_signatureChecker(t *testing.T) {
+	ctx := context.Background()
+	td := t.TempDir()
+
+	pub, key := newSigningKey(t)
+	_, otherKey := newSigningKey(t)
+	keysFile := writeAgentKeys(t, td, []agentKey{{Agent: "web-01", PublicKey: crypto.EncodeVerifyKey(pub)}})
+
+	cfg := &configuration.Config{AgentKeys: keysFile}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+
+	srv, err := NewHTTPServer(ctx, stg, cfg, zap.S())
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		key      ed25519.PrivateKey
+		name     string
+		agentID  string
+		wantCode int
+	}{
+		{name: "registered agent", agentID: "web-01", key: key},
+		{name: "not signed", agentID: "web-01", wantCode: http.StatusUnauthorized},
+		{name: "other key", agentID: "web-01", key: otherKey, wantCode: http.StatusUnauthorized},
+		{name: "unknown agent", agentID: "web-02", key: key, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acfg := &configuration.ConfigAgent{Server: u.Host, AgentID: tt.agentID}
+			if tt.key != nil {
+				acfg.SigningKey = writeSigningKey(t, td, tt.key)
+			}
+			c, err := NewHTTPClient(acfg, zap.S())
+			require.NoError(t, err)
+
+			err = c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)})
+			if tt.wantCode == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			var se *StatusError
+			require.ErrorAs(t, err, &se)
+			assert.Equal(t, tt.wantCode, se.Code)
+		})
+	}
+
+	t.Run("replayed request", func(t *testing.T) {
+		acfg := &configuration.ConfigAgent{Server: u.Host, AgentID: "web-01", SigningKey: writeSigningKey(t, td, key)}
+		c, err := NewHTTPClient(acfg, zap.S())
+		require.NoError(t, err)
+
+		req, err := c.prepareRequest(ctx, []byte(`[{"id":"g","type":"gauge","value":1}]`), ts.URL+"/updates/")
+		require.NoError(t, err)
+
+		for _, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+			resp, err := c.httpClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, want, resp.StatusCode)
+		}
+	})
+
+	t.Run("reading is not signed", func(t *testing.T) {
+		resp, _ := testRequest(t, ts, http.MethodGet, "/", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestGRPCServer_signatureChecker(t *testing.T) {
+	td := t.TempDir()
+
+	pub, key := newSigningKey(t)
+	_, otherKey := newSigningKey(t)
+	keysFile := writeAgentKeys(t, td, []agentKey{
+		{Agent: "web-01", PublicKey: crypto.EncodeVerifyKey(pub)},
+		{Agent: "web-02", PublicKey: crypto.EncodeVerifyKey(pub), Revoked: true},
+	})
+
+	tests := []struct {
+		key      ed25519.PrivateKey
+		name     string
+		agentID  string
+		wantCode codes.Code
+	}{
+		{name: "registered agent", agentID: "web-01", key: key, wantCode: codes.OK},
+		{name: "not signed", agentID: "web-01", wantCode: codes.Unauthenticated},
+		{name: "other key", agentID: "web-01", key: otherKey, wantCode: codes.Unauthenticated},
+		{name: "revoked agent", agentID: "web-02", key: key, wantCode: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			ctrl := gomock.NewController(t)
+			ms := NewMockMetcollServer(ctrl)
+			ms.EXPECT().Updates(gomock.Any(), gomock.Any()).Return(&BatchUpdateResponse{}, nil).MaxTimes(1)
+
+			s, err := NewGRPCServer(nil, &configuration.Config{Key: hashKey, AgentKeys: keysFile}, zap.S())
+			require.NoError(t, err)
+			s.RegisterService(&Metcoll_ServiceDesc, ms)
+
+			lis := bufconn.Listen(1024 * 1024)
+			go func() {
+				if err := s.Serve(lis); err != nil {
+					t.Errorf("server exited with error: %v", err)
+				}
+			}()
+			defer s.grpcServer.Stop()
+
+			acfg := &configuration.ConfigAgent{Server: "bufnet", Key: hashKey, AgentID: tt.agentID}
+			if tt.key != nil {
+				acfg.SigningKey = writeSigningKey(t, td, tt.key)
+			}
+			c, err := NewGRPCClient(ctx, acfg, zap.S())
+			require.NoError(t, err)
+
+			conn, err := grpc.DialContext(ctx, acfg.Server,
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+
+			request, headers, err := c.updatesRequest([]*metrics.Metrics{metrics.NewCounterMetric("c", 1)})
+			require.NoError(t, err)
+
+			mctx := metadata.NewOutgoingContext(ctx, metadata.New(headers))
+			_, err = NewMetcollClient(conn).Updates(mctx, request)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+
+			if tt.wantCode == codes.OK {
+				_, err = NewMetcollClient(conn).Updates(mctx, request)
+				assert.Equal(t, codes.Unauthenticated, status.Code(err), "the replayed request is accepted")
+			}
+		})
+	}
+}
+
+func newSigningKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return pub, key
+}
+
+// writeSigningKey - writes the key of the agent in the PKCS #8 PEM form and returns the path to it.
+func writeSigningKey(t *testing.T, dir string, key ed25519.PrivateKey) string {
+	t.Helper()
+
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	f, err := os.CreateTemp(dir, "*.pem")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, f.Close()) }()
+
+	require.NoError(t, pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+
+	return f.Name()
+}
+
+// writeAgentKeys - writes the registry of the agent keys to the dir and returns the path to it.
+func writeAgentKeys(t *testing.T, dir string, keys []agentKey) string {
+	t.Helper()
+
+	b, err := json.Marshal(keys)
+	require.NoError(t, err)
+
+	p := path.Join(dir, "agent_keys.json")
+	require.NoError(t, os.WriteFile(p, b, 0600))
+
+	return p
+}
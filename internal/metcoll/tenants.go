@@ -0,0 +1,292 @@
+package metcoll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ArtemShalinFe/metcoll/internal/crypto"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+// tenantHeader - header with the tenant of the request, the default tenant is used if it is not set.
+const tenantHeader = "X-Metcoll-Tenant"
+
+var (
+	// errUnknownTenant - the tenant is not in the tenants file.
+	errUnknownTenant = errors.New("the tenant is unknown")
+	// errTenantMismatch - the request names the tenant other than the tenant of its token.
+	errTenantMismatch = errors.New("the tenant of the request differs from the tenant of the token")
+	// errQuotaExceeded - the new metrics do not fit in the quota of the tenant.
+	errQuotaExceeded = errors.New("the quota on the number of metrics of the tenant is exceeded")
+)
+
+var tenantNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,62}$`)
+
+// tenantConfig - the tenant in the tenants file.
+type tenantConfig struct {
+	Name string `json:"name"`
+	// Key - the hash key of the requests of the tenant, the hash key of the server if it is empty.
+	Key string `json:"key"`
+	// CryptoKey - the private key or the directory with the private keys of the tenant,
+	// the keys of the server if it is empty.
+	CryptoKey string `json:"crypto_key"`
+	// MaxMetrics - the quota on the number of metrics of the tenant, the number is not limited if it is zero.
+	MaxMetrics int `json:"max_metrics"`
+}
+
+// tenant - the tenant with its keys and quota, the metrics of every tenant are stored separately.
+type tenant struct {
+	keyring    *crypto.Keyring
+	name       string
+	hashkey    []byte
+	maxMetrics int
+	// ownKey - the tenant has its own hash key, so its requests must be hashed.
+	ownKey bool
+}
+
+type tenantContextKey struct{}
+
+// tenantStore - the tenants of the server. The default tenant always exists and uses the keys of the server,
+// so the deployments without the tenants file work as before.
+type tenantStore struct {
+	tenants map[string]*tenant
+	keyring *crypto.Keyring
+}
+
+// newTenantStore - Object constructor. The tenants are loaded from the file if the path is set.
+func newTenantStore(path string, hashkey []byte, keyring *crypto.Keyring, sl *zap.SugaredLogger) (*tenantStore, error) {
+	ts := &tenantStore{
+		tenants: map[string]*tenant{
+			storage.DefaultTenant: {name: storage.DefaultTenant, hashkey: hashkey, keyring: keyring},
+		},
+		keyring: keyring,
+	}
+
+	if path == "" {
+		return ts, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tenants file err: %w", err)
+	}
+
+	var tcs []tenantConfig
+	if err := json.Unmarshal(b, &tcs); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal tenants err: %w", err)
+	}
+
+	for _, tc := range tcs {
+		if !tenantNameRe.MatchString(tc.Name) {
+			return nil, fmt.Errorf("tenant name %q is incorrect, it must be up to 63 letters, digits, '_', '.' or '-'",
+				tc.Name)
+		}
+		if _, ok := ts.tenants[tc.Name]; ok {
+			return nil, fmt.Errorf("tenant %s is duplicated", tc.Name)
+		}
+		if tc.MaxMetrics < 0 {
+			return nil, fmt.Errorf("tenant %s has negative max_metrics", tc.Name)
+		}
+
+		t := &tenant{name: tc.Name, hashkey: hashkey, keyring: keyring, maxMetrics: tc.MaxMetrics}
+		if tc.Key != "" {
+			t.hashkey = []byte(tc.Key)
+			t.ownKey = true
+		}
+		if tc.CryptoKey != "" {
+			t.keyring, err = crypto.NewKeyring(tc.CryptoKey)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load crypto keys of tenant %s err: %w", tc.Name, err)
+			}
+		}
+
+		ts.tenants[tc.Name] = t
+	}
+
+	sl.Infof("tenants were loaded from %s, tenants count: %d", path, len(tcs))
+
+	return ts, nil
+}
+
+// get - returns the tenant with the name.
+func (ts *tenantStore) get(name string) (*tenant, error) {
+	if ts == nil {
+		return nil, fmt.Errorf("%w: %s", errUnknownTenant, name)
+	}
+
+	t, ok := ts.tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownTenant, name)
+	}
+	return t, nil
+}
+
+// resolve - returns the tenant of the request. The token without the admin scope is bound to its tenant,
+// the tenant from the header is used for the admin tokens and if the tokens are not required.
+func (ts *tenantStore) resolve(ctx context.Context, header string) (*tenant, error) {
+	name := strings.TrimSpace(header)
+
+	if t, ok := ctx.Value(tokenContextKey{}).(*storage.Token); ok && !hasScope(t, scopeAdmin) {
+		if name != "" && name != t.Tenant {
+			return nil, errTenantMismatch
+		}
+		name = t.Tenant
+	}
+
+	return ts.get(name)
+}
+
+// reloadKeys - reads the private keys of the tenants with their own keys again.
+func (ts *tenantStore) reloadKeys(sl *zap.SugaredLogger) error {
+	for _, t := range ts.tenants {
+		if t.keyring == nil || t.keyring == ts.keyring {
+			continue
+		}
+
+		if err := t.keyring.Reload(); err != nil {
+			return fmt.Errorf("tenant %s reload keys err: %w", t.name, err)
+		}
+		sl.Infof("reloaded crypto keys of tenant %s: %v", t.name, t.keyring.IDs())
+	}
+
+	return nil
+}
+
+// withTenant - returns the context of the tenant, the storage reads and writes the metrics of the tenant in it.
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return storage.WithTenant(context.WithValue(ctx, tenantContextKey{}, t), t.name)
+}
+
+func tenantFromContext(ctx context.Context) *tenant {
+	t, ok := ctx.Value(tenantContextKey{}).(*tenant)
+	if !ok {
+		return nil
+	}
+	return t
+}
+
+// tenantHashKey - returns the hash key of the tenant of the request or the default key if the tenant is not set.
+func tenantHashKey(ctx context.Context, def []byte) []byte {
+	if t := tenantFromContext(ctx); t != nil {
+		return t.hashkey
+	}
+	return def
+}
+
+// tenantOwnsHashKey - reports whether the tenant of the request has its own hash key.
+func tenantOwnsHashKey(ctx context.Context) bool {
+	t := tenantFromContext(ctx)
+	return t != nil && t.ownKey
+}
+
+// tenantKeyring - returns the keyring of the tenant of the request or the default keyring if the tenant is not set.
+func tenantKeyring(ctx context.Context, def *crypto.Keyring) *crypto.Keyring {
+	if t := tenantFromContext(ctx); t != nil {
+		return t.keyring
+	}
+	return def
+}
+
+// checkQuota - checks that the new metrics of the update fit in the quota of the tenant of the request.
+// The quota is soft, the concurrent updates of the tenant may exceed it by the size of the batch.
+func checkQuota(ctx context.Context, stg Storage, mcs []*metrics.Metrics) error {
+	t := tenantFromContext(ctx)
+	if t == nil || t.maxMetrics == 0 {
+		return nil
+	}
+
+	var counters, gauges []string
+	seen := make(map[string]bool, len(mcs))
+	for _, m := range mcs {
+		key := m.MType + " " + m.ID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch m.MType {
+		case metrics.GaugeMetric:
+			gauges = append(gauges, m.ID)
+		case metrics.CounterMetric:
+			counters = append(counters, m.ID)
+		}
+	}
+
+	count, err := stg.MetricCount(ctx, counters, gauges)
+	if err != nil {
+		return fmt.Errorf("cannot count metrics of tenant %s err: %w", t.name, err)
+	}
+
+	if count > t.maxMetrics {
+		return fmt.Errorf("%w: %s, max metrics: %d", errQuotaExceeded, t.name, t.maxMetrics)
+	}
+
+	return nil
+}
+
+// quotaStatus - returns the status code of the error of the quota check.
+func quotaStatus(err error) int {
+	if errors.Is(err, errQuotaExceeded) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// quotaError - returns the gRPC status of the error of the quota check.
+func quotaError(err error) error {
+	if errors.Is(err, errQuotaExceeded) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, "cannot check the quota of the tenant")
+}
+
+// tenantSetter - middleware sets the tenant of the request, the request of the unknown tenant is rejected.
+func (s *HTTPServer) tenantSetter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, err := s.tenants.resolve(r.Context(), r.Header.Get(tenantHeader))
+		if err != nil {
+			s.log.Infof("request %s %s was rejected, err: %v", r.Method, r.URL.Path, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(withTenant(r.Context(), t)))
+	})
+}
+
+// tenantSetter - sets the tenant of the request from the metadata, the request of the unknown tenant is rejected.
+func (s *GRPCServer) tenantSetter() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		var header string
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Get(tenantHeader); len(v) > 0 {
+			header = v[0]
+		}
+
+		t, err := s.tenants.resolve(ctx, header)
+		if err != nil {
+			s.sl.Infof("request %s was rejected, err: %v", info.FullMethod, err)
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+
+		return handler(withTenant(ctx, t), req)
+	}
+}
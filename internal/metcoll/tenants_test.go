@@ -0,0 +1,432 @@
+//go:build usetempdir
+// +build usetempdir
+
+package metcoll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gomock "go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+func writeTenants(t *testing.T, tenants string) string {
+	t.Helper()
+
+	p := path.Join(t.TempDir(), "tenants.json")
+	require.NoError(t, os.WriteFile(p, []byte(tenants), 0600))
+
+	return p
+}
+
+func TestNewTenantStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "tenants",
+			tenants: `[{"name":"team-a","key":"a","max_metrics":10},{"name":"team.b"}]`,
+			want:    []string{storage.DefaultTenant, "team-a", "team.b"},
+		},
+		{name: "empty name", tenants: `[{"name":""}]`, wantErr: true},
+		{name: "incorrect name", tenants: `[{"name":"team a"}]`, wantErr: true},
+		{name: "duplicated tenant", tenants: `[{"name":"team-a"},{"name":"team-a"}]`, wantErr: true},
+		{name: "negative quota", tenants: `[{"name":"team-a","max_metrics":-1}]`, wantErr: true},
+		{name: "unknown crypto key", tenants: `[{"name":"team-a","crypto_key":"/unknown/private.pem"}]`, wantErr: true},
+		{name: "incorrect json", tenants: `{"name":"team-a"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, err := newTenantStore(writeTenants(t, tt.tenants), []byte("server-key"), nil, zap.S())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var names []string
+			for name := range ts.tenants {
+				names = append(names, name)
+			}
+			assert.ElementsMatch(t, tt.want, names)
+		})
+	}
+
+	t.Run("without tenants file", func(t *testing.T) {
+		ts, err := newTenantStore("", []byte("server-key"), nil, zap.S())
+		require.NoError(t, err)
+
+		def, err := ts.get(storage.DefaultTenant)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("server-key"), def.hashkey)
+
+		_, err = ts.get("team-a")
+		assert.ErrorIs(t, err, errUnknownTenant)
+	})
+
+	t.Run("keys of the server are used by default", func(t *testing.T) {
+		ts, err := newTenantStore(writeTenants(t, `[{"name":"team-a"},{"name":"team-b","key":"b"}]`),
+			[]byte("server-key"), nil, zap.S())
+		require.NoError(t, err)
+
+		assert.Equal(t, []byte("server-key"), ts.tenants["team-a"].hashkey)
+		assert.Equal(t, []byte("b"), ts.tenants["team-b"].hashkey)
+	})
+}
+
+func TestTenantStore_resolve(t *testing.T) {
+	ts, err := newTenantStore(writeTenants(t, `[{"name":"team-a"},{"name":"team-b"}]`), nil, nil, zap.S())
+	require.NoError(t, err)
+
+	admin := &storage.Token{Scopes: []string{scopeAdmin}}
+	writer := &storage.Token{Scopes: []string{scopeWrite}, Tenant: "team-a"}
+	defaultWriter := &storage.Token{Scopes: []string{scopeWrite}}
+
+	tests := []struct {
+		wantErr error
+		token   *storage.Token
+		name    string
+		header  string
+		want    string
+	}{
+		{name: "without token and header", want: storage.DefaultTenant},
+		{name: "without token", header: "team-a", want: "team-a"},
+		{name: "unknown tenant", header: "team-c", wantErr: errUnknownTenant},
+		{name: "token of tenant", token: writer, want: "team-a"},
+		{name: "token of tenant with its header", token: writer, header: " team-a ", want: "team-a"},
+		{name: "token of other tenant", token: writer, header: "team-b", wantErr: errTenantMismatch},
+		{name: "token of default tenant", token: defaultWriter, header: "team-b", wantErr: errTenantMismatch},
+		{name: "admin token", token: admin, header: "team-b", want: "team-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != nil {
+				ctx = withToken(ctx, tt.token)
+			}
+
+			got, err := ts.resolve(ctx, tt.header)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.name)
+		})
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	ctx := context.Background()
+
+	stg, err := storage.InitStorage(ctx, &configuration.Config{}, zap.S())
+	require.NoError(t, err)
+
+	tctx := withTenant(ctx, &tenant{name: "team-a", maxMetrics: 2})
+	_, err = stg.AddInt64Value(tctx, "requests", 1)
+	require.NoError(t, err)
+
+	assert.NoError(t, checkQuota(ctx, stg, []*metrics.Metrics{metrics.NewGaugeMetric("load", 1)}))
+	assert.NoError(t, checkQuota(tctx, stg, []*metrics.Metrics{
+		metrics.NewCounterMetric("requests", 1),
+		metrics.NewGaugeMetric("load", 1),
+		metrics.NewGaugeMetric("load", 2),
+	}))
+	assert.ErrorIs(t, checkQuota(tctx, stg, []*metrics.Metrics{
+		metrics.NewGaugeMetric("load", 1),
+		metrics.NewGaugeMetric("requests", 1),
+	}), errQuotaExceeded)
+	assert.NoError(t, checkQuota(withTenant(ctx, &tenant{name: "team-b"}), stg, []*metrics.Metrics{
+		metrics.NewGaugeMetric("load", 1),
+		metrics.NewGaugeMetric("requests", 1),
+	}))
+
+	t.Run("metrics are counted by the storage", func(t *testing.T) {
+		mstg := NewMockStorage(gomock.NewController(t))
+		mstg.EXPECT().MetricCount(gomock.Any(), []string{"requests"}, []string{"load"}).Return(2, nil)
+		mstg.EXPECT().MetricCount(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, errors.New("any error"))
+
+		ms := []*metrics.Metrics{
+			metrics.NewCounterMetric("requests", 1),
+			metrics.NewGaugeMetric("load", 1),
+			metrics.NewCounterMetric("requests", 2),
+		}
+		assert.NoError(t, checkQuota(tctx, mstg, ms))
+
+		err := checkQuota(tctx, mstg, ms)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, errQuotaExceeded)
+	})
+}
+
+func TestHTTPServer_tenants(t *testing.T) {
+	ctx := context.Background()
+
+	privateKey, publicKey := writeTestKeys(t)
+	tenants := writeTenants(t, `[
+		{"name":"team-a","key":"team-a-key","crypto_key":"`+privateKey+`","max_metrics":2},
+		{"name":"team-b"}
+	]`)
+
+	cfg := &configuration.Config{AdminToken: adminToken, Key: []byte("server-key"), Tenants: tenants}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+	srv, err := NewHTTPServer(ctx, stg, cfg, zap.S())
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv.httpServer.Handler)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	request := func(t *testing.T, method, path, token, tenant, body string) (int, string) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(authorizationHeader, bearerPrefix+token)
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.StatusCode, string(b)
+	}
+	newToken := func(t *testing.T, body string) string {
+		t.Helper()
+
+		code, resp := request(t, http.MethodPost, apiTokens, adminToken, "", body)
+		require.Equal(t, http.StatusCreated, code, resp)
+
+		var info tokenInfo
+		require.NoError(t, json.Unmarshal([]byte(resp), &info))
+
+		return info.Token
+	}
+
+	writer := newToken(t, `{"name":"agents","scopes":["write"],"tenant":"team-a"}`)
+	reader := newToken(t, `{"name":"grafana","scopes":["read"],"tenant":"team-a"}`)
+	defaultWriter := newToken(t, `{"name":"agents","scopes":["write"]}`)
+
+	agent := func(t *testing.T, cfg *configuration.ConfigAgent) *Client {
+		t.Helper()
+
+		cfg.Server = u.Host
+		c, err := NewHTTPClient(cfg, zap.S())
+		require.NoError(t, err)
+
+		return c
+	}
+
+	t.Run("agent of the default tenant", func(t *testing.T) {
+		c := agent(t, &configuration.ConfigAgent{AgentID: "web-default", APIToken: defaultWriter, Key: []byte("server-key")})
+		assert.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("load", 1)}))
+	})
+
+	t.Run("agent of the tenant", func(t *testing.T) {
+		c := agent(t, &configuration.ConfigAgent{
+			AgentID:         "web-a",
+			APIToken:        writer,
+			Tenant:          "team-a",
+			Key:             []byte("team-a-key"),
+			PublicCryptoKey: publicKey,
+		})
+		assert.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{
+			metrics.NewGaugeMetric("load", 2),
+			metrics.NewCounterMetric("requests", 1),
+		}))
+
+		err := c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("cpu", 1)})
+		var se *StatusError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, http.StatusForbidden, se.Code)
+
+		assert.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("requests", 1)}))
+	})
+
+	t.Run("agent of the tenant without the hash", func(t *testing.T) {
+		c := agent(t, &configuration.ConfigAgent{APIToken: writer, Tenant: "team-a", PublicCryptoKey: publicKey})
+		err := c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("requests", 1)})
+		var se *StatusError
+		require.ErrorAs(t, err, &se)
+		assert.Equal(t, http.StatusBadRequest, se.Code)
+
+		code, resp := request(t, http.MethodPost, "/update/counter/requests/1", writer, "team-a", "")
+		assert.Equal(t, http.StatusBadRequest, code, resp)
+	})
+
+	t.Run("agent of the default tenant without the hash", func(t *testing.T) {
+		code, resp := request(t, http.MethodPost, "/update/gauge/load/1", defaultWriter, "", "")
+		assert.Equal(t, http.StatusOK, code, resp)
+	})
+
+	t.Run("agent with the key of the server", func(t *testing.T) {
+		c := agent(t, &configuration.ConfigAgent{APIToken: writer, Key: []byte("server-key")})
+		assert.Error(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("requests", 1)}))
+	})
+
+	t.Run("metrics of the tenants are separated", func(t *testing.T) {
+		code, resp := request(t, http.MethodGet, "/value/gauge/load", reader, "", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "2", resp)
+
+		code, resp = request(t, http.MethodGet, "/value/counter/requests", reader, "", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "2", resp)
+
+		code, resp = request(t, http.MethodGet, "/value/gauge/load", adminToken, "", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "1", resp)
+
+		code, resp = request(t, http.MethodGet, "/", adminToken, "team-b", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.NotContains(t, resp, "load")
+	})
+
+	t.Run("agents of the tenants are separated", func(t *testing.T) {
+		for _, path := range []string{"/agents", "/api/agents"} {
+			code, resp := request(t, http.MethodGet, path, reader, "", "")
+			require.Equal(t, http.StatusOK, code)
+			assert.Contains(t, resp, "web-a")
+			assert.NotContains(t, resp, "web-default")
+
+			code, resp = request(t, http.MethodGet, path, adminToken, "", "")
+			require.Equal(t, http.StatusOK, code)
+			assert.Contains(t, resp, "web-default")
+			assert.NotContains(t, resp, "web-a")
+
+			code, resp = request(t, http.MethodGet, path, adminToken, "team-b", "")
+			require.Equal(t, http.StatusOK, code)
+			assert.NotContains(t, resp, "web-")
+		}
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		tenant string
+		body   string
+		want   int
+	}{
+		{name: "token of other tenant", method: http.MethodGet, path: "/", token: reader, tenant: "team-b",
+			want: http.StatusForbidden},
+		{name: "unknown tenant", method: http.MethodGet, path: "/", token: adminToken, tenant: "team-c",
+			want: http.StatusForbidden},
+		{name: "admin token of tenant", method: http.MethodPost, path: apiTokens, token: adminToken,
+			body: `{"name":"x","scopes":["admin"],"tenant":"team-a"}`, want: http.StatusBadRequest},
+		{name: "token of unknown tenant", method: http.MethodPost, path: apiTokens, token: adminToken,
+			body: `{"name":"x","scopes":["read"],"tenant":"team-c"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := request(t, tt.method, tt.path, tt.token, tt.tenant, tt.body)
+			assert.Equal(t, tt.want, code, resp)
+		})
+	}
+
+	t.Run("token list shows the tenants", func(t *testing.T) {
+		code, resp := request(t, http.MethodGet, apiTokens, adminToken, "", "")
+		require.Equal(t, http.StatusOK, code)
+		assert.Contains(t, resp, `"tenant":"team-a"`)
+	})
+}
+
+func TestGRPCServer_tenants(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := &configuration.Config{Tenants: writeTenants(t, `[{"name":"team-a","key":"team-a-key","max_metrics":1}]`)}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+
+	s, err := NewGRPCServer(stg, cfg, zap.S())
+	require.NoError(t, err)
+	RegisterMetcollServer(s.grpcServer, s.ms)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("server exited with error: %v", err)
+		}
+	}()
+	defer s.grpcServer.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	acfg := &configuration.ConfigAgent{Server: "bufnet", AgentID: "web-a", Tenant: "team-a", Key: []byte("team-a-key")}
+	c, err := NewGRPCClient(ctx, acfg, zap.S())
+	require.NoError(t, err)
+	c.conns[acfg.Server] = conn
+
+	require.NoError(t, c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("requests", 1)}))
+
+	v, err := stg.GetInt64Value(storage.WithTenant(ctx, "team-a"), "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), v)
+
+	_, err = stg.GetInt64Value(ctx, "requests")
+	assert.ErrorIs(t, err, storage.ErrNoRows)
+
+	agentList := func(t *testing.T, tenant string) []*Agent {
+		t.Helper()
+
+		md := metadata.New(headersForRequest(t, nil))
+		md.Set(tenantHeader, tenant)
+		resp, err := NewMetcollClient(conn).AgentList(metadata.NewOutgoingContext(ctx, md), &AgentListRequest{})
+		require.NoError(t, err)
+
+		return resp.GetAgents()
+	}
+	agents := agentList(t, "team-a")
+	require.Len(t, agents, 1)
+	assert.Equal(t, "web-a", agents[0].GetId())
+	assert.Empty(t, agentList(t, storage.DefaultTenant))
+
+	assert.Equal(t, codes.PermissionDenied,
+		status.Code(c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("load", 1)})))
+
+	acfg.Tenant = "team-c"
+	other, err := NewGRPCClient(ctx, acfg, zap.S())
+	require.NoError(t, err)
+	other.conns[acfg.Server] = conn
+	assert.Equal(t, codes.PermissionDenied,
+		status.Code(other.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewCounterMetric("requests", 1)})))
+}
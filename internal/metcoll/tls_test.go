@@ -0,0 +1,189 @@
+//go:build usetempdir
+// +build usetempdir
+
+package metcoll
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+	"github.com/ArtemShalinFe/metcoll/internal/storage"
+)
+
+func TestHTTPServer_TLS(t *testing.T) {
+	ctx := context.Background()
+	td := t.TempDir()
+
+	serverCert, serverKey := writeCert(t, td, "server", "server")
+	agentCert, agentKey := writeCert(t, td, "agent", "web-01")
+
+	cfg := &configuration.Config{TLSCert: serverCert, TLSKey: serverKey, TLSClientCA: agentCert}
+	stg, err := storage.InitStorage(ctx, cfg, zap.S())
+	require.NoError(t, err)
+
+	srv, err := NewHTTPServer(ctx, stg, cfg, zap.S())
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.httpServer.ServeTLS(lis, "", "") }()
+	defer func() { _ = srv.Shutdown(ctx) }()
+
+	tests := []struct {
+		name    string
+		cfg     configuration.ConfigAgent
+		wantErr bool
+	}{
+		{
+			name: "agent with certificate",
+			cfg:  configuration.ConfigAgent{TLSCA: serverCert, TLSCert: agentCert, TLSKey: agentKey},
+		},
+		{name: "agent without certificate", cfg: configuration.ConfigAgent{TLSCA: serverCert}, wantErr: true},
+		{name: "agent without TLS", cfg: configuration.ConfigAgent{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acfg := tt.cfg
+			acfg.Server = lis.Addr().String()
+
+			c, err := NewHTTPClient(&acfg, zap.S())
+			require.NoError(t, err)
+
+			err = c.BatchUpdate(ctx, []*metrics.Metrics{metrics.NewGaugeMetric("g", 1)})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestGRPCServer_mTLS(t *testing.T) {
+	ctx := context.Background()
+	td := t.TempDir()
+
+	serverCert, serverKey := writeCert(t, td, "server", "server")
+	agentCert, agentKey := writeCert(t, td, "agent", "web-01")
+
+	cfg := &configuration.Config{TLSCert: serverCert, TLSKey: serverKey, TLSClientCA: agentCert}
+	s, err := NewGRPCServer(testStorage(t), cfg, zap.S())
+	require.NoError(t, err)
+	RegisterMetcollServer(s.grpcServer, s.ms)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			t.Errorf("server exited with error: %v", err)
+		}
+	}()
+	defer s.grpcServer.Stop()
+
+	newClient := func(t *testing.T, acfg configuration.ConfigAgent) *GRPCClient {
+		t.Helper()
+
+		acfg.Server = lis.Addr().String()
+		c, err := NewGRPCClient(ctx, &acfg, zap.S())
+		require.NoError(t, err)
+		require.NoError(t, c.setupConn(ctx))
+		t.Cleanup(func() { _ = c.Close() })
+
+		return c
+	}
+	batch := []*metrics.Metrics{metrics.NewCounterMetric("c", 1)}
+
+	t.Run("certificate is the identity of the agent", func(t *testing.T) {
+		c := newClient(t, configuration.ConfigAgent{
+			AgentID: "web-01",
+			AgentIP: "10.0.0.1",
+			TLSCA:   serverCert,
+			TLSCert: agentCert,
+			TLSKey:  agentKey,
+		})
+		require.NoError(t, c.BatchUpdate(ctx, batch))
+
+		agents := s.ms.inventory.list(storage.DefaultTenant)
+		require.Len(t, agents, 1)
+		assert.Equal(t, "web-01", agents[0].ID)
+		assert.Equal(t, "127.0.0.1", agents[0].IP)
+
+		_, err := c.AgentProfile(ctx, "web-01", nil)
+		assert.ErrorIs(t, err, ErrProfileNotFound)
+	})
+
+	t.Run("agent with the certificate of other agent", func(t *testing.T) {
+		c := newClient(t, configuration.ConfigAgent{
+			AgentID: "web-02",
+			TLSCA:   serverCert,
+			TLSCert: agentCert,
+			TLSKey:  agentKey,
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(c.BatchUpdate(ctx, batch)))
+
+		_, err := c.AgentProfile(ctx, "web-02", nil)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrProfileNotFound)
+	})
+
+	t.Run("agent without certificate", func(t *testing.T) {
+		c := newClient(t, configuration.ConfigAgent{AgentID: "web-01", TLSCA: serverCert})
+		assert.Error(t, c.BatchUpdate(ctx, batch))
+	})
+}
+
+// writeCert - writes the self-signed certificate for the localhost, so it is its own CA,
+// and returns the paths to the certificate and the key.
+func writeCert(t *testing.T, dir, name, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := path.Join(dir, name+".crt")
+	keyPath := path.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
@@ -0,0 +1,133 @@
+package metcoll
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const forwardedFor = "X-Forwarded-For"
+
+// ipRanges - the IPv4 and IPv6 networks.
+type ipRanges []*net.IPNet
+
+// parseIPRanges - parses the comma separated list of the networks in the CIDR notation.
+// The single address is treated as the network of this address only, unless the classful is set:
+// then the IPv4 address means its classful network, like 192.168.31.1 means 192.168.31.0/24,
+// as the trusted subnet was set before the CIDR notation was supported.
+func parseIPRanges(s string, classful bool) (ipRanges, error) {
+	var ranges ipRanges
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or a CIDR", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			mask := net.CIDRMask(bits, bits)
+			if classful && ip.DefaultMask() != nil {
+				mask = ip.DefaultMask()
+			}
+			ranges = append(ranges, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or a CIDR, err: %w", v, err)
+		}
+		ranges = append(ranges, n)
+	}
+
+	return ranges, nil
+}
+
+func (r ipRanges) contains(ip net.IP) bool {
+	for _, n := range r {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipResolver - resolves the real address of the agent and checks that it belongs to the trusted subnets.
+type ipResolver struct {
+	subnets ipRanges
+	proxies ipRanges
+}
+
+func newIPResolver(subnets, proxies string) (*ipResolver, error) {
+	s, err := parseIPRanges(subnets, true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse trusted subnets err: %w", err)
+	}
+
+	p, err := parseIPRanges(proxies, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse trusted proxies err: %w", err)
+	}
+
+	return &ipResolver{subnets: s, proxies: p}, nil
+}
+
+// resolve - returns the address of the agent, nil is returned if the address is unknown.
+// The address of the connection is the address of the agent unless the connection comes from a trusted proxy.
+// The headers are taken into account only behind the trusted proxy: the X-Forwarded-For is read
+// from the right to the first address that is not a trusted proxy, then the X-Real-IP is used.
+// The connections that are not over IP, like the unix sockets, are local and are trusted as the proxies.
+func (r *ipResolver) resolve(peer string, forwarded []string, real string) (net.IP, error) {
+	ip := net.ParseIP(peer)
+	if ip != nil && !r.proxies.contains(ip) {
+		return ip, nil
+	}
+
+	var hops []string
+	for _, v := range forwarded {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	var forwardedIP net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+
+		forwardedIP = net.ParseIP(hop)
+		if forwardedIP == nil {
+			return nil, fmt.Errorf("%s header contains %q that is not an IP address", forwardedFor, hop)
+		}
+		if !r.proxies.contains(forwardedIP) {
+			return forwardedIP, nil
+		}
+	}
+	// every address of the X-Forwarded-For is a trusted proxy, so the first one is the closest to the agent.
+	if forwardedIP != nil {
+		return forwardedIP, nil
+	}
+
+	if real = strings.TrimSpace(real); real != "" {
+		realAddr := net.ParseIP(real)
+		if realAddr == nil {
+			return nil, fmt.Errorf("%s header contains %q that is not an IP address", realIP, real)
+		}
+		return realAddr, nil
+	}
+
+	return ip, nil
+}
+
+// allowed - checks that the address belongs to the trusted subnets, every address is allowed if they are not set.
+// The unknown address is not allowed if the trusted subnets are set.
+func (r *ipResolver) allowed(ip net.IP) bool {
+	return len(r.subnets) == 0 || r.subnets.contains(ip)
+}
@@ -0,0 +1,200 @@
+package metcoll
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranges   string
+		inside   []string
+		outside  []string
+		wantErr  bool
+		classful bool
+		wantSize int
+	}{
+		{name: "empty", ranges: "", outside: []string{"10.0.0.1"}},
+		{
+			name:     "IPv4 CIDR",
+			ranges:   "10.0.0.0/8",
+			inside:   []string{"10.0.0.1", "10.255.255.255"},
+			outside:  []string{"11.0.0.1", "::1"},
+			wantSize: 1,
+		},
+		{
+			name:     "several ranges with IPv6",
+			ranges:   "192.168.1.0/24, fd00::/8 ,2001:db8::1",
+			inside:   []string{"192.168.1.10", "fd12:3456::1", "2001:db8::1"},
+			outside:  []string{"192.168.2.10", "2001:db8::2"},
+			wantSize: 3,
+		},
+		{
+			name:     "single address",
+			ranges:   "192.168.1.10",
+			inside:   []string{"192.168.1.10", "::ffff:192.168.1.10"},
+			outside:  []string{"192.168.1.11"},
+			wantSize: 1,
+		},
+		{
+			name:     "single address of the classful network",
+			ranges:   "192.168.31.1, 10.1.2.3, 2001:db8::1",
+			classful: true,
+			inside:   []string{"192.168.31.1", "192.168.31.200", "10.200.0.1", "2001:db8::1"},
+			outside:  []string{"192.168.32.1", "11.0.0.1", "2001:db8::2"},
+			wantSize: 3,
+		},
+		{
+			name:     "CIDR is kept for the classful network",
+			ranges:   "192.168.31.128/25",
+			classful: true,
+			inside:   []string{"192.168.31.200"},
+			outside:  []string{"192.168.31.1"},
+			wantSize: 1,
+		},
+		{name: "invalid address", ranges: "10.0.0.0/8,localhost", wantErr: true},
+		{name: "invalid mask", ranges: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseIPRanges(tt.ranges, tt.classful)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, r, tt.wantSize)
+
+			for _, ip := range tt.inside {
+				assert.True(t, r.contains(net.ParseIP(ip)), ip)
+			}
+			for _, ip := range tt.outside {
+				assert.False(t, r.contains(net.ParseIP(ip)), ip)
+			}
+		})
+	}
+}
+
+func TestIPResolver_resolve(t *testing.T) {
+	r, err := newIPResolver("", "10.0.0.0/8,fd00::/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		peer      string
+		want      string
+		real      string
+		forwarded []string
+		wantErr   bool
+	}{
+		{name: "agent without proxy", peer: "192.168.1.10", want: "192.168.1.10"},
+		{name: "forged header without proxy", peer: "192.168.1.10", real: "10.1.1.1", want: "192.168.1.10"},
+		{
+			name:      "forged forwarded header without proxy",
+			peer:      "2001:db8::1",
+			forwarded: []string{"10.1.1.1"},
+			want:      "2001:db8::1",
+		},
+		{name: "agent behind proxy", peer: "10.0.0.1", real: "192.168.1.10", want: "192.168.1.10"},
+		{name: "proxy without headers", peer: "fd00::1", want: "fd00::1"},
+		{
+			name:      "chain of proxies",
+			peer:      "10.0.0.1",
+			forwarded: []string{"1.1.1.1, 192.168.1.10", "10.0.0.2"},
+			real:      "10.0.0.2",
+			want:      "192.168.1.10",
+		},
+		{name: "every hop is proxy", peer: "10.0.0.1", forwarded: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "invalid forwarded header", peer: "10.0.0.1", forwarded: []string{"unknown"}, wantErr: true},
+		{name: "invalid real header", peer: "10.0.0.1", real: "unknown", wantErr: true},
+		{name: "local connection", peer: "bufconn", real: "192.168.1.10", want: "192.168.1.10"},
+		{name: "local connection without headers", peer: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := r.resolve(tt.peer, tt.forwarded, tt.real)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if tt.want == "" {
+				assert.Nil(t, ip)
+				return
+			}
+			assert.Equal(t, tt.want, ip.String())
+		})
+	}
+}
+
+func TestHTTPServer_resolverIP(t *testing.T) {
+	r, err := newIPResolver("192.168.1.0/24,2001:db8::/32", "10.0.0.0/8")
+	require.NoError(t, err)
+
+	s := &HTTPServer{ipResolver: r, log: zap.S()}
+
+	var got string
+	h := s.resolverIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(realIP)
+	}))
+
+	tests := []struct {
+		headers    map[string]string
+		name       string
+		remoteAddr string
+		want       string
+		wantStatus int
+	}{
+		{name: "agent in trusted subnet", remoteAddr: "192.168.1.10:4000", want: "192.168.1.10", wantStatus: http.StatusOK},
+		{name: "IPv6 agent", remoteAddr: "[2001:db8::1]:4000", want: "2001:db8::1", wantStatus: http.StatusOK},
+		{
+			name:       "forged header",
+			remoteAddr: "172.16.0.1:4000",
+			headers:    map[string]string{realIP: "192.168.1.10"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "agent behind trusted proxy",
+			remoteAddr: "10.0.0.1:4000",
+			headers:    map[string]string{forwardedFor: "192.168.1.10"},
+			want:       "192.168.1.10",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid header behind trusted proxy",
+			remoteAddr: "10.0.0.1:4000",
+			headers:    map[string]string{realIP: "unknown"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", http.NoBody)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("invalid trusted subnet", func(t *testing.T) {
+		_, err := newIPResolver("10.0.0.1/8/8", "")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,2 @@
+// Package queue contains the persistent FIFO of metric batches waiting to be sent to the server.
+package queue
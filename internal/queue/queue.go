@@ -0,0 +1,567 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+const (
+	batchExt = ".batch"
+	tmpExt   = ".tmp"
+	// cursorsFile - the file with the positions of the servers in the fan-out mode.
+	cursorsFile = "cursors.json"
+
+	minRetryWait = 1 * time.Second
+	maxRetryWait = 30 * time.Second
+)
+
+// Sender - sends a batch of metrics to the server.
+type Sender interface {
+	BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error
+}
+
+// FanoutSender - the sender that sends every batch to all of its servers. The queue keeps the position
+// of every server, so the server that is down does not hold back the others and receives the batches later.
+type FanoutSender interface {
+	Sender
+	// Fanout - returns the servers, nil if the batch is sent to one of them.
+	Fanout() []string
+	// BatchUpdateTo - sends the batch only to the server.
+	BatchUpdateTo(ctx context.Context, server string, mcs []*metrics.Metrics) error
+}
+
+// permanent - reports whether the batch was rejected by all servers, such batches are not retried.
+func permanent(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !permanent(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	var p interface{ Permanent() bool }
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// unauthorized - reports whether any server has rejected the agent, the batches are kept until it is fixed.
+func unauthorized(err error) bool {
+	var u interface{ Unauthorized() bool }
+	if errors.As(err, &u) && u.Unauthorized() {
+		return true
+	}
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			if unauthorized(e) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type item struct {
+	seq  uint64
+	size int64
+}
+
+// Queue - FIFO of metric batches stored in the directory, one file per batch.
+// Batches survive agent restarts and are replayed in the order they were collected.
+// In the fan-out mode the batch is removed after it is sent to every server.
+type Queue struct {
+	mux    *sync.Mutex
+	pushed chan struct{}
+	// cursors - the sequence number of the next batch to send to every server.
+	cursors map[string]uint64
+	sl      *zap.SugaredLogger
+	dir     string
+	items   []item
+	size    int64
+	maxSize int64
+	next    uint64
+	dropped int64
+	retried int64
+	fsync   bool
+}
+
+// NewQueue - Object constructor. Batches left by the previous run are loaded from the directory.
+func NewQueue(cfg *configuration.ConfigAgent, sl *zap.SugaredLogger) (*Queue, error) {
+	const dirMode = 0700
+	if err := os.MkdirAll(cfg.QueuePath, dirMode); err != nil {
+		return nil, fmt.Errorf("cannot create queue directory, err: %w", err)
+	}
+
+	q := &Queue{
+		mux:     &sync.Mutex{},
+		pushed:  make(chan struct{}),
+		cursors: make(map[string]uint64),
+		sl:      sl,
+		dir:     cfg.QueuePath,
+		maxSize: cfg.QueueMaxSize,
+		fsync:   cfg.QueueFsync,
+	}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	if err := q.loadCursors(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *Queue) loadCursors() error {
+	b, err := os.ReadFile(filepath.Join(q.dir, cursorsFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("cannot read queue cursors, err: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &q.cursors); err != nil {
+		return fmt.Errorf("cannot unmarshal queue cursors, err: %w", err)
+	}
+
+	return nil
+}
+
+// saveCursors - saves the positions of the servers, so the batches are not sent to the server again
+// after the agent restart. The caller must hold the lock.
+func (q *Queue) saveCursors() error {
+	b, err := json.Marshal(q.cursors)
+	if err != nil {
+		return fmt.Errorf("cannot marshal queue cursors, err: %w", err)
+	}
+
+	return q.writeFile(filepath.Join(q.dir, cursorsFile), b)
+}
+
+// setCursors - sets the servers the batches are sent to. The new servers start from the head of the queue,
+// the positions of the servers that are no longer used are forgotten.
+func (q *Queue) setCursors(servers []string) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	cursors := make(map[string]uint64, len(servers))
+	for _, s := range servers {
+		cursors[s] = q.cursors[s]
+	}
+	q.cursors = cursors
+
+	if err := q.saveCursors(); err != nil {
+		q.sl.Errorf("saving the queue cursors was failed, err: %v", err)
+	}
+	if err := q.compact(); err != nil {
+		q.sl.Errorf("removing the sent batches from the queue was failed, err: %v", err)
+	}
+}
+
+func (q *Queue) load() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("cannot read queue directory, err: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, tmpExt) {
+			// the batch was not completely written before the agent stopped.
+			if err := os.Remove(filepath.Join(q.dir, name)); err != nil {
+				return fmt.Errorf("cannot remove incomplete batch %s, err: %w", name, err)
+			}
+			continue
+		}
+
+		if e.IsDir() || !strings.HasSuffix(name, batchExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchExt), 10, 64)
+		if err != nil {
+			q.sl.Infof("queue directory contains unknown file %s", name)
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("cannot get info of batch %s, err: %w", name, err)
+		}
+
+		q.items = append(q.items, item{seq: seq, size: info.Size()})
+		q.size += info.Size()
+		if seq >= q.next {
+			q.next = seq + 1
+		}
+	}
+
+	sort.Slice(q.items, func(i, j int) bool {
+		return q.items[i].seq < q.items[j].seq
+	})
+
+	if len(q.items) > 0 {
+		q.sl.Infof("queue restored %d batches", len(q.items))
+	}
+
+	return nil
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, batchExt))
+}
+
+// Push - appends the batch to the tail of the queue.
+// The oldest batches are dropped if the size of the queue exceeds the limit.
+// The error is returned only if the batch was not stored.
+func (q *Queue) Push(mcs []*metrics.Metrics) error {
+	b, err := json.Marshal(mcs)
+	if err != nil {
+		return fmt.Errorf("cannot marshal batch, err: %w", err)
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	seq := q.next
+	if err := q.write(seq, b); err != nil {
+		return err
+	}
+	q.next++
+
+	q.items = append(q.items, item{seq: seq, size: int64(len(b))})
+	q.size += int64(len(b))
+
+	for q.maxSize > 0 && q.size > q.maxSize && len(q.items) > 1 {
+		if err := q.remove(); err != nil {
+			q.sl.Errorf("cannot drop the oldest batch from the queue, err: %v", err)
+			break
+		}
+		q.dropped++
+		q.sl.Infof("queue size limit %d bytes exceeded, the oldest batch was dropped", q.maxSize)
+	}
+
+	close(q.pushed)
+	q.pushed = make(chan struct{})
+
+	return nil
+}
+
+// waitPush - returns the channel that is closed when the next batch is pushed.
+func (q *Queue) waitPush() <-chan struct{} {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.pushed
+}
+
+// write - writes the batch to the temporary file and renames it so that incomplete batches are never read.
+func (q *Queue) write(seq uint64, b []byte) error {
+	return q.writeFile(q.path(seq), b)
+}
+
+// writeFile - writes the file through the temporary one, so the file is never read incomplete.
+func (q *Queue) writeFile(name string, b []byte) error {
+	tmp := name + tmpExt
+
+	const fileMode = 0600
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileMode)
+	if err != nil {
+		return fmt.Errorf("cannot create batch file, err: %w", err)
+	}
+
+	if _, err := f.Write(b); err != nil {
+		return errors.Join(fmt.Errorf("cannot write batch file, err: %w", err), f.Close())
+	}
+
+	if q.fsync {
+		if err := f.Sync(); err != nil {
+			return errors.Join(fmt.Errorf("cannot sync batch file, err: %w", err), f.Close())
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close batch file, err: %w", err)
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("cannot rename batch file, err: %w", err)
+	}
+
+	if q.fsync {
+		return syncDir(q.dir)
+	}
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open queue directory, err: %w", err)
+	}
+
+	if err := d.Sync(); err != nil {
+		return errors.Join(fmt.Errorf("cannot sync queue directory, err: %w", err), d.Close())
+	}
+
+	if err := d.Close(); err != nil {
+		return fmt.Errorf("cannot close queue directory, err: %w", err)
+	}
+
+	return nil
+}
+
+// remove - removes the head of the queue. The caller must hold the lock.
+func (q *Queue) remove() error {
+	return q.removeAt(0)
+}
+
+// removeAt - removes the batch at the position. The caller must hold the lock.
+func (q *Queue) removeAt(i int) error {
+	it := q.items[i]
+	if err := os.Remove(q.path(it.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot remove batch file, err: %w", err)
+	}
+
+	q.items = append(q.items[:i], q.items[i+1:]...)
+	q.size -= it.size
+
+	return nil
+}
+
+// peek - returns the first batch the server has not received yet without removing it.
+func (q *Queue) peek(server string) ([]*metrics.Metrics, uint64, bool) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	cursor := q.cursors[server]
+	for i := 0; i < len(q.items); {
+		seq := q.items[i].seq
+		if seq < cursor {
+			i++
+			continue
+		}
+
+		b, err := os.ReadFile(q.path(seq))
+		if err == nil {
+			var mcs []*metrics.Metrics
+			if err = json.Unmarshal(b, &mcs); err == nil {
+				return mcs, seq, true
+			}
+		}
+
+		q.sl.Errorf("queue batch %d is unreadable and was dropped, err: %v", seq, err)
+		if err := q.removeAt(i); err != nil {
+			q.sl.Errorf("removing unreadable batch was failed, err: %v", err)
+			return nil, 0, false
+		}
+		q.dropped++
+	}
+
+	return nil, 0, false
+}
+
+// ack - moves the cursor of the server after the sent batch
+// and removes the batches that every server has received.
+func (q *Queue) ack(server string, seq uint64) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.cursors[server] <= seq {
+		q.cursors[server] = seq + 1
+	}
+
+	if server != "" {
+		if err := q.saveCursors(); err != nil {
+			return err
+		}
+	}
+
+	return q.compact()
+}
+
+// compact - removes the batches at the head that every server has received. The caller must hold the lock.
+func (q *Queue) compact() error {
+	for len(q.items) > 0 && len(q.cursors) > 0 {
+		head := q.items[0].seq
+		for _, cursor := range q.cursors {
+			if cursor <= head {
+				return nil
+			}
+		}
+
+		if err := q.remove(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len - returns the count of batches in the queue.
+func (q *Queue) Len() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return len(q.items)
+}
+
+// Dropped - returns the count of batches dropped because of the size limit, corruption, rejection
+// or the failed write.
+func (q *Queue) Dropped() int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.dropped
+}
+
+// Retried - returns the count of the failed sendings of the batches that are retried.
+func (q *Queue) Retried() int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.retried
+}
+
+// Enqueue - pushes the batches received from the channel `mcs` until the context is done.
+// The `pushed` callback is invoked after every batch stored in the queue,
+// the `undelivered` callback is invoked with the batch that cannot be stored, such batch is counted as dropped.
+func (q *Queue) Enqueue(ctx context.Context, mcs <-chan []*metrics.Metrics,
+	pushed func(), undelivered func(mcs []*metrics.Metrics)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-mcs:
+			if !ok {
+				return
+			}
+
+			if err := q.Push(m); err != nil {
+				q.sl.Errorf("cannot push batch to the queue, the batch was dropped, err: %v", err)
+
+				q.mux.Lock()
+				q.dropped++
+				q.mux.Unlock()
+
+				if undelivered != nil {
+					undelivered(m)
+				}
+				continue
+			}
+			if pushed != nil {
+				pushed()
+			}
+		}
+	}
+}
+
+// Send - sends the batches one by one in the order they were pushed until the context is done.
+// A batch is removed only after it was sent successfully or rejected by the server,
+// failed batches are retried with backoff. In the fan-out mode the batches are sent to every server
+// on its own, so the server that is down does not hold back the others.
+func (q *Queue) Send(ctx context.Context, s Sender) {
+	if fs, ok := s.(FanoutSender); ok {
+		if servers := fs.Fanout(); len(servers) > 0 {
+			q.setCursors(servers)
+
+			wg := &sync.WaitGroup{}
+			for _, server := range servers {
+				wg.Add(1)
+				go func(server string) {
+					defer wg.Done()
+					q.send(ctx, server, func(ctx context.Context, mcs []*metrics.Metrics) error {
+						return fs.BatchUpdateTo(ctx, server, mcs)
+					})
+				}(server)
+			}
+			wg.Wait()
+			return
+		}
+	}
+
+	q.setCursors([]string{""})
+	q.send(ctx, "", s.BatchUpdate)
+}
+
+// send - sends the batches the server has not received yet with `fn` until the context is done.
+// The empty server means the batches are sent by the sender to any of its servers.
+func (q *Queue) send(ctx context.Context, server string,
+	fn func(ctx context.Context, mcs []*metrics.Metrics) error) {
+	to := ""
+	if server != "" {
+		to = " to server " + server
+	}
+
+	wait := minRetryWait
+	for {
+		pushed := q.waitPush()
+		mcs, seq, ok := q.peek(server)
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pushed:
+				continue
+			}
+		}
+
+		err := fn(ctx, mcs)
+		switch {
+		case err != nil && permanent(err):
+			q.sl.Errorf("queued batch %d was rejected%s and dropped, err: %v", seq, to, err)
+
+			q.mux.Lock()
+			q.dropped++
+			q.mux.Unlock()
+		case err != nil:
+			if unauthorized(err) {
+				q.sl.Errorf("the agent is not authorized by the servers, the queued batches are kept "+
+					"until its token, keys or certificates are fixed, %d batches are waiting, err: %v", q.Len(), err)
+			} else {
+				q.sl.Errorf("sending queued batch%s was failed, %d batches are waiting, err: %v", to, q.Len(), err)
+			}
+
+			q.mux.Lock()
+			q.retried++
+			q.mux.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			wait *= 2
+			if wait > maxRetryWait {
+				wait = maxRetryWait
+			}
+			continue
+		}
+		wait = minRetryWait
+
+		if err := q.ack(server, seq); err != nil {
+			q.sl.Errorf("cannot remove sent batch from the queue, err: %v", err)
+		}
+	}
+}
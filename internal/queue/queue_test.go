@@ -0,0 +1,372 @@
+//go:build usetempdir
+// +build usetempdir
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/ArtemShalinFe/metcoll/internal/configuration"
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+func batch(delta int64) []*metrics.Metrics {
+	return []*metrics.Metrics{metrics.NewCounterMetric("PollCount", delta)}
+}
+
+type fakeSender struct {
+	mux   sync.Mutex
+	sent  []int64
+	fails int
+}
+
+func (s *fakeSender) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("server is not available")
+	}
+	s.sent = append(s.sent, *mcs[0].Delta)
+
+	return nil
+}
+
+func (s *fakeSender) Sent() []int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return append([]int64(nil), s.sent...)
+}
+
+func TestQueue_Restore(t *testing.T) {
+	cfg := &configuration.ConfigAgent{QueuePath: t.TempDir(), QueueFsync: true}
+
+	q, err := NewQueue(cfg, zap.S())
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, q.Push(batch(i)))
+	}
+
+	_, seq, ok := q.peek("")
+	require.True(t, ok)
+	require.NoError(t, q.ack("", seq))
+
+	// an incomplete batch left by the crash is ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(cfg.QueuePath, "00000000000000000009.batch.tmp"), []byte("[{"), 0600))
+
+	q, err = NewQueue(cfg, zap.S())
+	require.NoError(t, err)
+	assert.Equal(t, 2, q.Len())
+
+	require.NoError(t, q.Push(batch(4)))
+	var got []int64
+	for {
+		mcs, seq, ok := q.peek("")
+		if !ok {
+			break
+		}
+		got = append(got, *mcs[0].Delta)
+		require.NoError(t, q.ack("", seq))
+	}
+	assert.Equal(t, []int64{2, 3, 4}, got)
+}
+
+func TestQueue_DropOldest(t *testing.T) {
+	cfg := &configuration.ConfigAgent{QueuePath: t.TempDir()}
+
+	q, err := NewQueue(cfg, zap.S())
+	require.NoError(t, err)
+	require.NoError(t, q.Push(batch(1)))
+
+	q.maxSize = q.size * 2
+	for i := int64(2); i <= 4; i++ {
+		require.NoError(t, q.Push(batch(i)))
+	}
+
+	assert.Equal(t, 2, q.Len())
+	assert.Equal(t, int64(2), q.Dropped())
+
+	mcs, _, ok := q.peek("")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), *mcs[0].Delta)
+}
+
+func TestQueue_Send(t *testing.T) {
+	cfg := &configuration.ConfigAgent{QueuePath: t.TempDir()}
+
+	q, err := NewQueue(cfg, zap.S())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mcs := make(chan []*metrics.Metrics, 3)
+	for i := int64(1); i <= 3; i++ {
+		mcs <- batch(i)
+	}
+	go q.Enqueue(ctx, mcs, nil, nil)
+
+	s := &fakeSender{fails: 1}
+	go q.Send(ctx, s)
+
+	assert.Eventually(t, func() bool {
+		return len(s.Sent()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 2, 3}, s.Sent())
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueue_EnqueueFailed(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewQueue(&configuration.ConfigAgent{QueuePath: dir}, zap.S())
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chmod(dir, 0500))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0700) })
+	if os.Geteuid() == 0 {
+		// root ignores the permissions, so the directory is replaced by the file.
+		require.NoError(t, os.RemoveAll(dir))
+		require.NoError(t, os.WriteFile(dir, nil, 0400))
+		t.Cleanup(func() { _ = os.Remove(dir) })
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mcs := make(chan []*metrics.Metrics, 2)
+	for i := int64(1); i <= 2; i++ {
+		mcs <- batch(i)
+	}
+	close(mcs)
+
+	var pushed int
+	var undelivered []int64
+	q.Enqueue(ctx, mcs, func() { pushed++ }, func(mcs []*metrics.Metrics) {
+		undelivered = append(undelivered, *mcs[0].Delta)
+	})
+
+	assert.Zero(t, pushed)
+	assert.Equal(t, []int64{1, 2}, undelivered)
+	assert.Equal(t, int64(2), q.Dropped())
+	assert.Zero(t, q.Len())
+}
+
+type rejectedError struct{}
+
+func (e *rejectedError) Error() string   { return "batch was rejected" }
+func (e *rejectedError) Permanent() bool { return true }
+
+type rejectingSender struct {
+	fakeSender
+	reject int64
+}
+
+func (s *rejectingSender) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	if *mcs[0].Delta == s.reject {
+		return fmt.Errorf("server metcoll:8080: %w", &rejectedError{})
+	}
+
+	return s.fakeSender.BatchUpdate(ctx, mcs)
+}
+
+func TestQueue_SendRejected(t *testing.T) {
+	q, err := NewQueue(&configuration.ConfigAgent{QueuePath: t.TempDir()}, zap.S())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mcs := make(chan []*metrics.Metrics, 3)
+	for i := int64(1); i <= 3; i++ {
+		mcs <- batch(i)
+	}
+	go q.Enqueue(ctx, mcs, nil, nil)
+
+	s := &rejectingSender{reject: 2}
+	go q.Send(ctx, s)
+
+	assert.Eventually(t, func() bool {
+		return len(s.Sent()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 3}, s.Sent())
+	assert.Equal(t, int64(1), q.Dropped())
+	assert.Zero(t, q.Retried())
+}
+
+type unauthorizedError struct{}
+
+func (e *unauthorizedError) Error() string      { return "agent is not authorized" }
+func (e *unauthorizedError) Permanent() bool    { return false }
+func (e *unauthorizedError) Unauthorized() bool { return true }
+
+// unauthorizedSender - rejects the batches until the agent is authorized.
+type unauthorizedSender struct {
+	fakeSender
+	authorized atomic.Bool
+}
+
+func (s *unauthorizedSender) BatchUpdate(ctx context.Context, mcs []*metrics.Metrics) error {
+	if !s.authorized.Load() {
+		return fmt.Errorf("server metcoll:8080: %w", &unauthorizedError{})
+	}
+
+	return s.fakeSender.BatchUpdate(ctx, mcs)
+}
+
+func TestQueue_SendUnauthorized(t *testing.T) {
+	q, err := NewQueue(&configuration.ConfigAgent{QueuePath: t.TempDir()}, zap.S())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mcs := make(chan []*metrics.Metrics, 2)
+	for i := int64(1); i <= 2; i++ {
+		mcs <- batch(i)
+	}
+	go q.Enqueue(ctx, mcs, nil, nil)
+
+	s := &unauthorizedSender{}
+	go q.Send(ctx, s)
+
+	assert.Eventually(t, func() bool {
+		return q.Retried() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, q.Len(), "the batches are kept while the agent is not authorized")
+	assert.Zero(t, q.Dropped())
+
+	s.authorized.Store(true)
+	assert.Eventually(t, func() bool {
+		return len(s.Sent()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 2}, s.Sent())
+}
+
+func Test_unauthorized(t *testing.T) {
+	denied := fmt.Errorf("server a: %w", &unauthorizedError{})
+	rejected := fmt.Errorf("server b: %w", &rejectedError{})
+
+	assert.True(t, unauthorized(denied))
+	assert.True(t, unauthorized(errors.Join(rejected, denied)))
+	assert.False(t, unauthorized(rejected))
+	assert.False(t, permanent(errors.Join(rejected, denied)))
+}
+
+func Test_permanent(t *testing.T) {
+	rejected := fmt.Errorf("server a: %w", &rejectedError{})
+	unavailable := errors.New("server b is not available")
+
+	assert.True(t, permanent(rejected))
+	assert.True(t, permanent(errors.Join(rejected, rejected)))
+	assert.False(t, permanent(errors.Join(rejected, unavailable)))
+	assert.False(t, permanent(unavailable))
+}
+
+// fanoutSender - sends the batches to every server, the servers that are down fail.
+type fanoutSender struct {
+	fakeSender
+	down     map[string]bool
+	received map[string][]int64
+	servers  []string
+}
+
+func newFanoutSender(servers ...string) *fanoutSender {
+	return &fanoutSender{
+		servers:  servers,
+		down:     make(map[string]bool),
+		received: make(map[string][]int64),
+	}
+}
+
+func (s *fanoutSender) Fanout() []string {
+	return s.servers
+}
+
+func (s *fanoutSender) BatchUpdateTo(ctx context.Context, server string, mcs []*metrics.Metrics) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.down[server] {
+		return fmt.Errorf("server %s is not available", server)
+	}
+	s.received[server] = append(s.received[server], *mcs[0].Delta)
+
+	return nil
+}
+
+func (s *fanoutSender) setDown(server string, down bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.down[server] = down
+}
+
+func (s *fanoutSender) SentTo(server string) []int64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return append([]int64(nil), s.received[server]...)
+}
+
+func TestQueue_SendFanout(t *testing.T) {
+	cfg := &configuration.ConfigAgent{QueuePath: t.TempDir()}
+
+	q, err := NewQueue(cfg, zap.S())
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, q.Push(batch(i)))
+	}
+
+	s := newFanoutSender("a", "b")
+	s.setDown("b", true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Send(ctx, s)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(s.SentTo("a")) == 3
+	}, 5*time.Second, 10*time.Millisecond, "the server that is down does not hold back the others")
+	assert.Equal(t, []int64{1, 2, 3}, s.SentTo("a"))
+	assert.Empty(t, s.SentTo("b"))
+	assert.Equal(t, 3, q.Len(), "the batches are kept until every server has received them")
+
+	cancel()
+	<-done
+
+	t.Run("positions are restored after the restart", func(t *testing.T) {
+		q, err := NewQueue(cfg, zap.S())
+		require.NoError(t, err)
+		assert.Equal(t, 3, q.Len())
+		require.NoError(t, q.Push(batch(4)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s.setDown("b", false)
+		go q.Send(ctx, s)
+
+		assert.Eventually(t, func() bool {
+			return len(s.SentTo("b")) == 4 && q.Len() == 0
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, []int64{1, 2, 3, 4}, s.SentTo("a"), "server a does not receive the batches twice")
+		assert.Equal(t, []int64{1, 2, 3, 4}, s.SentTo("b"))
+	})
+}
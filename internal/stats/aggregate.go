@@ -0,0 +1,163 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+const (
+	AggregateMin  = "min"
+	AggregateMax  = "max"
+	AggregateAvg  = "avg"
+	AggregateLast = "last"
+)
+
+// window - the samples of the gauge polled within the report window.
+type window struct {
+	min   float64
+	max   float64
+	sum   float64
+	last  float64
+	count int
+}
+
+func (w *window) add(v float64) {
+	if w.count == 0 || v < w.min {
+		w.min = v
+	}
+	if w.count == 0 || v > w.max {
+		w.max = v
+	}
+	w.sum += v
+	w.last = v
+	w.count++
+}
+
+func (w *window) value(fn string) float64 {
+	switch fn {
+	case AggregateMin:
+		return w.min
+	case AggregateMax:
+		return w.max
+	case AggregateAvg:
+		return w.sum / float64(w.count)
+	default:
+		return w.last
+	}
+}
+
+// SetAggregations - sets the gauges that are aggregated within the report window.
+// Every rule is the gauge ID with an optional function, for example `Alloc:max`.
+// A rule without a function enables min, max and avg.
+// The aggregates are sent with the function as a suffix of the ID, for example `Alloc_max`.
+func (s *Stats) SetAggregations(rules []string) error {
+	known := make(map[string]bool)
+	for _, id := range gaugeMetrics() {
+		known[id] = true
+	}
+
+	aggregations := make(map[string][]string)
+	for _, rule := range rules {
+		id, fn, found := strings.Cut(rule, ":")
+		if !known[id] {
+			return fmt.Errorf("gauge %s cannot be aggregated", id)
+		}
+
+		if !found {
+			aggregations[id] = appendUnique(aggregations[id], AggregateMin, AggregateMax, AggregateAvg)
+			continue
+		}
+
+		switch fn {
+		case AggregateMin, AggregateMax, AggregateAvg, AggregateLast:
+			aggregations[id] = appendUnique(aggregations[id], fn)
+		default:
+			return fmt.Errorf("unknown aggregation %s of gauge %s", fn, id)
+		}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.aggregations = aggregations
+	s.windows = make(map[string]*window, len(aggregations))
+
+	return nil
+}
+
+func appendUnique(fns []string, add ...string) []string {
+	for _, a := range add {
+		found := false
+		for _, fn := range fns {
+			if fn == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fns = append(fns, a)
+		}
+	}
+
+	return fns
+}
+
+// sample - adds the current values of the aggregated gauges to the report window.
+func (s *Stats) sample(ctx context.Context) {
+	s.mux.RLock()
+	ids := make([]string, 0, len(s.aggregations))
+	for id := range s.aggregations {
+		ids = append(ids, id)
+	}
+	s.mux.RUnlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	values := make(map[string]float64, len(ids))
+	for _, id := range ids {
+		v, err := s.GetFloat64Value(ctx, id)
+		if err != nil {
+			log.Printf("an error occured while sampling gauge %s, err: %v", id, err)
+			continue
+		}
+		values[id] = v
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for id, v := range values {
+		w, ok := s.windows[id]
+		if !ok {
+			w = &window{}
+			s.windows[id] = w
+		}
+		w.add(v)
+	}
+}
+
+// aggregates - returns the aggregates of the report window and starts the next window.
+func (s *Stats) aggregates() []*metrics.Metrics {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var mcs []*metrics.Metrics
+	for id, w := range s.windows {
+		if w.count == 0 {
+			continue
+		}
+
+		for _, fn := range s.aggregations[id] {
+			mcs = append(mcs, metrics.NewGaugeMetric(id+"_"+fn, w.value(fn)))
+		}
+	}
+	s.windows = make(map[string]*window, len(s.aggregations))
+
+	return mcs
+}
@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_SetAggregations(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    map[string][]string
+		rules   []string
+		wantErr bool
+	}{
+		{
+			name:  "default functions",
+			rules: []string{Alloc, Alloc + ":last"},
+			want:  map[string][]string{Alloc: {AggregateMin, AggregateMax, AggregateAvg, AggregateLast}},
+		},
+		{
+			name:  "selected functions",
+			rules: []string{CPUutilization1 + ":max", CPUutilization1 + ":max"},
+			want:  map[string][]string{CPUutilization1: {AggregateMax}},
+		},
+		{
+			name:    "unknown gauge",
+			rules:   []string{PollCount},
+			wantErr: true,
+		},
+		{
+			name:    "unknown function",
+			rules:   []string{Alloc + ":median"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStats()
+			err := s.SetAggregations(tt.rules)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.aggregations)
+		})
+	}
+}
+
+func TestStats_aggregates(t *testing.T) {
+	s := NewStats()
+	require.NoError(t, s.SetAggregations([]string{RandomValue}))
+
+	for _, v := range []int64{4, 1, 7} {
+		s.randomValue = v
+		s.sample(context.Background())
+	}
+
+	got := make(map[string]float64)
+	for _, m := range s.aggregates() {
+		got[m.ID] = *m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"RandomValue_min": 1,
+		"RandomValue_max": 7,
+		"RandomValue_avg": 4,
+	}, got)
+
+	// the next window is empty until the next poll.
+	assert.Empty(t, s.aggregates())
+}
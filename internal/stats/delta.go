@@ -0,0 +1,103 @@
+package stats
+
+import (
+	"math"
+
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+// deltaFilter - drops the gauges that have not changed since they were sent last time.
+type deltaFilter struct {
+	sent     map[string]float64
+	absolute float64
+	relative float64
+	every    int
+	reports  int
+	resync   bool
+}
+
+// SetDeltaReporting - enables sending only the gauges whose value has changed by more than
+// the absolute or relative threshold. If both thresholds are zero, any change is sent.
+// All gauges are sent every `resyncEvery` reports and after Resync is called.
+func (s *Stats) SetDeltaReporting(absolute, relative float64, resyncEvery int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.delta = &deltaFilter{
+		sent:     make(map[string]float64),
+		absolute: absolute,
+		relative: relative,
+		every:    resyncEvery,
+		resync:   true,
+	}
+}
+
+// DisableDeltaReporting - enables sending all gauges in every report.
+func (s *Stats) DisableDeltaReporting() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.delta = nil
+}
+
+// Resync - forces sending all gauges in the next report,
+// for example when the server has lost the previously sent values.
+func (s *Stats) Resync() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.delta != nil {
+		s.delta.resync = true
+	}
+}
+
+// filterUnchanged - returns the metrics of the report that must be sent.
+func (s *Stats) filterUnchanged(mcs []*metrics.Metrics) []*metrics.Metrics {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	f := s.delta
+	if f == nil {
+		return mcs
+	}
+
+	f.reports++
+	full := f.resync || (f.every > 0 && f.reports%f.every == 0)
+	f.resync = false
+
+	res := make([]*metrics.Metrics, 0, len(mcs))
+	for _, m := range mcs {
+		if m.MType != metrics.GaugeMetric || m.Value == nil {
+			res = append(res, m)
+			continue
+		}
+
+		prev, ok := f.sent[m.ID]
+		if full || !ok || f.changed(prev, *m.Value) {
+			f.sent[m.ID] = *m.Value
+			res = append(res, m)
+		}
+	}
+
+	return res
+}
+
+func (f *deltaFilter) changed(prev, v float64) bool {
+	diff := math.Abs(v - prev)
+	if f.absolute == 0 && f.relative == 0 {
+		return diff > 0
+	}
+
+	if f.absolute > 0 && diff > f.absolute {
+		return true
+	}
+
+	if f.relative > 0 {
+		if prev == 0 {
+			return diff > 0
+		}
+		return diff/math.Abs(prev) > f.relative
+	}
+
+	return false
+}
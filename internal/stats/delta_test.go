@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+func sentIDs(mcs []*metrics.Metrics) []string {
+	ids := make([]string, 0, len(mcs))
+	for _, m := range mcs {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestStats_filterUnchanged(t *testing.T) {
+	report := func(a, b float64) []*metrics.Metrics {
+		return []*metrics.Metrics{
+			metrics.NewGaugeMetric("a", a),
+			metrics.NewGaugeMetric("b", b),
+			metrics.NewCounterMetric(PollCount, 1),
+		}
+	}
+
+	s := NewStats()
+	assert.Len(t, s.filterUnchanged(report(1, 1)), 3, "all metrics are sent if delta reporting is disabled")
+
+	s.SetDeltaReporting(0, 0.1, 4)
+
+	assert.Equal(t, []string{"a", "b", PollCount}, sentIDs(s.filterUnchanged(report(1, 100))))
+	assert.Equal(t, []string{PollCount}, sentIDs(s.filterUnchanged(report(1.05, 105))))
+	assert.Equal(t, []string{"a", "b", PollCount}, sentIDs(s.filterUnchanged(report(1.2, 111))))
+
+	// the fourth report is a full resync.
+	assert.Equal(t, []string{"a", "b", PollCount}, sentIDs(s.filterUnchanged(report(1.2, 111))))
+	assert.Equal(t, []string{PollCount}, sentIDs(s.filterUnchanged(report(1.2, 111))))
+
+	s.Resync()
+	assert.Equal(t, []string{"a", "b", PollCount}, sentIDs(s.filterUnchanged(report(1.2, 111))))
+
+	// the changed gauge was not delivered, so it is sent again although it has not changed since.
+	s.SetDeltaReporting(0, 0.1, 0)
+	assert.Len(t, s.filterUnchanged(report(1.2, 111)), 3)
+	failed := s.filterUnchanged(report(5, 111))
+	assert.Equal(t, []string{"a", PollCount}, sentIDs(failed))
+	s.Undelivered(failed)
+	assert.Equal(t, []string{"a", "b", PollCount}, sentIDs(s.filterUnchanged(report(5, 111))))
+	assert.Equal(t, []string{PollCount}, sentIDs(s.filterUnchanged(report(5, 111))))
+}
+
+func TestDeltaFilter_changed(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   deltaFilter
+		prev     float64
+		v        float64
+		expected bool
+	}{
+		{name: "any change", prev: 1, v: 1.0001, expected: true},
+		{name: "no change", prev: 1, v: 1, expected: false},
+		{name: "below absolute", filter: deltaFilter{absolute: 1}, prev: 1, v: 1.5, expected: false},
+		{name: "above absolute", filter: deltaFilter{absolute: 1}, prev: 1, v: 2.5, expected: true},
+		{name: "below relative", filter: deltaFilter{relative: 0.1}, prev: 100, v: 95, expected: false},
+		{name: "above relative", filter: deltaFilter{relative: 0.1}, prev: 100, v: 89, expected: true},
+		{name: "relative from zero", filter: deltaFilter{relative: 0.1}, prev: 0, v: 1, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.filter.changed(tt.prev, tt.v))
+		})
+	}
+}
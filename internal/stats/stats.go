@@ -52,11 +52,29 @@ const (
 	PollCount       = "PollCount"
 )
 
+// Collector - a source of additional metrics that are sent along with the runtime stats.
+type Collector interface {
+	// Collect - returns the metrics accumulated since the previous call.
+	Collect(ctx context.Context) []*metrics.Metrics
+}
+
 type Stats struct {
-	mux         *sync.RWMutex
-	memStats    *runtime.MemStats
-	pollCount   int64
-	randomValue int64 // timestamp
+	mux          *sync.RWMutex
+	memStats     *runtime.MemStats
+	aggregations map[string][]string
+	windows      map[string]*window
+	delta        *deltaFilter
+	// undelivered - the counter deltas of the collectors from the batches that were not delivered,
+	// they are added to the next report.
+	undelivered map[string]int64
+	collectors  []Collector
+	// retired - collectors removed since the last report, their remaining metrics are sent once more.
+	retired        []Collector
+	pollCount      int64
+	randomValue    int64 // timestamp
+	dropped        int64
+	pollInterval   time.Duration
+	reportInterval time.Duration
 }
 
 func NewStats() *Stats {
@@ -68,60 +86,203 @@ func NewStats() *Stats {
 	}
 }
 
-func (s *Stats) RunCollectBatchStats(ctx context.Context,
-	cfg *configuration.ConfigAgent, ms chan<- []*metrics.Metrics) {
-	pauseUpdate := time.Duration(cfg.PollInterval) * time.Second
-	pauseCollect := time.Duration(cfg.ReportInterval) * time.Second
+// AddCollector - registers an additional source of metrics for the report.
+func (s *Stats) AddCollector(c Collector) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 
-	go s.update(pauseUpdate)
-	go s.batchCollect(ctx, pauseCollect, ms)
+	s.collectors = append(s.collectors, c)
 }
 
-func (s *Stats) update(pause time.Duration) {
-	if pause == 0 {
+// RemoveCollector - unregisters the source of metrics.
+// The metrics it has accumulated are sent with the next report.
+func (s *Stats) RemoveCollector(c Collector) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for i, rc := range s.collectors {
+		if rc == c {
+			s.collectors = append(s.collectors[:i:i], s.collectors[i+1:]...)
+			s.retired = append(s.retired, c)
+			return
+		}
+	}
+}
+
+// SetIntervals - sets the poll and report intervals, the new values are used from the next iteration.
+func (s *Stats) SetIntervals(cfg *configuration.ConfigAgent) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.pollInterval = time.Duration(cfg.PollInterval) * time.Second
+	s.reportInterval = time.Duration(cfg.ReportInterval) * time.Second
+}
+
+func (s *Stats) intervals() (time.Duration, time.Duration) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	poll, report := s.pollInterval, s.reportInterval
+	if poll == 0 {
 		const defaultPause = 2 * time.Second
-		pause = defaultPause
+		poll = defaultPause
+	}
+	if report == 0 {
+		const defaultPause = 10 * time.Second
+		report = defaultPause
 	}
 
-	for {
-		s.mux.Lock()
+	return poll, report
+}
+
+func (s *Stats) RunCollectBatchStats(ctx context.Context,
+	cfg *configuration.ConfigAgent, ms chan<- []*metrics.Metrics) {
+	s.SetIntervals(cfg)
 
-		runtime.ReadMemStats(s.memStats)
-		s.randomValue = time.Now().Unix()
-		s.pollCount++
+	go s.update()
+	go s.batchCollect(ctx, ms)
+}
 
-		s.mux.Unlock()
+func (s *Stats) update() {
+	for {
+		s.poll(context.Background())
 
+		pause, _ := s.intervals()
 		time.Sleep(pause)
 	}
 }
 
-func (s *Stats) batchCollect(ctx context.Context, pause time.Duration, ms chan<- []*metrics.Metrics) {
-	if pause == 0 {
-		const defaultPause = 10 * time.Second
-		pause = defaultPause
+func (s *Stats) poll(ctx context.Context) {
+	s.mux.Lock()
+
+	runtime.ReadMemStats(s.memStats)
+	s.randomValue = time.Now().Unix()
+	s.pollCount++
+
+	s.mux.Unlock()
+
+	s.sample(ctx)
+}
+
+// CollectBatch - polls the runtime stats once and returns the report batch.
+// It is used instead of RunCollectBatchStats when a single batch is needed.
+func (s *Stats) CollectBatch(ctx context.Context) []*metrics.Metrics {
+	s.poll(ctx)
+
+	return s.batch(ctx)
+}
+
+func (s *Stats) batch(ctx context.Context) []*metrics.Metrics {
+	var mcs []*metrics.Metrics
+	for _, data := range s.GetReportData(ctx) {
+		for _, metric := range data {
+			mcs = append(mcs, metric)
+		}
 	}
+	mcs = append(mcs, s.aggregates()...)
+	mcs = append(mcs, s.collect(ctx)...)
+	mcs = s.addUndelivered(mcs)
 
+	return s.filterUnchanged(mcs)
+}
+
+func (s *Stats) batchCollect(ctx context.Context, ms chan<- []*metrics.Metrics) {
 	for {
-		var mcs []*metrics.Metrics
-		for _, data := range s.GetReportData(ctx) {
-			for _, metric := range data {
-				mcs = append(mcs, metric)
-			}
-		}
+		mcs := s.batch(ctx)
 
 		select {
 		case <-ctx.Done():
 			return
 		case ms <- mcs:
 		default:
+			s.mux.Lock()
+			s.dropped++
+			s.mux.Unlock()
+			s.Undelivered(mcs)
 		}
 
+		_, pause := s.intervals()
 		time.Sleep(pause)
 	}
 }
 
+func (s *Stats) collect(ctx context.Context) []*metrics.Metrics {
+	s.mux.Lock()
+	collectors := make([]Collector, 0, len(s.collectors)+len(s.retired))
+	collectors = append(collectors, s.collectors...)
+	collectors = append(collectors, s.retired...)
+	s.retired = nil
+	s.mux.Unlock()
+
+	var mcs []*metrics.Metrics
+	for _, c := range collectors {
+		mcs = append(mcs, c.Collect(ctx)...)
+	}
+
+	return mcs
+}
+
+// Dropped - returns the count of the reports dropped because the previous ones were not sent yet.
+func (s *Stats) Dropped() int64 {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.dropped
+}
+
+// Undelivered - keeps the counter deltas of the batch that was not delivered, they are sent with the next report.
+// The collectors reset their counters when they are collected, so the deltas would be lost otherwise.
+// The poll count is kept until it is delivered, so it is not added again.
+// The gauges of the batch were marked as sent by the delta reporting, so all gauges are sent with the next report.
+func (s *Stats) Undelivered(mcs []*metrics.Metrics) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.delta != nil {
+		s.delta.resync = true
+	}
+
+	for _, m := range mcs {
+		if m.MType != metrics.CounterMetric || m.Delta == nil || m.ID == PollCount {
+			continue
+		}
+
+		if s.undelivered == nil {
+			s.undelivered = make(map[string]int64)
+		}
+		s.undelivered[m.ID] += *m.Delta
+	}
+}
+
+// addUndelivered - adds the counter deltas of the undelivered batches to the batch.
+func (s *Stats) addUndelivered(mcs []*metrics.Metrics) []*metrics.Metrics {
+	s.mux.Lock()
+	undelivered := s.undelivered
+	s.undelivered = nil
+	s.mux.Unlock()
+
+	for _, m := range mcs {
+		if m.MType != metrics.CounterMetric || m.Delta == nil {
+			continue
+		}
+
+		if delta, ok := undelivered[m.ID]; ok {
+			sum := *m.Delta + delta
+			m.Delta = &sum
+			delete(undelivered, m.ID)
+		}
+	}
+	for id, delta := range undelivered {
+		mcs = append(mcs, metrics.NewCounterMetric(id, delta))
+	}
+
+	return mcs
+}
+
 func (s *Stats) ClearPollCount() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	s.pollCount = 0
 }
 
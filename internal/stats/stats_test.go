@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ArtemShalinFe/metcoll/internal/metrics"
+)
+
+// onceCollector - returns its metrics on the first call only, like the collectors that reset their counters.
+type onceCollector struct {
+	mcs []*metrics.Metrics
+}
+
+func (c *onceCollector) Collect(_ context.Context) []*metrics.Metrics {
+	mcs := c.mcs
+	c.mcs = nil
+	return mcs
+}
+
+func counters(mcs []*metrics.Metrics) map[string]int64 {
+	res := make(map[string]int64)
+	for _, m := range mcs {
+		if m.MType == metrics.CounterMetric {
+			res[m.ID] = *m.Delta
+		}
+	}
+	return res
+}
+
+func TestStats_Undelivered(t *testing.T) {
+	ctx := context.Background()
+
+	c := &onceCollector{mcs: []*metrics.Metrics{
+		metrics.NewCounterMetric("errors", 2),
+		metrics.NewCounterMetric("lines", 5),
+	}}
+
+	s := NewStats()
+	s.AddCollector(c)
+	s.poll(ctx)
+
+	failed := s.batch(ctx)
+	assert.Equal(t, map[string]int64{"errors": 2, "lines": 5, PollCount: 1}, counters(failed))
+	s.Undelivered(failed)
+
+	c.mcs = []*metrics.Metrics{metrics.NewCounterMetric("errors", 1)}
+	s.poll(ctx)
+	assert.Equal(t, map[string]int64{"errors": 3, "lines": 5, PollCount: 2}, counters(s.batch(ctx)),
+		"the deltas of the undelivered batch are sent with the next one")
+
+	assert.Equal(t, map[string]int64{PollCount: 2}, counters(s.batch(ctx)),
+		"the delivered deltas are not sent again")
+}
@@ -53,6 +53,10 @@ func newSQLStorage(ctx context.Context, dataSourceName string, logger *zap.Sugar
 
 	logger.Infof("successfully created tables in database")
 
+	if err := db.migrateTenants(ctx); err != nil {
+		return nil, fmt.Errorf("failed to migrate the tables to the tenants: %w", err)
+	}
+
 	return db, nil
 }
 
@@ -77,6 +81,17 @@ func (db *DB) createTables(ctx context.Context) error {
 			return fmt.Errorf("cannot create table for gauges metric err : %w", err)
 		}
 
+		q = `CREATE TABLE IF NOT EXISTS tokens (
+			id text PRIMARY KEY,
+			hash text UNIQUE NOT NULL,
+			name text NOT NULL,
+			scopes text[] NOT NULL,
+			prefixes text[] NOT NULL,
+			created_at timestamptz NOT NULL);`
+		if err = retryExec(ctx, tx, q); err != nil {
+			return fmt.Errorf("cannot create table for tokens err : %w", err)
+		}
+
 		return nil
 	}()
 
@@ -90,6 +105,42 @@ func (db *DB) createTables(ctx context.Context) error {
 	return nil
 }
 
+// migrateTenants - adds the tenant to the metrics and the tokens. The metrics saved before the tenants
+// were introduced get the default tenant, so the single-tenant deployments keep their data.
+// The statements do nothing if the tables have already been migrated.
+func (db *DB) migrateTenants(ctx context.Context) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction for migrating tables err : %w", err)
+	}
+
+	defer func() {
+		commitTransaction(ctx, tx, db.logger)
+	}()
+
+	queries := []string{
+		`ALTER TABLE counters
+			ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '',
+			DROP CONSTRAINT IF EXISTS counters_pkey;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS counters_tenant_id ON counters (tenant, id);`,
+		`ALTER TABLE gauges
+			ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '',
+			DROP CONSTRAINT IF EXISTS gauges_pkey;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS gauges_tenant_id ON gauges (tenant, id);`,
+		`ALTER TABLE tokens ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '';`,
+	}
+	for _, q := range queries {
+		if err := retryExec(ctx, tx, q); err != nil {
+			if rerr := retryRollback(ctx, tx); rerr != nil {
+				return fmt.Errorf(txRollbackFailed, rerr)
+			}
+			return fmt.Errorf("tx rollbacked, "+execQuerryError, q, err)
+		}
+	}
+
+	return nil
+}
+
 func (db *DB) GetInt64Value(ctx context.Context, key string) (int64, error) {
 	tx, err := db.pool.Begin(ctx)
 	if err != nil {
@@ -101,8 +152,8 @@ func (db *DB) GetInt64Value(ctx context.Context, key string) (int64, error) {
 	}()
 
 	val, err := func() (int64, error) {
-		q := `SELECT value FROM counters WHERE id = $1`
-		val, err := retryQueryRowInt64(ctx, tx, q, key)
+		q := `SELECT value FROM counters WHERE tenant = $1 AND id = $2`
+		val, err := retryQueryRowInt64(ctx, tx, q, TenantFromContext(ctx), key)
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return 0, ErrNoRows
@@ -133,8 +184,8 @@ func (db *DB) GetFloat64Value(ctx context.Context, key string) (float64, error)
 	}()
 
 	val, err := func() (float64, error) {
-		q := `SELECT delta FROM gauges WHERE id = $1`
-		val, err := retryQueryRowFloat64(ctx, tx, q, key)
+		q := `SELECT delta FROM gauges WHERE tenant = $1 AND id = $2`
+		val, err := retryQueryRowFloat64(ctx, tx, q, TenantFromContext(ctx), key)
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return 0, ErrNoRows
@@ -167,13 +218,13 @@ func (db *DB) AddInt64Value(ctx context.Context, key string, value int64) (int64
 	val, err := func() (int64, error) {
 		q := `
 		INSERT 
-			INTO counters (id, value) 
-			VALUES ($1, $2)
-		ON CONFLICT (id) 
+			INTO counters (tenant, id, value) 
+			VALUES ($1, $2, $3)
+		ON CONFLICT (tenant, id) 
 			DO UPDATE SET value = EXCLUDED.value + counters.value
 		RETURNING value`
 
-		val, err := retryQueryRowInt64(ctx, tx, q, key, value)
+		val, err := retryQueryRowInt64(ctx, tx, q, TenantFromContext(ctx), key, value)
 		if err != nil {
 			return 0, fmt.Errorf(execQuerryError, q, err)
 		}
@@ -202,13 +253,13 @@ func (db *DB) SetFloat64Value(ctx context.Context, key string, value float64) (f
 	val, err := func() (float64, error) {
 		q := `
 		INSERT 
-			INTO gauges (id, delta) 
-			VALUES ($1, $2)
-		ON CONFLICT (id) 
-			DO UPDATE SET delta = $2
+			INTO gauges (tenant, id, delta) 
+			VALUES ($1, $2, $3)
+		ON CONFLICT (tenant, id) 
+			DO UPDATE SET delta = $3
 		RETURNING delta`
 
-		val, err := retryQueryRowFloat64(ctx, tx, q, key, value)
+		val, err := retryQueryRowFloat64(ctx, tx, q, TenantFromContext(ctx), key, value)
 		if err != nil {
 			return 0, fmt.Errorf(execQuerryError, q, err)
 		}
@@ -242,15 +293,16 @@ func (db *DB) BatchSetFloat64Value(ctx context.Context,
 
 		sqlStatement := `
 		INSERT 
-			INTO gauges (id, delta) 
-			VALUES ($1, $2)
-		ON CONFLICT (id) 
-			DO UPDATE SET delta = $2
+			INTO gauges (tenant, id, delta) 
+			VALUES ($1, $2, $3)
+		ON CONFLICT (tenant, id) 
+			DO UPDATE SET delta = $3
 		RETURNING id, delta`
 
+		tenant := TenantFromContext(ctx)
 		idMap := make(map[int]string)
 		for gauge, delta := range gauges {
-			batch.Queue(sqlStatement, gauge, delta)
+			batch.Queue(sqlStatement, tenant, gauge, delta)
 			idMap[batch.Len()-1] = gauge
 		}
 
@@ -300,15 +352,16 @@ func (db *DB) BatchAddInt64Value(ctx context.Context,
 
 		sqlStatement := `
 		INSERT 
-			INTO counters (id, value) 
-			VALUES ($1, $2)
-		ON CONFLICT (id) 
+			INTO counters (tenant, id, value) 
+			VALUES ($1, $2, $3)
+		ON CONFLICT (tenant, id) 
 			DO UPDATE SET value = EXCLUDED.value + counters.value
 		RETURNING id, value`
 
+		tenant := TenantFromContext(ctx)
 		idMap := make(map[int]string)
 		for counter, value := range counters {
-			batch.Queue(sqlStatement, counter, value)
+			batch.Queue(sqlStatement, tenant, counter, value)
 			idMap[batch.Len()-1] = counter
 		}
 
@@ -350,8 +403,8 @@ func (db *DB) getAllDataInt64(ctx context.Context) (map[string]int64, error) {
 	}()
 
 	dataInt64, err := func() (map[string]int64, error) {
-		q := `SELECT id, value FROM counters;`
-		r, err := retryQuery(ctx, tx, q)
+		q := `SELECT id, value FROM counters WHERE tenant = $1;`
+		r, err := retryQuery(ctx, tx, q, TenantFromContext(ctx))
 		if err != nil {
 			return nil, fmt.Errorf(execQuerryError, q, err)
 		}
@@ -398,8 +451,8 @@ func (db *DB) getAllDataFloat64(ctx context.Context) (map[string]float64, error)
 	}()
 
 	dataFloat64, err := func() (map[string]float64, error) {
-		q := `SELECT id, delta FROM gauges;`
-		r, err := retryQuery(ctx, tx, q)
+		q := `SELECT id, delta FROM gauges WHERE tenant = $1;`
+		r, err := retryQuery(ctx, tx, q, TenantFromContext(ctx))
 		if err != nil {
 			return nil, fmt.Errorf(execQuerryError, q, err)
 		}
@@ -462,6 +515,159 @@ func (db *DB) GetDataList(ctx context.Context) ([]string, error) {
 	return list, nil
 }
 
+func (db *DB) MetricCount(ctx context.Context, counters []string, gauges []string) (int, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf(txStartFailed, err)
+	}
+	defer func() {
+		commitTransaction(ctx, tx, db.logger)
+	}()
+
+	q := `SELECT
+		(SELECT count(*) FROM counters WHERE tenant = $1) +
+		(SELECT count(*) FROM gauges WHERE tenant = $1) +
+		(SELECT count(*) FROM unnest($2::text[]) AS n(id)
+			WHERE NOT EXISTS (SELECT 1 FROM counters c WHERE c.tenant = $1 AND c.id = n.id)) +
+		(SELECT count(*) FROM unnest($3::text[]) AS n(id)
+			WHERE NOT EXISTS (SELECT 1 FROM gauges g WHERE g.tenant = $1 AND g.id = n.id));`
+	count, err := retryQueryRowInt64(ctx, tx, q, TenantFromContext(ctx), counters, gauges)
+	if err != nil {
+		if err = retryRollback(ctx, tx); err != nil {
+			return 0, fmt.Errorf(txRollbackFailed, err)
+		}
+		return 0, fmt.Errorf(execQuerryError, q, err)
+	}
+
+	return int(count), nil
+}
+
+func (db *DB) AddToken(ctx context.Context, token *Token) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf(txStartFailed, err)
+	}
+	defer func() {
+		commitTransaction(ctx, tx, db.logger)
+	}()
+
+	q := `INSERT INTO tokens (id, hash, name, scopes, prefixes, tenant, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7);`
+	prefixes := token.Prefixes
+	if prefixes == nil {
+		prefixes = []string{}
+	}
+	if err := retryExec(ctx, tx, q,
+		token.ID, token.Hash, token.Name, token.Scopes, prefixes, token.Tenant, token.CreatedAt); err != nil {
+		if rerr := retryRollback(ctx, tx); rerr != nil {
+			return fmt.Errorf(txRollbackFailed, rerr)
+		}
+		return fmt.Errorf("tx rollbacked, add token err: %w", err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetToken(ctx context.Context, hash string) (*Token, error) {
+	tokens, err := db.queryTokens(ctx,
+		`SELECT id, hash, name, scopes, prefixes, tenant, created_at FROM tokens WHERE hash = $1;`, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, ErrNoRows
+	}
+
+	return tokens[0], nil
+}
+
+func (db *DB) TokenList(ctx context.Context) ([]*Token, error) {
+	return db.queryTokens(ctx, `SELECT id, hash, name, scopes, prefixes, tenant, created_at FROM tokens ORDER BY created_at, id;`)
+}
+
+func (db *DB) queryTokens(ctx context.Context, q string, args ...any) ([]*Token, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf(txStartFailed, err)
+	}
+	defer func() {
+		commitTransaction(ctx, tx, db.logger)
+	}()
+
+	tokens, err := func() ([]*Token, error) {
+		r, err := retryQuery(ctx, tx, q, args...)
+		if err != nil {
+			return nil, fmt.Errorf(execQuerryError, q, err)
+		}
+		defer r.Close()
+
+		var tokens []*Token
+		for r.Next() {
+			var t Token
+			if err := r.Scan(&t.ID, &t.Hash, &t.Name, &t.Scopes, &t.Prefixes, &t.Tenant, &t.CreatedAt); err != nil {
+				return nil, fmt.Errorf("get tokens err: %w", err)
+			}
+			if len(t.Prefixes) == 0 {
+				t.Prefixes = nil
+			}
+
+			tokens = append(tokens, &t)
+		}
+
+		if err := r.Err(); err != nil {
+			return nil, fmt.Errorf("get tokens iteration err: %w", err)
+		}
+
+		return tokens, nil
+	}()
+
+	if err != nil {
+		if rerr := retryRollback(ctx, tx); rerr != nil {
+			return nil, fmt.Errorf(txRollbackFailed, rerr)
+		}
+		return nil, fmt.Errorf("tx rollbacked, get tokens err: %w", err)
+	}
+
+	return tokens, nil
+}
+
+func (db *DB) DeleteToken(ctx context.Context, id string) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf(txStartFailed, err)
+	}
+	defer func() {
+		commitTransaction(ctx, tx, db.logger)
+	}()
+
+	err = func() error {
+		q := `DELETE FROM tokens WHERE id = $1 RETURNING id;`
+		r, err := retryQuery(ctx, tx, q, id)
+		if err != nil {
+			return fmt.Errorf(execQuerryError, q, err)
+		}
+		deleted := r.Next()
+		r.Close()
+		if err := r.Err(); err != nil {
+			return fmt.Errorf("delete token err: %w", err)
+		}
+		if !deleted {
+			return ErrNoRows
+		}
+
+		return nil
+	}()
+
+	if err != nil && !errors.Is(err, ErrNoRows) {
+		if rerr := retryRollback(ctx, tx); rerr != nil {
+			return fmt.Errorf(txRollbackFailed, rerr)
+		}
+		return fmt.Errorf("tx rollbacked, delete token err: %w", err)
+	}
+
+	return err
+}
+
 func (db *DB) Interrupt() error {
 	db.pool.Close()
 	return nil
@@ -474,10 +680,10 @@ func (db *DB) Ping(ctx context.Context) error {
 	return nil
 }
 
-func retryExec(ctx context.Context, tx pgx.Tx, sql string) error {
+func retryExec(ctx context.Context, tx pgx.Tx, sql string, args ...any) error {
 	if err := retry.Do(
 		func() error {
-			if _, err := tx.Exec(ctx, sql); err != nil {
+			if _, err := tx.Exec(ctx, sql, args...); err != nil {
 				return fmt.Errorf("exec querry was failed, err: %w", err)
 			}
 			return nil
@@ -610,13 +816,13 @@ func retryBatchResultQueryRowInt64(ctx context.Context, results pgx.BatchResults
 	return id, val, nil
 }
 
-func retryQuery(ctx context.Context, tx pgx.Tx, sql string) (pgx.Rows, error) {
+func retryQuery(ctx context.Context, tx pgx.Tx, sql string, args ...any) (pgx.Rows, error) {
 	var rows pgx.Rows
 	var err error
 
 	if err = retry.Do(
 		func() error {
-			rows, err = tx.Query(ctx, sql)
+			rows, err = tx.Query(ctx, sql, args...)
 			if err != nil {
 				return fmt.Errorf("tx query err: %w", err)
 			}
@@ -3,8 +3,10 @@ package storage
 import (
 	"context"
 	"errors"
+	"reflect"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/pashagolub/pgxmock/v2"
@@ -27,10 +29,13 @@ func TestDB_createTables(t *testing.T) {
 		WillReturnResult(pgxmock.NewResult("CREATE", 1))
 	mock.ExpectExec("CREATE TABLE IF NOT EXISTS gauges (.+)").
 		WillReturnResult(pgxmock.NewResult("CREATE", 1))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS tokens (.+)").
+		WillReturnResult(pgxmock.NewResult("CREATE", 1))
 	mock.ExpectCommit()
 
 	const cgq = "CREATE TABLE IF NOT EXISTS gauges"
 	const ccq = "CREATE TABLE IF NOT EXISTS counters"
+	const ctq = "CREATE TABLE IF NOT EXISTS tokens"
 
 	mock.ExpectBegin()
 	mock.ExpectExec(ccq).
@@ -39,6 +44,8 @@ func TestDB_createTables(t *testing.T) {
 		WillReturnResult(pgxmock.NewResult("CREATE", 1))
 	mock.ExpectExec(cgq).
 		WillReturnResult(pgxmock.NewResult("CREATE", 1))
+	mock.ExpectExec(ctq).
+		WillReturnResult(pgxmock.NewResult("CREATE", 1))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
@@ -53,6 +60,8 @@ func TestDB_createTables(t *testing.T) {
 		WillReturnError(syscall.ECONNREFUSED)
 	mock.ExpectExec(cgq).
 		WillReturnResult(pgxmock.NewResult("CREATE", 1))
+	mock.ExpectExec(ctq).
+		WillReturnResult(pgxmock.NewResult("CREATE", 1))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
@@ -176,19 +185,19 @@ func TestDB_GetInt64Value(t *testing.T) {
 	var sqc = "SELECT value FROM counters"
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(sqc).WithArgs("keyOne").WillReturnRows(mock.NewRows([]string{"value"}).AddRow(int64(1)))
+	mock.ExpectQuery(sqc).WithArgs(DefaultTenant, "keyOne").WillReturnRows(mock.NewRows([]string{"value"}).AddRow(int64(1)))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(sqc).WithArgs("keyTwo").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(sqc).WithArgs(DefaultTenant, "keyTwo").WillReturnError(pgx.ErrNoRows)
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(sqc).WithArgs("keyTwo").WillReturnError(errors.New("bad querry"))
+	mock.ExpectQuery(sqc).WithArgs(DefaultTenant, "keyTwo").WillReturnError(errors.New("bad querry"))
 	mock.ExpectRollback()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(sqc).WithArgs("keyTwo").WillReturnError(errors.New("bad syntax querry"))
+	mock.ExpectQuery(sqc).WithArgs(DefaultTenant, "keyTwo").WillReturnError(errors.New("bad syntax querry"))
 	mock.ExpectRollback().WillReturnError(errors.New("fail rollback"))
 
 	type fields struct {
@@ -309,22 +318,22 @@ func TestDB_GetFloat64Value(t *testing.T) {
 	mock.ExpectBegin().WillReturnError(errors.New("just error"))
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(gaugeOne).
+	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(DefaultTenant, gaugeOne).
 		WillReturnRows(mock.NewRows([]string{"delta"}).AddRow(float64(1.1)))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(gaugeTwo).
+	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(DefaultTenant, gaugeTwo).
 		WillReturnError(pgx.ErrNoRows)
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(gaugeTwo).
+	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(DefaultTenant, gaugeTwo).
 		WillReturnError(errors.New("bad querry"))
 	mock.ExpectRollback()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(gaugeTwo).
+	mock.ExpectQuery("SELECT delta FROM gauges").WithArgs(DefaultTenant, gaugeTwo).
 		WillReturnError(errors.New("bad querry"))
 	mock.ExpectRollback().WillReturnError(errors.New("fail rollback"))
 
@@ -445,21 +454,21 @@ func TestDB_GetDataList(t *testing.T) {
 	const gq = "SELECT id, delta FROM gauges"
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(gq).
+	mock.ExpectQuery(gq).WithArgs(DefaultTenant).
 		WillReturnRows(mock.NewRows([]string{"id", "delta"}).AddRow(gaugeOne, float64(1.1)).AddRow("gaugeTwo", float64(1.2)))
 	mock.ExpectCommit()
 
 	const cq = "SELECT id, value FROM counters"
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(cq).
+	mock.ExpectQuery(cq).WithArgs(DefaultTenant).
 		WillReturnRows(mock.NewRows([]string{"id", "value"}).AddRow(counterOne, int64(1)).AddRow("counterTwo", int64(2)))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin().WillReturnError(errors.New("transaction begin error"))
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(gq).
+	mock.ExpectQuery(gq).WithArgs(DefaultTenant).
 		WillReturnRows(mock.NewRows([]string{"id", "delta"}).AddRow(gaugeOne, float64(1.1)).AddRow("gaugeTwo", float64(1.2)))
 	mock.ExpectCommit()
 
@@ -554,17 +563,17 @@ func TestDB_AddInt64Value(t *testing.T) {
 	const iq = "INSERT (.+)"
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(iq).WithArgs(counterOne, int64(1)).
+	mock.ExpectQuery(iq).WithArgs(DefaultTenant, counterOne, int64(1)).
 		WillReturnRows(mock.NewRows([]string{"value"}).AddRow(int64(2)))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(iq).WithArgs("counterTwo", int64(1)).
+	mock.ExpectQuery(iq).WithArgs(DefaultTenant, "counterTwo", int64(1)).
 		WillReturnError(errors.New("insert errors"))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(iq).WithArgs("counterThree", int64(3)).
+	mock.ExpectQuery(iq).WithArgs(DefaultTenant, "counterThree", int64(3)).
 		WillReturnError(errors.New("bad querry"))
 	mock.ExpectRollback().WillReturnError(errors.New("fail rollback"))
 
@@ -673,17 +682,17 @@ func TestDB_SetFloat64Value(t *testing.T) {
 	const iq = "INSERT (.+)"
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(iq).WithArgs("gaugeOne", float64(1.1)).
+	mock.ExpectQuery(iq).WithArgs(DefaultTenant, "gaugeOne", float64(1.1)).
 		WillReturnRows(mock.NewRows([]string{"value"}).AddRow(float64(1.1)))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(iq).WithArgs("gaugeTwo", float64(1.2)).
+	mock.ExpectQuery(iq).WithArgs(DefaultTenant, "gaugeTwo", float64(1.2)).
 		WillReturnError(errors.New("some insert errors"))
 	mock.ExpectCommit()
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(iq).WithArgs("gaugeThree", float64(1.3)).
+	mock.ExpectQuery(iq).WithArgs(DefaultTenant, "gaugeThree", float64(1.3)).
 		WillReturnError(errors.New("bad querry"))
 	mock.ExpectRollback().WillReturnError(errors.New("fail rollback"))
 
@@ -880,3 +889,199 @@ func TestDB_Interrupt(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestDB_MetricCount(t *testing.T) {
+	ctx := context.Background()
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	db := &DB{pool: mock, logger: zap.L().Sugar()}
+	counters := []string{counterOne}
+	gauges := []string{gaugeOne, gaugeTwo}
+
+	const q = "SELECT (.+) FROM counters WHERE tenant (.+) FROM gauges WHERE tenant"
+
+	t.Run("count", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(q).WithArgs("team-a", counters, gauges).
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(int64(5)))
+		mock.ExpectCommit()
+
+		got, err := db.MetricCount(WithTenant(ctx, "team-a"), counters, gauges)
+		if err != nil {
+			t.Errorf("DB.MetricCount() error = %v", err)
+		}
+		if got != 5 {
+			t.Errorf("DB.MetricCount() = %v, want %v", got, 5)
+		}
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(q).WithArgs(DefaultTenant, counters, gauges).WillReturnError(errors.New("bad querry"))
+		mock.ExpectRollback()
+
+		if _, err := db.MetricCount(ctx, counters, gauges); err == nil {
+			t.Error("DB.MetricCount() error is expected")
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDB_Tokens(t *testing.T) {
+	ctx := context.Background()
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	db := &DB{pool: mock, logger: zap.L().Sugar()}
+	token := &Token{
+		ID:        "a1",
+		Name:      "grafana",
+		Hash:      "hash",
+		Scopes:    []string{"read"},
+		Prefixes:  []string{"web."},
+		Tenant:    "team-a",
+		CreatedAt: time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+	columns := []string{"id", "hash", "name", "scopes", "prefixes", "tenant", "created_at"}
+
+	t.Run("add token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO tokens").
+			WithArgs(token.ID, token.Hash, token.Name, token.Scopes, token.Prefixes, token.Tenant, token.CreatedAt).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectCommit()
+
+		if err := db.AddToken(ctx, token); err != nil {
+			t.Errorf("DB.AddToken() error = %v", err)
+		}
+	})
+
+	t.Run("get token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM tokens WHERE hash").WithArgs("hash").
+			WillReturnRows(mock.NewRows(columns).
+				AddRow(token.ID, token.Hash, token.Name, token.Scopes, token.Prefixes, token.Tenant, token.CreatedAt))
+		mock.ExpectCommit()
+
+		got, err := db.GetToken(ctx, "hash")
+		if err != nil {
+			t.Fatalf("DB.GetToken() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, token) {
+			t.Errorf("DB.GetToken() = %v, want %v", got, token)
+		}
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM tokens WHERE hash").WithArgs("unknown").
+			WillReturnRows(mock.NewRows(columns))
+		mock.ExpectCommit()
+
+		if _, err := db.GetToken(ctx, "unknown"); !errors.Is(err, ErrNoRows) {
+			t.Errorf("DB.GetToken() error = %v, want %v", err, ErrNoRows)
+		}
+	})
+
+	t.Run("failed token list", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM tokens ORDER BY").WillReturnError(errors.New("bad querry"))
+		mock.ExpectRollback()
+
+		if _, err := db.TokenList(ctx); err == nil {
+			t.Error("DB.TokenList() error = nil, want error")
+		}
+	})
+
+	t.Run("delete token", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM tokens").WithArgs("a1").
+			WillReturnRows(mock.NewRows([]string{"id"}).AddRow("a1"))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM tokens").WithArgs("a1").
+			WillReturnRows(mock.NewRows([]string{"id"}))
+		mock.ExpectCommit()
+
+		if err := db.DeleteToken(ctx, "a1"); err != nil {
+			t.Errorf("DB.DeleteToken() error = %v", err)
+		}
+		if err := db.DeleteToken(ctx, "a1"); !errors.Is(err, ErrNoRows) {
+			t.Errorf("DB.DeleteToken() error = %v, want %v", err, ErrNoRows)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDB_migrateTenants(t *testing.T) {
+	ctx := context.Background()
+
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+
+	db := &DB{pool: mock, logger: zap.L().Sugar()}
+
+	t.Run("positive case", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE counters").WillReturnResult(pgxmock.NewResult("ALTER", 0))
+		mock.ExpectExec("CREATE UNIQUE INDEX IF NOT EXISTS counters_tenant_id").
+			WillReturnResult(pgxmock.NewResult("CREATE", 0))
+		mock.ExpectExec("ALTER TABLE gauges").WillReturnResult(pgxmock.NewResult("ALTER", 0))
+		mock.ExpectExec("CREATE UNIQUE INDEX IF NOT EXISTS gauges_tenant_id").
+			WillReturnResult(pgxmock.NewResult("CREATE", 0))
+		mock.ExpectExec("ALTER TABLE tokens").WillReturnResult(pgxmock.NewResult("ALTER", 0))
+		mock.ExpectCommit()
+
+		if err := db.migrateTenants(ctx); err != nil {
+			t.Errorf("DB.migrateTenants() error = %v", err)
+		}
+	})
+
+	t.Run("negative case", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("ALTER TABLE counters").WillReturnError(errors.New("permission denied"))
+		mock.ExpectRollback()
+
+		if err := db.migrateTenants(ctx); err == nil {
+			t.Error("DB.migrateTenants() error = nil, want error")
+		}
+	})
+
+	t.Run("metrics of the tenant", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT value FROM counters WHERE tenant").WithArgs("team-a", counterOne).
+			WillReturnRows(mock.NewRows([]string{"value"}).AddRow(int64(7)))
+		mock.ExpectCommit()
+
+		got, err := db.GetInt64Value(WithTenant(ctx, "team-a"), counterOne)
+		if err != nil {
+			t.Fatalf("DB.GetInt64Value() error = %v", err)
+		}
+		if got != 7 {
+			t.Errorf("DB.GetInt64Value() = %v, want %v", got, 7)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
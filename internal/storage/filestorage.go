@@ -103,6 +103,31 @@ func (fs *Filestorage) SetFloat64Value(ctx context.Context, key string, value fl
 	return newValue, nil
 }
 
+// AddToken - saves the token, the state is saved at once regardless of the store interval,
+// because the tokens are changed rarely and the lost token cannot be restored.
+func (fs *Filestorage) AddToken(ctx context.Context, token *Token) error {
+	if err := fs.MemStorage.AddToken(ctx, token); err != nil {
+		return fmt.Errorf("cannot add token in filestorage err: %w", err)
+	}
+
+	if err := fs.Save(fs.MemStorage); err != nil {
+		return fmt.Errorf("synchronous saving token to file storage cannot be performed err: %w", err)
+	}
+	return nil
+}
+
+// DeleteToken - deletes the token, the state is saved at once like in AddToken.
+func (fs *Filestorage) DeleteToken(ctx context.Context, id string) error {
+	if err := fs.MemStorage.DeleteToken(ctx, id); err != nil {
+		return fmt.Errorf("cannot delete token in filestorage err: %w", err)
+	}
+
+	if err := fs.Save(fs.MemStorage); err != nil {
+		return fmt.Errorf("synchronous saving token deletion to file storage cannot be performed err: %w", err)
+	}
+	return nil
+}
+
 func (fs *Filestorage) Save(storage *MemStorage) error {
 	const fileMode = 0666
 	file, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_CREATE, fileMode)
@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"sync"
 )
 
 // MemStorage - implementation of a in-memory database for storing metrics.
+// The metrics of the default tenant are kept in the storage itself, every other tenant has its own storage.
 type MemStorage struct {
 	mutex       *sync.Mutex
 	dataInt64   map[string]int64
 	dataFloat64 map[string]float64
+	tokens      map[string]*Token
+	tenants     map[string]*MemStorage
 }
 
 func newMemStorage() *MemStorage {
@@ -20,12 +24,48 @@ func newMemStorage() *MemStorage {
 		mutex:       &sync.Mutex{},
 		dataInt64:   make(map[string]int64),
 		dataFloat64: make(map[string]float64),
+		tokens:      make(map[string]*Token),
+		tenants:     make(map[string]*MemStorage),
 	}
 
 	return ms
 }
 
-func (ms *MemStorage) GetInt64Value(_ context.Context, key string) (int64, error) {
+// partition - returns the storage of the tenant of the context, the storage is created on the first access.
+func (ms *MemStorage) partition(ctx context.Context) *MemStorage {
+	tenant := TenantFromContext(ctx)
+	if tenant == DefaultTenant {
+		return ms
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	p, ok := ms.tenants[tenant]
+	if !ok {
+		p = newMemStorage()
+		ms.tenants[tenant] = p
+	}
+
+	return p
+}
+
+// tenantList - returns the tenants that have the own storage.
+func (ms *MemStorage) tenantList() []string {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	tenants := make([]string, 0, len(ms.tenants))
+	for t := range ms.tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+
+	return tenants
+}
+
+func (ms *MemStorage) GetInt64Value(ctx context.Context, key string) (int64, error) {
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -37,7 +77,8 @@ func (ms *MemStorage) GetInt64Value(_ context.Context, key string) (int64, error
 	return v, nil
 }
 
-func (ms *MemStorage) GetFloat64Value(_ context.Context, key string) (float64, error) {
+func (ms *MemStorage) GetFloat64Value(ctx context.Context, key string) (float64, error) {
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -48,7 +89,8 @@ func (ms *MemStorage) GetFloat64Value(_ context.Context, key string) (float64, e
 	return v, nil
 }
 
-func (ms *MemStorage) AddInt64Value(_ context.Context, key string, value int64) (int64, error) {
+func (ms *MemStorage) AddInt64Value(ctx context.Context, key string, value int64) (int64, error) {
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -62,7 +104,8 @@ func (ms *MemStorage) AddInt64Value(_ context.Context, key string, value int64)
 	return newValue, nil
 }
 
-func (ms *MemStorage) SetFloat64Value(_ context.Context, key string, value float64) (float64, error) {
+func (ms *MemStorage) SetFloat64Value(ctx context.Context, key string, value float64) (float64, error) {
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -71,14 +114,16 @@ func (ms *MemStorage) SetFloat64Value(_ context.Context, key string, value float
 	return value, nil
 }
 
-func (ms *MemStorage) getAllDataInt64(_ context.Context) (map[string]int64, error) { //nolint // for compatibility with the interface
+func (ms *MemStorage) getAllDataInt64(ctx context.Context) (map[string]int64, error) { //nolint // for compatibility with the interface
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
 	return ms.dataInt64, nil
 }
 
-func (ms *MemStorage) getAllDataFloat64(_ context.Context) (map[string]float64, error) { //nolint // for compatibility with the interface
+func (ms *MemStorage) getAllDataFloat64(ctx context.Context) (map[string]float64, error) { //nolint // for compatibility with the interface
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -112,6 +157,26 @@ func (ms *MemStorage) GetDataList(ctx context.Context) ([]string, error) {
 	return list, nil
 }
 
+func (ms *MemStorage) MetricCount(ctx context.Context, counters []string, gauges []string) (int, error) {
+	ms = ms.partition(ctx)
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	count := len(ms.dataInt64) + len(ms.dataFloat64)
+	for _, id := range counters {
+		if _, ok := ms.dataInt64[id]; !ok {
+			count++
+		}
+	}
+	for _, id := range gauges {
+		if _, ok := ms.dataFloat64[id]; !ok {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
 func (ms *MemStorage) GetState() ([]byte, error) {
 	b, err := json.Marshal(&ms)
 	if err != nil {
@@ -147,6 +212,22 @@ func (ms *MemStorage) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	for tenant, v := range state["tenants"] {
+		if err := ms.partition(WithTenant(ctx, tenant)).UnmarshalJSON([]byte(v)); err != nil {
+			return fmt.Errorf("memory storage set tenant %s state err: %w", tenant, err)
+		}
+	}
+
+	for id, v := range state["tokens"] {
+		var t Token
+		if err := json.Unmarshal([]byte(v), &t); err != nil {
+			return fmt.Errorf("memory storage unmarshal token %s err: %w", id, err)
+		}
+		if err := ms.AddToken(ctx, &t); err != nil {
+			return fmt.Errorf("memory storage set token err: %w", err)
+		}
+	}
+
 	stateInt64 := state["int64"]
 	for k, v := range stateInt64 {
 		pv, err := strconv.ParseInt(v, 10, 64)
@@ -190,6 +271,42 @@ func (ms *MemStorage) MarshalJSON() ([]byte, error) {
 	state["float64"] = float64map
 	state["int64"] = int64map
 
+	tokens, err := ms.TokenList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// the tokens are saved as the json strings, so the state of the storage without the tokens is not changed.
+	if len(tokens) > 0 {
+		state["tokens"] = make(map[string]string, len(tokens))
+		for _, t := range tokens {
+			b, err := json.Marshal(t)
+			if err != nil {
+				return nil, fmt.Errorf("memory storage marshal token err: %w", err)
+			}
+			state["tokens"][t.ID] = string(b)
+		}
+	}
+
+	// the state of every tenant is saved like the state of the storage, the tenant without the metrics is skipped.
+	for _, tenant := range ms.tenantList() {
+		p := ms.partition(WithTenant(ctx, tenant))
+		p.mutex.Lock()
+		empty := len(p.dataInt64) == 0 && len(p.dataFloat64) == 0
+		p.mutex.Unlock()
+		if empty {
+			continue
+		}
+
+		b, err := p.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("memory storage marshal tenant %s err: %w", tenant, err)
+		}
+		if state["tenants"] == nil {
+			state["tenants"] = make(map[string]string)
+		}
+		state["tenants"][tenant] = string(b)
+	}
+
 	b, err := json.Marshal(state)
 	if err != nil {
 		return nil, fmt.Errorf("memory storage marshal err: %w", err)
@@ -198,8 +315,9 @@ func (ms *MemStorage) MarshalJSON() ([]byte, error) {
 	return b, nil
 }
 
-func (ms *MemStorage) BatchSetFloat64Value(_ context.Context,
+func (ms *MemStorage) BatchSetFloat64Value(ctx context.Context,
 	gauges map[string]float64) (map[string]float64, []error, error) {
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -210,8 +328,9 @@ func (ms *MemStorage) BatchSetFloat64Value(_ context.Context,
 	return gauges, errs, nil
 }
 
-func (ms *MemStorage) BatchAddInt64Value(_ context.Context,
+func (ms *MemStorage) BatchAddInt64Value(ctx context.Context,
 	counters map[string]int64) (map[string]int64, []error, error) {
+	ms = ms.partition(ctx)
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
@@ -228,6 +347,62 @@ func (ms *MemStorage) BatchAddInt64Value(_ context.Context,
 	return counters, errs, nil
 }
 
+func (ms *MemStorage) AddToken(_ context.Context, token *Token) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	t := *token
+	ms.tokens[t.ID] = &t
+
+	return nil
+}
+
+func (ms *MemStorage) GetToken(_ context.Context, hash string) (*Token, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, t := range ms.tokens {
+		if t.Hash == hash {
+			token := *t
+			return &token, nil
+		}
+	}
+
+	return nil, ErrNoRows
+}
+
+func (ms *MemStorage) TokenList(_ context.Context) ([]*Token, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	tokens := make([]*Token, 0, len(ms.tokens))
+	for _, t := range ms.tokens {
+		token := *t
+		tokens = append(tokens, &token)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].ID < tokens[j].ID
+		}
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+func (ms *MemStorage) DeleteToken(_ context.Context, id string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, ok := ms.tokens[id]; !ok {
+		return ErrNoRows
+	}
+	delete(ms.tokens, id)
+
+	return nil
+}
+
 func (ms *MemStorage) Interrupt() error {
 	return nil
 }
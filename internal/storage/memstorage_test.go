@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,6 +15,8 @@ func TestNewMemStorage(t *testing.T) {
 		mutex:       &sync.Mutex{},
 		dataInt64:   make(map[string]int64),
 		dataFloat64: make(map[string]float64),
+		tokens:      make(map[string]*Token),
+		tenants:     make(map[string]*MemStorage),
 	}
 
 	t.Run("Test mem storage constructor", func(t *testing.T) {
@@ -209,3 +212,111 @@ func TestGetSetState(t *testing.T) {
 
 	assert.Equal(t, tsb, ts)
 }
+
+func TestMemStorage_Tokens(t *testing.T) {
+	ctx := context.Background()
+
+	ms := newMemStorage()
+	created := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	writer := &Token{ID: "b", Name: "agents", Hash: "hash-b", Scopes: []string{"write"}, CreatedAt: created}
+	reader := &Token{
+		ID:        "a",
+		Name:      "grafana",
+		Hash:      "hash-a",
+		Scopes:    []string{"read"},
+		Prefixes:  []string{"web."},
+		CreatedAt: created.Add(time.Hour),
+	}
+	assert.NoError(t, ms.AddToken(ctx, writer))
+	assert.NoError(t, ms.AddToken(ctx, reader))
+
+	got, err := ms.GetToken(ctx, "hash-a")
+	assert.NoError(t, err)
+	assert.Equal(t, reader, got)
+
+	_, err = ms.GetToken(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNoRows)
+
+	list, err := ms.TokenList(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Token{writer, reader}, list)
+
+	t.Run("tokens are saved in the state", func(t *testing.T) {
+		b, err := ms.GetState()
+		assert.NoError(t, err)
+
+		restored := newMemStorage()
+		assert.NoError(t, restored.SetState(b))
+
+		list, err := restored.TokenList(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []*Token{writer, reader}, list)
+	})
+
+	t.Run("delete token", func(t *testing.T) {
+		assert.NoError(t, ms.DeleteToken(ctx, "b"))
+		assert.ErrorIs(t, ms.DeleteToken(ctx, "b"), ErrNoRows)
+
+		_, err := ms.GetToken(ctx, "hash-b")
+		assert.ErrorIs(t, err, ErrNoRows)
+	})
+}
+
+func TestMemStorage_Tenants(t *testing.T) {
+	ctx := context.Background()
+	teamA := WithTenant(ctx, "team-a")
+
+	ms := newMemStorage()
+	_, err := ms.AddInt64Value(ctx, "requests", 1)
+	assert.NoError(t, err)
+	_, err = ms.AddInt64Value(teamA, "requests", 5)
+	assert.NoError(t, err)
+	_, _, err = ms.BatchSetFloat64Value(teamA, map[string]float64{"load": 0.5})
+	assert.NoError(t, err)
+
+	v, err := ms.GetInt64Value(ctx, "requests")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), v)
+
+	v, err = ms.GetInt64Value(teamA, "requests")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), v)
+
+	_, err = ms.GetFloat64Value(ctx, "load")
+	assert.ErrorIs(t, err, ErrNoRows)
+
+	list, err := ms.GetDataList(teamA)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"requests 5", "load 0.5"}, list)
+
+	list, err = ms.GetDataList(WithTenant(ctx, "team-b"))
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+
+	t.Run("metrics of the tenant are counted", func(t *testing.T) {
+		count, err := ms.MetricCount(teamA, []string{"requests", "errors"}, []string{"load", "requests"})
+		assert.NoError(t, err)
+		assert.Equal(t, 4, count)
+
+		count, err = ms.MetricCount(ctx, nil, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("tenants are saved in the state", func(t *testing.T) {
+		b, err := ms.GetState()
+		assert.NoError(t, err)
+		assert.NotContains(t, string(b), "team-b")
+
+		restored := newMemStorage()
+		assert.NoError(t, restored.SetState(b))
+
+		list, err := restored.GetDataList(teamA)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"requests 5", "load 0.5"}, list)
+
+		list, err = restored.GetDataList(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"requests 1"}, list)
+	})
+}
@@ -36,6 +36,10 @@ type Storage interface {
 	//	...
 	GetDataList(ctx context.Context) ([]string, error)
 
+	// MetricCount - returns the count of the metrics of the tenant after the counters and gauges are saved,
+	// the metrics that are not saved yet are counted as new.
+	MetricCount(ctx context.Context, counters []string, gauges []string) (int, error)
+
 	// BatchSetFloat64Value - Batch saving of metric values.
 	// Returns the set metric values and errors for those metrics whose values could not be set.
 	BatchSetFloat64Value(ctx context.Context, gauges map[string]float64) (map[string]float64, []error, error)
@@ -44,6 +48,8 @@ type Storage interface {
 	// Returns the set metric values and errors for those metrics whose values could not be set.
 	BatchAddInt64Value(ctx context.Context, counters map[string]int64) (map[string]int64, []error, error)
 
+	TokenStorage
+
 	// Interrupt - function for gracefull shutdown.
 	Interrupt() error
 
@@ -0,0 +1,24 @@
+package storage
+
+import "context"
+
+// DefaultTenant - the tenant of the requests that do not name a tenant.
+// The metrics of the default tenant are stored the same way as before the tenants were introduced,
+// so the single-tenant deployments keep their data.
+const DefaultTenant = ""
+
+type tenantKey struct{}
+
+// WithTenant - returns the context in which the storage reads and writes the metrics of the tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext - returns the tenant of the context, the default tenant if it is not set.
+func TenantFromContext(ctx context.Context) string {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		return DefaultTenant
+	}
+	return tenant
+}
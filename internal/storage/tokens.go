@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Token - the API token of the server. The token itself is not stored, only its SHA-256 hash.
+type Token struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	// Tenant - the tenant whose metrics the token accesses, the default tenant if it is empty.
+	Tenant string `json:"tenant,omitempty"`
+	// Scopes - the scopes granted to the token: read, write or admin.
+	Scopes []string `json:"scopes"`
+	// Prefixes - the prefixes of the metric IDs the token is allowed to access, every metric if it is empty.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+type TokenStorage interface {
+	// AddToken - saves the token.
+	AddToken(ctx context.Context, token *Token) error
+
+	// GetToken - returns the token with the hash or ErrNoRows if it does not exist.
+	GetToken(ctx context.Context, hash string) (*Token, error)
+
+	// TokenList - returns all saved tokens ordered by the creation time.
+	TokenList(ctx context.Context) ([]*Token, error)
+
+	// DeleteToken - deletes the token with the ID or returns ErrNoRows if it does not exist.
+	DeleteToken(ctx context.Context, id string) error
+}
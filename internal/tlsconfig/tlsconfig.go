@@ -0,0 +1,328 @@
+// Package tlsconfig is used to build the TLS configurations of the servers and the agent.
+// The certificates and the CA bundles are read again when the files change,
+// so they are renewed without the restart.
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// NewServer - returns the TLS configuration of the server with the certificate and the key from the files.
+// If the clientCA is set, the clients have to present a certificate signed by one of its CAs.
+// If the crlFile is set, the client certificates revoked by its CRLs are rejected.
+func NewServer(certFile, keyFile, clientCA, crlFile string, sl *zap.SugaredLogger) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS certificate and TLS key must be set together")
+	}
+
+	cert, err := newCertFile(certFile, keyFile, sl)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.get(), nil
+		},
+	}
+
+	if clientCA == "" {
+		if crlFile != "" {
+			return nil, errors.New("CRL is set without the CA bundle of the clients")
+		}
+		return cfg, nil
+	}
+
+	ca, err := newCAFile(clientCA, sl)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if crlFile != "" {
+		crl, err := newCRLFile(crlFile, sl)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return checkRevoked(cs.VerifiedChains, crl.get())
+		}
+	}
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = ca.get()
+		return c, nil
+	}
+
+	return cfg, nil
+}
+
+// Client - the TLS configuration of the agent. The configuration is built for every server,
+// because the certificate of the server is verified against the host the agent connects to.
+type Client struct {
+	cfg *tls.Config
+	ca  *watchedFile[*x509.CertPool]
+}
+
+// NewClient - returns the TLS configuration of the agent.
+// The servers are verified with the CA bundle if it is set, otherwise with the system roots.
+// The serverName replaces the host of the server in the verification if it is set.
+// The client certificate is presented to the servers if the certFile is set.
+func NewClient(caFile, certFile, keyFile, serverName string, sl *zap.SugaredLogger) (*Client, error) {
+	c := &Client{
+		cfg: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: serverName,
+		},
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("TLS certificate and TLS key must be set together")
+		}
+
+		cert, err := newCertFile(certFile, keyFile, sl)
+		if err != nil {
+			return nil, err
+		}
+
+		c.cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.get(), nil
+		}
+	}
+
+	if caFile == "" {
+		return c, nil
+	}
+
+	ca, err := newCAFile(caFile, sl)
+	if err != nil {
+		return nil, err
+	}
+	c.ca = ca
+
+	return c, nil
+}
+
+// Config - returns the TLS configuration to connect to the server at the address.
+// The certificate of the server is verified against the server name if it is set, otherwise against the host
+// of the address, the IP addresses are checked against the IP SANs of the certificate.
+func (c *Client) Config(addr string) *tls.Config {
+	cfg := c.cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = hostOf(addr)
+	}
+
+	if c.ca == nil {
+		return cfg
+	}
+
+	// the client has no hook to replace the roots on every handshake,
+	// so the chain is verified here against the CA bundle read at the moment.
+	// The name is taken from the configuration, because the connection state
+	// has no server name if the server is dialed by the IP address.
+	name := cfg.ServerName
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		return verifyServer(cs, name, c.ca.get())
+	}
+
+	return cfg
+}
+
+// hostOf - returns the host of the address, the address may be set without the port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]")
+	}
+	return host
+}
+
+// verifyServer - verifies the certificate chain of the server and its name, like the default verification does.
+func verifyServer(cs tls.ConnectionState, name string, roots *x509.CertPool) error {
+	if name == "" {
+		return errors.New("server name is not set, the server certificate cannot be verified")
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server has not presented a certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("server certificate verification was failed, err: %w", err)
+	}
+
+	return nil
+}
+
+// checkRevoked - returns the error if any certificate of the verified chains is revoked
+// by the CRL of its issuer. The CRLs that are not signed by the issuer are ignored.
+func checkRevoked(chains [][]*x509.Certificate, crls []*x509.RevocationList) error {
+	for _, chain := range chains {
+		for i := 0; i+1 < len(chain); i++ {
+			cert, issuer := chain[i], chain[i+1]
+
+			for _, crl := range crls {
+				if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+					continue
+				}
+
+				for _, revoked := range crl.RevokedCertificates {
+					if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+						return fmt.Errorf("certificate %q is revoked", cert.Subject.CommonName)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchedFile - the value loaded from the files, it is loaded again when any file changes.
+// If the changed files cannot be loaded, the previous value is kept.
+type watchedFile[T any] struct {
+	value   T
+	mux     *sync.Mutex
+	sl      *zap.SugaredLogger
+	load    func() (T, error)
+	version string
+	paths   []string
+}
+
+func newWatchedFile[T any](sl *zap.SugaredLogger, load func() (T, error), paths ...string) (*watchedFile[T], error) {
+	w := &watchedFile[T]{
+		mux:   &sync.Mutex{},
+		sl:    sl,
+		load:  load,
+		paths: paths,
+	}
+
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// get - returns the value, the files are loaded again if they have changed since the last reading.
+func (w *watchedFile[T]) get() T {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.reload(); err != nil {
+		w.sl.Errorf("TLS files %v were not reloaded, err: %v", w.paths, err)
+	}
+
+	return w.value
+}
+
+func (w *watchedFile[T]) reload() error {
+	var version string
+	for _, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("cannot stat TLS file err: %w", err)
+		}
+		version += fmt.Sprintf("%d-%d;", info.ModTime().UnixNano(), info.Size())
+	}
+
+	if version == w.version {
+		return nil
+	}
+
+	value, err := w.load()
+	if err != nil {
+		return err
+	}
+
+	w.value = value
+	w.version = version
+	w.sl.Infof("TLS files %v were loaded", w.paths)
+
+	return nil
+}
+
+func newCertFile(certFile, keyFile string, sl *zap.SugaredLogger) (*watchedFile[*tls.Certificate], error) {
+	return newWatchedFile(sl, func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS certificate err: %w", err)
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+}
+
+func newCAFile(caFile string, sl *zap.SugaredLogger) (*watchedFile[*x509.CertPool], error) {
+	return newWatchedFile(sl, func() (*x509.CertPool, error) {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle err: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("CA bundle %s contains no certificates", caFile)
+		}
+		return pool, nil
+	}, caFile)
+}
+
+// newCRLFile - loads the CRLs in the PEM or the DER form, the PEM file may contain the CRLs of several CAs.
+func newCRLFile(crlFile string, sl *zap.SugaredLogger) (*watchedFile[[]*x509.RevocationList], error) {
+	return newWatchedFile(sl, func() ([]*x509.RevocationList, error) {
+		b, err := os.ReadFile(crlFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CRL file err: %w", err)
+		}
+
+		if !bytes.Contains(b, []byte("-----BEGIN")) {
+			crl, err := x509.ParseRevocationList(b)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse CRL err: %w", err)
+			}
+			return []*x509.RevocationList{crl}, nil
+		}
+
+		var crls []*x509.RevocationList
+		for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "X509 CRL" {
+				continue
+			}
+
+			crl, err := x509.ParseRevocationList(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse CRL err: %w", err)
+			}
+			crls = append(crls, crl)
+		}
+		if len(crls) == 0 {
+			return nil, fmt.Errorf("CRL file %s contains no CRLs", crlFile)
+		}
+
+		return crls, nil
+	}, crlFile)
+}
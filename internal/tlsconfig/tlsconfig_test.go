@@ -0,0 +1,369 @@
+//go:build usetempdir
+// +build usetempdir
+
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewServer(t *testing.T) {
+	td := t.TempDir()
+
+	serverCert, serverKey := writeCert(t, td, "server", "server")
+	agentCert, agentKey := writeCert(t, td, "agent", "agent")
+	otherCert, otherKey := writeCert(t, td, "other", "other")
+
+	scfg, err := NewServer(serverCert, serverKey, agentCert, "", zap.S())
+	require.NoError(t, err)
+
+	url := serveTLS(t, scfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+
+	tests := []struct {
+		name       string
+		ca         string
+		cert       string
+		key        string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "agent with certificate", ca: serverCert, cert: agentCert, key: agentKey},
+		{name: "server name is set", ca: serverCert, cert: agentCert, key: agentKey, serverName: "localhost"},
+		{name: "agent without certificate", ca: serverCert, wantErr: true},
+		{name: "agent with unknown certificate", ca: serverCert, cert: otherCert, key: otherKey, wantErr: true},
+		{name: "server signed by unknown CA", ca: otherCert, cert: agentCert, key: agentKey, wantErr: true},
+		{name: "wrong server name", ca: serverCert, cert: agentCert, key: agentKey, serverName: "metcoll", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ccfg, err := NewClient(tt.ca, tt.cert, tt.key, tt.serverName, zap.S())
+			require.NoError(t, err)
+
+			resp, err := get(ccfg, url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "agent", resp.Header.Get("X-Client"))
+		})
+	}
+
+	t.Run("incomplete configuration", func(t *testing.T) {
+		_, err := NewServer(serverCert, "", "", "", zap.S())
+		assert.Error(t, err)
+
+		_, err = NewClient("", agentCert, "", "", zap.S())
+		assert.Error(t, err)
+
+		_, err = NewServer(serverCert, serverKey, path.Join(td, "missing.pem"), "", zap.S())
+		assert.Error(t, err)
+	})
+}
+
+func TestNewServer_CRL(t *testing.T) {
+	td := t.TempDir()
+
+	serverCert, serverKey := writeCert(t, td, "server", "server")
+	ca := newCert(t, "agents", nil)
+	caFile, _ := ca.write(t, td, "ca")
+
+	web01 := newCert(t, "web-01", ca)
+	web02 := newCert(t, "web-02", ca)
+	web01Cert, web01Key := web01.write(t, td, "web-01")
+	web02Cert, web02Key := web02.write(t, td, "web-02")
+
+	crlFile := writeCRL(t, td, ca)
+
+	scfg, err := NewServer(serverCert, serverKey, caFile, crlFile, zap.S())
+	require.NoError(t, err)
+	url := serveTLS(t, scfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(cert, key string) error {
+		ccfg, err := NewClient(serverCert, cert, key, "", zap.S())
+		require.NoError(t, err)
+
+		_, err = get(ccfg, url)
+		return err
+	}
+
+	require.NoError(t, request(web01Cert, web01Key))
+	require.NoError(t, request(web02Cert, web02Key))
+
+	t.Run("revoked agent is rejected without restart", func(t *testing.T) {
+		writeCRL(t, td, ca, web01)
+
+		assert.Error(t, request(web01Cert, web01Key))
+		assert.NoError(t, request(web02Cert, web02Key))
+	})
+
+	t.Run("CRL of other CA is ignored", func(t *testing.T) {
+		other := newCert(t, "agents", nil)
+		writeCRL(t, td, other, web01, web02)
+
+		assert.NoError(t, request(web02Cert, web02Key))
+	})
+
+	t.Run("CRL without the CA bundle", func(t *testing.T) {
+		_, err := NewServer(serverCert, serverKey, "", crlFile, zap.S())
+		assert.Error(t, err)
+	})
+}
+
+func TestNewClient_ServerAddress(t *testing.T) {
+	td := t.TempDir()
+
+	ca := newCert(t, "ca", nil)
+	caFile, _ := ca.write(t, td, "ca")
+
+	// the certificate is signed by the trusted CA, but it is issued for the other host.
+	other := newCertFor(t, "server", ca, []string{"metcoll"}, net.IPv4(10, 9, 9, 9))
+	serverCert, serverKey := other.write(t, td, "server")
+
+	scfg, err := NewServer(serverCert, serverKey, "", "", zap.S())
+	require.NoError(t, err)
+	url := serveTLS(t, scfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		serverName string
+		wantErr    bool
+	}{
+		{name: "IP address is not in the certificate", wantErr: true},
+		{name: "server name is in the certificate", serverName: "metcoll"},
+		{name: "IP address of the server name is in the certificate", serverName: "10.9.9.9"},
+		{name: "server name is not in the certificate", serverName: "localhost", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ccfg, err := NewClient(caFile, "", "", tt.serverName, zap.S())
+			require.NoError(t, err)
+
+			_, err = get(ccfg, url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	t.Run("empty server name is rejected", func(t *testing.T) {
+		ccfg, err := NewClient(caFile, "", "", "", zap.S())
+		require.NoError(t, err)
+
+		assert.Error(t, verifyServer(tls.ConnectionState{PeerCertificates: []*x509.Certificate{other.cert}},
+			ccfg.Config("").ServerName, ca.pool()))
+	})
+}
+
+func TestReload(t *testing.T) {
+	td := t.TempDir()
+
+	serverCert, serverKey := writeCert(t, td, "server", "server")
+	caFile := path.Join(td, "ca.pem")
+	copyFile(t, serverCert, caFile)
+
+	scfg, err := NewServer(serverCert, serverKey, "", "", zap.S())
+	require.NoError(t, err)
+
+	url := serveTLS(t, scfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	ccfg, err := NewClient(caFile, "", "", "", zap.S())
+	require.NoError(t, err)
+
+	_, err = get(ccfg, url)
+	require.NoError(t, err)
+
+	t.Run("server certificate is renewed", func(t *testing.T) {
+		// the renewed certificate is issued by other CA, so the agent rejects it until its CA is renewed.
+		renewedCert, renewedKey := writeCert(t, td, "renewed", "server")
+		copyFile(t, renewedCert, serverCert)
+		copyFile(t, renewedKey, serverKey)
+
+		_, err = get(ccfg, url)
+		assert.Error(t, err)
+
+		copyFile(t, renewedCert, caFile)
+		_, err = get(ccfg, url)
+		assert.NoError(t, err)
+	})
+
+	t.Run("broken certificate is not loaded", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(serverCert, []byte("broken"), 0600))
+
+		_, err = get(ccfg, url)
+		assert.NoError(t, err)
+	})
+}
+
+// serveTLS - serves the handler over TLS on the local address and returns the URL of the server.
+// The httptest server is not used, because it sets its own certificate to the configuration.
+func serveTLS(t *testing.T, cfg *tls.Config, h http.Handler) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &http.Server{Handler: h, TLSConfig: cfg, ReadHeaderTimeout: time.Second}
+	go func() { _ = srv.ServeTLS(lis, "", "") }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return "https://" + lis.Addr().String()
+}
+
+// get - sends the request over the new connection, so every request makes the handshake.
+// The connection is made like the agent does it, the TLS configuration is built for the dialed address.
+func get(tc *Client, url string) (*http.Response, error) {
+	c := &http.Client{Transport: &http.Transport{
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := &tls.Dialer{Config: tc.Config(addr)}
+			return d.DialContext(ctx, network, addr)
+		},
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	return resp, resp.Body.Close()
+}
+
+// testCert - the certificate for the localhost along with its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCert - returns the certificate issued by the parent, the certificate is self-signed if the parent is nil.
+// Every certificate is allowed to issue the certificates, so the self-signed certificate is its own CA.
+func newCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+
+	return newCertFor(t, commonName, parent, []string{"localhost"}, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+}
+
+// newCertFor - returns the certificate for the names and the IP addresses issued by the parent.
+func newCertFor(t *testing.T, commonName string, parent *testCert, names []string, ips ...net.IP) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+
+	issuer, issuerKey := tmpl, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, issuerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+// pool - returns the pool with the certificate.
+func (c *testCert) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+// write - writes the certificate and the key to the dir and returns the paths to them.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(c.key)
+	require.NoError(t, err)
+
+	certPath := path.Join(dir, name+".crt")
+	keyPath := path.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+// writeCert - writes the self-signed certificate and returns the paths to the certificate and the key.
+func writeCert(t *testing.T, dir, name, commonName string) (string, string) {
+	t.Helper()
+
+	return newCert(t, commonName, nil).write(t, dir, name)
+}
+
+// writeCRL - writes the CRL of the CA in the PEM form and returns the path to it.
+func writeCRL(t *testing.T, dir string, ca *testCert, revoked ...*testCert) string {
+	t.Helper()
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, c := range revoked {
+		tmpl.RevokedCertificates = append(tmpl.RevokedCertificates,
+			pkix.RevokedCertificate{SerialNumber: c.cert.SerialNumber, RevocationTime: time.Now()})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	require.NoError(t, err)
+
+	crlPath := path.Join(dir, "ca.crl")
+	require.NoError(t, os.WriteFile(crlPath, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600))
+	// the modification time is moved forward like in copyFile.
+	mtime := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(crlPath, mtime, mtime))
+
+	return crlPath
+}
+
+// copyFile - rewrites the dst with the src, the modification time is moved forward,
+// so the change is noticed even if the file of the same size is rewritten within the same clock tick.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	b, err := os.ReadFile(src)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dst, b, 0600))
+
+	mtime := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(dst, mtime, mtime))
+}
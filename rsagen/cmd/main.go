@@ -22,7 +22,7 @@ const (
 	defaultPrivateKeyName = "private.pem"
 	defaultOutFlag        = "o"
 	defaultBitSizeFlag    = "b"
-	defaultBitsSize       = 16384
+	defaultBitsSize       = 4096
 )
 
 type app struct {